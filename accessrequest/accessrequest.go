@@ -0,0 +1,287 @@
+// Package accessrequest provides the just-in-time access workflow.
+//
+// A user requests the use of a role mapping for a period of time with a justification. The request must be approved
+// by a user satisfying one of the approver authorization values defined against the role mapping's account or the
+// account's class. Once approved the requester is granted use of the role mapping until the period expires.
+package accessrequest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/notification"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending          = "Pending"
+	StatusApproved         = "Approved"
+	StatusDenied           = "Denied"
+	MaxJustificationLength = 1024
+	MaxCommentLength       = 1024
+)
+
+type AccessRequest struct {
+	ID            string     `json:"ID"`
+	RoleMappingID string     `json:"RoleMappingID"`
+	Username      string     `json:"Username"`
+	UserDomain    string     `json:"UserDomain"`
+	Justification string     `json:"Justification"`
+	Duration      int        `json:"Duration"` // Duration in minutes
+	Status        string     `json:"Status"`
+	Requested     time.Time  `json:"Requested"`
+	DecidedBy     string     `json:"DecidedBy,omitempty"`
+	Decided       *time.Time `json:"Decided,omitempty"`
+	Comment       string     `json:"Comment,omitempty"`
+	ValidUntil    *time.Time `json:"ValidUntil,omitempty"`
+}
+
+// Row is implemented by *sql.Row and *sql.Rows.
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan reads an access request from a row returned by one of the access request select statements.
+func Scan(row Row) (ar AccessRequest, err error) {
+	var decidedBy, comment *string
+	err = row.Scan(&ar.ID, &ar.RoleMappingID, &ar.Username, &ar.UserDomain, &ar.Justification, &ar.Duration, &ar.Status, &ar.Requested, &decidedBy, &ar.Decided, &comment, &ar.ValidUntil)
+	if decidedBy != nil {
+		ar.DecidedBy = *decidedBy
+	}
+	if comment != nil {
+		ar.Comment = *comment
+	}
+	return
+}
+
+// Get returns the access request with the ID provided.
+func Get(ctx context.Context, id string, stmtMap database.StmtMap) (AccessRequest, error) {
+	if _, err := uuid.ParseUUID(id); err != nil {
+		return AccessRequest{}, appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestUnknown}.Errorf("access request ID not valid")
+	}
+	stmt, ok := stmtMap[database.StmtKeyAccessRequestSelect]
+	if !ok {
+		return AccessRequest{}, errors.New("Prepared statement for DB access request lookup not found")
+	}
+	ar, err := Scan(database.QueryRow(ctx, stmt, id))
+	if err == sql.ErrNoRows {
+		return ar, appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestUnknown}.Errorf("access request %s not found", id)
+	}
+	return ar, err
+}
+
+// Approvers returns the authorization values that identify who can approve requests for the role mapping.
+func Approvers(ctx context.Context, roleMappingID string, stmtMap database.StmtMap) ([]string, error) {
+	stmt, ok := stmtMap[database.StmtKeyAccessRequestApprovers]
+	if !ok {
+		return nil, errors.New("Prepared statement for DB access request approvers lookup not found")
+	}
+	rows, err := database.Query(ctx, stmt, roleMappingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var as []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+	return as, rows.Err()
+}
+
+// CanApprove indicates if the user can approve or deny access requests for the role mapping.
+func CanApprove(ctx context.Context, u goidentity.Identity, roleMappingID string, stmtMap database.StmtMap) (bool, error) {
+	as, err := Approvers(ctx, roleMappingID, stmtMap)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range as {
+		ok, err := authz.Authorized(u, a)
+		if err != nil {
+			return false, fmt.Errorf("error evaluating approver authorization for role mapping %s: %v", roleMappingID, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Granted returns whether the user holds an approved grant for the role mapping at the time provided and when it ends.
+func Granted(ctx context.Context, u goidentity.Identity, roleMappingID string, t time.Time, stmtMap database.StmtMap) (bool, time.Time, error) {
+	var until time.Time
+	stmt, ok := stmtMap[database.StmtKeyAccessGrantCheck]
+	if !ok {
+		return false, until, errors.New("Prepared statement for DB access grant check not found")
+	}
+	err := database.QueryRow(ctx, stmt, roleMappingID, u.UserName(), u.Domain(), StatusApproved, t.UTC()).Scan(&until)
+	if err == sql.ErrNoRows {
+		return false, until, nil
+	}
+	if err != nil {
+		return false, until, err
+	}
+	return true, until, nil
+}
+
+// New creates a pending access request for the user to use the role mapping.
+func New(ctx context.Context, u goidentity.Identity, roleMappingID, justification string, duration int, stmtMap database.StmtMap, c *config.Config) (ar AccessRequest, err error) {
+	if _, e := uuid.ParseUUID(roleMappingID); e != nil {
+		err = appcodes.ErrBadPostData{}.Errorf("role mapping ID not valid")
+		return
+	}
+	justification = strings.TrimSpace(justification)
+	if justification == "" || len(justification) > MaxJustificationLength {
+		err = appcodes.ErrBadPostData{}.Errorf("a justification of no more than %d characters must be provided", MaxJustificationLength)
+		return
+	}
+	max := c.Server.AccessRequest.MaxDuration
+	if duration < 1 || duration > max {
+		err = appcodes.ErrBadPostData{}.Errorf("duration must be between 1 and %d minutes", max)
+		return
+	}
+	as, err := Approvers(ctx, roleMappingID, stmtMap)
+	if err != nil {
+		return
+	}
+	if len(as) < 1 {
+		err = appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestNoApprovers}.Errorf("no approvers are defined for role mapping %s", roleMappingID)
+		return
+	}
+	stmt, ok := stmtMap[database.StmtKeyAccessRequestExists]
+	if !ok {
+		err = errors.New("Prepared statement for DB access request check not found")
+		return
+	}
+	var i int
+	err = database.QueryRow(ctx, stmt, roleMappingID, u.UserName(), u.Domain(), StatusPending).Scan(&i)
+	if err == nil {
+		err = appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestAlreadyExists}.Errorf("a pending access request for role mapping %s already exists", roleMappingID)
+		return
+	}
+	if err != sql.ErrNoRows {
+		return
+	}
+	ar = AccessRequest{
+		RoleMappingID: roleMappingID,
+		Username:      u.UserName(),
+		UserDomain:    u.Domain(),
+		Justification: justification,
+		Duration:      duration,
+		Status:        StatusPending,
+		Requested:     time.Now().UTC().Truncate(time.Second),
+	}
+	ar.ID, err = uuid.GenerateUUID()
+	if err != nil {
+		return
+	}
+	stmt, ok = stmtMap[database.StmtKeyAccessRequestInsert]
+	if !ok {
+		err = errors.New("Prepared statement for DB access request creation not found")
+		return
+	}
+	_, err = database.Exec(ctx, stmt, ar.ID, ar.RoleMappingID, ar.Username, ar.UserDomain, ar.Justification, ar.Duration, ar.Status, ar.Requested)
+	if err != nil {
+		return
+	}
+	event(ctx, u, "AccessRequestCreated", ar, c)
+	return
+}
+
+// Decide approves or denies the pending access request.
+// The user deciding must satisfy one of the approvers defined for the role mapping and cannot decide their own request.
+func Decide(ctx context.Context, u goidentity.Identity, id string, approve bool, comment string, stmtMap database.StmtMap, c *config.Config) (ar AccessRequest, err error) {
+	ar, err = Get(ctx, id, stmtMap)
+	if err != nil {
+		return
+	}
+	if len(comment) > MaxCommentLength {
+		err = appcodes.ErrBadPostData{}.Errorf("comment must be no more than %d characters", MaxCommentLength)
+		return
+	}
+	if ar.Status != StatusPending {
+		err = appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestNotPending}.Errorf("access request %s is not pending, status is %s", id, ar.Status)
+		return
+	}
+	if strings.EqualFold(ar.Username, u.UserName()) && strings.EqualFold(ar.UserDomain, u.Domain()) {
+		err = appcodes.ErrUnauthorized{}.Errorf("users cannot decide their own access requests")
+		event(ctx, u, "AccessRequestDecisionRejected", ar, c)
+		return
+	}
+	ok, err := CanApprove(ctx, u, ar.RoleMappingID, stmtMap)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = appcodes.ErrUnauthorized{}.Errorf("user is not an approver for access request %s", id)
+		event(ctx, u, "AccessRequestDecisionRejected", ar, c)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	ar.DecidedBy = u.UserName() + "@" + u.Domain()
+	ar.Decided = &now
+	ar.Comment = comment
+	eventType := "AccessRequestDenied"
+	ar.Status = StatusDenied
+	if approve {
+		eventType = "AccessRequestApproved"
+		ar.Status = StatusApproved
+		vu := now.Add(time.Duration(ar.Duration) * time.Minute)
+		ar.ValidUntil = &vu
+	}
+	stmt, ok := stmtMap[database.StmtKeyAccessRequestDecide]
+	if !ok {
+		err = errors.New("Prepared statement for DB access request decision not found")
+		return
+	}
+	res, err := database.Exec(ctx, stmt, ar.Status, ar.DecidedBy, ar.Decided, ar.Comment, ar.ValidUntil, ar.ID, StatusPending)
+	if err != nil {
+		return
+	}
+	if i, e := res.RowsAffected(); e != nil || i != 1 {
+		// Another approver has decided the request in the meantime.
+		err = appcodes.ErrAccessRequest{AppCode: appcodes.AccessRequestNotPending}.Errorf("access request %s is no longer pending", id)
+		return
+	}
+	event(ctx, u, eventType, ar, c)
+	return
+}
+
+// event audit logs the step in the workflow and sends a notification of it.
+func event(ctx context.Context, u goidentity.Identity, eventType string, ar AccessRequest, c *config.Config) {
+	eventUUID, _ := uuid.GenerateUUID()
+	now := time.Now().UTC()
+	b, _ := json.Marshal(ar)
+	c.AuditLog(config.AuditLogLine{
+		Username:      u.UserName(),
+		UserDomain:    u.Domain(),
+		UserSessionID: u.SessionID(),
+		Time:          now,
+		EventType:     eventType,
+		UUID:          eventUUID,
+		Detail:        url.QueryEscape(string(b)),
+		RequestID:     applog.RequestID(ctx),
+	})
+	notification.Send(notification.Event{
+		EventType:  eventType,
+		EventUUID:  eventUUID,
+		Time:       now,
+		Username:   u.UserName(),
+		UserDomain: u.Domain(),
+		Detail:     ar,
+	}, c)
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
@@ -9,11 +10,19 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	"github.com/jcmturner/awsfederation/assumerole"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/breakglass"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/health"
 	"github.com/jcmturner/awsfederation/httphandling"
-	"github.com/jcmturner/vaultclient"
+	"github.com/jcmturner/awsfederation/ratelimit"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"github.com/jcmturner/awsfederation/tracing"
 	krb5config "gopkg.in/jcmturner/gokrb5.v4/config"
 	"gopkg.in/jcmturner/gokrb5.v4/keytab"
 	"gopkg.in/ldap.v2"
@@ -21,7 +30,11 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -38,9 +51,23 @@ type App struct {
 	Router        *mux.Router
 	Config        *config.Config
 	FedUserCache  *federationuser.FedUserCache
-	DB            *sql.DB
-	PreparedStmts *database.StmtMap
-	VaultClient   *vaultclient.Client
+	Database      *database.Current
+	dbLease       secretstore.Lease
+	SecretStore   secretstore.Store
+	RateLimiter   *ratelimit.Limiter
+	CredCache     *credcache.Cache
+	HealthChecker *health.Checker
+	// CatalogueSyncer is set when the catalogue is synced with its definitions.
+	CatalogueSyncer *catalogue.Syncer
+	Server          *http.Server
+	ConfigPath      string
+	mux             sync.RWMutex
+	reloadMux       sync.Mutex
+	handler         atomic.Value
+	cert            atomic.Value
+	stop            chan struct{}
+	jobs            sync.WaitGroup
+	stopTracing     func(context.Context) error
 }
 
 func Version() (string, string, time.Time) {
@@ -57,44 +84,111 @@ func generatePasswd() string {
 }
 
 func ApplyDBSchema(c *config.Config, dbSocket, dbAdminUser, dbAdminPasswd string) error {
-	//TODO make this work with a TLS DB connection
-	//dbs := fmt.Sprintf("%s:%s@tcp(%s)/dbname?tls=skip-verify&multiStatements=true&parseTime=true&autocommit=true&charset=utf8&timeout=90s", dbAdminUser, dbAdminPasswd, dbSocket)
 	dbs := fmt.Sprintf("%s:%s@tcp(%s)/?multiStatements=true&parseTime=true&autocommit=true&charset=utf8&timeout=90s", dbAdminUser, dbAdminPasswd, dbSocket)
+	dbs, err := database.DSN(dbs, c.Database.TLS)
+	if err != nil {
+		return err
+	}
 	db, err := sql.Open("mysql", dbs)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	appPasswd := generatePasswd()
-	if err != nil {
-		return fmt.Errorf("could not generate random password: %v", err)
+	if c.Database.DynamicCredentials.Enabled {
+		// The vault creates the database users so only the schema is needed. The vault role's creation statements
+		// must grant the users access to the awsfederation schema.
+		if _, err = db.Exec(database.DBCreateSchema); err != nil {
+			return err
+		}
+	} else {
+		appPasswd := generatePasswd()
+		_, err = db.Exec(fmt.Sprintf(database.DBCreateSchemaAppUser, appUser, appPasswd, appUser))
+		if err != nil {
+			return err
+		}
+
+		// Store the database password in the secret store
+		s, err := secretstore.New(c)
+		if err != nil {
+			return err
+		}
+		m := make(map[string]interface{})
+		m["username"] = appUser
+		m["password"] = appPasswd
+		if err := s.Write(c.Database.CredentialsVaultPath, m); err != nil {
+			return fmt.Errorf("could not store database credentials: %v", err)
+		}
 	}
-	_, err = db.Exec(fmt.Sprintf(database.DBCreateSchemaAppUser, appUser, appPasswd, appUser))
+
+	_, err = db.Exec(database.DBCreateTables)
 	if err != nil {
 		return err
 	}
+	return recordMetadata(db)
+}
 
-	// Store the database password in vault
-	cl, err := vaultclient.NewClient(c.Vault.Config, c.Vault.Credentials)
+// UpgradeDBSchema upgrades the tables of a database created by an earlier release to the schema of this release. It
+// returns the schema version the database was upgraded from.
+func UpgradeDBSchema(c *config.Config, dbSocket, dbAdminUser, dbAdminPasswd string) (int, error) {
+	dbs := fmt.Sprintf("%s:%s@tcp(%s)/?multiStatements=true&parseTime=true&autocommit=true&charset=utf8&timeout=90s", dbAdminUser, dbAdminPasswd, dbSocket)
+	dbs, err := database.DSN(dbs, c.Database.TLS)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	m := make(map[string]interface{})
-	m["username"] = appUser
-	m["password"] = appPasswd
-	cl.Write(c.Database.CredentialsVaultPath, m)
-
-	_, err = db.Exec(database.DBCreateTables)
+	db, err := sql.Open("mysql", dbs)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer db.Close()
+	from, err := database.Upgrade(db)
+	if err != nil {
+		return from, err
 	}
+	return from, recordMetadata(db)
+}
+
+// recordMetadata records the release and the schema version applied to the database.
+func recordMetadata(db *sql.DB) error {
 	bt, err := time.Parse(time.RFC3339, buildtime)
 	if err != nil {
 		return fmt.Errorf("format of buildtime set during compliation is not correct. It must confirm to RFC3339: %v", err)
 	}
-	_, err = db.Exec("INSERT INTO metadata(datetime, version, buildhash, buildtime) VALUES (?, ?, ?, ?)", time.Now().UTC(), version, buildhash, bt)
-	if err != nil {
-		return err
+	_, err = db.Exec("INSERT INTO awsfederation.metadata(datetime, version, buildhash, buildtime, schema_version) VALUES (?, ?, ?, ?, ?)",
+		time.Now().UTC(), version, buildhash, bt, database.SchemaVersion)
+	return err
+}
+
+// CheckConfig validates the configuration, including the settings validated by the packages that use them, and
+// returns a config.ValidationError listing all the problems found.
+func CheckConfig(c *config.Config) error {
+	var problems []string
+	if err := c.Validate(); err != nil {
+		verr, ok := err.(config.ValidationError)
+		if !ok {
+			return err
+		}
+		problems = append(problems, verr.Problems...)
+	}
+	if c.Server.RoleMappingExpiry.Enabled {
+		if err := assumerole.ValidExpiryAction(c.Server.RoleMappingExpiry.Action); err != nil {
+			problems = append(problems, fmt.Sprintf("Server.RoleMappingExpiry.Action: %v", err))
+		}
+		if c.Server.RoleMappingExpiry.Interval < 1 {
+			problems = append(problems, "Server.RoleMappingExpiry.Interval: interval for expired role mapping job must be at least one minute")
+		}
+	}
+	if c.Server.RateLimit.Enabled {
+		if err := ratelimit.Validate(c.Server.RateLimit); err != nil {
+			problems = append(problems, fmt.Sprintf("Server.RateLimit: %v", err))
+		}
+	}
+	if c.Server.BreakGlass.Enabled {
+		if err := breakglass.Validate(c.Server.BreakGlass); err != nil {
+			problems = append(problems, fmt.Sprintf("Server.BreakGlass: %v", err))
+		}
+	}
+	if len(problems) > 0 {
+		return config.ValidationError{Problems: problems}
 	}
 	return nil
 }
@@ -102,23 +196,97 @@ func ApplyDBSchema(c *config.Config, dbSocket, dbAdminUser, dbAdminPasswd string
 func (a *App) Initialize(c *config.Config) error {
 	a.Config = c
 
-	// Initialise the Vault Client
-	vc, err := vaultclient.NewClient(c.Vault.Config, c.Vault.Credentials)
+	// Reject an invalid configuration rather than failing later
+	if err := CheckConfig(c); err != nil {
+		c.Logger().Error(err.Error())
+		return err
+	}
+
+	// Start exporting the trace spans
+	stopTracing, err := tracing.Init(c.Server.Tracing)
+	if err != nil {
+		return fmt.Errorf("error configuring tracing: %v", err)
+	}
+	a.stopTracing = stopTracing
+
+	// Initialise the secret store
+	s, err := secretstore.ForConfig(c)
 	if err != nil {
-		return fmt.Errorf("error creating vault client: %v", err)
+		return fmt.Errorf("error creating secret store: %v", err)
 	}
-	a.VaultClient = &vc
+	a.SecretStore = s
 
 	// Initialise authentication config
+	if err := a.loadAuthentication(c); err != nil {
+		return err
+	}
+
+	// Load the break-glass emergency identities
+	if c.Server.BreakGlass.Enabled {
+		c.Server.BreakGlass.Credentials, err = breakglass.LoadCredentials(c.Server.BreakGlass.CredentialsVaultPath, a.SecretStore)
+		if err != nil {
+			err = fmt.Errorf("error loading break-glass credentials from secret store: %v", err)
+			c.Logger().Error(err.Error())
+			return err
+		}
+	}
+
+	// Set up the database connection and prepare the DB statements
+	conn, l, err := a.connectDB(c)
+	if err != nil {
+		return err
+	}
+	a.Database = database.NewCurrent(conn)
+	a.dbLease = l
+
+	// Initialise the rate limiter
+	if c.Server.RateLimit.Enabled {
+		a.RateLimiter, err = ratelimit.NewLimiter(c.Server.RateLimit, a.Database)
+		if err != nil {
+			return fmt.Errorf("error configuring rate limits: %v", err)
+		}
+	}
+
+	// Store the audit events in the database when enabled
+	a.setAuditStore(c)
+
+	// Initialise the caches
+	fc := make(federationuser.FedUserCache)
+	a.FedUserCache = &fc
+	if c.Server.CredentialCache.Enabled {
+		a.CredCache, err = credcache.New(time.Duration(c.Server.CredentialCache.MinRemaining) * time.Minute)
+		if err != nil {
+			return fmt.Errorf("error creating credential cache: %v", err)
+		}
+	}
+
+	// Keep the catalogue in line with its definitions
+	if c.Server.CatalogueSync.Enabled {
+		a.CatalogueSyncer = catalogue.NewSyncer(c.Server.CatalogueSync.Directory)
+	}
+
+	// Set up the dependency checks for the readiness endpoint
+	a.HealthChecker = a.healthChecker(c)
+
+	// Initialise the HTTP router
+	a.Router = a.newRouter(c, a.HealthChecker)
+	a.handler.Store(a.Router)
+
+	return nil
+}
+
+// loadAuthentication loads the keytabs and LDAP bind password from the secret store and connects to LDAP as required by the
+// authentication configuration.
+func (a *App) loadAuthentication(c *config.Config) (err error) {
 	if c.Server.Authentication.Kerberos.Enabled {
 		if c.Server.Authentication.Kerberos.KeytabVaultPath == "" {
 			return errors.New("kerberos authentication enabled but no path to keytab in vault defined")
 		}
 		var kt keytab.Keytab
-		kt, err = loadKeytabFromVault(c.Vault.Config.SecretsPath+c.Server.Authentication.Kerberos.KeytabVaultPath, a.VaultClient)
+		kt, err = loadKeytab(c.Vault.Config.SecretsPath+c.Server.Authentication.Kerberos.KeytabVaultPath, a.SecretStore)
 		if err != nil {
-			err = fmt.Errorf("error loading keytab for kerberos authentication from vault: %v", err)
-			c.ApplicationLogf(err.Error())
+			err = fmt.Errorf("error loading keytab for kerberos authentication from secret store: %v", err)
+			c.Logger().Error(err.Error())
 			return err
 		}
 		c.Server.Authentication.Kerberos.Keytab = &kt
@@ -126,17 +294,17 @@ func (a *App) Initialize(c *config.Config) error {
 	if c.Server.Authentication.Basic.Enabled {
 		switch strings.ToLower(c.Server.Authentication.Basic.Protocol) {
 		case "ldap":
-			c.Server.Authentication.Basic.LDAP.BindUserPassword, err = loadLDAPBindPasswordFromVault(c.Server.Authentication.Basic.LDAP.BindUserPasswordVaultPath, a.VaultClient)
+			c.Server.Authentication.Basic.LDAP.BindUserPassword, err = loadLDAPBindPassword(c.Server.Authentication.Basic.LDAP.BindUserPasswordVaultPath, a.SecretStore)
 			if err != nil {
-				err = fmt.Errorf("error loading LDAP bind password from vault: %v", err)
-				c.ApplicationLogf(err.Error())
+				err = fmt.Errorf("error loading LDAP bind password from secret store: %v", err)
+				c.Logger().Error(err.Error())
 				return err
 			}
 			var lc *ldap.Conn
 			lc, err = ldapConn(c.Server.Authentication.Basic.LDAP)
 			if err != nil {
 				err = fmt.Errorf("error getting LDAP connection: %v", err)
-				c.ApplicationLogf(err.Error())
+				c.Logger().Error(err.Error())
 				return err
 			}
 			c.Server.Authentication.Basic.LDAP.LDAPConn = lc
@@ -144,79 +312,247 @@ func (a *App) Initialize(c *config.Config) error {
 			c.Server.Authentication.Basic.Kerberos.Conf, err = krb5config.Load(c.Server.Authentication.Basic.Kerberos.KRB5ConfPath)
 			if err != nil {
 				err = fmt.Errorf("invalid kerberos basic authentication configuration: %v", err)
-				c.ApplicationLogf(err.Error())
+				c.Logger().Error(err.Error())
 				return err
 			}
 			var kt keytab.Keytab
-			kt, err = loadKeytabFromVault(c.Vault.Config.SecretsPath+c.Server.Authentication.Basic.Kerberos.KeytabVaultPath, a.VaultClient)
+			kt, err = loadKeytab(c.Vault.Config.SecretsPath+c.Server.Authentication.Basic.Kerberos.KeytabVaultPath, a.SecretStore)
 			if err != nil {
-				err = errors.New("error loading keytab for kerberos basic authentication from vault: %v")
-				c.ApplicationLogf(err.Error())
+				err = fmt.Errorf("error loading keytab for kerberos basic authentication from secret store: %v", err)
+				c.Logger().Error(err.Error())
 				return err
 			}
 			c.Server.Authentication.Basic.Kerberos.Keytab = &kt
 		case "static":
 			if c.Server.Authentication.Basic.Static.RequiredSecret == "" {
 				err = errors.New("static authentication configured without a required secret")
+				c.Logger().Error(err.Error())
+				return err
 			}
 		default:
 			err = fmt.Errorf("invalid protocol (%v) for basic authentication", c.Server.Authentication.Basic.Protocol)
-			c.ApplicationLogf(err.Error())
+			c.Logger().Error(err.Error())
 			return err
 		}
 	}
+	return nil
+}
 
-	// Set up the database connection
-	dbs := c.Database.ConnectionString
-	dbm, err := a.VaultClient.Read(c.Database.CredentialsVaultPath)
-	if err != nil {
-		return fmt.Errorf("failed to load database credentials from the vault: %v", err)
+func (a *App) Run() (err error) {
+	v, bh, bt := Version()
+	fmt.Fprintf(os.Stderr, "AWS Federation Version Information:\nVersion:\t%s\nBuild hash:\t%s\nBuild time:\t%v\n", v, bh, bt)
+	c := a.config()
+	fmt.Fprintln(os.Stderr, c.Summary())
+	// Start background jobs
+	a.stop = make(chan struct{})
+	if c.Server.RoleMappingExpiry.Enabled {
+		a.jobs.Add(1)
+		go a.expiredRoleMappingJob()
 	}
-	if v, ok := dbm["username"]; ok {
-		dbs = strings.Replace(dbs, "${username}", v.(string), -1)
+	if a.CredCache != nil {
+		a.jobs.Add(1)
+		go a.credentialCachePurgeJob()
 	}
-	if v, ok := dbm["password"]; ok {
-		dbs = strings.Replace(dbs, "${password}", v.(string), -1)
+	if c.Database.DynamicCredentials.Enabled {
+		a.jobs.Add(1)
+		go a.dbCredentialsJob()
 	}
-	a.DB, err = sql.Open("mysql", dbs)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %v\n", err)
+	a.jobs.Add(1)
+	go a.auditPurgeJob()
+	if a.CatalogueSyncer != nil {
+		a.jobs.Add(1)
+		go a.catalogueSyncJob()
 	}
-	if err := a.DB.Ping(); err != nil {
-		return fmt.Errorf("database connection test failed: %v\n", err)
+	// Start server
+	if c.Server.TLS.Enabled {
+		if err = a.loadCertificate(c); err != nil {
+			a.close()
+			return
+		}
 	}
+	a.Server = a.httpServer()
+	serveErr := make(chan error, 1)
+	go func() {
+		if c.Server.TLS.Enabled {
+			// The certificate is provided by the server's TLS configuration so that it can be reloaded.
+			serveErr <- a.Server.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- a.Server.ListenAndServe()
+		}
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case err = <-serveErr:
+			if err == http.ErrServerClosed {
+				// Shutdown has been called and cleans up once the connections are drained.
+				return nil
+			}
+			a.config().Logger().Errorf("server stopped: %v", err)
+			a.close()
+			return
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				a.Reload()
+				continue
+			}
+			a.config().Logger().Infof("received signal %v, shutting down", s)
+			err = a.Shutdown()
+			return
+		}
+	}
+}
 
-	// Prepare and store DB statements
-	a.PreparedStmts, err = database.NewStmtMap(a.DB)
-	if err != nil {
-		return fmt.Errorf("error preparing database statements: %v", err)
+func (a *App) httpServer() *http.Server {
+	c := a.config()
+	t := c.Server.Timeouts
+	srv := &http.Server{
+		Addr: c.Server.Socket,
+		// Requests are passed to the current router which is replaced when the configuration is reloaded.
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.handler.Load().(*mux.Router).ServeHTTP(w, r)
+		}),
+		ReadTimeout:  time.Duration(t.Read) * time.Second,
+		WriteTimeout: time.Duration(t.Write) * time.Second,
+		IdleTimeout:  time.Duration(t.Idle) * time.Second,
+	}
+	if c.Server.TLS.Enabled {
+		srv.TLSConfig = &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return a.cert.Load().(*tls.Certificate), nil
+			},
+		}
 	}
+	return srv
+}
 
-	// Initialise the HTTP router
-	a.Router = httphandling.NewRouter(a.Config, a.PreparedStmts, a.FedUserCache)
+// Shutdown stops the server accepting new connections and waits for in-flight requests to complete, up to the
+// configured shutdown timeout, before stopping the background jobs and closing the connections and log files.
+func (a *App) Shutdown() (err error) {
+	if a.Server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.config().Server.Timeouts.Shutdown)*time.Second)
+		defer cancel()
+		if err = a.Server.Shutdown(ctx); err != nil {
+			err = fmt.Errorf("error draining connections: %v", err)
+			a.config().Logger().Error(err.Error())
+		}
+	}
+	a.close()
+	return
+}
 
-	return nil
+// close stops the background jobs and closes the connections and log files.
+func (a *App) close() {
+	if a.stop != nil {
+		close(a.stop)
+		a.jobs.Wait()
+		a.stop = nil
+	}
+	httphandling.StopSessionCacheCleaner()
+	c := a.config()
+	if a.Database != nil {
+		if err := a.Database.Close(); err != nil {
+			c.Logger().Errorf("error closing database: %v", err)
+		}
+		// Revoke the dynamic credentials so that they do not outlive the server.
+		a.revokeDBLease(a.dbLease)
+	}
+	if lc := c.Server.Authentication.Basic.LDAP.LDAPConn; lc != nil {
+		lc.Close()
+	}
+	if a.stopTracing != nil {
+		// Export the remaining spans, giving up after the shutdown timeout.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Server.Timeouts.Shutdown)*time.Second)
+		if err := a.stopTracing(ctx); err != nil {
+			c.Logger().Errorf("error flushing trace spans: %v", err)
+		}
+		cancel()
+		a.stopTracing = nil
+	}
+	if err := c.CloseLogs(); err != nil {
+		c.Logger().Errorf("error closing log files: %v", err)
+	}
 }
 
-func (a *App) Run() (err error) {
-	v, bh, bt := Version()
-	fmt.Fprintf(os.Stderr, "AWS Federation Version Information:\nVersion:\t%s\nBuild hash:\t%s\nBuild time:\t%v\n", v, bh, bt)
-	fmt.Fprintln(os.Stderr, a.Config.Summary())
-	// Deferred clean up actions
-	//
-	defer a.DB.Close()
-	// Start server
-	if a.Config.Server.TLS.Enabled {
-		err = http.ListenAndServeTLS(a.Config.Server.Socket, a.Config.Server.TLS.CertificateFile, a.Config.Server.TLS.KeyFile, a.Router)
-	} else {
-		err = http.ListenAndServe(a.Config.Server.Socket, a.Router)
+func (a *App) expiredRoleMappingJob() {
+	defer a.jobs.Done()
+	exp := a.config().Server.RoleMappingExpiry
+	ticker := time.NewTicker(time.Duration(exp.Interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		c := a.config()
+		conn, release := a.Database.Acquire()
+		if err := assumerole.ProcessExpiredRoleMappings(exp.Action, conn, c); err != nil {
+			c.Logger().Errorf("error processing expired role mappings: %v", err)
+		}
+		release()
+		select {
+		case <-ticker.C:
+		case <-a.stop:
+			return
+		}
 	}
-	return
 }
 
-func loadKeytabFromVault(p string, vc *vaultclient.Client) (kt keytab.Keytab, err error) {
-	m, e := vc.Read(p)
-	if err != nil {
+func (a *App) credentialCachePurgeJob() {
+	defer a.jobs.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.CredCache.Purge(time.Now().UTC())
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// setAuditStore makes audit events be stored in the database under the configuration if the audit store is enabled.
+func (a *App) setAuditStore(c *config.Config) {
+	if c.Server.Logging != nil && c.Server.Logging.AuditStore.Enabled {
+		auditstore.Set(c, auditstore.New(a.Database))
+	}
+}
+
+// auditPurgeJob deletes the audit events that are older than the retention period. The settings are read from the
+// current configuration each time so that changes to them are applied when the configuration is reloaded.
+func (a *App) auditPurgeJob() {
+	defer a.jobs.Done()
+	for {
+		c := a.config()
+		wait := time.Hour
+		if c.Server.Logging != nil {
+			as := c.Server.Logging.AuditStore
+			if as.PurgeInterval > 0 {
+				wait = time.Duration(as.PurgeInterval) * time.Minute
+			}
+			if s, ok := auditstore.ForConfig(c); ok && as.RetentionDays > 0 {
+				ctx, span := tracing.Start(context.Background(), "audit purge")
+				n, err := s.Purge(ctx, time.Now().UTC().AddDate(0, 0, -as.RetentionDays))
+				tracing.End(span, err)
+				if err != nil {
+					c.Logger().Errorf("error purging audit events: %v", err)
+				} else if n > 0 {
+					c.Logger().Infof("%d audit events older than %d days purged", n, as.RetentionDays)
+				}
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func loadKeytab(p string, s secretstore.Store) (kt keytab.Keytab, err error) {
+	m, e := s.Read(p)
+	if e != nil {
 		err = e
 		return
 	}
@@ -232,7 +568,7 @@ func loadKeytabFromVault(p string, vc *vaultclient.Client) (kt keytab.Keytab, er
 		}
 		return
 	}
-	err = errors.New("keytab not found in vault")
+	err = errors.New("keytab not found in secret store")
 	return
 }
 
@@ -265,8 +601,8 @@ func ldapConn(l config.LDAPBasic) (c *ldap.Conn, err error) {
 	return
 }
 
-func loadLDAPBindPasswordFromVault(p string, vc *vaultclient.Client) (passwd string, err error) {
-	m, err := vc.Read(p)
+func loadLDAPBindPassword(p string, s secretstore.Store) (passwd string, err error) {
+	m, err := s.Read(p)
 	if err != nil {
 		return
 	}
@@ -274,6 +610,6 @@ func loadLDAPBindPasswordFromVault(p string, vc *vaultclient.Client) (passwd str
 		passwd = pswd.(string)
 		return
 	}
-	err = errors.New("LDAP bind password not found in vault")
+	err = errors.New("LDAP bind password not found in secret store")
 	return
 }
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"net/url"
+	"os"
+	"os/user"
+	"time"
+)
+
+// ExportCatalogue reads the catalogue from the database and returns it in the format given.
+func ExportCatalogue(c *config.Config, format string) (b []byte, err error) {
+	err = withDB(c, func(conn *database.Conn) error {
+		cat, err := catalogue.Export(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		b, err = cat.Marshal(format)
+		return err
+	})
+	return
+}
+
+// ImportCatalogue imports the catalogue document given into the database. The import is audited, and the changes are
+// recorded in their history, as made by the user running the command unless an actor is given in the options.
+func ImportCatalogue(c *config.Config, b []byte, opts catalogue.Options) (rep catalogue.Report, err error) {
+	if opts.Actor == "" {
+		opts.Actor = commandUser()
+	}
+	doc, err := catalogue.Parse(b)
+	if err != nil {
+		return
+	}
+	err = withDB(c, func(conn *database.Conn) error {
+		rep, err = catalogue.Import(context.Background(), conn, doc, opts)
+		auditCatalogueImport(conn, c, opts, rep, err)
+		return err
+	})
+	return
+}
+
+// commandUser returns the name of the operating system user running the command.
+func commandUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "unknown"
+}
+
+func auditCatalogueImport(conn *database.Conn, c *config.Config, opts catalogue.Options, rep catalogue.Report, err error) {
+	eventUUID, e := uuid.GenerateUUID()
+	if e != nil {
+		c.Logger().Errorf("could not audit catalogue import: %v", e)
+		return
+	}
+	d := struct {
+		DryRun    bool
+		Created   int
+		Updated   int
+		Deleted   int
+		Conflicts int
+		Error     string `json:",omitempty"`
+	}{opts.DryRun, rep.Created, rep.Updated, rep.Deleted, rep.Conflicts, ""}
+	l := config.AuditLogLine{
+		Username:  opts.Actor,
+		EventType: "Catalogue Import",
+		Time:      time.Now().UTC(),
+		UUID:      eventUUID,
+		Outcome:   config.AuditOutcomeSuccess,
+	}
+	if err != nil {
+		l.Outcome = config.AuditOutcomeFailure
+		d.Error = err.Error()
+	}
+	b, _ := json.Marshal(d)
+	l.Detail = url.QueryEscape(string(b))
+	c.AuditLog(l)
+	if c.Server.Logging.AuditStore.Enabled {
+		if e := auditstore.New(conn).Add(context.Background(), auditstore.NewEvent(l, auditstore.Reference{})); e != nil {
+			c.Logger().Errorf("could not store audit event %s: %v", l.UUID, e)
+		}
+	}
+}
+
+// withDB connects to the database for a command run outside of the server and closes the connection once done.
+func withDB(c *config.Config, f func(*database.Conn) error) error {
+	var a App
+	a.Config = c
+	s, err := secretstore.ForConfig(c)
+	if err != nil {
+		return fmt.Errorf("error creating secret store: %v", err)
+	}
+	a.SecretStore = s
+	conn, l, err := a.connectDB(c)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		conn.Close()
+		a.revokeDBLease(l)
+	}()
+	return f(conn)
+}
+
+// catalogueSyncJob syncs the catalogue with its definitions. The interval is read from the current configuration each
+// time so that a change to it is applied when the configuration is reloaded.
+func (a *App) catalogueSyncJob() {
+	defer a.jobs.Done()
+	for {
+		c := a.config()
+		conn, release := a.Database.Acquire()
+		st, err := a.CatalogueSyncer.Sync(context.Background(), conn, c)
+		release()
+		switch {
+		case err != nil:
+			c.Logger().Errorf("error syncing the catalogue: %v", err)
+		case len(st.Drift.Changes) == 0:
+		case st.Drift.DryRun:
+			c.Logger().Warnf("catalogue has drifted from the definitions of revision %s: %d differences", st.Revision, len(st.Drift.Changes))
+		default:
+			c.Logger().Infof("catalogue synced to the definitions of revision %s: %d created, %d updated, %d deleted", st.Revision, st.Drift.Created, st.Drift.Updated, st.Drift.Deleted)
+			a.invalidateStaleCredentials(*st.Drift)
+		}
+		timer := time.NewTimer(time.Duration(c.Server.CatalogueSync.Interval) * time.Second)
+		select {
+		case <-timer.C:
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// invalidateStaleCredentials removes the cached credentials that the changes of the report may have made stale.
+func (a *App) invalidateStaleCredentials(rep catalogue.Report) {
+	if a.CredCache == nil {
+		return
+	}
+	all, rms := rep.StaleCredentials()
+	if all {
+		a.CredCache.InvalidateAll()
+		return
+	}
+	for _, id := range rms {
+		a.CredCache.InvalidateRoleMapping(id)
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/url"
+	"testing"
+)
+
+func TestAuditCatalogueImport(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	c := config.NewConfig()
+	var buf bytes.Buffer
+	c.SetAuditLogger(json.NewEncoder(&buf))
+	c.Server.Logging.AuditStore.Enabled = true
+	opts := catalogue.Options{Upsert: true, Actor: "operator"}
+
+	ep[database.StmtKeyAuditEventInsert].ExpectExec().
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Catalogue Import", "operator", "", "", "", config.AuditOutcomeFailure, "", "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	auditCatalogueImport(&database.Conn{DB: db, Stmts: stmtMap}, c, opts, catalogue.Report{Created: 1}, errors.New("import failed"))
+
+	var l config.AuditLogLine
+	if err := json.Unmarshal(buf.Bytes(), &l); err != nil {
+		t.Fatalf("could not unmarshal audit log line: %v", err)
+	}
+	assert.Equal(t, "Catalogue Import", l.EventType, "audit event type not as expected")
+	assert.Equal(t, "operator", l.Username, "audit actor not as expected")
+	assert.Equal(t, config.AuditOutcomeFailure, l.Outcome, "audit outcome not as expected")
+	d, _ := url.QueryUnescape(l.Detail)
+	assert.JSONEq(t, `{"DryRun":false,"Created":1,"Updated":0,"Deleted":0,"Conflicts":0,"Error":"import failed"}`, d, "audit detail not as expected")
+	assert.NoError(t, mock.ExpectationsWereMet(), "audit event not stored")
+}
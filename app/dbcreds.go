@@ -0,0 +1,156 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"strings"
+	"time"
+)
+
+// dbRetryInterval is how long to wait before trying again when the database credentials could not be renewed or
+// replaced.
+const dbRetryInterval = 30 * time.Second
+
+// connectDB loads the database credentials from the secret store, connects to the database and prepares the
+// statements. When dynamic credentials are enabled the lease under which the credentials were issued is also returned.
+func (a *App) connectDB(c *config.Config) (conn *database.Conn, l secretstore.Lease, err error) {
+	var dbm map[string]interface{}
+	if c.Database.DynamicCredentials.Enabled {
+		ls, ok := a.SecretStore.(secretstore.Leaser)
+		if !ok {
+			err = errors.New("secret store does not support dynamic database credentials")
+			return
+		}
+		dbm, l, err = ls.ReadLease(c.Database.CredentialsVaultPath)
+	} else {
+		dbm, err = a.SecretStore.Read(c.Database.CredentialsVaultPath)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to load database credentials from the secret store: %v", err)
+		return
+	}
+	dbs := c.Database.ConnectionString
+	if v, ok := dbm["username"].(string); ok {
+		dbs = strings.Replace(dbs, config.DSNUsernamePlaceholder, v, -1)
+	}
+	if v, ok := dbm["password"].(string); ok {
+		dbs = strings.Replace(dbs, config.DSNPasswordPlaceholder, v, -1)
+	}
+	dbs, err = database.DSN(dbs, c.Database.TLS)
+	if err != nil {
+		a.revokeDBLease(l)
+		return
+	}
+	db, err := sql.Open("mysql", dbs)
+	if err != nil {
+		err = fmt.Errorf("failed to open database: %v", err)
+		a.revokeDBLease(l)
+		return
+	}
+	if err = db.Ping(); err != nil {
+		err = fmt.Errorf("database connection test failed: %v", err)
+		db.Close()
+		a.revokeDBLease(l)
+		return
+	}
+	stmtMap, err := database.NewStmtMap(db)
+	if err != nil {
+		err = fmt.Errorf("error preparing database statements: %v", err)
+		db.Close()
+		a.revokeDBLease(l)
+		return
+	}
+	conn = &database.Conn{DB: db, Stmts: stmtMap}
+	return
+}
+
+// revokeDBLease revokes the lease of dynamic database credentials that are no longer in use.
+func (a *App) revokeDBLease(l secretstore.Lease) {
+	if l.ID == "" {
+		return
+	}
+	if ls, ok := a.SecretStore.(secretstore.Leaser); ok {
+		if err := ls.Revoke(l); err != nil {
+			a.config().Logger().Errorf("error revoking database credentials: %v", err)
+		}
+	}
+}
+
+// dbCredentialsJob keeps the dynamic database credentials valid. Before the lease expires it is renewed and, when
+// the vault will not extend it any further, new credentials are obtained and the server switches to them.
+func (a *App) dbCredentialsJob() {
+	defer a.jobs.Done()
+	wait := renewIn(a.dbLease.Duration, a.config().Database.DynamicCredentials.RenewBefore)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+		wait = a.renewDBCredentials(a.config())
+	}
+}
+
+// renewIn returns how long to wait before renewing a lease of the duration given. The lease is renewed the
+// configured number of seconds before it expires but not before half of the lease has elapsed.
+func renewIn(d time.Duration, renewBefore int) time.Duration {
+	w := d - time.Duration(renewBefore)*time.Second
+	if w < d/2 {
+		w = d / 2
+	}
+	if w < time.Second {
+		w = time.Second
+	}
+	return w
+}
+
+// renewDBCredentials renews the lease of the database credentials or, if it cannot be extended for long enough,
+// replaces them. It returns how long to wait before the next renewal.
+func (a *App) renewDBCredentials(c *config.Config) time.Duration {
+	rb := time.Duration(c.Database.DynamicCredentials.RenewBefore) * time.Second
+	ls, ok := a.SecretStore.(secretstore.Leaser)
+	if !ok {
+		c.Logger().Warn("secret store does not support dynamic database credentials")
+		return dbRetryInterval
+	}
+	if a.dbLease.Renewable {
+		l, err := ls.Renew(a.dbLease, a.dbLease.Duration)
+		if err == nil && l.Duration > rb {
+			a.dbLease = l
+			return renewIn(l.Duration, c.Database.DynamicCredentials.RenewBefore)
+		}
+		if err != nil {
+			c.Logger().Errorf("error renewing database credentials, obtaining new credentials: %v", err)
+		}
+	}
+	if err := a.rotateDBCredentials(c); err != nil {
+		c.Logger().Errorf("error rotating database credentials: %v", err)
+		return dbRetryInterval
+	}
+	c.Logger().Info("database credentials rotated")
+	return renewIn(a.dbLease.Duration, c.Database.DynamicCredentials.RenewBefore)
+}
+
+// rotateDBCredentials connects to the database with new credentials and switches the server to the new connection and
+// statements. The previous connection is closed, and its credentials revoked, once the operations using it have
+// completed.
+func (a *App) rotateDBCredentials(c *config.Config) error {
+	conn, l, err := a.connectDB(c)
+	if err != nil {
+		return err
+	}
+	a.mux.Lock()
+	oldLease := a.dbLease
+	a.dbLease = l
+	a.mux.Unlock()
+	a.Database.Replace(conn, func() {
+		a.revokeDBLease(oldLease)
+	})
+	return nil
+}
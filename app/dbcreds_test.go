@@ -0,0 +1,23 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRenewIn(t *testing.T) {
+	var tests = []struct {
+		Lease       time.Duration
+		RenewBefore int
+		Wait        time.Duration
+	}{
+		{time.Hour, 300, 55 * time.Minute},
+		{10 * time.Minute, 300, 5 * time.Minute},
+		{5 * time.Minute, 300, 150 * time.Second},
+		{0, 300, time.Second},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Wait, renewIn(test.Lease, test.RenewBefore), "Wait before renewing %v lease not as expected", test.Lease)
+	}
+}
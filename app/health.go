@@ -0,0 +1,61 @@
+package app
+
+import (
+	"errors"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/health"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"gopkg.in/jcmturner/gokrb5.v4/keytab"
+	"strings"
+)
+
+// healthChecker returns the checks of the dependencies the server needs to be able to serve requests.
+func (a *App) healthChecker(c *config.Config) *health.Checker {
+	hc := health.NewChecker()
+	hc.Add("database", func() error {
+		conn, release := a.Database.Acquire()
+		defer release()
+		return conn.DB.Ping()
+	})
+	// The database credentials are not read to check the secret store as, when they are dynamic, every read issues new
+	// credentials. The app ID store cannot be checked without reading a secret so it is left unchecked.
+	if sc, ok := a.SecretStore.(secretstore.Checker); ok {
+		hc.Add("secret store", sc.Check)
+	}
+	if c.Server.Authentication.Kerberos.Enabled {
+		hc.Add("kerberos keytab", func() error {
+			return keytabPresent(c.Server.Authentication.Kerberos.Keytab)
+		})
+	}
+	if c.Server.Authentication.Basic.Enabled {
+		switch strings.ToLower(c.Server.Authentication.Basic.Protocol) {
+		case "ldap":
+			hc.Add("ldap", func() error {
+				// A new connection is used so that the check does not interfere with the connection used for
+				// authentication. The checker reuses its report so this binds at most once every MaxAge.
+				l := c.Server.Authentication.Basic.LDAP
+				lc, err := ldapConn(l)
+				if err != nil {
+					return err
+				}
+				defer lc.Close()
+				return lc.Bind(l.BindUserDN, l.BindUserPassword)
+			})
+		case "kerberos":
+			hc.Add("basic kerberos keytab", func() error {
+				return keytabPresent(c.Server.Authentication.Basic.Kerberos.Keytab)
+			})
+		}
+	}
+	return hc
+}
+
+func keytabPresent(kt *keytab.Keytab) error {
+	if kt == nil {
+		return errors.New("keytab not loaded")
+	}
+	if len(kt.Entries) < 1 {
+		return errors.New("keytab has no entries")
+	}
+	return nil
+}
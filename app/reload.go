@@ -0,0 +1,122 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/health"
+	"github.com/jcmturner/awsfederation/httphandling"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"strings"
+	"time"
+)
+
+// config returns the current configuration, which is replaced when the configuration is reloaded.
+func (a *App) config() *config.Config {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	return a.Config
+}
+
+func (a *App) newRouter(c *config.Config, hc *health.Checker) *mux.Router {
+	return httphandling.NewRouter(c, httphandling.Dependencies{
+		Database:        a.Database,
+		FedUserCache:    a.FedUserCache,
+		RateLimiter:     a.RateLimiter,
+		CredCache:       a.CredCache,
+		CatalogueSyncer: a.CatalogueSyncer,
+		HealthChecker:   hc,
+		Reload:          a.Reload,
+	})
+}
+
+func (a *App) loadCertificate(c *config.Config) error {
+	cert, err := tls.LoadX509KeyPair(c.Server.TLS.CertificateFile, c.Server.TLS.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+	a.cert.Store(&cert)
+	return nil
+}
+
+// Reload loads the configuration file again and applies the settings that can be changed while the server is running:
+// the log destinations, the TLS certificate and the authentication settings including the LDAP connection.
+// The new settings are validated before any are applied. Requests being served when the configuration is reloaded
+// complete with the previous settings.
+func (a *App) Reload() (rep config.ReloadReport, err error) {
+	a.reloadMux.Lock()
+	defer a.reloadMux.Unlock()
+	cur := a.config()
+	defer func() {
+		if err != nil {
+			cur.Logger().Errorf("configuration reload failed: %v", err)
+		}
+	}()
+	if a.ConfigPath == "" {
+		err = errors.New("configuration was not loaded from a file")
+		return
+	}
+	nc, err := config.Load(a.ConfigPath)
+	if err != nil {
+		return
+	}
+	c, rep := cur.Reload(nc)
+	// The secret store settings require a restart so the current store, and its login, is used with the new settings.
+	secretstore.Set(c, a.SecretStore)
+
+	// Load and validate the new settings. If any are invalid the current configuration is left in place.
+	if err = CheckConfig(c); err != nil {
+		a.retire(c)
+		return
+	}
+	if err = a.loadAuthentication(c); err != nil {
+		a.retire(c)
+		return
+	}
+	var cert tls.Certificate
+	if c.Server.TLS.Enabled {
+		cert, err = tls.LoadX509KeyPair(c.Server.TLS.CertificateFile, c.Server.TLS.KeyFile)
+		if err != nil {
+			err = fmt.Errorf("could not load TLS certificate: %v", err)
+			a.retire(c)
+			return
+		}
+	}
+
+	// Build a new router using the new configuration and swap it in for new requests.
+	a.setAuditStore(c)
+	hc := a.healthChecker(c)
+	rt := a.newRouter(c, hc)
+	a.mux.Lock()
+	a.Config = c
+	a.HealthChecker = hc
+	a.Router = rt
+	a.mux.Unlock()
+	a.handler.Store(rt)
+	if c.Server.TLS.Enabled {
+		a.cert.Store(&cert)
+	}
+
+	// Requests in flight may still be using the previous loggers and LDAP connection so allow them to complete
+	// before these are closed.
+	time.AfterFunc(time.Duration(cur.Server.Timeouts.Write)*time.Second, func() {
+		a.retire(cur)
+	})
+	c.Logger().Infof("configuration reloaded from %s. Settings changed that require a restart: [%s]", a.ConfigPath, strings.Join(rep.RestartRequired, ", "))
+	return
+}
+
+// retire closes the LDAP connection and log files of a configuration that is no longer in use.
+func (a *App) retire(c *config.Config) {
+	secretstore.Release(c)
+	auditstore.Release(c)
+	if lc := c.Server.Authentication.Basic.LDAP.LDAPConn; lc != nil {
+		lc.Close()
+	}
+	if err := c.CloseLogs(); err != nil {
+		a.config().Logger().Errorf("error closing log files: %v", err)
+	}
+}
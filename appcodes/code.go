@@ -23,4 +23,20 @@ const (
 	RoleMappingAlreadyExists    = 62
 	AccountUnknown              = 71
 	AccountAlreadyExists        = 72
+	AccountInUse                = 73
+	AccountDeleted              = 74
+	AccessRequestError          = 80
+	AccessRequestUnknown        = 81
+	AccessRequestAlreadyExists  = 82
+	AccessRequestNotPending     = 83
+	AccessRequestNoApprovers    = 84
+	AccessApproverUnknown       = 91
+	BreakGlassError             = 100
+	BreakGlassNotJustified      = 101
+	BreakGlassNotPermitted      = 102
+	RateLimitExceeded           = 110
+	PreconditionFailed          = 120
+	PreconditionRequired        = 121
+	CatalogueError              = 130
+	CatalogueManaged            = 131
 )
@@ -2,6 +2,7 @@ package appcodes
 
 import (
 	"fmt"
+	"time"
 )
 
 type ErrInvalidAuthentication struct {
@@ -24,6 +25,22 @@ type ErrFederationUserNotFound struct {
 	Text    string
 }
 
+type ErrAccessRequest struct {
+	AppCode int
+	Text    string
+}
+
+type ErrBreakGlass struct {
+	AppCode int
+	Text    string
+}
+
+type ErrRateLimited struct {
+	AppCode    int
+	Text       string
+	RetryAfter time.Duration
+}
+
 type ErrBadPostData struct {
 	Code int
 	Text string
@@ -34,7 +51,7 @@ func (e ErrInvalidAuthentication) Error() string {
 }
 
 func (e ErrInvalidAuthentication) Errorf(format string, a ...interface{}) ErrInvalidAuthentication {
-	e.Text = fmt.Sprintf(format, a)
+	e.Text = fmt.Sprintf(format, a...)
 	e.AppCode = InvalidAuthentication
 	return e
 }
@@ -44,7 +61,7 @@ func (e ErrUnauthorized) Error() string {
 }
 
 func (e ErrUnauthorized) Errorf(format string, a ...interface{}) ErrUnauthorized {
-	e.Text = fmt.Sprintf(format, a)
+	e.Text = fmt.Sprintf(format, a...)
 	e.AppCode = Unauthorized
 	return e
 }
@@ -54,7 +71,7 @@ func (e ErrAssumeRoleFailure) Error() string {
 }
 
 func (e ErrAssumeRoleFailure) Errorf(format string, a ...interface{}) ErrAssumeRoleFailure {
-	e.Text = fmt.Sprintf(format, a)
+	e.Text = fmt.Sprintf(format, a...)
 	e.AppCode = AssumeRoleError
 	return e
 }
@@ -64,17 +81,55 @@ func (e ErrFederationUserNotFound) Error() string {
 }
 
 func (e ErrFederationUserNotFound) Errorf(format string, a ...interface{}) ErrFederationUserNotFound {
-	e.Text = fmt.Sprintf(format, a)
+	e.Text = fmt.Sprintf(format, a...)
 	e.AppCode = FederationUserUnknown
 	return e
 }
 
+func (e ErrAccessRequest) Error() string {
+	return e.Text
+}
+
+// Errorf sets the text of the error. The AppCode should be set to one of the AccessRequest codes, if not set it
+// defaults to AccessRequestError.
+func (e ErrAccessRequest) Errorf(format string, a ...interface{}) ErrAccessRequest {
+	e.Text = fmt.Sprintf(format, a...)
+	if e.AppCode == 0 {
+		e.AppCode = AccessRequestError
+	}
+	return e
+}
+
+func (e ErrBreakGlass) Error() string {
+	return e.Text
+}
+
+// Errorf sets the text of the error. The AppCode should be set to one of the BreakGlass codes, if not set it
+// defaults to BreakGlassError.
+func (e ErrBreakGlass) Errorf(format string, a ...interface{}) ErrBreakGlass {
+	e.Text = fmt.Sprintf(format, a...)
+	if e.AppCode == 0 {
+		e.AppCode = BreakGlassError
+	}
+	return e
+}
+
+func (e ErrRateLimited) Error() string {
+	return e.Text
+}
+
+func (e ErrRateLimited) Errorf(format string, a ...interface{}) ErrRateLimited {
+	e.Text = fmt.Sprintf(format, a...)
+	e.AppCode = RateLimitExceeded
+	return e
+}
+
 func (e ErrBadPostData) Error() string {
 	return e.Text
 }
 
 func (e ErrBadPostData) Errorf(format string, a ...interface{}) ErrBadPostData {
-	e.Text = fmt.Sprintf(format, a)
+	e.Text = fmt.Sprintf(format, a...)
 	e.Code = BadData
 	return e
 }
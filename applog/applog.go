@@ -0,0 +1,286 @@
+// Package applog provides the structured, leveled logger used for the application log. Records are written as JSON
+// objects or logfmt lines with the time, level and message followed by the logger's and the record's fields.
+// It also carries the request ID through a request's context so that the application, access and audit log records
+// of a request can be correlated.
+package applog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	FormatJSON   = "JSON"
+	FormatLogfmt = "logfmt"
+
+	// KeyRequestID is the field holding the ID of the request a record was logged for.
+	KeyRequestID = "request_id"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if s, ok := levelNames[l]; ok {
+		return s
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel returns the level with the name given. An empty name is the info level.
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return LevelInfo, nil
+	}
+	for l, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return l, nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s, must be debug, info, warn or error", s)
+}
+
+// ValidFormat checks the format is JSON or logfmt. An empty format is logfmt.
+func ValidFormat(s string) error {
+	if s == "" || strings.EqualFold(s, FormatJSON) || strings.EqualFold(s, FormatLogfmt) {
+		return nil
+	}
+	return fmt.Errorf("unknown log format %s, must be JSON or logfmt", s)
+}
+
+// output is the destination shared by a logger and those derived from it with With.
+type output struct {
+	mux   sync.Mutex
+	w     io.Writer
+	json  bool
+	level Level
+	now   func() time.Time
+}
+
+// Logger writes structured log records at or above its level. Fields are given as alternating keys and values.
+// A Logger is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New returns a logger writing records of the level given and above to w in the format given.
+func New(w io.Writer, format string, level Level) *Logger {
+	return &Logger{
+		out: &output{
+			w:     w,
+			json:  strings.EqualFold(format, FormatJSON),
+			level: level,
+			now:   func() time.Time { return time.Now().UTC() },
+		},
+	}
+}
+
+// With returns a logger that adds the fields given to every record it writes.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fs := make([]interface{}, 0, len(l.fields)+len(kv))
+	fs = append(fs, l.fields...)
+	fs = append(fs, kv...)
+	return &Logger{out: l.out, fields: fs}
+}
+
+// Enabled indicates if records of the level given are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(LevelDebug, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.Log(LevelInfo, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.Log(LevelWarn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(LevelError, msg, kv...) }
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(LevelInfo, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(LevelWarn, format, v...) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
+
+func (l *Logger) logf(level Level, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.Log(level, fmt.Sprintf(format, v...))
+}
+
+// Log writes a record with the message and fields given if the level is enabled.
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fs := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fs = append(fs, "time", l.out.now(), "level", level.String(), "msg", strings.TrimRight(msg, "\n"))
+	fs = append(fs, l.fields...)
+	fs = append(fs, kv...)
+	var b []byte
+	if l.out.json {
+		b = encodeJSON(fs)
+	} else {
+		b = encodeLogfmt(fs)
+	}
+	l.out.mux.Lock()
+	defer l.out.mux.Unlock()
+	l.out.w.Write(b)
+}
+
+// pairs calls f for each key and value. A key without a value is given the value "MISSING".
+func pairs(kv []interface{}, f func(k string, v interface{})) {
+	for i := 0; i < len(kv); i += 2 {
+		k := fmt.Sprint(kv[i])
+		var v interface{} = "MISSING"
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		f(k, v)
+	}
+}
+
+// value returns the value to be logged, formatting errors, times and other values that do not encode usefully.
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return t
+	default:
+		return fmt.Sprintf("%+v", t)
+	}
+}
+
+func encodeJSON(kv []interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	first := true
+	pairs(kv, func(k string, v interface{}) {
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		kb, _ := json.Marshal(k)
+		b.Write(kb)
+		b.WriteByte(':')
+		vb, err := json.Marshal(value(v))
+		if err != nil {
+			vb, _ = json.Marshal(fmt.Sprint(v))
+		}
+		b.Write(vb)
+	})
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func encodeLogfmt(kv []interface{}) []byte {
+	var b bytes.Buffer
+	first := true
+	pairs(kv, func(k string, v interface{}) {
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(logfmtKey(k))
+		b.WriteByte('=')
+		var s string
+		switch t := value(v).(type) {
+		case nil:
+			s = "null"
+		case string:
+			s = t
+		default:
+			s = fmt.Sprint(t)
+		}
+		if needsQuoting(s) {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	})
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// logfmtKey removes the characters not permitted in a logfmt key.
+func logfmtKey(k string) string {
+	k = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+	if k == "" {
+		return "_"
+	}
+	return k
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// WithRequestID returns a copy of the context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by the context or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ForRequest returns a logger that adds the request ID carried by the context, if any, to its records.
+func (l *Logger) ForRequest(ctx context.Context) *Logger {
+	if id := RequestID(ctx); id != "" {
+		return l.With(KeyRequestID, id)
+	}
+	return l
+}
@@ -0,0 +1,69 @@
+package applog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testLogger(format string, level Level) (*Logger, *bytes.Buffer) {
+	var b bytes.Buffer
+	l := New(&b, format, level)
+	l.out.now = func() time.Time { return time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &b
+}
+
+func TestLogger_Logfmt(t *testing.T) {
+	l, b := testLogger(FormatLogfmt, LevelInfo)
+	l.With("component", "test").Error("could not do it", "error", errors.New("it failed"), "count", 2)
+	assert.Equal(t, `time=2018-01-02T03:04:05Z level=error msg="could not do it" component=test error="it failed" count=2`+"\n", b.String(), "logfmt record not as expected")
+}
+
+func TestLogger_JSON(t *testing.T) {
+	l, b := testLogger(FormatJSON, LevelInfo)
+	l.Infof("%d events purged from %s", 3, "db")
+	l.Warn("odd fields", "key")
+	var m map[string]interface{}
+	d := json.NewDecoder(b)
+	if err := d.Decode(&m); err != nil {
+		t.Fatalf("could not decode JSON record: %v", err)
+	}
+	assert.Equal(t, map[string]interface{}{"time": "2018-01-02T03:04:05Z", "level": "info", "msg": "3 events purged from db"}, m, "JSON record not as expected")
+	m = nil
+	if err := d.Decode(&m); err != nil {
+		t.Fatalf("could not decode JSON record: %v", err)
+	}
+	assert.Equal(t, "MISSING", m["key"], "value of key without a value not as expected")
+}
+
+func TestLogger_Level(t *testing.T) {
+	l, b := testLogger(FormatLogfmt, LevelWarn)
+	l.Debug("debug")
+	l.Infof("info")
+	assert.Equal(t, 0, b.Len(), "records below the level should not be written")
+	l.Warn("warn")
+	l.Errorf("error")
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte("\n")), "records at or above the level should be written")
+
+	for s, want := range map[string]Level{"": LevelInfo, "DEBUG": LevelDebug, "warning": LevelWarn, "error": LevelError} {
+		lvl, err := ParseLevel(s)
+		assert.NoError(t, err, "error parsing level %q", s)
+		assert.Equal(t, want, lvl, "level %q not as expected", s)
+	}
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err, "expected error for unknown level")
+}
+
+func TestLogger_ForRequest(t *testing.T) {
+	l, b := testLogger(FormatLogfmt, LevelInfo)
+	ctx := WithRequestID(context.Background(), "abc-123")
+	assert.Equal(t, "abc-123", RequestID(ctx), "request ID not carried by the context")
+	l.ForRequest(ctx).Info("handled")
+	l.ForRequest(context.Background()).Info("no request")
+	assert.Equal(t, "time=2018-01-02T03:04:05Z level=info msg=handled request_id=abc-123\n"+
+		`time=2018-01-02T03:04:05Z level=info msg="no request"`+"\n", b.String(), "records not as expected")
+}
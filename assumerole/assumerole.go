@@ -1,15 +1,24 @@
 package assumerole
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	awssts "github.com/aws/aws-sdk-go/service/sts"
 	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/accessrequest"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/breakglass"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/metrics"
+	"github.com/jcmturner/awsfederation/ratelimit"
 	"github.com/jcmturner/awsfederation/sts"
 	goidentity "gopkg.in/jcmturner/goidentity.v1"
 	"net/url"
@@ -26,15 +35,45 @@ type AuditDetail struct {
 	SessionDuration time.Duration
 	FederationUser  string
 	Comment         string
+	BreakGlass      bool   `json:",omitempty"`
+	Justification   string `json:",omitempty"`
+	CredentialCache string `json:",omitempty"`
 }
 
-func auditLog(l config.AuditLogLine, d AuditDetail, c *config.Config) {
+const (
+	CredentialCacheHit  = "Hit"
+	CredentialCacheMiss = "Miss"
+)
+
+func auditLog(ctx context.Context, l config.AuditLogLine, d AuditDetail, c *config.Config) {
 	b, _ := json.Marshal(d)
 	l.Detail = url.QueryEscape(string(b))
-	c.AccessLog(l)
+	l.Outcome = config.AuditOutcomeFailure
+	if d.Successful {
+		l.Outcome = config.AuditOutcomeSuccess
+	}
+	ref := auditstore.Reference{
+		RoleMappingID: d.RoleMappingID,
+		AccountID:     metrics.AccountFromARN(d.RoleArn),
+	}
+	if d.FederationUser != "NA" {
+		ref.FederationUser = d.FederationUser
+	}
+	if d.BreakGlass {
+		breakglass.Alert(l, "", d, c)
+		l.Severity = config.AuditSeverityHigh
+	} else {
+		c.AuditLog(l)
+	}
+	auditstore.Record(ctx, c, l, ref)
 }
 
-func Federate(u goidentity.Identity, id string, stmtMap database.StmtMap, fc *federationuser.FedUserCache, c *config.Config) (o *awssts.AssumeRoleOutput, err error) {
+// Federate assumes the role of the role mapping on behalf of the user.
+// A justification must be provided for break-glass role mappings and is otherwise ignored.
+// Credentials previously issued to the user's session for the role mapping are returned from the credential cache
+// when available. Break-glass credentials are never cached.
+// The ID of the request carried by the context is recorded in the audit and application logs.
+func Federate(ctx context.Context, u goidentity.Identity, id, justification string, stmtMap database.StmtMap, fc *federationuser.FedUserCache, rl *ratelimit.Limiter, cc *credcache.Cache, c *config.Config) (o *awssts.AssumeRoleOutput, err error) {
 	eventUUID, err := uuid.GenerateUUID()
 	if err != nil {
 		return
@@ -46,85 +85,172 @@ func Federate(u goidentity.Identity, id string, stmtMap database.StmtMap, fc *fe
 		EventType:     "AssumeRoleFederation",
 		Time:          time.Now().UTC(),
 		UUID:          eventUUID,
+		RequestID:     applog.RequestID(ctx),
 	}
+	l := c.Logger().ForRequest(ctx).With("event_uuid", eventUUID, "role_mapping", id)
 	d := AuditDetail{
 		RoleMappingID:   id,
 		RoleSessionName: "NA",
 		SessionDuration: time.Duration(0),
 		FederationUser:  "NA",
 	}
+	defer func() {
+		outcome := metrics.OutcomeSuccess
+		if err != nil {
+			outcome = metrics.OutcomeError
+			switch err.(type) {
+			case appcodes.ErrUnauthorized, appcodes.ErrBreakGlass, appcodes.ErrRateLimited:
+				outcome = metrics.OutcomeFailure
+			}
+		}
+		metrics.ObserveFederation(id, d.RoleArn, outcome)
+	}()
+
+	// Emergency identities may only use break-glass role mappings which in turn must be justified.
+	emergency := breakglass.EmergencyIdentity(u, c)
+	if breakglass.RoleMapping(id, c) {
+		auditLine.EventType = "BreakGlassAssumeRoleFederation"
+		d.BreakGlass = true
+		d.Justification = justification
+		if err = breakglass.ValidateJustification(justification); err != nil {
+			d.Comment = err.Error()
+			auditLog(ctx, auditLine, d, c)
+			return
+		}
+	} else if emergency {
+		d.Comment = "Access denied, emergency identities may only assume break-glass role mappings"
+		err = appcodes.ErrBreakGlass{AppCode: appcodes.BreakGlassNotPermitted}.Errorf(d.Comment)
+		auditLog(ctx, auditLine, d, c)
+		return
+	}
 
 	var authzed bool
-	authzed, err = Authorize(u, id, stmtMap)
+	if emergency {
+		// The identity provider holding the user's authorization attributes may be the reason for using break-glass.
+		authzed = true
+	} else {
+		authzed, err = Authorize(ctx, u, id, stmtMap)
+	}
 	if err != nil {
 		d.Comment = fmt.Sprintf("Authorization check failed due to error: [%v]", err)
-		c.ApplicationLogf("%v Request: %+v Details: %+v", err, auditLine, d)
-		auditLog(auditLine, d, c)
+		l.Error(err.Error(), "username", u.UserName(), "user_domain", u.Domain(), "role", d.RoleArn)
+		auditLog(ctx, auditLine, d, c)
 		return
 	}
 	if authzed {
-		role, fu, duration, policy, roleSessionNameFmt, e := RoleMappingLookup(id, stmtMap)
+		role, fu, duration, policy, roleSessionNameFmt, e := RoleMappingLookup(ctx, id, stmtMap)
 		if e != nil {
 			err = fmt.Errorf("Error getting role mapping details during federation: [%v]", e)
 			d.Comment = err.Error()
-			c.ApplicationLogf("%v Request: %+v Details: %+v", err, auditLine, d)
-			auditLog(auditLine, d, c)
+			l.Error(err.Error(), "username", u.UserName(), "user_domain", u.Domain(), "role", d.RoleArn)
+			auditLog(ctx, auditLine, d, c)
 			return
 		}
 		d.RoleArn = role
 		d.FederationUser = fu
-		o, err = sts.Federate(c, fc, fu, role, roleSessionNamef(roleSessionNameFmt, u), policy, duration)
+		if d.BreakGlass {
+			duration = breakglass.ClampDuration(duration, c)
+		}
+		d.SessionDuration = time.Duration(duration) * time.Second
+		// Credentials returned from the cache count against the rate limits as much as those issued.
+		if err = rl.Allow(ctx, u, id, fu); err != nil {
+			d.Comment = fmt.Sprintf("Request not permitted: [%v]", err)
+			auditLog(ctx, auditLine, d, c)
+			return
+		}
+		if cc != nil && !d.BreakGlass {
+			// Credentials for another role or issued by another federation user are stale as the role mapping has
+			// been changed, possibly on another server.
+			if cr, ok := cc.Get(u, id, time.Now().UTC()); ok && cr.RoleArn == role && cr.FedUserArn == fu {
+				d.Successful = true
+				d.CredentialCache = CredentialCacheHit
+				auditLog(ctx, auditLine, d, c)
+				return cr.Output, nil
+			}
+			d.CredentialCache = CredentialCacheMiss
+		}
+		d.RoleSessionName = roleSessionNamef(roleSessionNameFmt, u)
+		o, err = sts.Federate(ctx, c, fc, fu, role, d.RoleSessionName, policy, duration)
 		if err != nil {
 			err = fmt.Errorf("Error performing federation: [%v]", err)
-			d.RoleSessionName = o.AssumedRoleUser.String()
-			d.SessionDuration = time.Duration(duration)
 			d.Comment = err.Error()
-			c.ApplicationLogf("%v Request: %+v Details: %+v", err, auditLine, d)
-			auditLog(auditLine, d, c)
+			l.Error(err.Error(), "username", u.UserName(), "user_domain", u.Domain(), "role", d.RoleArn)
+			auditLog(ctx, auditLine, d, c)
 			return
 		}
+		d.Successful = true
+		if d.CredentialCache == CredentialCacheMiss {
+			if e := cc.Put(u, id, role, fu, o); e != nil {
+				l.Errorf("error caching credentials for role mapping %s: %v", id, e)
+			}
+		}
+		auditLog(ctx, auditLine, d, c)
 		return
 	} else {
 		d.Comment = "Access denied, user not authorized"
 		err = appcodes.ErrUnauthorized{}.Errorf(d.Comment)
-		auditLog(auditLine, d, c)
+		auditLog(ctx, auditLine, d, c)
 		return
 	}
 }
 
-func Authorize(u goidentity.Identity, id string, stmtMap database.StmtMap) (bool, error) {
+func Authorize(ctx context.Context, u goidentity.Identity, id string, stmtMap database.StmtMap) (bool, error) {
 	// Validate id format. Ensure no SQL injection.
 	if _, err := uuid.ParseUUID(id); err != nil {
 		return false, errors.New("Role mapping ID not valid")
 	}
 	if stmt, ok := stmtMap[database.StmtKeyAuthzCheck]; ok {
-		rows, err := stmt.Query(id)
+		rows, err := database.Query(ctx, stmt, id)
 		if err != nil {
 			return false, err
 		}
 		defer rows.Close()
 		var a string
+		var from, until *time.Time
+		var schedule *string
+		var active bool
+		now := time.Now().UTC()
 		for rows.Next() {
-			err := rows.Scan(&a)
+			err := rows.Scan(&a, &from, &until, &schedule)
 			if err != nil {
 				return false, err
 			}
-			if u.Authorized(a) {
+			v, err := authz.NewValidity(from, until, schedule)
+			if err != nil {
+				return false, fmt.Errorf("error evaluating validity of role mapping %s: %v", id, err)
+			}
+			if !v.Active(now) {
+				continue
+			}
+			active = true
+			ok, err := authz.Authorized(u, a)
+			if err != nil {
+				return false, fmt.Errorf("error evaluating authorization for role mapping %s: %v", id, err)
+			}
+			if ok {
 				return true, nil
 			}
 		}
-		return false, nil
+		if !active {
+			return false, nil
+		}
+		// Without standing authorization the user may hold an approved just-in-time grant.
+		granted, _, err := accessrequest.Granted(ctx, u, id, now, stmtMap)
+		if err != nil {
+			return false, fmt.Errorf("error checking access grants for role mapping %s: %v", id, err)
+		}
+		return granted, nil
 	}
 	return false, errors.New("Prepared statement for DB authorization check not found")
 }
 
-func RoleMappingLookup(id string, stmtMap database.StmtMap) (role string, fuStr string, duration int64, policyStr string, roleSessionNameFmt string, err error) {
+func RoleMappingLookup(ctx context.Context, id string, stmtMap database.StmtMap) (role string, fuStr string, duration int64, policyStr string, roleSessionNameFmt string, err error) {
 	// Validate id format. Ensure no SQL injection.
 	if _, err = uuid.ParseUUID(id); err != nil {
 		return
 	}
 	if stmt, ok := stmtMap[database.StmtKeyRoleMappingLookup]; ok {
-		err := stmt.QueryRow(id).Scan(&role, &fuStr, &duration, &policyStr, &roleSessionNameFmt)
+		err := database.QueryRow(ctx, stmt, id).Scan(&role, &fuStr, &duration, &policyStr, &roleSessionNameFmt)
 		if err != nil {
 			return role, fuStr, duration, policyStr, roleSessionNameFmt, err
 		}
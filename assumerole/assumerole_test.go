@@ -1,13 +1,28 @@
 package assumerole
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	awssts "github.com/aws/aws-sdk-go/service/sts"
 	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/accessrequest"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/ratelimit"
 	"github.com/jcmturner/goidentity"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/url"
 	"regexp"
 	"testing"
+	"time"
 )
 
 const (
@@ -32,23 +47,29 @@ func TestAuthorize(t *testing.T) {
 	}
 
 	roleMappingID, _ := uuid.GenerateUUID()
-	rows := sqlmock.NewRows([]string{"authzAttribute"}).
-		AddRow(authzAttrib).
-		AddRow("otherAttrib")
+	cols := []string{"authzAttribute", "validFrom", "validUntil", "schedule"}
+	attribRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(cols).
+			AddRow(authzAttrib, nil, nil, nil).
+			AddRow("otherAttrib", nil, nil, nil)
+	}
+	rows := attribRows()
 
 	user := goidentity.NewUser("testuser")
 	user.AddAuthzAttribute(authzAttrib)
 
 	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
-	authz, err := Authorize(&user, roleMappingID, *stmtMap)
+	authz, err := Authorize(context.Background(), &user, roleMappingID, *stmtMap)
 	if err != nil {
 		t.Fatalf("Error in authorization: %v", err)
 	}
 	assert.True(t, authz, "User should be authorized but is not.")
 
 	user.DisableAuthzAttribute(authzAttrib)
+	rows = attribRows()
 	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
-	authz, err = Authorize(&user, roleMappingID, *stmtMap)
+	ep[database.StmtKeyAccessGrantCheck].ExpectQuery().WithArgs(roleMappingID, "testuser", "", accessrequest.StatusApproved, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"validUntil"}))
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
 	if err != nil {
 		t.Fatalf("Error in authorization: %v", err)
 	}
@@ -56,12 +77,246 @@ func TestAuthorize(t *testing.T) {
 
 	user.EnableAuthzAttribute(authzAttrib)
 	user.RemoveAuthzAttribute(authzAttrib)
+	rows = attribRows()
 	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
-	authz, err = Authorize(&user, roleMappingID, *stmtMap)
+	ep[database.StmtKeyAccessGrantCheck].ExpectQuery().WithArgs(roleMappingID, "testuser", "", accessrequest.StatusApproved, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"validUntil"}))
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
 	if err != nil {
 		t.Fatalf("Error in authorization: %v", err)
 	}
 	assert.False(t, authz, "User should be not be authorized without attribute")
+
+	rows = sqlmock.NewRows(cols).
+		AddRow(`glob("attrib*") AND NOT "otherAttrib"`, nil, nil, nil)
+	user.AddAuthzAttribute(authzAttrib)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.True(t, authz, "User should be authorized by the expression but is not.")
+
+	rows = sqlmock.NewRows(cols).
+		AddRow(`glob("attrib*" AND`, nil, nil, nil)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	assert.Error(t, err, "Invalid authorization expression should return an error")
+	assert.False(t, authz, "User should be not be authorized by an invalid expression")
+
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, past, future, nil)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.True(t, authz, "User should be authorized within the validity period")
+
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, nil, past, nil)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.False(t, authz, "User should be not be authorized by an expired role mapping")
+
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, future, nil, nil)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.False(t, authz, "User should be not be authorized before the role mapping is valid")
+
+	outside := fmt.Sprintf(`{"Windows":[{"Start":"%s","End":"%s"}]}`, future.Format("15:04"), future.Add(time.Minute).Format("15:04"))
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, nil, nil, outside)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.False(t, authz, "User should be not be authorized outside of the role mapping's schedule")
+
+	inside := fmt.Sprintf(`{"Timezone":"UTC","Windows":[{"Start":"%s","End":"%s"}]}`, past.Format("15:04"), future.Format("15:04"))
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, nil, nil, inside)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.True(t, authz, "User should be authorized within the role mapping's schedule")
+
+	// Approved just-in-time access grant
+	user.RemoveAuthzAttribute(authzAttrib)
+	rows = sqlmock.NewRows(cols).
+		AddRow(authzAttrib, nil, nil, nil)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(rows)
+	ep[database.StmtKeyAccessGrantCheck].ExpectQuery().WithArgs(roleMappingID, "testuser", "", accessrequest.StatusApproved, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"validUntil"}).AddRow(future))
+	authz, err = Authorize(context.Background(), &user, roleMappingID, *stmtMap)
+	if err != nil {
+		t.Fatalf("Error in authorization: %v", err)
+	}
+	assert.True(t, authz, "User should be authorized by an approved access grant")
+}
+
+func TestFederateBreakGlass(t *testing.T) {
+	c := config.NewConfig()
+	c.SetAuditLogFile("null")
+	bgRoleMappingID, _ := uuid.GenerateUUID()
+	roleMappingID, _ := uuid.GenerateUUID()
+	c.Server.BreakGlass.Enabled = true
+	c.Server.BreakGlass.RoleMappings = []string{bgRoleMappingID}
+
+	user := goidentity.NewUser("emergency1")
+	user.SetDomain("BREAKGLASS")
+	_, err := Federate(context.Background(), &user, roleMappingID, "outage", database.StmtMap{}, nil, nil, nil, c)
+	if assert.Error(t, err, "emergency identity should not be able to assume a role mapping that is not break-glass") {
+		e, ok := err.(appcodes.ErrBreakGlass)
+		assert.True(t, ok, "error not of the expected type")
+		assert.Equal(t, appcodes.BreakGlassNotPermitted, e.AppCode, "app code not as expected")
+	}
+
+	_, err = Federate(context.Background(), &user, bgRoleMappingID, "", database.StmtMap{}, nil, nil, nil, c)
+	if assert.Error(t, err, "break-glass role mapping should require a justification") {
+		e, ok := err.(appcodes.ErrBreakGlass)
+		assert.True(t, ok, "error not of the expected type")
+		assert.Equal(t, appcodes.BreakGlassNotJustified, e.AppCode, "app code not as expected")
+	}
+}
+
+func TestFederateCachedCredentials(t *testing.T) {
+	c := config.NewConfig()
+	var buf bytes.Buffer
+	c.SetAuditLogger(json.NewEncoder(&buf))
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ep := make(map[int]*sqlmock.ExpectedPrepare)
+	for _, stmt := range database.Statements() {
+		ep[stmt.ID] = mock.ExpectPrepare(regexp.QuoteMeta(stmt.Query))
+	}
+	stmtMap, err := database.NewStmtMap(db)
+	if err != nil {
+		t.Fatalf("Error creating statement map: %v", err)
+	}
+
+	roleMappingID, _ := uuid.GenerateUUID()
+	roleArn := "arn:aws:iam::201345678912:role/role-name"
+	fedUserArn := "arn:aws:iam::012345678912:user/feduser"
+	user := goidentity.NewUser("testuser")
+	user.AddAuthzAttribute(authzAttrib)
+	expectFederation := func() {
+		ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).
+			WillReturnRows(sqlmock.NewRows([]string{"authzAttribute", "validFrom", "validUntil", "schedule"}).AddRow(authzAttrib, nil, nil, nil))
+		ep[database.StmtKeyRoleMappingLookup].ExpectQuery().WithArgs(roleMappingID).
+			WillReturnRows(sqlmock.NewRows([]string{"role.arn", "federationUser.arn", "duration", "policy", "roleSessionNameFormat"}).
+				AddRow(roleArn, fedUserArn, 3600, "", "${username}"))
+	}
+
+	cc, err := credcache.New(time.Minute * 5)
+	if err != nil {
+		t.Fatalf("error creating credential cache: %v", err)
+	}
+	cached := &awssts.AssumeRoleOutput{
+		Credentials: &awssts.Credentials{
+			AccessKeyId:     aws.String("ASIAEXAMPLE"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().UTC().Add(time.Hour)),
+		},
+	}
+	cc.Put(&user, roleMappingID, roleArn, fedUserArn, cached)
+	rl := ratelimit.NewLimiterWithStore(config.RateLimit{Enabled: true, User: config.Limit{Rate: 1, Burst: 1}}, ratelimit.NewMemoryStore())
+
+	expectFederation()
+	o, err := Federate(context.Background(), &user, roleMappingID, "", *stmtMap, nil, rl, cc, c)
+	if assert.NoError(t, err, "cached credentials should be returned") {
+		assert.Equal(t, "ASIAEXAMPLE", aws.StringValue(o.Credentials.AccessKeyId), "credentials not those cached")
+	}
+	var l config.AuditLogLine
+	if err := json.NewDecoder(&buf).Decode(&l); err != nil {
+		t.Fatalf("could not decode audit log line: %v", err)
+	}
+	var d AuditDetail
+	detail, _ := url.QueryUnescape(l.Detail)
+	json.Unmarshal([]byte(detail), &d)
+	assert.Equal(t, CredentialCacheHit, d.CredentialCache, "credential cache outcome not as expected")
+	assert.Equal(t, roleArn, d.RoleArn, "role of cached credentials not recorded")
+
+	// The cached credentials are still there but the user has used their burst
+	expectFederation()
+	_, err = Federate(context.Background(), &user, roleMappingID, "", *stmtMap, nil, rl, cc, c)
+	if assert.Error(t, err, "cached credentials should not be returned beyond the rate limit") {
+		_, ok := err.(appcodes.ErrRateLimited)
+		assert.True(t, ok, "error not of the expected type")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "federation queries not as expected")
+}
+
+// unavailableStore is a secret store that cannot be read.
+type unavailableStore struct{}
+
+func (unavailableStore) Read(p string) (map[string]interface{}, error) {
+	return nil, errors.New("secret store unavailable")
+}
+func (unavailableStore) Write(p string, m map[string]interface{}) error { return nil }
+func (unavailableStore) Delete(p string) error                          { return nil }
+func (unavailableStore) List(p string) ([]string, error)                { return nil, nil }
+
+func TestFederateSTSFailure(t *testing.T) {
+	c := config.NewConfig()
+	var buf bytes.Buffer
+	c.SetAuditLogger(json.NewEncoder(&buf))
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ep := make(map[int]*sqlmock.ExpectedPrepare)
+	for _, stmt := range database.Statements() {
+		ep[stmt.ID] = mock.ExpectPrepare(regexp.QuoteMeta(stmt.Query))
+	}
+	stmtMap, err := database.NewStmtMap(db)
+	if err != nil {
+		t.Fatalf("Error creating statement map: %v", err)
+	}
+
+	roleMappingID, _ := uuid.GenerateUUID()
+	fedUserArn := "arn:aws:iam::012345678912:user/feduser"
+	user := goidentity.NewUser("testuser")
+	user.AddAuthzAttribute(authzAttrib)
+	ep[database.StmtKeyAuthzCheck].ExpectQuery().WithArgs(roleMappingID).
+		WillReturnRows(sqlmock.NewRows([]string{"authzAttribute", "validFrom", "validUntil", "schedule"}).AddRow(authzAttrib, nil, nil, nil))
+	ep[database.StmtKeyRoleMappingLookup].ExpectQuery().WithArgs(roleMappingID).
+		WillReturnRows(sqlmock.NewRows([]string{"role.arn", "federationUser.arn", "duration", "policy", "roleSessionNameFormat"}).
+			AddRow("arn:aws:iam::201345678912:role/role-name", fedUserArn, 3600, "", "${username}"))
+	// The federation user's key cannot be read so no credentials are issued.
+	fc := federationuser.FedUserCache{fedUserArn: &federationuser.FederationUser{Provider: federationuser.NewProvider(unavailableStore{}, fedUserArn)}}
+
+	_, err = Federate(context.Background(), &user, roleMappingID, "", *stmtMap, &fc, nil, nil, c)
+	assert.Error(t, err, "federation should fail when credentials cannot be issued")
+
+	var l config.AuditLogLine
+	if err := json.NewDecoder(&buf).Decode(&l); err != nil {
+		t.Fatalf("failure not audited: %v", err)
+	}
+	assert.Equal(t, config.AuditOutcomeFailure, l.Outcome, "audit outcome not as expected")
+	var d AuditDetail
+	detail, _ := url.QueryUnescape(l.Detail)
+	json.Unmarshal([]byte(detail), &d)
+	assert.Equal(t, "testuser", d.RoleSessionName, "role session name not audited")
+	assert.Contains(t, d.Comment, "secret store unavailable", "audit comment not as expected")
 }
 
 func TestRoleMappingLookup(t *testing.T) {
@@ -93,7 +348,7 @@ func TestRoleMappingLookup(t *testing.T) {
 	}
 
 	ep[database.StmtKeyRoleMappingLookup].ExpectQuery().WithArgs(roleMappingID).WillReturnRows(roleMappingLookupRows)
-	role, fuStr, duration, policyStr, roleSessionNameFmt, err := RoleMappingLookup(roleMappingID, *stmtMap)
+	role, fuStr, duration, policyStr, roleSessionNameFmt, err := RoleMappingLookup(context.Background(), roleMappingID, *stmtMap)
 	if err != nil {
 		t.Fatalf("Error from RoleMappingLookup: %v", err)
 	}
@@ -0,0 +1,182 @@
+package assumerole
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/jcmturner/awsfederation/tracing"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ExpiryActionReport = "report"
+	ExpiryActionDelete = "delete"
+	expiryAuditUser    = "awsfederation"
+)
+
+type ExpiredRoleMapping struct {
+	RoleMappingID  string
+	AccountID      string
+	RoleArn        string
+	AuthzAttribute string
+	ValidUntil     time.Time
+	Deleted        bool
+	Comment        string `json:",omitempty"`
+	// Reported is true if the expiry of the role mapping has already been audited.
+	Reported bool `json:"-"`
+}
+
+// ValidExpiryAction checks that the action to take on expired role mappings is supported.
+func ValidExpiryAction(action string) error {
+	switch strings.ToLower(action) {
+	case ExpiryActionReport, ExpiryActionDelete:
+		return nil
+	}
+	return fmt.Errorf("invalid action (%s) for expired role mappings, must be %s or %s", action, ExpiryActionReport, ExpiryActionDelete)
+}
+
+// ExpiredRoleMappings returns the role mappings whose validity period has ended at the time provided.
+func ExpiredRoleMappings(ctx context.Context, t time.Time, stmtMap database.StmtMap) ([]ExpiredRoleMapping, error) {
+	stmt, ok := stmtMap[database.StmtKeyRoleMappingExpired]
+	if !ok {
+		return nil, errors.New("Prepared statement for DB expired role mapping lookup not found")
+	}
+	rows, err := database.Query(ctx, stmt, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rms []ExpiredRoleMapping
+	for rows.Next() {
+		var rm ExpiredRoleMapping
+		err := rows.Scan(&rm.RoleMappingID, &rm.AccountID, &rm.RoleArn, &rm.AuthzAttribute, &rm.ValidUntil, &rm.Reported)
+		if err != nil {
+			return nil, err
+		}
+		rms = append(rms, rm)
+	}
+	return rms, rows.Err()
+}
+
+// ProcessExpiredRoleMappings audits each expired role mapping and, if the action is delete, removes it. When the action
+// is report each role mapping is only audited once, rather than on every run, until it is updated.
+func ProcessExpiredRoleMappings(action string, conn *database.Conn, c *config.Config) (err error) {
+	if err := ValidExpiryAction(action); err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(context.Background(), "role mapping expiry")
+	defer func() { tracing.End(span, err) }()
+	stmtMap := *conn.Stmts
+	rms, err := ExpiredRoleMappings(ctx, time.Now().UTC(), stmtMap)
+	if err != nil {
+		return fmt.Errorf("error retrieving expired role mappings: %v", err)
+	}
+	var delStmtOK bool
+	if strings.ToLower(action) == ExpiryActionDelete {
+		if _, delStmtOK = stmtMap[database.StmtKeyRoleMappingDelete]; !delStmtOK {
+			return errors.New("Prepared statement for DB role mapping deletion not found")
+		}
+	} else if _, ok := stmtMap[database.StmtKeyRoleMappingExpiryReported]; !ok {
+		return errors.New("Prepared statement for DB role mapping expiry reported not found")
+	}
+	for _, rm := range rms {
+		eventType := "RoleMappingExpired"
+		if delStmtOK {
+			eventType = "RoleMappingExpiredDeleted"
+			deleted, err := deleteExpired(ctx, conn, rm)
+			rm.Deleted = deleted
+			if err != nil {
+				rm.Comment = fmt.Sprintf("error deleting expired role mapping: %v", err)
+				c.Logger().Error(rm.Comment, "role_mapping", rm.RoleMappingID)
+			}
+		} else {
+			if rm.Reported {
+				continue
+			}
+			// The role mapping is marked as reported before it is audited so that, when several servers run the job,
+			// only the one that marks it audits it.
+			reported, err := markReported(ctx, stmtMap, rm.RoleMappingID)
+			if err != nil {
+				c.Logger().Error(fmt.Sprintf("error marking expired role mapping as reported: %v", err), "role_mapping", rm.RoleMappingID)
+				continue
+			}
+			if !reported {
+				continue
+			}
+		}
+		auditExpiry(ctx, eventType, rm, c)
+	}
+	return nil
+}
+
+// deleteExpired deletes the expired role mapping and records the deletion in its history in one transaction, so that
+// it is only deleted if the deletion is also recorded. False is returned if it had already been deleted.
+func deleteExpired(ctx context.Context, conn *database.Conn, rm ExpiredRoleMapping) (bool, error) {
+	stmtMap := *conn.Stmts
+	tx, err := database.Begin(ctx, conn.DB, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	before, err := catalogue.LookupTx(ctx, tx, stmtMap, catalogue.KindRoleMapping, rm.RoleMappingID)
+	if err != nil {
+		return false, err
+	}
+	res, err := database.TxExec(ctx, tx, stmtMap[database.StmtKeyRoleMappingDelete], rm.RoleMappingID, 0, 0)
+	if err != nil {
+		return false, err
+	}
+	if i, err := res.RowsAffected(); err != nil || i != 1 {
+		return false, err
+	}
+	e, err := history.New(history.ResourceRoleMapping, rm.RoleMappingID, history.ActionDelete, history.SystemActor, before, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := history.RecordTx(ctx, tx, stmtMap, e); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// markReported records that the expiry of the role mapping has been audited. False is returned if it already had been.
+func markReported(ctx context.Context, stmtMap database.StmtMap, id string) (bool, error) {
+	res, err := database.Exec(ctx, stmtMap[database.StmtKeyRoleMappingExpiryReported], id)
+	if err != nil {
+		return false, err
+	}
+	i, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return i == 1, nil
+}
+
+func auditExpiry(ctx context.Context, eventType string, rm ExpiredRoleMapping, c *config.Config) {
+	eventUUID, _ := uuid.GenerateUUID()
+	b, _ := json.Marshal(rm)
+	l := config.AuditLogLine{
+		Username:  expiryAuditUser,
+		EventType: eventType,
+		Time:      time.Now().UTC(),
+		UUID:      eventUUID,
+		Detail:    url.QueryEscape(string(b)),
+	}
+	c.AuditLog(l)
+	auditstore.Record(ctx, c, l, auditstore.Reference{
+		RoleMappingID: rm.RoleMappingID,
+		AccountID:     rm.AccountID,
+	})
+}
@@ -0,0 +1,54 @@
+package assumerole
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessExpiredRoleMappingsReport(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	c := config.NewConfig()
+	var buf bytes.Buffer
+	c.SetAuditLogger(json.NewEncoder(&buf))
+
+	reportedID := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	newID := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	otherServerID := "16fd2706-8baf-433b-82eb-8c7fada847da"
+	until := time.Now().UTC().Add(-time.Hour)
+	ep[database.StmtKeyRoleMappingExpired].ExpectQuery().WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "account_id", "role_arn", "authz_attrib", "valid_until", "reported"}).
+			AddRow(reportedID, "123456789012", "arn:aws:iam::123456789012:role/one", "attrib1", until, true).
+			AddRow(newID, "123456789012", "arn:aws:iam::123456789012:role/two", "attrib1", until, false).
+			AddRow(otherServerID, "123456789012", "arn:aws:iam::123456789012:role/three", "attrib1", until, false))
+	ep[database.StmtKeyRoleMappingExpiryReported].ExpectExec().WithArgs(newID).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Another server reported this role mapping after it was read.
+	ep[database.StmtKeyRoleMappingExpiryReported].ExpectExec().WithArgs(otherServerID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := ProcessExpiredRoleMappings(ExpiryActionReport, &database.Conn{DB: db, Stmts: stmtMap}, c)
+	if err != nil {
+		t.Fatalf("error processing expired role mappings: %v", err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "expired role mappings not marked as reported")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Equal(t, 1, len(lines), "only the role mapping not yet reported should be audited") {
+		var l config.AuditLogLine
+		if err := json.Unmarshal([]byte(lines[0]), &l); err != nil {
+			t.Fatalf("could not unmarshal audit log line: %v", err)
+		}
+		assert.Equal(t, "RoleMappingExpired", l.EventType, "audit event type not as expected")
+		d, _ := url.QueryUnescape(l.Detail)
+		var rm ExpiredRoleMapping
+		json.Unmarshal([]byte(d), &rm)
+		assert.Equal(t, newID, rm.RoleMappingID, "role mapping audited not as expected")
+	}
+}
@@ -0,0 +1,330 @@
+// Package auditchain makes an audit log tamper-evident. Each event is written as a record chained to the previous
+// record with an HMAC, so that modifying, removing or reordering records breaks the chain, and checkpoints signed
+// with an Ed25519 key are written periodically so that the chain can be shown to have been written by the server.
+package auditchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeEvent      = "Event"
+	TypeCheckpoint = "Checkpoint"
+
+	// MinKeyLength is the minimum length in bytes of the HMAC key.
+	MinKeyLength = 32
+)
+
+// Record is a line of a chained audit log. The MAC covers the sequence number, type, the MAC of the previous record
+// and the body, which is the audit event or a Checkpoint.
+type Record struct {
+	Seq  uint64          `json:"Seq"`
+	Type string          `json:"Type"`
+	Prev string          `json:"Prev"`
+	MAC  string          `json:"MAC"`
+	Body json.RawMessage `json:"Body"`
+}
+
+// Checkpoint is signed to attest to the chain up to and including the previous record.
+type Checkpoint struct {
+	Time      time.Time `json:"Time"`
+	Signature string    `json:"Signature"`
+}
+
+func mac(key []byte, seq uint64, typ, prev string, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%d|%s|%s|", seq, typ, prev)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkpointMessage is the message signed for a checkpoint.
+func checkpointMessage(seq uint64, prev string, t time.Time) []byte {
+	return []byte(fmt.Sprintf("%d|%s|%s", seq, prev, t.UTC().Format(time.RFC3339Nano)))
+}
+
+// Writer writes audit events, each given as a JSON object in a single call to Write, as chained records.
+// It is safe for concurrent use.
+type Writer struct {
+	mux      sync.Mutex
+	path     string
+	f        *os.File
+	key      []byte
+	signer   ed25519.PrivateKey
+	interval int
+	seq      uint64
+	prev     string
+	pending  int
+	refs     int
+	stop     chan struct{}
+}
+
+var (
+	writersMux sync.Mutex
+	writers    = make(map[string]*Writer)
+)
+
+// Open opens the audit log file for appending chained records, continuing the chain of any records already in it.
+// A checkpoint is written after every interval events and, if there are events not yet covered by one, every period.
+// If the file is already open the same Writer is returned, and
+// its settings kept, so that the chain is not broken when the logs are reopened as the configuration is reloaded.
+// Each call to Open must be matched by a call to Close.
+func Open(path string, key []byte, signer ed25519.PrivateKey, interval int, period time.Duration) (*Writer, error) {
+	if len(key) < MinKeyLength {
+		return nil, fmt.Errorf("audit chain key must be at least %d bytes", MinKeyLength)
+	}
+	if signer == nil {
+		return nil, errors.New("audit chain signing key not defined")
+	}
+	if interval < 1 {
+		return nil, errors.New("audit checkpoint interval must be at least 1")
+	}
+	if period <= 0 {
+		return nil, errors.New("audit checkpoint period must be positive")
+	}
+	writersMux.Lock()
+	defer writersMux.Unlock()
+	if w, ok := writers[path]; ok {
+		w.refs++
+		return w, nil
+	}
+	seq, prev, err := tail(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not continue audit chain in %s: %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		path:     path,
+		f:        f,
+		key:      key,
+		signer:   signer,
+		interval: interval,
+		seq:      seq,
+		prev:     prev,
+		refs:     1,
+		stop:     make(chan struct{}),
+	}
+	writers[path] = w
+	go w.checkpoints(period)
+	return w, nil
+}
+
+// checkpoints writes a checkpoint every period if there are events not yet covered by one, so that the events written
+// when the server is quiet are signed without waiting for the interval to be reached.
+func (w *Writer) checkpoints(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mux.Lock()
+			if w.f != nil && w.pending > 0 {
+				if err := w.checkpoint(); err != nil {
+					fmt.Fprintf(os.Stderr, "could not write audit checkpoint to %s: %v\n", w.path, err)
+				}
+			}
+			w.mux.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// tail returns the sequence number and MAC of the last record in the file.
+func tail(path string) (seq uint64, prev string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	var last []byte
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) > 0 {
+			last = append(last[:0], s.Bytes()...)
+		}
+	}
+	if err = s.Err(); err != nil || last == nil {
+		return
+	}
+	var r Record
+	if err = json.Unmarshal(last, &r); err != nil {
+		err = fmt.Errorf("last line is not an audit chain record: %v", err)
+		return
+	}
+	return r.Seq, r.MAC, nil
+}
+
+// Write appends the audit event to the chain. The event must be a single JSON value.
+func (w *Writer) Write(p []byte) (int, error) {
+	body := bytes.TrimSpace(p)
+	if !json.Valid(body) {
+		return 0, errors.New("audit event is not valid JSON")
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.f == nil {
+		return 0, errors.New("audit chain closed")
+	}
+	if err := w.append(TypeEvent, body); err != nil {
+		return 0, err
+	}
+	w.pending++
+	if w.pending >= w.interval {
+		if err := w.checkpoint(); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *Writer) append(typ string, body []byte) error {
+	seq := w.seq + 1
+	m := mac(w.key, seq, typ, w.prev, body)
+	// The line is formatted directly so that the body is written exactly as covered by the MAC.
+	line := fmt.Sprintf("{\"Seq\":%d,\"Type\":%q,\"Prev\":%q,\"MAC\":%q,\"Body\":%s}\n", seq, typ, w.prev, m, body)
+	if _, err := io.WriteString(w.f, line); err != nil {
+		return err
+	}
+	w.seq = seq
+	w.prev = m
+	return nil
+}
+
+// checkpoint writes a signed checkpoint covering the chain so far.
+func (w *Writer) checkpoint() error {
+	t := time.Now().UTC()
+	cp := Checkpoint{
+		Time:      t,
+		Signature: hex.EncodeToString(ed25519.Sign(w.signer, checkpointMessage(w.seq+1, w.prev, t))),
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := w.append(TypeCheckpoint, b); err != nil {
+		return err
+	}
+	w.pending = 0
+	return nil
+}
+
+// Close writes a final checkpoint and closes the file once all the callers of Open have closed the Writer.
+func (w *Writer) Close() error {
+	writersMux.Lock()
+	defer writersMux.Unlock()
+	w.refs--
+	if w.refs > 0 {
+		return nil
+	}
+	delete(writers, w.path)
+	close(w.stop)
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	var errs []string
+	if w.pending > 0 {
+		if err := w.checkpoint(); err != nil {
+			errs = append(errs, fmt.Sprintf("could not write final checkpoint: %v", err))
+		}
+	}
+	if err := w.f.Sync(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := w.f.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	w.f = nil
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LoadKey reads a hex encoded HMAC key from the file.
+func LoadKey(p string) ([]byte, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit chain key: %v", err)
+	}
+	k, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("audit chain key is not hex encoded: %v", err)
+	}
+	if len(k) < MinKeyLength {
+		return nil, fmt.Errorf("audit chain key must be at least %d bytes", MinKeyLength)
+	}
+	return k, nil
+}
+
+// LoadSigningKey reads a PEM encoded PKCS #8 Ed25519 private key from the file, as generated by
+// openssl genpkey -algorithm ed25519.
+func LoadSigningKey(p string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(p)
+	if err != nil {
+		return nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse audit signing key: %v", err)
+	}
+	sk, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("audit signing key is not an Ed25519 key")
+	}
+	return sk, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key from the file, as generated by openssl pkey -pubout.
+func LoadPublicKey(p string) (ed25519.PublicKey, error) {
+	block, err := readPEM(p)
+	if err != nil {
+		return nil, err
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse audit public key: %v", err)
+	}
+	pk, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("audit public key is not an Ed25519 key")
+	}
+	return pk, nil
+}
+
+func readPEM(p string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", p)
+	}
+	return block, nil
+}
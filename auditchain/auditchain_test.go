@@ -0,0 +1,201 @@
+package auditchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) ([]byte, ed25519.PublicKey, ed25519.PrivateKey) {
+	key := make([]byte, MinKeyLength)
+	rand.Read(key)
+	pub, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pub, sk
+}
+
+func writeEvents(t *testing.T, w *Writer, from, to int) {
+	for i := from; i <= to; i++ {
+		b, _ := json.Marshal(map[string]interface{}{"EventType": "Test", "N": i})
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			t.Fatalf("Error writing event %d: %v", i, err)
+		}
+	}
+}
+
+func testLog(t *testing.T, key []byte, sk ed25519.PrivateKey) (string, func()) {
+	d, err := ioutil.TempDir(os.TempDir(), "auditchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(d, "audit.log")
+	w, err := Open(p, key, sk, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Error opening audit chain: %v", err)
+	}
+	writeEvents(t, w, 1, 4)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing audit chain: %v", err)
+	}
+	// Reopening continues the chain.
+	w, err = Open(p, key, sk, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Error reopening audit chain: %v", err)
+	}
+	writeEvents(t, w, 5, 7)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing audit chain: %v", err)
+	}
+	return p, func() { os.RemoveAll(d) }
+}
+
+func readLines(t *testing.T, p string) []string {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func verifyLines(t *testing.T, lines []string, key []byte, pub ed25519.PublicKey) Report {
+	rep, err := Verify(strings.NewReader(strings.Join(lines, "\n")), key, pub, 0)
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	return rep
+}
+
+func TestVerify(t *testing.T) {
+	key, pub, sk := testKeys(t)
+	p, cleanup := testLog(t, key, sk)
+	defer cleanup()
+	lines := readLines(t, p)
+	// 7 events, a checkpoint after every 3 and at each close.
+	assert.Equal(t, 10, len(lines), "Number of records not as expected")
+
+	rep := verifyLines(t, lines, key, pub)
+	assert.True(t, rep.Valid(), "Unmodified log not valid: %v", rep.Problems)
+	assert.Equal(t, 7, rep.Events, "Number of events not as expected")
+	assert.Equal(t, 3, rep.Checkpoints, "Number of checkpoints not as expected")
+	assert.Equal(t, 0, rep.Unsigned, "Unsigned records not as expected")
+	assert.Equal(t, uint64(10), rep.LastSeq, "Last sequence number not as expected")
+
+	// Modified event
+	mod := append([]string{}, lines...)
+	mod[1] = strings.Replace(mod[1], `"N":2`, `"N":20`, 1)
+	rep = verifyLines(t, mod, key, pub)
+	if assert.Equal(t, 1, len(rep.Problems), "Problems not as expected: %v", rep.Problems) {
+		assert.True(t, strings.Contains(rep.Problems[0], "modified"), "Problem not as expected: %v", rep.Problems)
+	}
+
+	// Removed event
+	gap := append(append([]string{}, lines[:2]...), lines[3:]...)
+	rep = verifyLines(t, gap, key, pub)
+	assert.False(t, rep.Valid(), "Gap not detected")
+	assert.True(t, strings.Contains(rep.Problems[0], "records 3 to 3 are missing"), "Problem not as expected: %v", rep.Problems)
+
+	// Reordered events
+	reord := append([]string{}, lines...)
+	reord[0], reord[1] = reord[1], reord[0]
+	rep = verifyLines(t, reord, key, pub)
+	assert.False(t, rep.Valid(), "Reordering not detected")
+
+	// Removed start of the log
+	rep = verifyLines(t, lines[2:], key, pub)
+	assert.False(t, rep.Valid(), "Missing records at start not detected")
+
+	// Wrong keys
+	otherKey, otherPub, _ := testKeys(t)
+	rep = verifyLines(t, lines, otherKey, pub)
+	assert.False(t, rep.Valid(), "Wrong HMAC key not detected")
+	rep = verifyLines(t, lines, key, otherPub)
+	assert.Equal(t, 3, len(rep.Problems), "Checkpoint signatures verified with the wrong key: %v", rep.Problems)
+
+	// Removing the final checkpoint leaves the events it covered unsigned.
+	rep = verifyLines(t, lines[:len(lines)-1], key, pub)
+	assert.True(t, rep.Valid(), "Truncated log not valid: %v", rep.Problems)
+	assert.Equal(t, 3, rep.Unsigned, "Unsigned records not as expected")
+	// The events exceed the checkpoint interval so the checkpoint must have been removed.
+	rep, err := Verify(strings.NewReader(strings.Join(lines[:len(lines)-1], "\n")), key, pub, 3)
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	if assert.Equal(t, 1, len(rep.Problems), "Problems not as expected: %v", rep.Problems) {
+		assert.True(t, strings.Contains(rep.Problems[0], "3 records after the last checkpoint"), "Problem not as expected: %v", rep.Problems)
+	}
+	rep, err = Verify(strings.NewReader(strings.Join(lines, "\n")), key, pub, 3)
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	assert.True(t, rep.Valid(), "Unmodified log not valid with the checkpoint interval: %v", rep.Problems)
+}
+
+func TestWriter_CheckpointPeriod(t *testing.T) {
+	key, pub, sk := testKeys(t)
+	d, _ := ioutil.TempDir(os.TempDir(), "auditchain")
+	defer os.RemoveAll(d)
+	p := filepath.Join(d, "audit.log")
+	w, err := Open(p, key, sk, 100, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	writeEvents(t, w, 1, 2)
+	// The events are signed once the period has passed without waiting for the interval to be reached.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, _ := ioutil.ReadFile(p)
+		rep, err := Verify(bytes.NewReader(b), key, pub, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Checkpoints > 0 {
+			assert.True(t, rep.Valid(), "Log not valid: %v", rep.Problems)
+			assert.Equal(t, 0, rep.Unsigned, "Unsigned records not as expected")
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the periodic checkpoint")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpen_Shared(t *testing.T) {
+	key, pub, sk := testKeys(t)
+	d, _ := ioutil.TempDir(os.TempDir(), "auditchain")
+	defer os.RemoveAll(d)
+	p := filepath.Join(d, "audit.log")
+	w1, err := Open(p, key, sk, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, err := Open(p, key, sk, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, w1 == w2, "Writer for the same file not shared")
+	writeEvents(t, w1, 1, 2)
+	w1.Close()
+	writeEvents(t, w2, 3, 4)
+	w2.Close()
+	b, _ := ioutil.ReadFile(p)
+	rep, err := Verify(bytes.NewReader(b), key, pub, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, rep.Valid(), "Log written by shared writer not valid: %v", rep.Problems)
+	assert.Equal(t, 4, rep.Events, "Number of events not as expected")
+	assert.Equal(t, 1, rep.Checkpoints, fmt.Sprintf("Number of checkpoints not as expected: %s", b))
+}
@@ -0,0 +1,111 @@
+package auditchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Report is the result of verifying an audit log.
+type Report struct {
+	Records     int    `json:"Records"`
+	Events      int    `json:"Events"`
+	Checkpoints int    `json:"Checkpoints"`
+	FirstSeq    uint64 `json:"FirstSeq"`
+	LastSeq     uint64 `json:"LastSeq"`
+	// Unsigned is the number of records after the last valid checkpoint. These can be removed from the end of the log
+	// without detection.
+	Unsigned int      `json:"Unsigned"`
+	Problems []string `json:"Problems"`
+}
+
+// Valid indicates if no problems were found.
+func (r Report) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) problem(line int, format string, v ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf("line %d: %s", line, fmt.Sprintf(format, v...)))
+}
+
+// Verify reads a chained audit log and reports any records that have been modified, removed, inserted or reordered.
+// The checkpoint signatures are verified if the public key is provided. If the checkpoint interval the log was written
+// with is given, more unsigned records at the end of the log than the interval allows are reported as a missing
+// checkpoint.
+// A log that does not start at sequence number 1 is reported as missing the earlier records, as is the case for a log
+// file that has been rotated.
+func Verify(r io.Reader, key []byte, pub ed25519.PublicKey, interval int) (Report, error) {
+	var rep Report
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	var prevSeq uint64
+	var prevMAC string
+	line := 0
+	for s.Scan() {
+		line++
+		b := bytes.TrimSpace(s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(b, &rec); err != nil {
+			rep.problem(line, "not an audit chain record: %v", err)
+			continue
+		}
+		rep.Records++
+		if rep.Records == 1 {
+			rep.FirstSeq = rec.Seq
+			if rec.Seq != 1 {
+				rep.problem(line, "log starts at sequence number %d, records 1 to %d are missing", rec.Seq, rec.Seq-1)
+			}
+		} else {
+			switch {
+			case rec.Seq == prevSeq+1:
+			case rec.Seq > prevSeq+1:
+				rep.problem(line, "gap in sequence, records %d to %d are missing", prevSeq+1, rec.Seq-1)
+			default:
+				rep.problem(line, "sequence number %d out of order after %d", rec.Seq, prevSeq)
+			}
+			if rec.Prev != prevMAC {
+				rep.problem(line, "record %d is not chained to the preceding record", rec.Seq)
+			}
+		}
+		if mac(key, rec.Seq, rec.Type, rec.Prev, rec.Body) != rec.MAC {
+			rep.problem(line, "record %d has been modified", rec.Seq)
+		}
+		switch rec.Type {
+		case TypeEvent:
+			rep.Events++
+			rep.Unsigned++
+		case TypeCheckpoint:
+			rep.Checkpoints++
+			var cp Checkpoint
+			if err := json.Unmarshal(rec.Body, &cp); err != nil {
+				rep.problem(line, "checkpoint %d not valid: %v", rec.Seq, err)
+				break
+			}
+			if pub != nil {
+				sig, err := hex.DecodeString(cp.Signature)
+				if err != nil || !ed25519.Verify(pub, checkpointMessage(rec.Seq, rec.Prev, cp.Time), sig) {
+					rep.problem(line, "checkpoint %d signature not valid", rec.Seq)
+					break
+				}
+			}
+			rep.Unsigned = 0
+		default:
+			rep.problem(line, "record %d has unknown type %s", rec.Seq, rec.Type)
+		}
+		prevSeq = rec.Seq
+		prevMAC = rec.MAC
+	}
+	rep.LastSeq = prevSeq
+	// A checkpoint is written as soon as the interval is reached so there can be no more than interval-1 unsigned events.
+	if interval > 0 && rep.Unsigned >= interval {
+		rep.problem(line, "%d records after the last checkpoint, checkpoints are written every %d events", rep.Unsigned, interval)
+	}
+	return rep, s.Err()
+}
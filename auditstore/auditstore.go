@@ -0,0 +1,247 @@
+// Package auditstore holds the audit events in the database so that they can be queried, for example to find who
+// assumed a role in an account, without searching the audit log files of each server.
+package auditstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLimit is the number of events returned when the filter does not set a limit.
+	DefaultLimit = 100
+	// MaxLimit is the maximum number of events returned at once.
+	MaxLimit = 1000
+	// purgeBatch is the number of events deleted by each statement when purging, to avoid holding long locks.
+	purgeBatch = 1000
+)
+
+var (
+	errStmtNotFound = errors.New("prepared statement for audit events not found")
+	// endOfTime is the upper bound of the time range when the filter does not set one.
+	endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// Event is an audit event as held in the store.
+type Event struct {
+	ID             int64           `json:"ID"`
+	UUID           string          `json:"EventUUID"`
+	Time           time.Time       `json:"Time"`
+	EventType      string          `json:"EventType"`
+	Username       string          `json:"Username"`
+	UserDomain     string          `json:"UserDomain"`
+	UserSessionID  string          `json:"UserSessionID"`
+	Severity       string          `json:"Severity,omitempty"`
+	Outcome        string          `json:"Outcome,omitempty"`
+	RoleMappingID  string          `json:"RoleMappingID,omitempty"`
+	AccountID      string          `json:"AccountID,omitempty"`
+	FederationUser string          `json:"FederationUser,omitempty"`
+	Detail         json.RawMessage `json:"Detail,omitempty"`
+}
+
+// Reference identifies the role mapping, AWS account and federation user an audit event relates to.
+type Reference struct {
+	RoleMappingID  string
+	AccountID      string
+	FederationUser string
+}
+
+// NewEvent returns the event for the audit log line.
+func NewEvent(l config.AuditLogLine, ref Reference) Event {
+	return Event{
+		UUID:           l.UUID,
+		Time:           l.Time,
+		EventType:      l.EventType,
+		Username:       l.Username,
+		UserDomain:     l.UserDomain,
+		UserSessionID:  l.UserSessionID,
+		Severity:       l.Severity,
+		Outcome:        l.Outcome,
+		RoleMappingID:  ref.RoleMappingID,
+		AccountID:      ref.AccountID,
+		FederationUser: ref.FederationUser,
+		Detail:         detail(l.Detail),
+	}
+}
+
+// detail returns the JSON of the audit log line's detail, which is query escaped in the log line. Detail that is not
+// JSON is kept as a JSON string.
+func detail(d string) json.RawMessage {
+	if d == "" {
+		return nil
+	}
+	if u, err := url.QueryUnescape(d); err == nil {
+		d = u
+	}
+	if json.Valid([]byte(d)) {
+		return json.RawMessage(d)
+	}
+	b, _ := json.Marshal(d)
+	return json.RawMessage(b)
+}
+
+// Filter selects the audit events to list. Empty values match all events.
+// Events are listed newest first. To continue from a previous page set Before to the ID of the last event of that page.
+type Filter struct {
+	Username       string
+	UserDomain     string
+	EventType      string
+	RoleMappingID  string
+	AccountID      string
+	FederationUser string
+	Outcome        string
+	From           time.Time
+	To             time.Time
+	Before         int64
+	Limit          int
+}
+
+// Store holds audit events in the database.
+// The connection is acquired on each call so that the store follows the connection being replaced when the database
+// credentials are rotated.
+type Store struct {
+	db database.Source
+}
+
+func New(db database.Source) *Store {
+	return &Store{db: db}
+}
+
+// Add stores the audit event.
+func (s *Store) Add(ctx context.Context, e Event) error {
+	conn, release := s.db.Acquire()
+	defer release()
+	stmt, ok := (*conn.Stmts)[database.StmtKeyAuditEventInsert]
+	if !ok {
+		return errStmtNotFound
+	}
+	var d interface{}
+	if e.Detail != nil {
+		d = string(e.Detail)
+	}
+	_, err := database.Exec(ctx, stmt, e.UUID, e.Time, e.EventType, e.Username, e.UserDomain, e.UserSessionID, e.Severity, e.Outcome,
+		e.RoleMappingID, e.AccountID, e.FederationUser, d)
+	return err
+}
+
+// List returns the audit events that match the filter.
+func (s *Store) List(ctx context.Context, f Filter) ([]Event, error) {
+	conn, release := s.db.Acquire()
+	defer release()
+	stmt, ok := (*conn.Stmts)[database.StmtKeyAuditEventSelectList]
+	if !ok {
+		return nil, errStmtNotFound
+	}
+	if f.Limit < 1 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	if f.To.IsZero() {
+		f.To = endOfTime
+	}
+	if f.Before < 1 {
+		f.Before = math.MaxInt64
+	}
+	rows, err := database.Query(ctx, stmt,
+		f.Username, f.Username,
+		f.UserDomain, f.UserDomain,
+		f.EventType, f.EventType,
+		f.RoleMappingID, f.RoleMappingID,
+		f.AccountID, f.AccountID,
+		f.FederationUser, f.FederationUser,
+		f.Outcome, f.Outcome,
+		f.From.UTC(), f.To.UTC(), f.Before, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var es []Event
+	for rows.Next() {
+		var e Event
+		var d sql.NullString
+		if err := rows.Scan(&e.ID, &e.UUID, &e.Time, &e.EventType, &e.Username, &e.UserDomain, &e.UserSessionID,
+			&e.Severity, &e.Outcome, &e.RoleMappingID, &e.AccountID, &e.FederationUser, &d); err != nil {
+			return nil, err
+		}
+		if d.Valid && d.String != "" {
+			e.Detail = json.RawMessage(d.String)
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+// Purge deletes the audit events from before the time given and returns the number deleted.
+func (s *Store) Purge(ctx context.Context, before time.Time) (int64, error) {
+	conn, release := s.db.Acquire()
+	defer release()
+	stmt, ok := (*conn.Stmts)[database.StmtKeyAuditEventPurge]
+	if !ok {
+		return 0, errStmtNotFound
+	}
+	var total int64
+	for {
+		res, err := database.Exec(ctx, stmt, before.UTC(), purgeBatch)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < purgeBatch {
+			return total, nil
+		}
+	}
+}
+
+var (
+	storesMux sync.Mutex
+	stores    = make(map[*config.Config]*Store)
+)
+
+// Set makes the store the one that audit events are recorded to under the configuration.
+func Set(c *config.Config, s *Store) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	stores[c] = s
+}
+
+// ForConfig returns the store audit events are recorded to under the configuration. False is returned if the audit
+// store is not enabled.
+func ForConfig(c *config.Config) (*Store, bool) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	s, ok := stores[c]
+	return s, ok
+}
+
+// Release removes the store held for a configuration that is no longer in use.
+func Release(c *config.Config) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	delete(stores, c)
+}
+
+// Record adds the audit log line to the store of the configuration, if the audit store is enabled. The event is
+// always written to the audit log so a failure to store it is only logged.
+func Record(ctx context.Context, c *config.Config, l config.AuditLogLine, ref Reference) {
+	s, ok := ForConfig(c)
+	if !ok {
+		return
+	}
+	if err := s.Add(ctx, NewEvent(l, ref)); err != nil {
+		c.Logger().ForRequest(ctx).Errorf("could not store audit event %s: %v", l.UUID, err)
+	}
+}
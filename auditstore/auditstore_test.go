@@ -0,0 +1,142 @@
+package auditstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testRoleMappingID = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	testAccountID     = "012345678912"
+	testUUID          = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+)
+
+var eventColumns = []string{"id", "uuid", "time", "event_type", "username", "user_domain", "user_session_id", "severity", "outcome", "roleMapping_id", "account_id", "federation_user", "detail"}
+
+func TestNewEvent(t *testing.T) {
+	now := time.Now().UTC()
+	l := config.AuditLogLine{
+		Username:      "testuser",
+		UserDomain:    "TESTING",
+		UserSessionID: "00000000-0000-0000-0000-000000000000",
+		Time:          now,
+		EventType:     "AssumeRoleFederation",
+		UUID:          testUUID,
+		Detail:        url.QueryEscape(`{"Successful":true}`),
+		Outcome:       config.AuditOutcomeSuccess,
+	}
+	e := NewEvent(l, Reference{RoleMappingID: testRoleMappingID, AccountID: testAccountID})
+	assert.Equal(t, testUUID, e.UUID, "UUID not as expected")
+	assert.Equal(t, config.AuditOutcomeSuccess, e.Outcome, "outcome not as expected")
+	assert.Equal(t, testRoleMappingID, e.RoleMappingID, "role mapping not as expected")
+	assert.Equal(t, testAccountID, e.AccountID, "account not as expected")
+	assert.Equal(t, `{"Successful":true}`, string(e.Detail), "detail not as expected")
+
+	l.Detail = "not json"
+	assert.Equal(t, `"not json"`, string(NewEvent(l, Reference{}).Detail), "detail that is not JSON should be a JSON string")
+	l.Detail = ""
+	assert.Nil(t, NewEvent(l, Reference{}).Detail, "empty detail should be nil")
+}
+
+func TestStore(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	s := New(&database.Conn{DB: db, Stmts: stmtMap})
+	now := time.Now().UTC()
+
+	// Add
+	e := Event{
+		UUID:          testUUID,
+		Time:          now,
+		EventType:     "AssumeRoleFederation",
+		Username:      "testuser",
+		UserDomain:    "TESTING",
+		UserSessionID: "00000000-0000-0000-0000-000000000000",
+		Outcome:       config.AuditOutcomeFailure,
+		RoleMappingID: testRoleMappingID,
+		AccountID:     testAccountID,
+		Detail:        []byte(`{"Successful":false}`),
+	}
+	ep[database.StmtKeyAuditEventInsert].ExpectExec().WithArgs(testUUID, now, "AssumeRoleFederation", "testuser", "TESTING",
+		"00000000-0000-0000-0000-000000000000", "", config.AuditOutcomeFailure, testRoleMappingID, testAccountID, "",
+		`{"Successful":false}`).WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := s.Add(context.Background(), e); err != nil {
+		t.Fatalf("error adding audit event: %v", err)
+	}
+
+	// List with the defaults for the filter
+	ep[database.StmtKeyAuditEventSelectList].ExpectQuery().WithArgs("", "", "", "", "", "", "", "", testAccountID, testAccountID,
+		"", "", "", "", time.Time{}, endOfTime, int64(math.MaxInt64), DefaultLimit).
+		WillReturnRows(sqlmock.NewRows(eventColumns).
+			AddRow(2, testUUID, now, "AssumeRoleFederation", "testuser", "TESTING", "00000000-0000-0000-0000-000000000000", "", config.AuditOutcomeFailure, testRoleMappingID, testAccountID, "", `{"Successful":false}`).
+			AddRow(1, testUUID, now, "Authentication Successful", "testuser", "TESTING", "00000000-0000-0000-0000-000000000000", "", config.AuditOutcomeSuccess, "", "", "", nil))
+	es, err := s.List(context.Background(), Filter{AccountID: testAccountID})
+	if err != nil {
+		t.Fatalf("error listing audit events: %v", err)
+	}
+	if assert.Len(t, es, 2, "number of events not as expected") {
+		assert.Equal(t, int64(2), es[0].ID, "ID not as expected")
+		assert.Equal(t, `{"Successful":false}`, string(es[0].Detail), "detail not as expected")
+		assert.Nil(t, es[1].Detail, "detail should be nil")
+	}
+
+	// List a page with a time range
+	from := now.Add(-time.Hour)
+	ep[database.StmtKeyAuditEventSelectList].ExpectQuery().WithArgs("testuser", "testuser", "", "", "", "", "", "", "", "",
+		"", "", config.AuditOutcomeSuccess, config.AuditOutcomeSuccess, from, now, int64(10), MaxLimit).
+		WillReturnRows(sqlmock.NewRows(eventColumns))
+	es, err = s.List(context.Background(), Filter{Username: "testuser", Outcome: config.AuditOutcomeSuccess, From: from, To: now, Before: 10, Limit: MaxLimit + 1})
+	if err != nil {
+		t.Fatalf("error listing audit events: %v", err)
+	}
+	assert.Len(t, es, 0, "number of events not as expected")
+
+	// Purge in batches
+	before := now.AddDate(0, 0, -30)
+	ep[database.StmtKeyAuditEventPurge].ExpectExec().WithArgs(before, purgeBatch).WillReturnResult(sqlmock.NewResult(0, purgeBatch))
+	ep[database.StmtKeyAuditEventPurge].ExpectExec().WithArgs(before, purgeBatch).WillReturnResult(sqlmock.NewResult(0, 5))
+	n, err := s.Purge(context.Background(), before)
+	if err != nil {
+		t.Fatalf("error purging audit events: %v", err)
+	}
+	assert.Equal(t, int64(purgeBatch+5), n, "number of events purged not as expected")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("database expectations not met: %v", err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	c := config.NewConfig()
+	l := config.AuditLogLine{UUID: testUUID, Time: time.Now().UTC(), EventType: "Test"}
+
+	// Nothing is stored unless a store is set for the configuration
+	Record(context.Background(), c, l, Reference{})
+
+	Set(c, New(&database.Conn{DB: db, Stmts: stmtMap}))
+	args := make([]driver.Value, 12)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	ep[database.StmtKeyAuditEventInsert].ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	Record(context.Background(), c, l, Reference{})
+
+	Release(c)
+	_, ok := ForConfig(c)
+	assert.False(t, ok, "store should be released")
+	Record(context.Background(), c, l, Reference{})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("database expectations not met: %v", err)
+	}
+}
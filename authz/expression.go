@@ -0,0 +1,226 @@
+// Package authz provides the evaluation of the authorization rules held against role mappings.
+//
+// A role mapping's authorization value is either a plain authorization attribute, which the user must hold, or an
+// expression. Expressions are recognised by their first token: a double quoted string, an opening parenthesis, the
+// NOT operator or one of the functions below.
+//
+// Expression syntax:
+//
+//	"value"              user holds the authorization attribute "value" (shorthand for attr("value"))
+//	attr("value")        user holds the authorization attribute "value"
+//	glob("aws-prod-*")   user holds an authorization attribute matching the glob pattern (* and ? wildcards)
+//	regex("^aws-.*$")    user holds an authorization attribute matching the regular expression
+//	domain("EXAMPLE")    user's identity domain is "EXAMPLE" (case insensitive)
+//	a AND b, a && b      both a and b are true
+//	a OR b, a || b       either a or b is true
+//	NOT a, !a            a is false
+//	( ... )              grouping
+//
+// AND binds more tightly than OR, so "A" OR "B" AND "C" is evaluated as "A" OR ("B" AND "C").
+package authz
+
+import (
+	"errors"
+	"fmt"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"regexp"
+	"strings"
+)
+
+const (
+	FuncAttribute = "attr"
+	FuncGlob      = "glob"
+	FuncRegex     = "regex"
+	FuncDomain    = "domain"
+)
+
+// Expression is a parsed authorization expression.
+type Expression interface {
+	Evaluate(u goidentity.Identity) bool
+	String() string
+}
+
+// IsExpression indicates if the authorization value provided should be treated as an expression rather than a plain
+// authorization attribute. The operators and function names are matched case insensitively, as they are when parsed.
+func IsExpression(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	switch s[0] {
+	case '"', '(', '!':
+		return true
+	}
+	if hasPrefixFold(s, "NOT ") || hasPrefixFold(s, "NOT(") {
+		return true
+	}
+	for _, f := range []string{FuncAttribute, FuncGlob, FuncRegex, FuncDomain} {
+		if hasPrefixFold(s, f) && strings.HasPrefix(strings.TrimLeft(s[len(f):], " "), "(") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPrefixFold tests whether the string begins with the prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// Validate checks that the authorization value provided is either a plain attribute or a valid expression.
+func Validate(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return errors.New("authorization value is empty")
+	}
+	if !IsExpression(s) {
+		return nil
+	}
+	_, err := Parse(s)
+	return err
+}
+
+// Authorized evaluates the authorization value of a role mapping against the identity provided.
+func Authorized(u goidentity.Identity, s string) (bool, error) {
+	if !IsExpression(s) {
+		return u.Authorized(s), nil
+	}
+	e, err := Parse(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid authorization expression: %v", err)
+	}
+	return e.Evaluate(u), nil
+}
+
+// Parse parses the authorization expression string into an Expression.
+func Parse(s string) (Expression, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %s at position %d", p.peek().String(), p.peek().pos)
+	}
+	return e, nil
+}
+
+type andExpr struct {
+	left, right Expression
+}
+
+func (e andExpr) Evaluate(u goidentity.Identity) bool {
+	return e.left.Evaluate(u) && e.right.Evaluate(u)
+}
+
+func (e andExpr) String() string {
+	return "(" + e.left.String() + " AND " + e.right.String() + ")"
+}
+
+type orExpr struct {
+	left, right Expression
+}
+
+func (e orExpr) Evaluate(u goidentity.Identity) bool {
+	return e.left.Evaluate(u) || e.right.Evaluate(u)
+}
+
+func (e orExpr) String() string {
+	return "(" + e.left.String() + " OR " + e.right.String() + ")"
+}
+
+type notExpr struct {
+	inner Expression
+}
+
+func (e notExpr) Evaluate(u goidentity.Identity) bool {
+	return !e.inner.Evaluate(u)
+}
+
+func (e notExpr) String() string {
+	return "NOT " + e.inner.String()
+}
+
+type attributeExpr struct {
+	value string
+}
+
+func (e attributeExpr) Evaluate(u goidentity.Identity) bool {
+	return u.Authorized(e.value)
+}
+
+func (e attributeExpr) String() string {
+	return fmt.Sprintf("%s(%q)", FuncAttribute, e.value)
+}
+
+type patternExpr struct {
+	function string
+	value    string
+	re       *regexp.Regexp
+}
+
+func (e patternExpr) Evaluate(u goidentity.Identity) bool {
+	for _, a := range u.AuthzAttributes() {
+		// Authorized is checked as well so that disabled attributes are not matched.
+		if e.re.MatchString(a) && u.Authorized(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e patternExpr) String() string {
+	return fmt.Sprintf("%s(%q)", e.function, e.value)
+}
+
+type domainExpr struct {
+	value string
+}
+
+func (e domainExpr) Evaluate(u goidentity.Identity) bool {
+	return strings.EqualFold(u.Domain(), e.value)
+}
+
+func (e domainExpr) String() string {
+	return fmt.Sprintf("%s(%q)", FuncDomain, e.value)
+}
+
+func newFunctionExpr(f, v string) (Expression, error) {
+	switch f {
+	case FuncAttribute:
+		return attributeExpr{value: v}, nil
+	case FuncGlob:
+		re, err := regexp.Compile(globToRegex(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %v", v, err)
+		}
+		return patternExpr{function: f, value: v, re: re}, nil
+	case FuncRegex:
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %v", v, err)
+		}
+		return patternExpr{function: f, value: v, re: re}, nil
+	case FuncDomain:
+		return domainExpr{value: v}, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", f)
+}
+
+func globToRegex(g string) string {
+	re := "^"
+	for _, r := range g {
+		switch r {
+		case '*':
+			re += ".*"
+		case '?':
+			re += "."
+		default:
+			re += regexp.QuoteMeta(string(r))
+		}
+	}
+	return re + "$"
+}
@@ -0,0 +1,114 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"testing"
+)
+
+func TestIsExpression(t *testing.T) {
+	var tests = []struct {
+		value string
+		isExp bool
+	}{
+		{"myGroup1", false},
+		{"CN=AWS Admins,OU=Groups,DC=example,DC=com", false},
+		{"globalAdmins", false},
+		{"domainUsers", false},
+		{`"myGroup1"`, true},
+		{`"myGroup1" AND "myGroup2"`, true},
+		{`glob("aws-prod-*")`, true},
+		{`regex ("^aws-.*$")`, true},
+		{`domain("EXAMPLE.COM")`, true},
+		{`attr("myGroup1")`, true},
+		{`NOT "myGroup1"`, true},
+		{`!"myGroup1"`, true},
+		{`("myGroup1" OR "myGroup2")`, true},
+		{`not "myGroup1"`, true},
+		{`Not("myGroup1")`, true},
+		{`GLOB("aws-prod-*")`, true},
+		{`Regex ("^aws-.*$")`, true},
+		{`Domain("EXAMPLE.COM")`, true},
+		{`ATTR("myGroup1")`, true},
+		{"notMyGroup", false},
+		{"Nothing", false},
+		{"Domain Admins", false},
+		{"GLOBAL", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.isExp, IsExpression(test.value), "IsExpression not as expected for %s", test.value)
+		if test.isExp {
+			assert.NoError(t, Validate(test.value), "expression %s should be valid", test.value)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	var tests = []string{
+		`"myGroup1" AND`,
+		`("myGroup1"`,
+		`"myGroup1`,
+		`glob(myGroup1)`,
+		`regex("[")`,
+		`unknown("a")`,
+		`"a" & "b"`,
+		`"a" "b"`,
+		`NOT`,
+	}
+	for _, test := range tests {
+		_, err := Parse(test)
+		assert.Error(t, err, "expected error parsing %s", test)
+		if IsExpression(test) {
+			assert.Error(t, Validate(test), "expected validation error for %s", test)
+		}
+	}
+	assert.Error(t, Validate(""), "expected validation error for empty value")
+	assert.NoError(t, Validate("myGroup1"), "plain attribute should be valid")
+}
+
+func TestAuthorized(t *testing.T) {
+	u := goidentity.NewUser("testuser")
+	u.SetDomain("EXAMPLE.COM")
+	u.AddAuthzAttribute("myGroup1")
+	u.AddAuthzAttribute("myGroup2")
+	u.AddAuthzAttribute("aws-prod-admins")
+	u.AddAuthzAttribute("aws-dev-disabled")
+	u.DisableAuthzAttribute("aws-dev-disabled")
+
+	var tests = []struct {
+		value      string
+		authorized bool
+	}{
+		{"myGroup1", true},
+		{"myGroup3", false},
+		{`"myGroup1"`, true},
+		{`"myGroup1" AND "myGroup2"`, true},
+		{`"myGroup1" && "myGroup3"`, false},
+		{`"myGroup3" OR "myGroup2"`, true},
+		{`"myGroup3" || "myGroup4"`, false},
+		{`NOT "myGroup3"`, true},
+		{`!"myGroup1"`, false},
+		{`glob("aws-prod-*")`, true},
+		{`glob("aws-test-*")`, false},
+		{`glob("aws-dev-*")`, false},
+		{`glob("myGroup?")`, true},
+		{`regex("^aws-(prod|test)-admins$")`, true},
+		{`regex("^aws-dev-")`, false},
+		{`domain("example.com")`, true},
+		{`domain("OTHER.COM")`, false},
+		{`domain("EXAMPLE.COM") AND (glob("aws-test-*") OR attr("myGroup2"))`, true},
+		{`domain("EXAMPLE.COM") AND NOT "myGroup2"`, false},
+		{`"myGroup3" OR "myGroup1" AND "myGroup4"`, false},
+		{`("myGroup3" OR "myGroup1") AND "myGroup2"`, true},
+	}
+	for _, test := range tests {
+		ok, err := Authorized(&u, test.value)
+		if err != nil {
+			t.Errorf("error evaluating %s: %v", test.value, err)
+		}
+		assert.Equal(t, test.authorized, ok, "authorization not as expected for %s", test.value)
+	}
+
+	_, err := Authorized(&u, `glob("aws-*"`)
+	assert.Error(t, err, "expected error evaluating invalid expression")
+}
@@ -0,0 +1,209 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	tokString = iota
+	tokIdent
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokString:
+		return fmt.Sprintf("string %q", t.value)
+	case tokIdent:
+		return fmt.Sprintf("identifier %s", t.value)
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+func lex(s string) ([]token, error) {
+	var toks []token
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		switch {
+		case unicode.IsSpace(r[i]):
+			i++
+		case r[i] == '(':
+			toks = append(toks, token{kind: tokLParen, value: "(", pos: i})
+			i++
+		case r[i] == ')':
+			toks = append(toks, token{kind: tokRParen, value: ")", pos: i})
+			i++
+		case r[i] == '!':
+			toks = append(toks, token{kind: tokNot, value: "!", pos: i})
+			i++
+		case r[i] == '&' || r[i] == '|':
+			if i+1 >= len(r) || r[i+1] != r[i] {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r[i], i)
+			}
+			k := tokAnd
+			if r[i] == '|' {
+				k = tokOr
+			}
+			toks = append(toks, token{kind: k, value: string(r[i : i+2]), pos: i})
+			i += 2
+		case r[i] == '"':
+			start := i
+			var v []rune
+			i++
+			for ; i < len(r) && r[i] != '"'; i++ {
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				}
+				v = append(v, r[i])
+			}
+			if i >= len(r) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", start)
+			}
+			i++
+			toks = append(toks, token{kind: tokString, value: string(v), pos: start})
+		case unicode.IsLetter(r[i]):
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || r[i] == '_') {
+				i++
+			}
+			w := string(r[start:i])
+			switch strings.ToUpper(w) {
+			case "AND":
+				toks = append(toks, token{kind: tokAnd, value: w, pos: start})
+			case "OR":
+				toks = append(toks, token{kind: tokOr, value: w, pos: start})
+			case "NOT":
+				toks = append(toks, token{kind: tokNot, value: w, pos: start})
+			default:
+				toks = append(toks, token{kind: tokIdent, value: w, pos: start})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r[i], i)
+		}
+	}
+	if len(toks) < 1 {
+		return nil, errors.New("expression is empty")
+	}
+	return toks, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) done() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	p.i++
+	return t
+}
+
+func (p *parser) expect(kind int, what string) (token, error) {
+	if p.done() {
+		return token{}, fmt.Errorf("unexpected end of expression, expected %s", what)
+	}
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("unexpected %s at position %d, expected %s", t.String(), t.pos, what)
+	}
+	return t, nil
+}
+
+func (p *parser) parseOr() (Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expression, error) {
+	if !p.done() && p.peek().kind == tokNot {
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner: inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expression, error) {
+	if p.done() {
+		return nil, errors.New("unexpected end of expression")
+	}
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return attributeExpr{value: t.value}, nil
+	case tokLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tokIdent:
+		f := strings.ToLower(t.value)
+		if _, err := p.expect(tokLParen, `"(" after function `+t.value); err != nil {
+			return nil, err
+		}
+		v, err := p.expect(tokString, "quoted string argument to "+t.value)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return newFunctionExpr(f, v.value)
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", t.String(), t.pos)
+}
@@ -0,0 +1,233 @@
+package authz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	TimeOfDayFormat = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Validity defines when a role mapping can be used.
+// A nil From or Until leaves that side of the period open. A nil Schedule places no restriction on the time of day.
+type Validity struct {
+	From     *time.Time
+	Until    *time.Time
+	Schedule *Schedule
+}
+
+// Schedule defines recurring time windows within which a role mapping can be used.
+type Schedule struct {
+	Timezone string   `json:"Timezone,omitempty"` // IANA timezone name. Defaults to UTC.
+	Windows  []Window `json:"Windows"`
+}
+
+// Window is a recurring period of the day.
+// Days are three letter day names (Mon, Tue...) on which the window starts. No days means every day.
+// Start and End are in 24 hour HH:MM format. If End is not after Start the window runs over midnight into the next day.
+type Window struct {
+	Days  []string `json:"Days,omitempty"`
+	Start string   `json:"Start"`
+	End   string   `json:"End"`
+}
+
+// NewValidity creates a Validity from the values held against a role mapping.
+// The schedule is the JSON definition as stored, with nil or an empty string meaning no schedule.
+func NewValidity(from, until *time.Time, schedule *string) (Validity, error) {
+	v := Validity{
+		From:  from,
+		Until: until,
+	}
+	if schedule != nil && *schedule != "" {
+		sch, err := ParseSchedule(*schedule)
+		if err != nil {
+			return v, err
+		}
+		v.Schedule = sch
+	}
+	return v, nil
+}
+
+// ParseSchedule unmarshals and validates a JSON schedule definition.
+func ParseSchedule(s string) (*Schedule, error) {
+	var sch Schedule
+	err := json.Unmarshal([]byte(s), &sch)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal schedule: %v", err)
+	}
+	err = sch.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &sch, nil
+}
+
+// String returns the JSON representation of the schedule as stored in the database.
+func (s Schedule) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Validate checks the schedule definition.
+func (s Schedule) Validate() error {
+	if _, err := s.location(); err != nil {
+		return err
+	}
+	if len(s.Windows) < 1 {
+		return errors.New("schedule does not define any time windows")
+	}
+	for i, w := range s.Windows {
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("schedule window %d has invalid day %s", i, d)
+			}
+		}
+		if _, err := time.Parse(TimeOfDayFormat, w.Start); err != nil {
+			return fmt.Errorf("schedule window %d has invalid start time %s, must be HH:MM", i, w.Start)
+		}
+		if _, err := time.Parse(TimeOfDayFormat, w.End); err != nil {
+			return fmt.Errorf("schedule window %d has invalid end time %s, must be HH:MM", i, w.End)
+		}
+	}
+	return nil
+}
+
+func (s Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule has invalid timezone %s: %v", s.Timezone, err)
+	}
+	return loc, nil
+}
+
+// Active returns whether the time provided is within one of the schedule's windows.
+func (s Schedule) Active(t time.Time) bool {
+	_, ok := s.windowEnd(t)
+	return ok
+}
+
+// windowEnd returns the end time of the latest ending window that the time provided falls in.
+func (s Schedule) windowEnd(t time.Time) (end time.Time, ok bool) {
+	loc, err := s.location()
+	if err != nil {
+		return
+	}
+	t = t.In(loc)
+	for _, w := range s.Windows {
+		st, err1 := time.Parse(TimeOfDayFormat, w.Start)
+		et, err2 := time.Parse(TimeOfDayFormat, w.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// Check the window starting today and the one starting yesterday as it may run over midnight.
+		for _, offset := range []int{0, -1} {
+			day := t.AddDate(0, 0, offset)
+			if !w.onDay(day.Weekday()) {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), st.Hour(), st.Minute(), 0, 0, loc)
+			e := time.Date(day.Year(), day.Month(), day.Day(), et.Hour(), et.Minute(), 0, 0, loc)
+			if !e.After(start) {
+				e = e.AddDate(0, 0, 1)
+			}
+			if !t.Before(start) && t.Before(e) && e.After(end) {
+				end = e
+				ok = true
+			}
+		}
+	}
+	return
+}
+
+func (w Window) onDay(d time.Weekday) bool {
+	if len(w.Days) < 1 {
+		return true
+	}
+	for _, s := range w.Days {
+		if weekdays[strings.ToLower(s)] == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounded indicates if the validity places any restriction on when the role mapping can be used.
+func (v Validity) Bounded() bool {
+	return v.From != nil || v.Until != nil || v.Schedule != nil
+}
+
+// Active returns whether the role mapping can be used at the time provided.
+func (v Validity) Active(t time.Time) bool {
+	if v.From != nil && t.Before(*v.From) {
+		return false
+	}
+	if v.Expired(t) {
+		return false
+	}
+	if v.Schedule != nil && !v.Schedule.Active(t) {
+		return false
+	}
+	return true
+}
+
+// Expired returns whether the validity period has ended at the time provided.
+func (v Validity) Expired(t time.Time) bool {
+	return v.Until != nil && !t.Before(*v.Until)
+}
+
+// Remaining returns how much longer from the time provided the role mapping can be used without interruption.
+// ok is false if the validity is not bounded.
+func (v Validity) Remaining(t time.Time) (d time.Duration, ok bool) {
+	if !v.Bounded() {
+		return
+	}
+	ok = true
+	if !v.Active(t) {
+		return
+	}
+	var end time.Time
+	if v.Until != nil {
+		end = *v.Until
+	}
+	if v.Schedule != nil {
+		we, _ := v.Schedule.windowEnd(t)
+		if end.IsZero() || we.Before(end) {
+			end = we
+		}
+	}
+	if end.IsZero() {
+		// Only a start time is defined so there is no limit once active.
+		ok = false
+		return
+	}
+	d = end.Sub(t)
+	return
+}
+
+// Validate checks the consistency of the validity definition.
+func (v Validity) Validate() error {
+	if v.From != nil && v.Until != nil && !v.Until.After(*v.From) {
+		return errors.New("validity end must be after the validity start")
+	}
+	if v.Schedule != nil {
+		return v.Schedule.Validate()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Active(t *testing.T) {
+	sch, err := ParseSchedule(`{"Timezone":"Europe/London","Windows":[{"Days":["Mon","Tue","Wed","Thu","Fri"],"Start":"09:00","End":"17:30"},{"Days":["Sat"],"Start":"22:00","End":"02:00"}]}`)
+	if err != nil {
+		t.Fatalf("error parsing schedule: %v", err)
+	}
+	loc, _ := time.LoadLocation("Europe/London")
+	var tests = []struct {
+		t      time.Time
+		active bool
+	}{
+		{time.Date(2018, 1, 8, 9, 0, 0, 0, loc), true},       // Monday start of window
+		{time.Date(2018, 1, 8, 17, 29, 0, 0, loc), true},     // Monday before end of window
+		{time.Date(2018, 1, 8, 17, 30, 0, 0, loc), false},    // Monday end of window
+		{time.Date(2018, 1, 8, 8, 59, 0, 0, loc), false},     // Monday before window
+		{time.Date(2018, 1, 7, 12, 0, 0, 0, loc), false},     // Sunday
+		{time.Date(2018, 1, 13, 23, 0, 0, 0, loc), true},     // Saturday night
+		{time.Date(2018, 1, 14, 1, 0, 0, 0, loc), true},      // Into Sunday from Saturday's window
+		{time.Date(2018, 1, 14, 23, 0, 0, 0, loc), false},    // Sunday night
+		{time.Date(2018, 7, 9, 8, 30, 0, 0, time.UTC), true}, // Monday 09:30 BST
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.active, sch.Active(test.t), "schedule activity not as expected for %v", test.t)
+	}
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	var tests = []string{
+		`{"Windows":[]}`,
+		`{"Timezone":"Nowhere/Special","Windows":[{"Start":"09:00","End":"17:00"}]}`,
+		`{"Windows":[{"Days":["Funday"],"Start":"09:00","End":"17:00"}]}`,
+		`{"Windows":[{"Start":"9am","End":"17:00"}]}`,
+		`{"Windows":[{"Start":"09:00","End":"25:00"}]}`,
+		`not json`,
+	}
+	for _, test := range tests {
+		_, err := ParseSchedule(test)
+		assert.Error(t, err, "expected error parsing schedule %s", test)
+	}
+}
+
+func TestValidity(t *testing.T) {
+	now := time.Date(2018, 1, 8, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	var v Validity
+	assert.True(t, v.Active(now), "unbounded validity should be active")
+	_, ok := v.Remaining(now)
+	assert.False(t, ok, "unbounded validity should not have a remaining duration")
+
+	v = Validity{From: &past, Until: &future}
+	assert.True(t, v.Active(now), "validity should be active")
+	d, ok := v.Remaining(now)
+	assert.True(t, ok, "bounded validity should have a remaining duration")
+	assert.Equal(t, time.Hour, d, "remaining validity not as expected")
+
+	v = Validity{Until: &past}
+	assert.False(t, v.Active(now), "validity should have expired")
+	assert.True(t, v.Expired(now), "validity should have expired")
+	d, ok = v.Remaining(now)
+	assert.True(t, ok, "bounded validity should have a remaining duration")
+	assert.Equal(t, time.Duration(0), d, "remaining validity of expired validity not as expected")
+
+	v = Validity{From: &future}
+	assert.False(t, v.Active(now), "validity should not be active yet")
+	assert.False(t, v.Expired(now), "validity should not have expired")
+
+	sch := Schedule{Windows: []Window{{Start: "11:00", End: "12:30"}}}
+	v = Validity{Until: &future, Schedule: &sch}
+	d, ok = v.Remaining(now)
+	assert.True(t, ok, "scheduled validity should have a remaining duration")
+	assert.Equal(t, 30*time.Minute, d, "remaining validity should be limited by the schedule window")
+
+	v = Validity{From: &future, Until: &past}
+	assert.Error(t, v.Validate(), "validity ending before it starts should be invalid")
+}
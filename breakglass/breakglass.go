@@ -0,0 +1,213 @@
+// Package breakglass provides emergency access to role mappings for when the usual identity providers are unavailable.
+package breakglass
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/notification"
+	"github.com/jcmturner/awsfederation/secretstore"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MaxJustificationLength = 1024
+)
+
+// Validate checks the break-glass configuration is usable.
+func Validate(bg config.BreakGlass) error {
+	if bg.Domain == "" {
+		return errors.New("break-glass enabled but no domain for emergency identities defined")
+	}
+	if bg.CredentialsVaultPath == "" {
+		return errors.New("break-glass enabled but no path to emergency credentials in vault defined")
+	}
+	if len(bg.RoleMappings) < 1 {
+		return errors.New("break-glass enabled but no role mappings defined")
+	}
+	for _, id := range bg.RoleMappings {
+		if _, err := uuid.ParseUUID(id); err != nil {
+			return fmt.Errorf("break-glass role mapping ID %s not valid", id)
+		}
+	}
+	if bg.MaxDuration < 15 {
+		return errors.New("maximum duration for break-glass sessions must be at least 15 minutes")
+	}
+	if bg.AlertInterval < 0 {
+		return errors.New("break-glass alert interval must not be negative")
+	}
+	return nil
+}
+
+// LoadCredentials reads the emergency identities' secrets from the secret store.
+func LoadCredentials(p string, s secretstore.Store) (map[string]string, error) {
+	m, err := s.Read(p)
+	if err != nil {
+		return nil, err
+	}
+	creds := make(map[string]string)
+	for k, v := range m {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("secret for emergency identity %s in vault not valid", k)
+		}
+		creds[k] = s
+	}
+	if len(creds) < 1 {
+		return nil, errors.New("no emergency identities found in vault")
+	}
+	return creds, nil
+}
+
+// Authenticate checks the secret provided for the emergency identity.
+func Authenticate(username, secret string, c *config.Config) bool {
+	s, ok := c.Server.BreakGlass.Credentials[username]
+	if !ok {
+		return false
+	}
+	// Compare digests so that the comparison does not leak the length of the secret.
+	a := sha256.Sum256([]byte(secret))
+	b := sha256.Sum256([]byte(s))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// IsDomain returns true if the domain is the one used by emergency identities.
+func IsDomain(domain string, c *config.Config) bool {
+	return c.Server.BreakGlass.Enabled && strings.EqualFold(domain, c.Server.BreakGlass.Domain)
+}
+
+// EmergencyIdentity returns true if the identity is one of the break-glass emergency identities.
+func EmergencyIdentity(u goidentity.Identity, c *config.Config) bool {
+	return IsDomain(u.Domain(), c)
+}
+
+// RoleMapping returns true if the role mapping is configured as a break-glass role mapping.
+func RoleMapping(id string, c *config.Config) bool {
+	if !c.Server.BreakGlass.Enabled {
+		return false
+	}
+	for _, rm := range c.Server.BreakGlass.RoleMappings {
+		if strings.EqualFold(rm, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateJustification checks the justification given for use of a break-glass role mapping.
+func ValidateJustification(j string) error {
+	if strings.TrimSpace(j) == "" {
+		return appcodes.ErrBreakGlass{AppCode: appcodes.BreakGlassNotJustified}.Errorf("a justification is required to assume a break-glass role mapping")
+	}
+	if len(j) > MaxJustificationLength {
+		return appcodes.ErrBreakGlass{AppCode: appcodes.BreakGlassNotJustified}.Errorf("justification must not be longer than %d characters", MaxJustificationLength)
+	}
+	return nil
+}
+
+// ClampDuration limits the session duration, in seconds, to the maximum allowed for break-glass sessions.
+func ClampDuration(d int64, c *config.Config) int64 {
+	max := int64(c.Server.BreakGlass.MaxDuration) * 60
+	if d <= 0 || d > max {
+		return max
+	}
+	return d
+}
+
+// Alert raises a high severity audit event and sends it to the configured webhook. The alerts of the same event for
+// the same user and remote address are sent at most once per BreakGlass.AlertInterval so that repeated failures do not
+// flood the webhook. The remote address is empty if not known. Every alert is written to the audit log.
+func Alert(l config.AuditLogLine, remoteAddr string, detail interface{}, c *config.Config) {
+	l.Severity = config.AuditSeverityHigh
+	c.AuditLog(l)
+	e := notification.Event{
+		EventType:  l.EventType,
+		EventUUID:  l.UUID,
+		Time:       l.Time,
+		Username:   l.Username,
+		UserDomain: l.UserDomain,
+		Severity:   l.Severity,
+		Detail:     detail,
+	}
+	interval := time.Duration(c.Server.BreakGlass.AlertInterval) * time.Second
+	if interval <= 0 {
+		notification.Send(e, c)
+		return
+	}
+	if alerts.allow(e, remoteAddr, interval, c) {
+		notification.Send(e, c)
+	}
+}
+
+// MaxAlertSources is the number of users and remote addresses that alerts are throttled for separately. Once reached
+// the alerts from further sources are throttled together.
+const MaxAlertSources = 1024
+
+// SuppressedAlerts is the detail of the alert sent at the end of an interval in which alerts were not sent.
+type SuppressedAlerts struct {
+	EventType  string
+	RemoteAddr string
+	Count      int
+	Interval   string
+}
+
+var alerts = &throttle{sources: make(map[string]*alertSource)}
+
+type throttle struct {
+	mux     sync.Mutex
+	sources map[string]*alertSource
+}
+
+type alertSource struct {
+	suppressed int
+}
+
+// allow returns true if the event should be sent. Otherwise it is counted and the count is sent once the interval since
+// the last event sent has passed.
+func (t *throttle) allow(e notification.Event, remoteAddr string, interval time.Duration, c *config.Config) bool {
+	key := strings.Join([]string{e.EventType, e.Username, e.UserDomain, remoteAddr}, "\x00")
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.sources[key]; !ok && len(t.sources) >= MaxAlertSources {
+		key, remoteAddr = "", ""
+		e.Username, e.UserDomain = "", ""
+	}
+	if s, ok := t.sources[key]; ok {
+		s.suppressed++
+		return false
+	}
+	s := new(alertSource)
+	t.sources[key] = s
+	time.AfterFunc(interval, func() {
+		t.mux.Lock()
+		delete(t.sources, key)
+		n := s.suppressed
+		t.mux.Unlock()
+		if n < 1 {
+			return
+		}
+		id, _ := uuid.GenerateUUID()
+		notification.Send(notification.Event{
+			EventType:  "BreakGlassAlertsSuppressed",
+			EventUUID:  id,
+			Time:       time.Now().UTC(),
+			Username:   e.Username,
+			UserDomain: e.UserDomain,
+			Severity:   config.AuditSeverityHigh,
+			Detail: SuppressedAlerts{
+				EventType:  e.EventType,
+				RemoteAddr: remoteAddr,
+				Count:      n,
+				Interval:   interval.String(),
+			},
+		}, c)
+	})
+	return true
+}
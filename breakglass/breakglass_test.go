@@ -0,0 +1,129 @@
+package breakglass
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/notification"
+	"github.com/stretchr/testify/assert"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	roleMappingID      = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	otherRoleMappingID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+)
+
+func breakGlassConfig() *config.Config {
+	c := config.NewConfig()
+	c.Server.BreakGlass.Enabled = true
+	c.Server.BreakGlass.CredentialsVaultPath = "/secret/breakglass"
+	c.Server.BreakGlass.RoleMappings = []string{roleMappingID}
+	c.Server.BreakGlass.Credentials = map[string]string{"emergency1": "sealedsecret"}
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	c := breakGlassConfig()
+	assert.NoError(t, Validate(c.Server.BreakGlass), "valid break-glass configuration returned an error")
+
+	bg := c.Server.BreakGlass
+	bg.RoleMappings = []string{}
+	assert.Error(t, Validate(bg), "expected error with no role mappings")
+	bg.RoleMappings = []string{"notauuid"}
+	assert.Error(t, Validate(bg), "expected error with an invalid role mapping ID")
+
+	bg = c.Server.BreakGlass
+	bg.CredentialsVaultPath = ""
+	assert.Error(t, Validate(bg), "expected error with no vault path")
+
+	bg = c.Server.BreakGlass
+	bg.MaxDuration = 5
+	assert.Error(t, Validate(bg), "expected error with a maximum duration below the AWS minimum")
+}
+
+func TestAuthenticate(t *testing.T) {
+	c := breakGlassConfig()
+	assert.True(t, Authenticate("emergency1", "sealedsecret", c), "emergency identity should authenticate")
+	assert.False(t, Authenticate("emergency1", "wrong", c), "emergency identity should not authenticate with the wrong secret")
+	assert.False(t, Authenticate("emergency2", "sealedsecret", c), "unknown emergency identity should not authenticate")
+}
+
+func TestEmergencyIdentity(t *testing.T) {
+	c := breakGlassConfig()
+	u := goidentity.NewUser("emergency1")
+	u.SetDomain("breakglass")
+	assert.True(t, EmergencyIdentity(&u, c), "identity in the break-glass domain should be an emergency identity")
+	u.SetDomain("TESTING")
+	assert.False(t, EmergencyIdentity(&u, c), "identity in another domain should not be an emergency identity")
+
+	u.SetDomain("BREAKGLASS")
+	c.Server.BreakGlass.Enabled = false
+	assert.False(t, EmergencyIdentity(&u, c), "there should be no emergency identities when break-glass is disabled")
+}
+
+func TestRoleMapping(t *testing.T) {
+	c := breakGlassConfig()
+	assert.True(t, RoleMapping(roleMappingID, c), "role mapping should be a break-glass role mapping")
+	assert.False(t, RoleMapping(otherRoleMappingID, c), "role mapping should not be a break-glass role mapping")
+	c.Server.BreakGlass.Enabled = false
+	assert.False(t, RoleMapping(roleMappingID, c), "there should be no break-glass role mappings when break-glass is disabled")
+}
+
+func TestValidateJustification(t *testing.T) {
+	assert.NoError(t, ValidateJustification("Identity provider outage, incident 1234"), "valid justification returned an error")
+	for _, j := range []string{"", "   ", strings.Repeat("a", MaxJustificationLength+1)} {
+		err := ValidateJustification(j)
+		if assert.Error(t, err, "expected error for invalid justification") {
+			e, ok := err.(appcodes.ErrBreakGlass)
+			assert.True(t, ok, "error not of the expected type")
+			assert.Equal(t, appcodes.BreakGlassNotJustified, e.AppCode, "app code not as expected")
+		}
+	}
+}
+
+func TestClampDuration(t *testing.T) {
+	c := breakGlassConfig()
+	assert.Equal(t, int64(900), ClampDuration(3600, c), "duration should be clamped to the maximum")
+	assert.Equal(t, int64(900), ClampDuration(0, c), "duration not set should default to the maximum")
+	assert.Equal(t, int64(600), ClampDuration(600, c), "duration below the maximum should not be changed")
+}
+
+func TestThrottle(t *testing.T) {
+	received := make(chan notification.Event, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notification.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+	c := breakGlassConfig()
+	c.Notification.Webhook.Enabled = true
+	c.Notification.Webhook.URL = s.URL
+
+	th := &throttle{sources: make(map[string]*alertSource)}
+	e := notification.Event{EventType: "Break-glass Authentication Failed", Username: "emergency1", UserDomain: "BREAKGLASS"}
+	interval := 50 * time.Millisecond
+	assert.True(t, th.allow(e, "10.0.0.1:1234", interval, c), "first alert should be sent")
+	assert.False(t, th.allow(e, "10.0.0.1:1234", interval, c), "repeated alert should not be sent")
+	assert.False(t, th.allow(e, "10.0.0.1:1234", interval, c), "repeated alert should not be sent")
+	assert.True(t, th.allow(e, "10.0.0.2:1234", interval, c), "alert from another remote address should be sent")
+
+	select {
+	case got := <-received:
+		assert.Equal(t, "BreakGlassAlertsSuppressed", got.EventType, "summary of the alerts not sent not as expected")
+		assert.Equal(t, "emergency1", got.Username, "user of the alerts not sent not as expected")
+		d, _ := got.Detail.(map[string]interface{})
+		assert.Equal(t, "10.0.0.1:1234", d["RemoteAddr"], "remote address of the alerts not sent not as expected")
+		assert.Equal(t, float64(2), d["Count"], "count of the alerts not sent not as expected")
+	case <-time.After(time.Second):
+		t.Fatal("summary of the alerts not sent")
+	}
+	assert.True(t, th.allow(e, "10.0.0.1:1234", interval, c), "alert after the interval should be sent")
+}
@@ -0,0 +1,211 @@
+// Package catalogue exports and imports the federation catalogue as a single document. The catalogue is the account
+// classes, types and statuses, the accounts, the role mappings and the metadata of the federation users.
+package catalogue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/tracing"
+	"time"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+
+	KindAccountClass   = "AccountClass"
+	KindAccountType    = "AccountType"
+	KindAccountStatus  = "AccountStatus"
+	KindFederationUser = "FederationUser"
+	KindAccount        = "Account"
+	KindRoleMapping    = "RoleMapping"
+)
+
+// Catalogue is the document the catalogue is exported to and imported from. The credentials of the federation users
+// are held in the secret store and are never part of it.
+type Catalogue struct {
+	AccountClasses  []AccountClass   `json:"AccountClasses"`
+	AccountTypes    []AccountType    `json:"AccountTypes"`
+	AccountStatuses []AccountStatus  `json:"AccountStatuses"`
+	FederationUsers []FederationUser `json:"FederationUsers"`
+	Accounts        []Account        `json:"Accounts"`
+	RoleMappings    []RoleMapping    `json:"RoleMappings"`
+}
+
+type AccountClass struct {
+	ID    int    `json:"ID"`
+	Class string `json:"Class"`
+}
+
+type AccountType struct {
+	ID      int    `json:"ID"`
+	Type    string `json:"Type"`
+	ClassID int    `json:"ClassID"`
+}
+
+type AccountStatus struct {
+	ID     int    `json:"ID"`
+	Status string `json:"Status"`
+}
+
+// FederationUser is the metadata of a federation user. Its credentials are stored through the federation user API.
+type FederationUser struct {
+	ARN  string `json:"Arn"`
+	Name string `json:"Name"`
+	TTL  int64  `json:"TTL"`
+}
+
+type Account struct {
+	ID                string `json:"ID"`
+	Email             string `json:"Email"`
+	Name              string `json:"Name"`
+	TypeID            int    `json:"TypeID"`
+	StatusID          int    `json:"StatusID"`
+	FederationUserARN string `json:"FederationUserARN"`
+}
+
+type RoleMapping struct {
+	ID                string          `json:"ID"`
+	AccountID         string          `json:"AccountID"`
+	RoleARN           string          `json:"RoleARN"`
+	AuthzAttribute    string          `json:"AuthzAttribute"`
+	Policy            string          `json:"Policy,omitempty"`
+	Duration          int             `json:"Duration,omitempty"`
+	SessionNameFormat string          `json:"SessionNameFormat,omitempty"`
+	ValidFrom         *time.Time      `json:"ValidFrom,omitempty"`
+	ValidUntil        *time.Time      `json:"ValidUntil,omitempty"`
+	Schedule          *authz.Schedule `json:"Schedule,omitempty"`
+}
+
+// Parse reads a catalogue document in either JSON or YAML format. Fields that are not part of the catalogue are
+// rejected so that mistakes in a hand written document are not silently ignored.
+func Parse(b []byte) (c Catalogue, err error) {
+	err = yaml.Unmarshal(b, &c, yaml.DisallowUnknownFields)
+	if err != nil {
+		err = fmt.Errorf("could not parse catalogue: %v", err)
+	}
+	return
+}
+
+// Marshal returns the catalogue document in the format given.
+func (c Catalogue) Marshal(format string) ([]byte, error) {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return b, nil
+	case FormatYAML:
+		return yaml.JSONToYAML(b)
+	default:
+		return nil, fmt.Errorf("unsupported format for catalogue: %s", format)
+	}
+}
+
+// Export reads the catalogue from the database. It is read in a single transaction so that the entities it holds
+// are consistent with each other.
+func Export(ctx context.Context, conn *database.Conn) (c Catalogue, err error) {
+	ctx, span := tracing.Start(ctx, "catalogue export")
+	defer func() { tracing.End(span, err) }()
+	tx, err := database.Begin(ctx, conn.DB, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	return load(ctx, tx, *conn.Stmts)
+}
+
+// load reads the catalogue within the transaction. Kinds without any entities are empty rather than nil so that they
+// are still listed in the document.
+func load(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap) (c Catalogue, err error) {
+	c = Catalogue{
+		AccountClasses:  []AccountClass{},
+		AccountTypes:    []AccountType{},
+		AccountStatuses: []AccountStatus{},
+		FederationUsers: []FederationUser{},
+		Accounts:        []Account{},
+		RoleMappings:    []RoleMapping{},
+	}
+	for _, l := range []struct {
+		stmtKey int
+		name    string
+		scan    func(*sql.Rows) error
+	}{
+		{database.StmtKeyCatalogueAcctClasses, "account classes", func(rows *sql.Rows) error {
+			var e AccountClass
+			err := rows.Scan(&e.ID, &e.Class)
+			c.AccountClasses = append(c.AccountClasses, e)
+			return err
+		}},
+		{database.StmtKeyCatalogueAcctTypes, "account types", func(rows *sql.Rows) error {
+			var e AccountType
+			err := rows.Scan(&e.ID, &e.Type, &e.ClassID)
+			c.AccountTypes = append(c.AccountTypes, e)
+			return err
+		}},
+		{database.StmtKeyCatalogueAcctStatuses, "account statuses", func(rows *sql.Rows) error {
+			var e AccountStatus
+			err := rows.Scan(&e.ID, &e.Status)
+			c.AccountStatuses = append(c.AccountStatuses, e)
+			return err
+		}},
+		{database.StmtKeyCatalogueFedUsers, "federation users", func(rows *sql.Rows) error {
+			var e FederationUser
+			err := rows.Scan(&e.ARN, &e.Name, &e.TTL)
+			c.FederationUsers = append(c.FederationUsers, e)
+			return err
+		}},
+		{database.StmtKeyCatalogueAccts, "accounts", func(rows *sql.Rows) error {
+			var e Account
+			err := rows.Scan(&e.ID, &e.Email, &e.Name, &e.TypeID, &e.StatusID, &e.FederationUserARN)
+			c.Accounts = append(c.Accounts, e)
+			return err
+		}},
+		{database.StmtKeyCatalogueRoleMappings, "role mappings", func(rows *sql.Rows) error {
+			var e RoleMapping
+			var policy, sessFmt, sch sql.NullString
+			var duration sql.NullInt64
+			err := rows.Scan(&e.ID, &e.AccountID, &e.RoleARN, &e.AuthzAttribute, &policy, &duration, &sessFmt, &e.ValidFrom, &e.ValidUntil, &sch)
+			if err != nil {
+				return err
+			}
+			e.Policy, e.SessionNameFormat, e.Duration = policy.String, sessFmt.String, int(duration.Int64)
+			if sch.Valid {
+				e.Schedule, err = authz.ParseSchedule(sch.String)
+				if err != nil {
+					return fmt.Errorf("invalid schedule stored against role mapping %s: %v", e.ID, err)
+				}
+			}
+			c.RoleMappings = append(c.RoleMappings, e)
+			return nil
+		}},
+	} {
+		stmt, ok := stmtMap[l.stmtKey]
+		if !ok {
+			return c, fmt.Errorf("prepared statement for reading the %s of the catalogue not found", l.name)
+		}
+		var rows *sql.Rows
+		rows, err = database.TxQuery(ctx, tx, stmt)
+		if err != nil {
+			return c, fmt.Errorf("error reading the %s of the catalogue: %v", l.name, err)
+		}
+		for rows.Next() {
+			if err = l.scan(rows); err != nil {
+				rows.Close()
+				return c, fmt.Errorf("error reading the %s of the catalogue: %v", l.name, err)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return c, fmt.Errorf("error reading the %s of the catalogue: %v", l.name, err)
+		}
+	}
+	return c, nil
+}
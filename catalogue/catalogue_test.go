@@ -0,0 +1,242 @@
+package catalogue
+
+import (
+	"context"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+const (
+	testAccountID1  = "012345678912"
+	testAccountID2  = "123456789012"
+	testFedUserARN1 = "arn:aws:iam::012345678912:user/TestFedUser1"
+	testRoleARN1    = "arn:aws:iam::012345678912:role/rolename1"
+	testRoleARN2    = "arn:aws:iam::123456789012:role/rolename2"
+	testUUID1       = "6901e2f6-0677-4a0c-95f8-174testuuid1"
+	testUUID2       = "6901e2f6-0677-4a0c-95f8-174testuuid2"
+	testActor       = "testuser@TESTING"
+)
+
+func testCatalogue() Catalogue {
+	from := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	return Catalogue{
+		AccountClasses:  []AccountClass{{ID: 1, Class: "Production"}},
+		AccountTypes:    []AccountType{{ID: 1, Type: "Standard", ClassID: 1}},
+		AccountStatuses: []AccountStatus{{ID: 1, Status: "Active"}},
+		FederationUsers: []FederationUser{{ARN: testFedUserARN1, Name: "TestFedUser1", TTL: 900}},
+		Accounts:        []Account{{ID: testAccountID1, Email: "one@example.com", Name: "One", TypeID: 1, StatusID: 1, FederationUserARN: testFedUserARN1}},
+		RoleMappings: []RoleMapping{{ID: testUUID1, AccountID: testAccountID1, RoleARN: testRoleARN1, AuthzAttribute: "group1", ValidFrom: &from,
+			Schedule: &authz.Schedule{Timezone: "Europe/London", Windows: []authz.Window{{Days: []string{"Mon"}, Start: "09:00", End: "17:00"}}}}},
+	}
+}
+
+// expectLock sets the expectation of an import locking the catalogue.
+func expectLock(ep map[int]*sqlmock.ExpectedPrepare) {
+	ep[database.StmtKeyCatalogueLock].ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+// expectLoad sets the expectations of reading the catalogue given from the database.
+func expectLoad(ep map[int]*sqlmock.ExpectedPrepare, c Catalogue) {
+	rows := sqlmock.NewRows([]string{"id", "class"})
+	for _, e := range c.AccountClasses {
+		rows.AddRow(e.ID, e.Class)
+	}
+	ep[database.StmtKeyCatalogueAcctClasses].ExpectQuery().WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "type", "class_id"})
+	for _, e := range c.AccountTypes {
+		rows.AddRow(e.ID, e.Type, e.ClassID)
+	}
+	ep[database.StmtKeyCatalogueAcctTypes].ExpectQuery().WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "status"})
+	for _, e := range c.AccountStatuses {
+		rows.AddRow(e.ID, e.Status)
+	}
+	ep[database.StmtKeyCatalogueAcctStatuses].ExpectQuery().WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"arn", "name", "ttl"})
+	for _, e := range c.FederationUsers {
+		rows.AddRow(e.ARN, e.Name, e.TTL)
+	}
+	ep[database.StmtKeyCatalogueFedUsers].ExpectQuery().WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "email", "name", "type", "status", "feduser"})
+	for _, e := range c.Accounts {
+		rows.AddRow(e.ID, e.Email, e.Name, e.TypeID, e.StatusID, e.FederationUserARN)
+	}
+	ep[database.StmtKeyCatalogueAccts].ExpectQuery().WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "account", "arn", "authz", "policy", "duration", "sessfmt", "validfrom", "validuntil", "schedule"})
+	for _, e := range c.RoleMappings {
+		var sch interface{}
+		if s := e.scheduleValue(); s != nil {
+			sch = *s
+		}
+		rows.AddRow(e.ID, e.AccountID, e.RoleARN, e.AuthzAttribute, nil, nil, nil, e.ValidFrom, e.ValidUntil, sch)
+	}
+	ep[database.StmtKeyCatalogueRoleMappings].ExpectQuery().WillReturnRows(rows)
+}
+
+// expectHistory sets the expectation of the change being recorded in the history of the entity.
+func expectHistory(ep map[int]*sqlmock.ExpectedPrepare, resource, id, action, actor string) {
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WithArgs(resource, id, action, actor, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestCatalogue_MarshalParse(t *testing.T) {
+	c := testCatalogue()
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		b, err := c.Marshal(format)
+		if err != nil {
+			t.Fatalf("error marshaling catalogue to %s: %v", format, err)
+		}
+		p, err := Parse(b)
+		if err != nil {
+			t.Fatalf("error parsing %s catalogue: %v", format, err)
+		}
+		assert.Equal(t, c, p, "%s catalogue not the same once parsed", format)
+	}
+	_, err := c.Marshal("xml")
+	assert.Error(t, err, "unsupported format accepted")
+	_, err = Parse([]byte("AccountClasses:\n- ID: 1\n  Clas: Production\n"))
+	assert.Error(t, err, "unknown field accepted")
+}
+
+func TestCatalogue_Validate(t *testing.T) {
+	c := testCatalogue()
+	c.normalise()
+	assert.NoError(t, c.Validate(), "catalogue should be valid")
+
+	c.AccountClasses = append(c.AccountClasses, AccountClass{ID: 1, Class: "Dev"})
+	c.Accounts[0].ID = "12345"
+	c.RoleMappings[0].AccountID = testAccountID2
+	c.RoleMappings = append(c.RoleMappings, RoleMapping{RoleARN: testRoleARN2, AuthzAttribute: `glob("aws-*") AND`})
+	c.normalise()
+	err := c.Validate()
+	if assert.IsType(t, ValidationError{}, err, "catalogue should not be valid") {
+		assert.Equal(t, []string{
+			"AccountClass 1 is given more than once",
+			"Account 12345: ID must be 12 digits",
+			"RoleMapping " + testUUID1 + ": account " + testAccountID2 + " is not the account of the Role ARN",
+			"RoleMapping for " + testRoleARN2 + " has no ID",
+			"RoleMapping : invalid authorization attribute or expression: unexpected end of expression",
+		}, err.(ValidationError).Problems, "problems not as expected")
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	c := testCatalogue()
+	mock.ExpectBegin()
+	expectLoad(ep, c)
+	mock.ExpectRollback()
+
+	e, err := Export(context.Background(), &database.Conn{DB: db, Stmts: stmtMap})
+	if err != nil {
+		t.Fatalf("error exporting catalogue: %v", err)
+	}
+	assert.Equal(t, c, e, "exported catalogue not as expected")
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
+
+func TestImport(t *testing.T) {
+	cur := testCatalogue()
+	cur.AccountStatuses = append(cur.AccountStatuses, AccountStatus{ID: 2, Status: "Suspended"})
+	cur.Accounts = append(cur.Accounts, Account{ID: testAccountID2, Email: "two@example.com", Name: "Two", TypeID: 1, StatusID: 2, FederationUserARN: testFedUserARN1})
+
+	doc := testCatalogue()
+	doc.AccountClasses = append(doc.AccountClasses, AccountClass{ID: 2, Class: "Development"})
+	doc.Accounts[0].Name = "One renamed"
+	doc.RoleMappings[0].AccountID = ""
+	doc.RoleMappings = append(doc.RoleMappings, RoleMapping{ID: testUUID2, RoleARN: testRoleARN1, AuthzAttribute: "group2"})
+
+	var tests = []struct {
+		Name    string
+		Options Options
+		Changes []Change
+		Expect  func(ep map[int]*sqlmock.ExpectedPrepare)
+	}{
+		{"create only", Options{}, []Change{
+			{Kind: KindAccountClass, ID: "2", Action: ActionCreate},
+			{Kind: KindAccount, ID: testAccountID1, Action: ActionConflict, Fields: []string{"Name"}},
+			{Kind: KindRoleMapping, ID: testUUID2, Action: ActionCreate},
+		}, func(ep map[int]*sqlmock.ExpectedPrepare) {
+			ep[database.StmtKeyCatalogueAcctClassAdd].ExpectExec().WithArgs(2, "Development").WillReturnResult(sqlmock.NewResult(2, 1))
+			expectHistory(ep, history.ResourceAccountClass, "2", history.ActionCreate, testActor)
+			ep[database.StmtKeyCatalogueRoleMappingRevive].ExpectExec().WithArgs(testAccountID1, testRoleARN1, "group2", "", 0, "", nil, nil, nil, testUUID2).WillReturnResult(sqlmock.NewResult(0, 0))
+			ep[database.StmtKeyRoleMappingInsert].ExpectExec().WithArgs(testUUID2, testAccountID1, testRoleARN1, "group2", "", 0, "", nil, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectHistory(ep, history.ResourceRoleMapping, testUUID2, history.ActionCreate, testActor)
+		}},
+		{"upsert and prune", Options{Upsert: true, Prune: true}, []Change{
+			{Kind: KindAccountClass, ID: "2", Action: ActionCreate},
+			{Kind: KindAccount, ID: testAccountID1, Action: ActionUpdate, Fields: []string{"Name"}},
+			{Kind: KindRoleMapping, ID: testUUID2, Action: ActionCreate},
+			{Kind: KindAccount, ID: testAccountID2, Action: ActionDelete},
+			{Kind: KindAccountStatus, ID: "2", Action: ActionDelete},
+		}, func(ep map[int]*sqlmock.ExpectedPrepare) {
+			ep[database.StmtKeyCatalogueAcctClassAdd].ExpectExec().WithArgs(2, "Development").WillReturnResult(sqlmock.NewResult(2, 1))
+			expectHistory(ep, history.ResourceAccountClass, "2", history.ActionCreate, testActor)
+			ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs("one@example.com", "One renamed", 1, 1, testFedUserARN1, testAccountID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectHistory(ep, history.ResourceAccount, testAccountID1, history.ActionUpdate, testActor)
+			ep[database.StmtKeyCatalogueRoleMappingRevive].ExpectExec().WithArgs(testAccountID1, testRoleARN1, "group2", "", 0, "", nil, nil, nil, testUUID2).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectHistory(ep, history.ResourceRoleMapping, testUUID2, history.ActionRestore, testActor)
+			ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(testAccountID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectHistory(ep, history.ResourceAccount, testAccountID2, history.ActionDelete, testActor)
+			ep[database.StmtKeyAcctStatusDelete].ExpectExec().WithArgs(2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectHistory(ep, history.ResourceAccountStatus, "2", history.ActionDelete, testActor)
+		}},
+	}
+	for _, test := range tests {
+		for _, dryRun := range []bool{false, true} {
+			db, mock, ep, stmtMap := database.Mock(t)
+			mock.ExpectBegin()
+			expectLock(ep)
+			expectLoad(ep, cur)
+			test.Expect(ep)
+			if dryRun {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+			opts := test.Options
+			opts.DryRun = dryRun
+			opts.Actor = testActor
+			rep, err := Import(context.Background(), &database.Conn{DB: db, Stmts: stmtMap}, doc, opts)
+			if err != nil {
+				t.Fatalf("error importing catalogue (%s): %v", test.Name, err)
+			}
+			var changes []Change
+			for _, ch := range rep.Changes {
+				changes = append(changes, Change{Kind: ch.Kind, ID: ch.ID, Action: ch.Action, Fields: ch.Fields})
+			}
+			assert.Equal(t, test.Changes, changes, "changes not as expected (%s)", test.Name)
+			assert.Equal(t, opts, rep.Options, "options not reported (%s)", test.Name)
+			assert.Equal(t, 5, rep.Unchanged, "unchanged entities not as expected (%s)", test.Name)
+			assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected (%s, dry run %t)", test.Name, dryRun)
+			db.Close()
+		}
+	}
+}
+
+func TestImport_References(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	mock.ExpectBegin()
+	expectLock(ep)
+	expectLoad(ep, testCatalogue())
+	mock.ExpectRollback()
+
+	doc := testCatalogue()
+	doc.AccountClasses = nil
+	doc.Accounts[0].StatusID = 2
+	_, err := Import(context.Background(), &database.Conn{DB: db, Stmts: stmtMap}, doc, Options{Prune: true})
+	if assert.IsType(t, ValidationError{}, err, "missing references should not be valid") {
+		assert.Equal(t, []string{
+			"AccountType 1 refers to AccountClass 1 which does not exist",
+			"Account " + testAccountID1 + " refers to AccountStatus 2 which does not exist",
+		}, err.(ValidationError).Problems, "problems not as expected")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
@@ -0,0 +1,571 @@
+package catalogue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsarn"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/jcmturner/awsfederation/tracing"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionCreate   = "Create"
+	ActionUpdate   = "Update"
+	ActionDelete   = "Delete"
+	ActionConflict = "Conflict"
+)
+
+var accountIDFormat = regexp.MustCompile(`^[0-9]{12}$`)
+
+// historyResources are the resources the history of each kind of entity is recorded under. The history of the
+// federation users is not recorded.
+var historyResources = map[string]string{
+	KindAccountClass:  history.ResourceAccountClass,
+	KindAccountType:   history.ResourceAccountType,
+	KindAccountStatus: history.ResourceAccountStatus,
+	KindAccount:       history.ResourceAccount,
+	KindRoleMapping:   history.ResourceRoleMapping,
+}
+
+// Options of an import. Entities in the document that are not in the database are always created. Upsert also updates
+// the entities that differ from the document, otherwise these are reported as conflicts and left unchanged. Prune
+// deletes the entities that are not in the document, apart from the federation users as their credentials are deleted
+// through the federation user API. DryRun makes the changes and reports them but then rolls them back. Actor is who the
+// changes are recorded against in the history of the entities, the service itself if not set.
+type Options struct {
+	DryRun bool   `json:"DryRun"`
+	Upsert bool   `json:"Upsert"`
+	Prune  bool   `json:"Prune"`
+	Actor  string `json:"-"`
+}
+
+// Report is the difference between the catalogue in the database and the document imported, and so the changes made
+// by the import.
+type Report struct {
+	Options
+	Created   int      `json:"Created"`
+	Updated   int      `json:"Updated"`
+	Deleted   int      `json:"Deleted"`
+	Conflicts int      `json:"Conflicts"`
+	Unchanged int      `json:"Unchanged"`
+	Changes   []Change `json:"Changes"`
+}
+
+// StaleCredentials returns which cached credentials may no longer be valid once the changes of the report are made.
+// A change to an account or federation user can affect any role mapping so all are stale, otherwise only those of the
+// role mappings updated or deleted.
+func (r Report) StaleCredentials() (all bool, roleMappings []string) {
+	for _, ch := range r.Changes {
+		if ch.Action != ActionUpdate && ch.Action != ActionDelete {
+			continue
+		}
+		switch ch.Kind {
+		case KindAccount, KindFederationUser:
+			return true, nil
+		case KindRoleMapping:
+			roleMappings = append(roleMappings, ch.ID)
+		}
+	}
+	return
+}
+
+// Change is a difference of an entity. Fields lists the fields that differ when the entity is updated or in conflict.
+// Before is the entity in the database and After the entity in the document.
+type Change struct {
+	Kind   string      `json:"Kind"`
+	ID     string      `json:"ID"`
+	Action string      `json:"Action"`
+	Fields []string    `json:"Fields,omitempty"`
+	Before interface{} `json:"Before,omitempty"`
+	After  interface{} `json:"After,omitempty"`
+}
+
+// ValidationError lists the problems found with a catalogue document.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("catalogue not valid: %s", strings.Join(e.Problems, "; "))
+}
+
+// entity is an item of the catalogue. Its fields are the values it stores in the database in the form they are
+// compared.
+type entity interface {
+	key() string
+	fields() []field
+}
+
+type field struct {
+	name  string
+	value interface{}
+}
+
+// kind is the entities of a kind in the catalogue.
+type kind struct {
+	name     string
+	entities []entity
+}
+
+func (e AccountClass) key() string { return strconv.Itoa(e.ID) }
+func (e AccountClass) fields() []field {
+	return []field{{"Class", e.Class}}
+}
+
+func (e AccountType) key() string { return strconv.Itoa(e.ID) }
+func (e AccountType) fields() []field {
+	return []field{{"Type", e.Type}, {"ClassID", e.ClassID}}
+}
+
+func (e AccountStatus) key() string { return strconv.Itoa(e.ID) }
+func (e AccountStatus) fields() []field {
+	return []field{{"Status", e.Status}}
+}
+
+func (e FederationUser) key() string { return e.ARN }
+func (e FederationUser) fields() []field {
+	return []field{{"Name", e.Name}, {"TTL", e.TTL}}
+}
+
+func (e Account) key() string { return e.ID }
+func (e Account) fields() []field {
+	return []field{{"Email", e.Email}, {"Name", e.Name}, {"TypeID", e.TypeID}, {"StatusID", e.StatusID}, {"FederationUserARN", e.FederationUserARN}}
+}
+
+func (e RoleMapping) key() string { return e.ID }
+func (e RoleMapping) fields() []field {
+	var sch string
+	if s := e.scheduleValue(); s != nil {
+		sch = *s
+	}
+	return []field{
+		{"AccountID", e.AccountID},
+		{"RoleARN", e.RoleARN},
+		{"AuthzAttribute", e.AuthzAttribute},
+		{"Policy", e.Policy},
+		{"Duration", e.Duration},
+		{"SessionNameFormat", e.SessionNameFormat},
+		{"ValidFrom", timeValue(e.ValidFrom)},
+		{"ValidUntil", timeValue(e.ValidUntil)},
+		{"Schedule", sch},
+	}
+}
+
+// scheduleValue returns the schedule in the form stored in the database.
+func (e RoleMapping) scheduleValue() *string {
+	if e.Schedule == nil {
+		return nil
+	}
+	s := e.Schedule.String()
+	return &s
+}
+
+func timeValue(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// kinds returns the entities of the catalogue by kind, in the order they are created so that the entities they refer
+// to exist first. They are deleted in the reverse order.
+func (c Catalogue) kinds() []kind {
+	ks := []kind{
+		{name: KindAccountClass},
+		{name: KindAccountType},
+		{name: KindAccountStatus},
+		{name: KindFederationUser},
+		{name: KindAccount},
+		{name: KindRoleMapping},
+	}
+	for _, e := range c.AccountClasses {
+		ks[0].entities = append(ks[0].entities, e)
+	}
+	for _, e := range c.AccountTypes {
+		ks[1].entities = append(ks[1].entities, e)
+	}
+	for _, e := range c.AccountStatuses {
+		ks[2].entities = append(ks[2].entities, e)
+	}
+	for _, e := range c.FederationUsers {
+		ks[3].entities = append(ks[3].entities, e)
+	}
+	for _, e := range c.Accounts {
+		ks[4].entities = append(ks[4].entities, e)
+	}
+	for _, e := range c.RoleMappings {
+		ks[5].entities = append(ks[5].entities, e)
+	}
+	return ks
+}
+
+// normalise sets the values of the document that are derived from others or stored with less precision, so that
+// they compare equal to those read back from the database.
+func (c *Catalogue) normalise() {
+	for i := range c.RoleMappings {
+		rm := &c.RoleMappings[i]
+		if a, err := awsarn.Parse(rm.RoleARN, nil); err == nil && rm.AccountID == "" {
+			rm.AccountID = a.AccountID
+		}
+		for _, t := range []**time.Time{&rm.ValidFrom, &rm.ValidUntil} {
+			if *t != nil {
+				u := (*t).UTC().Truncate(time.Second)
+				*t = &u
+			}
+		}
+	}
+}
+
+// Validate checks the entities of the document and returns a ValidationError listing all the problems found. Whether
+// the entities they refer to exist is checked when the document is imported.
+func (c Catalogue) Validate() error {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	for _, k := range c.kinds() {
+		seen := make(map[string]bool)
+		for _, e := range k.entities {
+			if seen[e.key()] {
+				problem("%s %s is given more than once", k.name, e.key())
+			}
+			seen[e.key()] = true
+		}
+	}
+	for _, e := range c.AccountClasses {
+		if e.ID < 1 || e.Class == "" {
+			problem("AccountClass %d must have an ID greater than 0 and a class", e.ID)
+		}
+	}
+	for _, e := range c.AccountTypes {
+		if e.ID < 1 || e.Type == "" {
+			problem("AccountType %d must have an ID greater than 0 and a type", e.ID)
+		}
+	}
+	for _, e := range c.AccountStatuses {
+		if e.ID < 1 || e.Status == "" {
+			problem("AccountStatus %d must have an ID greater than 0 and a status", e.ID)
+		}
+	}
+	for _, e := range c.FederationUsers {
+		if _, err := federationuser.ValidateFederationUserARN(e.ARN); err != nil {
+			problem("FederationUser %s: %v", e.ARN, err)
+		}
+		if e.TTL < 0 {
+			problem("FederationUser %s: TTL cannot be negative", e.ARN)
+		}
+	}
+	for _, e := range c.Accounts {
+		if !accountIDFormat.MatchString(e.ID) {
+			problem("Account %s: ID must be 12 digits", e.ID)
+		}
+		if e.Email == "" || e.Name == "" {
+			problem("Account %s: email and name must be given", e.ID)
+		}
+	}
+	for _, e := range c.RoleMappings {
+		if e.ID == "" {
+			problem("RoleMapping for %s has no ID", e.RoleARN)
+		}
+		a, err := awsarn.Parse(e.RoleARN, nil)
+		if err != nil {
+			problem("RoleMapping %s: invalid Role ARN: %v", e.ID, err)
+		} else if e.AccountID != a.AccountID {
+			problem("RoleMapping %s: account %s is not the account of the Role ARN", e.ID, e.AccountID)
+		}
+		if err := authz.Validate(e.AuthzAttribute); err != nil {
+			problem("RoleMapping %s: invalid authorization attribute or expression: %v", e.ID, err)
+		}
+		v := authz.Validity{From: e.ValidFrom, Until: e.ValidUntil, Schedule: e.Schedule}
+		if err := v.Validate(); err != nil {
+			problem("RoleMapping %s: invalid validity: %v", e.ID, err)
+		}
+	}
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// references checks that the entities of the document refer to entities that will exist after the import.
+func references(cur, doc Catalogue, opts Options) error {
+	exists := make(map[string]map[string]bool)
+	for i, k := range doc.kinds() {
+		exists[k.name] = make(map[string]bool)
+		for _, e := range k.entities {
+			exists[k.name][e.key()] = true
+		}
+		if !opts.Prune || k.name == KindFederationUser {
+			for _, e := range cur.kinds()[i].entities {
+				exists[k.name][e.key()] = true
+			}
+		}
+	}
+	var problems []string
+	check := func(k, id, refKind, ref string) {
+		if !exists[refKind][ref] {
+			problems = append(problems, fmt.Sprintf("%s %s refers to %s %s which does not exist", k, id, refKind, ref))
+		}
+	}
+	for _, e := range doc.AccountTypes {
+		check(KindAccountType, e.key(), KindAccountClass, strconv.Itoa(e.ClassID))
+	}
+	for _, e := range doc.Accounts {
+		check(KindAccount, e.ID, KindAccountType, strconv.Itoa(e.TypeID))
+		check(KindAccount, e.ID, KindAccountStatus, strconv.Itoa(e.StatusID))
+		check(KindAccount, e.ID, KindFederationUser, e.FederationUserARN)
+	}
+	for _, e := range doc.RoleMappings {
+		check(KindRoleMapping, e.ID, KindAccount, e.AccountID)
+	}
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// diff returns the report of the changes needed to import the document into the catalogue.
+func diff(cur, doc Catalogue, opts Options) Report {
+	rep := Report{Options: opts}
+	ck, dk := cur.kinds(), doc.kinds()
+	for i, k := range dk {
+		current := make(map[string]entity)
+		for _, e := range ck[i].entities {
+			current[e.key()] = e
+		}
+		for _, e := range k.entities {
+			c, ok := current[e.key()]
+			if !ok {
+				rep.Created++
+				rep.Changes = append(rep.Changes, Change{Kind: k.name, ID: e.key(), Action: ActionCreate, After: e})
+				continue
+			}
+			fs := changedFields(c, e)
+			switch {
+			case len(fs) == 0:
+				rep.Unchanged++
+			case opts.Upsert:
+				rep.Updated++
+				rep.Changes = append(rep.Changes, Change{Kind: k.name, ID: e.key(), Action: ActionUpdate, Fields: fs, Before: c, After: e})
+			default:
+				rep.Conflicts++
+				rep.Changes = append(rep.Changes, Change{Kind: k.name, ID: e.key(), Action: ActionConflict, Fields: fs, Before: c, After: e})
+			}
+		}
+	}
+	if !opts.Prune {
+		return rep
+	}
+	for i := len(ck) - 1; i >= 0; i-- {
+		if ck[i].name == KindFederationUser {
+			continue
+		}
+		wanted := make(map[string]bool)
+		for _, e := range dk[i].entities {
+			wanted[e.key()] = true
+		}
+		for _, e := range ck[i].entities {
+			if !wanted[e.key()] {
+				rep.Deleted++
+				rep.Changes = append(rep.Changes, Change{Kind: ck[i].name, ID: e.key(), Action: ActionDelete, Before: e})
+			}
+		}
+	}
+	return rep
+}
+
+// changedFields returns the names of the fields that differ between the entities.
+func changedFields(a, b entity) []string {
+	var fs []string
+	af, bf := a.fields(), b.fields()
+	for i := range af {
+		if af[i].value != bf[i].value {
+			fs = append(fs, af[i].name)
+		}
+	}
+	return fs
+}
+
+// Import changes the catalogue in the database to match the document according to the options given. All the changes
+// are made in a single transaction, so if any fails none are made. The report of the changes is returned even when a
+// change fails so that it can be seen which were attempted.
+func Import(ctx context.Context, conn *database.Conn, doc Catalogue, opts Options) (rep Report, err error) {
+	ctx, span := tracing.Start(ctx, "catalogue import")
+	defer func() { tracing.End(span, err) }()
+	doc.normalise()
+	if err = doc.Validate(); err != nil {
+		return
+	}
+	tx, err := database.Begin(ctx, conn.DB, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	stmtMap := *conn.Stmts
+	if err = lock(ctx, tx, stmtMap); err != nil {
+		return
+	}
+	cur, err := load(ctx, tx, stmtMap)
+	if err != nil {
+		return
+	}
+	if err = references(cur, doc, opts); err != nil {
+		return
+	}
+	rep = diff(cur, doc, opts)
+	for _, ch := range rep.Changes {
+		var revived bool
+		if revived, err = apply(ctx, tx, stmtMap, ch); err != nil {
+			err = fmt.Errorf("error applying %s of %s %s: %v", strings.ToLower(ch.Action), ch.Kind, ch.ID, err)
+			return
+		}
+		if err = record(ctx, tx, stmtMap, ch, revived, opts.Actor); err != nil {
+			err = fmt.Errorf("error recording history of %s %s: %v", ch.Kind, ch.ID, err)
+			return
+		}
+	}
+	if opts.DryRun {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// lock waits for any other import to finish and then stops others starting until the transaction is committed or
+// rolled back.
+func lock(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap) error {
+	stmt, ok := stmtMap[database.StmtKeyCatalogueLock]
+	if !ok {
+		return errors.New("prepared statement for locking the catalogue not found")
+	}
+	var id int
+	if err := database.TxQueryRow(ctx, tx, stmt).Scan(&id); err != nil {
+		return fmt.Errorf("could not lock the catalogue: %v", err)
+	}
+	return nil
+}
+
+// apply makes the change within the transaction. An account or role mapping that is created while a deleted one with
+// the same ID exists is revived instead, in which case revived is true.
+func apply(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap, ch Change) (revived bool, err error) {
+	var stmtKey int
+	var args []interface{}
+	switch ch.Action {
+	case ActionCreate:
+		switch e := ch.After.(type) {
+		case AccountClass:
+			stmtKey, args = database.StmtKeyCatalogueAcctClassAdd, []interface{}{e.ID, e.Class}
+		case AccountType:
+			stmtKey, args = database.StmtKeyCatalogueAcctTypeAdd, []interface{}{e.ID, e.Type, e.ClassID}
+		case AccountStatus:
+			stmtKey, args = database.StmtKeyCatalogueAcctStatusAdd, []interface{}{e.ID, e.Status}
+		case FederationUser:
+			stmtKey, args = database.StmtKeyFedUserInsert, []interface{}{e.ARN, e.Name, e.TTL}
+		case Account:
+			if revived, err = revive(ctx, tx, stmtMap, database.StmtKeyCatalogueAcctRevive, e.Email, e.Name, e.TypeID, e.StatusID, e.FederationUserARN, e.ID); err != nil || revived {
+				return
+			}
+			stmtKey, args = database.StmtKeyAcctInsert, []interface{}{e.ID, e.Email, e.Name, e.TypeID, e.StatusID, e.FederationUserARN}
+		case RoleMapping:
+			if revived, err = revive(ctx, tx, stmtMap, database.StmtKeyCatalogueRoleMappingRevive, e.AccountID, e.RoleARN, e.AuthzAttribute, e.Policy, e.Duration, e.SessionNameFormat, e.ValidFrom, e.ValidUntil, e.scheduleValue(), e.ID); err != nil || revived {
+				return
+			}
+			stmtKey, args = database.StmtKeyRoleMappingInsert, []interface{}{e.ID, e.AccountID, e.RoleARN, e.AuthzAttribute, e.Policy, e.Duration, e.SessionNameFormat, e.ValidFrom, e.ValidUntil, e.scheduleValue()}
+		}
+	case ActionUpdate:
+		switch e := ch.After.(type) {
+		case AccountClass:
+			stmtKey, args = database.StmtKeyAcctClassUpdate, []interface{}{e.Class, e.ID, 0, 0}
+		case AccountType:
+			stmtKey, args = database.StmtKeyAcctTypeUpdate, []interface{}{e.Type, e.ClassID, e.ID, 0, 0}
+		case AccountStatus:
+			stmtKey, args = database.StmtKeyAcctStatusUpdate, []interface{}{e.Status, e.ID, 0, 0}
+		case FederationUser:
+			stmtKey, args = database.StmtKeyCatalogueFedUserUpdate, []interface{}{e.Name, e.TTL, e.ARN}
+		case Account:
+			stmtKey, args = database.StmtKeyAcctUpdate, []interface{}{e.Email, e.Name, e.TypeID, e.StatusID, e.FederationUserARN, e.ID, 0, 0}
+		case RoleMapping:
+			stmtKey, args = database.StmtKeyRoleMappingUpdate, []interface{}{e.AccountID, e.RoleARN, e.AuthzAttribute, e.Policy, e.Duration, e.SessionNameFormat, e.ValidFrom, e.ValidUntil, e.scheduleValue(), e.ID, 0, 0}
+		}
+	case ActionDelete:
+		switch e := ch.Before.(type) {
+		case AccountClass:
+			stmtKey, args = database.StmtKeyAcctClassDelete, []interface{}{e.ID, 0, 0}
+		case AccountType:
+			stmtKey, args = database.StmtKeyAcctTypeDelete, []interface{}{e.ID, 0, 0}
+		case AccountStatus:
+			stmtKey, args = database.StmtKeyAcctStatusDelete, []interface{}{e.ID, 0, 0}
+		case Account:
+			stmtKey, args = database.StmtKeyAcctDelete, []interface{}{e.ID, 0, 0}
+		case RoleMapping:
+			stmtKey, args = database.StmtKeyRoleMappingDelete, []interface{}{e.ID, 0, 0}
+		}
+	default:
+		return
+	}
+	stmt, ok := stmtMap[stmtKey]
+	if !ok {
+		return false, fmt.Errorf("prepared statement %d not found", stmtKey)
+	}
+	res, err := database.TxExec(ctx, tx, stmt, args...)
+	if err != nil {
+		return
+	}
+	if i, e := res.RowsAffected(); i != 1 || e != nil {
+		return false, fmt.Errorf("expected (1) row affected, got (%d); error: %v", i, e)
+	}
+	return
+}
+
+// revive undeletes the entity with the statement given, returning false if there is no deleted entity to revive.
+func revive(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap, stmtKey int, args ...interface{}) (bool, error) {
+	stmt, ok := stmtMap[stmtKey]
+	if !ok {
+		return false, fmt.Errorf("prepared statement %d not found", stmtKey)
+	}
+	res, err := database.TxExec(ctx, tx, stmt, args...)
+	if err != nil {
+		return false, err
+	}
+	i, err := res.RowsAffected()
+	return i == 1, err
+}
+
+// record adds the change to the history of the entity within the transaction.
+func record(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap, ch Change, revived bool, actor string) error {
+	resource, ok := historyResources[ch.Kind]
+	if !ok {
+		return nil
+	}
+	var action string
+	switch ch.Action {
+	case ActionCreate:
+		action = history.ActionCreate
+		if revived {
+			action = history.ActionRestore
+		}
+	case ActionUpdate:
+		action = history.ActionUpdate
+	case ActionDelete:
+		action = history.ActionDelete
+	default:
+		return nil
+	}
+	if actor == "" {
+		actor = history.SystemActor
+	}
+	e, err := history.New(resource, ch.ID, action, actor, ch.Before, ch.After)
+	if err != nil {
+		return err
+	}
+	return history.RecordTx(ctx, tx, stmtMap, e)
+}
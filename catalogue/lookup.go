@@ -0,0 +1,110 @@
+package catalogue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/database"
+)
+
+// HistoryResource returns the resource the history of the kind of entity is recorded under. False is returned if the
+// history of the kind is not recorded.
+func HistoryResource(kind string) (string, bool) {
+	r, ok := historyResources[kind]
+	return r, ok
+}
+
+// Lookup reads the entity of the kind and ID given from the database, in the form of the catalogue document in which
+// its history is recorded. Nil is returned if the entity does not exist or has been deleted. Federation users cannot
+// be looked up.
+func Lookup(ctx context.Context, stmtMap database.StmtMap, kind, id string) (interface{}, error) {
+	return lookup(stmtMap, kind, id, func(stmt *sql.Stmt) *sql.Row {
+		return database.QueryRow(ctx, stmt, id)
+	})
+}
+
+// LookupTx reads the entity as Lookup does but within the transaction, so that it can be read before and after a
+// change made in the same transaction.
+func LookupTx(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap, kind, id string) (interface{}, error) {
+	return lookup(stmtMap, kind, id, func(stmt *sql.Stmt) *sql.Row {
+		return database.TxQueryRow(ctx, tx, stmt, id)
+	})
+}
+
+func lookup(stmtMap database.StmtMap, kind, id string, queryRow func(*sql.Stmt) *sql.Row) (interface{}, error) {
+	var stmtKey int
+	switch kind {
+	case KindAccountClass:
+		stmtKey = database.StmtKeyAcctClassSelect
+	case KindAccountType:
+		stmtKey = database.StmtKeyAcctTypeSelect
+	case KindAccountStatus:
+		stmtKey = database.StmtKeyAcctStatusSelect
+	case KindAccount:
+		stmtKey = database.StmtKeyAcctSelect
+	case KindRoleMapping:
+		stmtKey = database.StmtKeyRoleMappingSelect
+	default:
+		return nil, fmt.Errorf("cannot look up entity of kind %s", kind)
+	}
+	stmt, ok := stmtMap[stmtKey]
+	if !ok {
+		return nil, fmt.Errorf("prepared statement for looking up %s not found", kind)
+	}
+	row := queryRow(stmt)
+	var e interface{}
+	var err error
+	switch kind {
+	case KindAccountClass:
+		var a AccountClass
+		var version int64
+		err = row.Scan(&a.ID, &a.Class, &version)
+		e = a
+	case KindAccountType:
+		var a AccountType
+		var version int64
+		err = row.Scan(&a.ID, &a.Type, &a.ClassID, &version)
+		e = a
+	case KindAccountStatus:
+		var a AccountStatus
+		var version int64
+		err = row.Scan(&a.ID, &a.Status, &version)
+		e = a
+	case KindAccount:
+		var a Account
+		var typ, class, status string
+		var classID int
+		var version int64
+		err = row.Scan(&a.ID, &a.Email, &a.Name, &a.TypeID, &typ, &classID, &class, &a.StatusID, &status, &a.FederationUserARN, &version)
+		e = a
+	case KindRoleMapping:
+		e, err = scanRoleMapping(row)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s %s: %v", kind, id, err)
+	}
+	return e, nil
+}
+
+// scanRoleMapping reads the role mapping from the row of the API's statement selecting a role mapping.
+func scanRoleMapping(row *sql.Row) (e RoleMapping, err error) {
+	var policy, sessFmt, sch sql.NullString
+	var duration sql.NullInt64
+	var version int64
+	err = row.Scan(&e.ID, &e.AccountID, &e.RoleARN, &e.AuthzAttribute, &policy, &duration, &sessFmt, &e.ValidFrom, &e.ValidUntil, &sch, &version)
+	if err != nil {
+		return
+	}
+	e.Policy, e.SessionNameFormat, e.Duration = policy.String, sessFmt.String, int(duration.Int64)
+	if sch.Valid {
+		e.Schedule, err = authz.ParseSchedule(sch.String)
+		if err != nil {
+			err = fmt.Errorf("invalid schedule stored against role mapping %s: %v", e.ID, err)
+		}
+	}
+	return
+}
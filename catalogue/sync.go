@@ -0,0 +1,211 @@
+package catalogue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/tracing"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const syncAuditUser = "awsfederation"
+
+// SyncStatus is the outcome of syncing the catalogue with its definitions. Revision identifies the definitions last
+// read. Drift is the difference between the database and the definitions found by the last successful sync, which
+// has been corrected unless the sync was a dry run. InSync is whether the database matched the definitions once the
+// last sync was complete.
+type SyncStatus struct {
+	Directory   string     `json:"Directory"`
+	Revision    string     `json:"Revision"`
+	LastRun     *time.Time `json:"LastRun"`
+	LastSuccess *time.Time `json:"LastSuccess"`
+	InSync      bool       `json:"InSync"`
+	Managed     int        `json:"Managed"`
+	Error       string     `json:"Error,omitempty"`
+	Drift       *Report    `json:"Drift"`
+}
+
+// Syncer keeps the catalogue in the database in line with the definitions in a directory. The entities defined are
+// managed by the Syncer.
+type Syncer struct {
+	dir     string
+	run     sync.Mutex
+	mux     sync.RWMutex
+	status  SyncStatus
+	managed map[string]bool
+}
+
+// NewSyncer returns a Syncer of the definitions in the directory given.
+func NewSyncer(dir string) *Syncer {
+	return &Syncer{
+		dir:     dir,
+		status:  SyncStatus{Directory: dir},
+		managed: make(map[string]bool),
+	}
+}
+
+// ReadDir reads the catalogue defined by the YAML and JSON files in the directory and its subdirectories. Each file
+// holds part of the catalogue in the form of an exported document and the parts are merged. Hidden files and
+// directories, such as the .git directory of a working copy, are skipped. The revision returned is a digest of the
+// files read, so changes whenever the definitions do.
+func ReadDir(dir string) (c Catalogue, revision string, err error) {
+	h := sha256.New()
+	var n int
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		part, err := Parse(b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(b))
+		h.Write(b)
+		c.merge(part)
+		n++
+		return nil
+	})
+	if err != nil {
+		return c, "", fmt.Errorf("error reading catalogue definitions: %v", err)
+	}
+	if n == 0 {
+		// Rather than pruning the whole catalogue because the directory has not been populated.
+		return c, "", fmt.Errorf("no catalogue definitions found in %s", dir)
+	}
+	return c, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// merge adds the entities of the part given to the catalogue.
+func (c *Catalogue) merge(p Catalogue) {
+	c.AccountClasses = append(c.AccountClasses, p.AccountClasses...)
+	c.AccountTypes = append(c.AccountTypes, p.AccountTypes...)
+	c.AccountStatuses = append(c.AccountStatuses, p.AccountStatuses...)
+	c.FederationUsers = append(c.FederationUsers, p.FederationUsers...)
+	c.Accounts = append(c.Accounts, p.Accounts...)
+	c.RoleMappings = append(c.RoleMappings, p.RoleMappings...)
+}
+
+// Sync reads the definitions and imports them, updating the entities that differ and, if pruning, deleting those not
+// defined. The sync settings are taken from the configuration given so that they can be reloaded. As imports lock the
+// catalogue, the servers sharing the database sync one at a time.
+func (s *Syncer) Sync(ctx context.Context, conn *database.Conn, c *config.Config) (st SyncStatus, err error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	ctx, span := tracing.Start(ctx, "catalogue sync")
+	defer func() { tracing.End(span, err) }()
+	cs := c.Server.CatalogueSync
+	now := time.Now().UTC()
+	var rep Report
+	doc, rev, err := ReadDir(s.dir)
+	if err == nil {
+		rep, err = Import(ctx, conn, doc, Options{DryRun: cs.DryRun, Upsert: true, Prune: cs.Prune, Actor: syncAuditUser})
+	}
+	if err == nil {
+		// Only once imported so that the entities that failed to import can still be corrected through the API.
+		s.setManaged(doc)
+	}
+	s.mux.Lock()
+	s.status.LastRun = &now
+	if rev != "" {
+		s.status.Revision = rev
+	}
+	s.status.InSync = false
+	s.status.Error = ""
+	if err != nil {
+		s.status.Error = err.Error()
+	} else {
+		s.status.LastSuccess = &now
+		s.status.InSync = !rep.DryRun || len(rep.Changes) == 0
+		s.status.Drift = &rep
+	}
+	st = s.status
+	s.mux.Unlock()
+	if err == nil && !rep.DryRun && len(rep.Changes) > 0 {
+		auditSync(ctx, st, c)
+	}
+	return
+}
+
+// setManaged records the entities defined as those managed.
+func (s *Syncer) setManaged(doc Catalogue) {
+	m := make(map[string]bool)
+	for _, k := range doc.kinds() {
+		for _, e := range k.entities {
+			m[k.name+"/"+e.key()] = true
+		}
+	}
+	s.mux.Lock()
+	s.managed = m
+	s.status.Managed = len(m)
+	s.mux.Unlock()
+}
+
+// Status returns the outcome of the last sync.
+func (s *Syncer) Status() SyncStatus {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.status
+}
+
+// Managed indicates if the entity of the kind and ID given is defined in the definitions last read. A nil Syncer
+// manages nothing.
+func (s *Syncer) Managed(kind, id string) bool {
+	if s == nil {
+		return false
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.managed[kind+"/"+id]
+}
+
+func auditSync(ctx context.Context, st SyncStatus, c *config.Config) {
+	eventUUID, _ := uuid.GenerateUUID()
+	b, _ := json.Marshal(struct {
+		Directory string
+		Revision  string
+		Created   int
+		Updated   int
+		Deleted   int
+	}{st.Directory, st.Revision, st.Drift.Created, st.Drift.Updated, st.Drift.Deleted})
+	l := config.AuditLogLine{
+		Username:  syncAuditUser,
+		EventType: "Catalogue Sync",
+		Time:      time.Now().UTC(),
+		UUID:      eventUUID,
+		Outcome:   config.AuditOutcomeSuccess,
+		Detail:    url.QueryEscape(string(b)),
+	}
+	c.AuditLog(l)
+	auditstore.Record(ctx, c, l, auditstore.Reference{})
+}
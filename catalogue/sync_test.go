@@ -0,0 +1,136 @@
+package catalogue
+
+import (
+	"context"
+	"errors"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDefinitions writes the catalogue given into a directory, split between a YAML and a JSON file.
+func writeDefinitions(t *testing.T, dir string, c Catalogue) {
+	a := Catalogue{AccountClasses: c.AccountClasses, AccountTypes: c.AccountTypes, AccountStatuses: c.AccountStatuses, FederationUsers: c.FederationUsers}
+	b := Catalogue{Accounts: c.Accounts, RoleMappings: c.RoleMappings}
+	for _, f := range []struct {
+		name   string
+		format string
+		part   Catalogue
+	}{
+		{"classification.yaml", FormatYAML, a},
+		{filepath.Join("accounts", "one.json"), FormatJSON, b},
+	} {
+		d, err := f.part.Marshal(f.format)
+		if err != nil {
+			t.Fatalf("error marshaling definitions: %v", err)
+		}
+		p := filepath.Join(dir, f.name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, d, 0644); err != nil {
+			t.Fatalf("error writing definitions: %v", err)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogue")
+	if err != nil {
+		t.Fatalf("error creating definitions directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	_, _, err = ReadDir(dir)
+	assert.Error(t, err, "an empty directory should not be read as an empty catalogue")
+
+	writeDefinitions(t, dir, testCatalogue())
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".git", "config.yaml"), []byte("core: {}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# Catalogue\n"), 0644)
+	c, rev, err := ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading definitions: %v", err)
+	}
+	assert.Equal(t, testCatalogue(), c, "definitions not read as expected")
+	assert.Len(t, rev, 64, "revision should be a SHA-256 digest")
+
+	ioutil.WriteFile(filepath.Join(dir, "statuses.yml"), []byte("AccountStatuses:\n- ID: 2\n  Status: Suspended\n"), 0644)
+	c, rev2, err := ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading definitions: %v", err)
+	}
+	assert.Len(t, c.AccountStatuses, 2, "definitions of the new file not merged")
+	assert.NotEqual(t, rev, rev2, "revision should change with the definitions")
+
+	ioutil.WriteFile(filepath.Join(dir, "statuses.yml"), []byte("AccountStatuses:\n- ID: 2\n  State: Suspended\n"), 0644)
+	_, _, err = ReadDir(dir)
+	assert.Error(t, err, "invalid definitions should not be read")
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogue")
+	if err != nil {
+		t.Fatalf("error creating definitions directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeDefinitions(t, dir, testCatalogue())
+	c := config.NewConfig()
+	c.SetAuditLogFile("null")
+	cur := testCatalogue()
+	cur.Accounts[0].Name = "One changed through the API"
+
+	sy := NewSyncer(dir)
+	for _, dryRun := range []bool{false, true} {
+		db, mock, ep, stmtMap := database.Mock(t)
+		mock.ExpectBegin()
+		expectLock(ep)
+		expectLoad(ep, cur)
+		ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs("one@example.com", "One", 1, 1, testFedUserARN1, testAccountID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(ep, history.ResourceAccount, testAccountID1, history.ActionUpdate, history.SystemActor)
+		if dryRun {
+			mock.ExpectRollback()
+		} else {
+			mock.ExpectCommit()
+		}
+		c.Server.CatalogueSync.DryRun = dryRun
+		st, err := sy.Sync(context.Background(), &database.Conn{DB: db, Stmts: stmtMap}, c)
+		if err != nil {
+			t.Fatalf("error syncing catalogue (dry run %t): %v", dryRun, err)
+		}
+		assert.Equal(t, st, sy.Status(), "status not kept")
+		assert.Equal(t, !dryRun, st.InSync, "in sync not as expected (dry run %t)", dryRun)
+		assert.Equal(t, 1, st.Drift.Updated, "drift not as expected (dry run %t)", dryRun)
+		assert.Equal(t, 6, st.Managed, "managed entities not as expected")
+		assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected (dry run %t)", dryRun)
+		db.Close()
+	}
+	assert.True(t, sy.Managed(KindAccount, testAccountID1), "account defined should be managed")
+	assert.False(t, sy.Managed(KindAccount, testAccountID2), "account not defined should not be managed")
+	var none *Syncer
+	assert.False(t, none.Managed(KindAccount, testAccountID1), "nil syncer should manage nothing")
+
+	ok := sy.Status()
+	// The definitions are not managed until they have been imported
+	sy2 := NewSyncer(dir)
+	db, mock, ep, stmtMap := database.Mock(t)
+	mock.ExpectBegin()
+	ep[database.StmtKeyCatalogueLock].ExpectQuery().WillReturnError(errors.New("lock wait timeout exceeded"))
+	mock.ExpectRollback()
+	_, err = sy2.Sync(context.Background(), &database.Conn{DB: db, Stmts: stmtMap}, c)
+	assert.Error(t, err, "sync should fail when the catalogue cannot be locked")
+	assert.False(t, sy2.Managed(KindAccount, testAccountID1), "definitions not imported should not be managed")
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected when the catalogue cannot be locked")
+	db.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("Accounts: {"), 0644)
+	st, err := sy.Sync(context.Background(), &database.Conn{}, c)
+	assert.Error(t, err, "invalid definitions should not be synced")
+	assert.NotEmpty(t, st.Error, "error not reported in the status")
+	assert.False(t, st.InSync, "should not be in sync after an error")
+	assert.Equal(t, ok.LastSuccess, st.LastSuccess, "last success should be kept")
+	assert.Equal(t, ok.Revision, st.Revision, "revision of the definitions last read should be kept")
+}
@@ -6,6 +6,10 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/auditchain"
+	"github.com/jcmturner/awsfederation/logsink"
+	"github.com/jcmturner/awsfederation/tracing"
 	"github.com/jcmturner/restclient"
 	"github.com/jcmturner/vaultclient"
 	krb5config "gopkg.in/jcmturner/gokrb5.v4/config"
@@ -13,7 +17,6 @@ import (
 	"gopkg.in/ldap.v2"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"strings"
@@ -95,12 +98,18 @@ const (
 `
 	MockStaticSecret    = "mocktestsecret"
 	MockStaticAttribute = "authzattrib"
+	AuditSeverityHigh   = "High"
+	AuditOutcomeSuccess = "Success"
+	AuditOutcomeFailure = "Failure"
 )
 
 type Config struct {
-	Server   Server   `json:"Server"`
-	Vault    Vault    `json:"Vault"`
-	Database Database `json:"Database"`
+	Server       Server            `json:"Server"`
+	Vault        Vault             `json:"Vault"`
+	Database     Database          `json:"Database"`
+	Notification Notification      `json:"Notification"`
+	SecretStore  SecretStore       `json:"SecretStore"`
+	Sources      map[string]string `json:"-"`
 }
 
 type Vault struct {
@@ -108,16 +117,190 @@ type Vault struct {
 	Credentials *vaultclient.Credentials `json:"Credentials"`
 }
 
+const (
+	SecretStoreVaultAppID      = "VaultAppID"
+	SecretStoreVaultAppRole    = "VaultAppRole"
+	SecretStoreVaultToken      = "VaultToken"
+	SecretStoreVaultKubernetes = "VaultKubernetes"
+	SecretStoreFile            = "File"
+)
+
+// SecretStore configures where the server's secrets are held. The vault types connect to the vault defined in the
+// Vault section, the VaultAppID type also authenticating with the credentials defined there.
+// The File type is an encrypted local file intended for development only.
+type SecretStore struct {
+	Type       string          `json:"Type"` // VaultAppID, VaultAppRole, VaultToken, VaultKubernetes or File
+	AppRole    VaultAppRole    `json:"AppRole"`
+	Token      VaultToken      `json:"Token"`
+	Kubernetes VaultKubernetes `json:"Kubernetes"`
+	File       FileStore       `json:"File"`
+}
+
+type VaultAppRole struct {
+	MountPath    string `json:"MountPath"`
+	RoleID       string `json:"RoleID"`
+	SecretID     string `json:"SecretID"`
+	SecretIDFile string `json:"SecretIDFile"`
+}
+
+type VaultToken struct {
+	Token     string `json:"Token"`
+	TokenFile string `json:"TokenFile"`
+}
+
+type VaultKubernetes struct {
+	MountPath               string `json:"MountPath"`
+	Role                    string `json:"Role"`
+	ServiceAccountTokenFile string `json:"ServiceAccountTokenFile"`
+}
+
+type FileStore struct {
+	Path    string `json:"Path"`
+	KeyFile string `json:"KeyFile"` // File containing the hex encoded 256 bit encryption key
+}
+
+// IsVault indicates if the secret store is a vault.
+func (s SecretStore) IsVault() bool {
+	return !strings.EqualFold(s.Type, SecretStoreFile)
+}
+
 type Server struct {
-	Socket         string         `json:"Socket"`
-	TLS            TLS            `json:"TLS"`
-	Authentication Authentication `json:"Authentication"`
-	Logging        *Loggers       `json:"Logging"`
+	Socket            string            `json:"Socket"`
+	TLS               TLS               `json:"TLS"`
+	Timeouts          Timeouts          `json:"Timeouts"`
+	Authentication    Authentication    `json:"Authentication"`
+	Logging           *Loggers          `json:"Logging"`
+	RoleMappingExpiry RoleMappingExpiry `json:"RoleMappingExpiry"`
+	AccessRequest     AccessRequest     `json:"AccessRequest"`
+	BreakGlass        BreakGlass        `json:"BreakGlass"`
+	RateLimit         RateLimit         `json:"RateLimit"`
+	CredentialCache   CredentialCache   `json:"CredentialCache"`
+	CatalogueSync     CatalogueSync     `json:"CatalogueSync"`
+	Tracing           tracing.Config    `json:"Tracing"`
+	// RequireIfMatch rejects updates and deletions of accounts, role mappings and account classes, types and statuses
+	// that do not give the ETag of the version being changed in an If-Match header.
+	RequireIfMatch bool `json:"RequireIfMatch"`
+}
+
+// Timeouts configures the HTTP server. Shutdown is how long in-flight requests are given to complete when the server
+// is stopped.
+type Timeouts struct {
+	Read     int `json:"Read"`     // Duration in seconds
+	Write    int `json:"Write"`    // Duration in seconds
+	Idle     int `json:"Idle"`     // Duration in seconds
+	Shutdown int `json:"Shutdown"` // Duration in seconds
+}
+
+type RoleMappingExpiry struct {
+	Enabled  bool   `json:"Enabled"`
+	Action   string `json:"Action"`   // Report or Delete
+	Interval int    `json:"Interval"` // Duration in minutes
+}
+
+type AccessRequest struct {
+	MaxDuration int `json:"MaxDuration"` // Duration in minutes
+}
+
+// BreakGlass configures emergency access for when the usual identity providers are unavailable.
+// The emergency identities' secrets are held in the vault at CredentialsVaultPath as a map of username to secret.
+// Emergency identities authenticate with basic authentication in the Domain and may only assume the RoleMappings listed.
+// An alert of the same event for the same user and remote address is sent to the webhook at most once per AlertInterval,
+// the alerts in between are counted and reported together.
+type BreakGlass struct {
+	Enabled              bool              `json:"Enabled"`
+	Domain               string            `json:"Domain"`
+	CredentialsVaultPath string            `json:"CredentialsVaultPath"`
+	Credentials          map[string]string `json:"-"`
+	RoleMappings         []string          `json:"RoleMappings"`
+	MaxDuration          int               `json:"MaxDuration"`   // Duration in minutes
+	AlertInterval        int               `json:"AlertInterval"` // Duration in seconds
+}
+
+// RateLimit configures token bucket limits on federation requests.
+// The buckets are held in the database when the Store is Database so that the limits apply across all replicas.
+type RateLimit struct {
+	Enabled        bool   `json:"Enabled"`
+	Store          string `json:"Store"` // Database or Memory
+	User           Limit  `json:"User"`
+	RoleMapping    Limit  `json:"RoleMapping"`
+	FederationUser Limit  `json:"FederationUser"`
+}
+
+// Limit defines a token bucket. A Rate of zero means no limit.
+type Limit struct {
+	Rate  float64 `json:"Rate"` // Requests per minute
+	Burst int     `json:"Burst"`
+}
+
+// CredentialCache caches the credentials issued to a user session for a role mapping in memory, returning them again
+// while they have more than MinRemaining left. Each server has its own cache, so after a change to a role mapping or
+// federation user, the other servers sharing the database can return credentials issued before the change until they
+// expire.
+type CredentialCache struct {
+	Enabled      bool `json:"Enabled"`
+	MinRemaining int  `json:"MinRemaining"` // Duration in minutes
+}
+
+// CatalogueSync keeps the catalogue in the database in line with the definitions in the YAML and JSON files of the
+// Directory, usually the working copy of a git repository. The differences are applied every Interval unless DryRun
+// is set, in which case they are only reported as drift. Prune deletes the entities that are not defined. ReadOnly
+// rejects changes through the API to the entities that are defined.
+type CatalogueSync struct {
+	Enabled   bool   `json:"Enabled"`
+	Directory string `json:"Directory"`
+	Interval  int    `json:"Interval"` // Duration in seconds
+	DryRun    bool   `json:"DryRun"`
+	Prune     bool   `json:"Prune"`
+	ReadOnly  bool   `json:"ReadOnly"`
+}
+
+type Notification struct {
+	Webhook Webhook `json:"Webhook"`
+}
+
+type Webhook struct {
+	Enabled     bool   `json:"Enabled"`
+	URL         string `json:"URL"`
+	Timeout     int    `json:"Timeout"`     // Duration in seconds
+	MaxInFlight int    `json:"MaxInFlight"` // Notifications being delivered at once, further low severity notifications are dropped
+	MaxBacklog  int    `json:"MaxBacklog"`  // High severity notifications queued when MaxInFlight is reached
 }
 
 type Database struct {
-	ConnectionString     string `json:"ConnectionString"`
-	CredentialsVaultPath string `json:"CredentialsVaultPath"`
+	ConnectionString     string             `json:"ConnectionString"`
+	CredentialsVaultPath string             `json:"CredentialsVaultPath"`
+	DynamicCredentials   DynamicCredentials `json:"DynamicCredentials"`
+	TLS                  DatabaseTLS        `json:"TLS"`
+}
+
+const (
+	DBTLSVerifyFull  = "VerifyFull"
+	DBTLSVerifyCA    = "VerifyCA"
+	DBTLSSkipVerify  = "SkipVerify"
+	DBTLSDefaultMode = DBTLSVerifyFull
+)
+
+// DatabaseTLS configures TLS for the connections to the database, including the admin connection used to initialise
+// it. With the VerifyFull mode the server's certificate must be issued by a trusted CA and match the server name,
+// VerifyCA only checks the certificate is issued by a trusted CA and SkipVerify does not check the certificate.
+// The CAFile is a PEM bundle of the trusted CAs. If not set the system's trusted CAs are used.
+// A client certificate is presented if the CertificateFile and KeyFile are set.
+type DatabaseTLS struct {
+	Enabled         bool   `json:"Enabled"`
+	CAFile          string `json:"CAFile"`
+	CertificateFile string `json:"CertificateFile"`
+	KeyFile         string `json:"KeyFile"`
+	ServerName      string `json:"ServerName"` // Defaults to the host in the connection string
+	VerifyMode      string `json:"VerifyMode"` // VerifyFull, VerifyCA or SkipVerify
+}
+
+// DynamicCredentials configures the use of short-lived credentials issued by the vault's database secrets engine.
+// When enabled the Database.CredentialsVaultPath is the credentials path of the engine's role, for example
+// /database/creds/awsfederation. The lease is renewed in the background and, once it can no longer be extended, new
+// credentials are obtained and the server reconnects to the database before the old ones expire.
+type DynamicCredentials struct {
+	Enabled     bool `json:"Enabled"`
+	RenewBefore int  `json:"RenewBefore"` // Seconds before the lease expires to renew it or obtain new credentials
 }
 
 type Authentication struct {
@@ -184,12 +367,43 @@ type TLS struct {
 }
 
 type Loggers struct {
-	AuditFile         string `json:"Audit"`
+	AuditFile         string     `json:"Audit"`
+	AuditChain        AuditChain `json:"AuditChain"`
+	AuditStore        AuditStore `json:"AuditStore"`
 	AuditEncoder      *json.Encoder
 	ApplicationFile   string `json:"Application"`
-	ApplicationLogger *log.Logger
+	ApplicationFormat string `json:"ApplicationFormat"` // JSON or logfmt
+	ApplicationLevel  string `json:"ApplicationLevel"`  // debug, info, warn or error
+	ApplicationLogger *applog.Logger
 	AccessLog         string `json:"Access"`
 	AccessEncoder     *json.Encoder
+	ApplicationSink   logsink.Config `json:"ApplicationSink"`
+	AuditSink         logsink.Config `json:"AuditSink"`
+	AccessSink        logsink.Config `json:"AccessSink"`
+	files             []*os.File
+	closers           []io.Closer
+}
+
+// AuditChain configures writing the audit log file as a tamper-evident chain. Each event is chained to the previous
+// with an HMAC using the hex encoded key in the KeyFile and every CheckpointInterval events, or every CheckpointPeriod
+// seconds if there are events not yet covered, a checkpoint is signed with the PEM encoded Ed25519 private key in the
+// SigningKeyFile.
+// The chain settings of an audit log file that is already open are kept until the server is restarted.
+type AuditChain struct {
+	Enabled            bool   `json:"Enabled"`
+	KeyFile            string `json:"KeyFile"`
+	SigningKeyFile     string `json:"SigningKeyFile"`
+	CheckpointInterval int    `json:"CheckpointInterval"`
+	CheckpointPeriod   int    `json:"CheckpointPeriod"` // Duration in seconds
+}
+
+// AuditStore configures storing the audit events in the database, in addition to the audit log, so that they can be
+// queried through the API. Events older than RetentionDays are purged every PurgeInterval. A RetentionDays of zero
+// keeps the events indefinitely.
+type AuditStore struct {
+	Enabled       bool `json:"Enabled"`
+	RetentionDays int  `json:"RetentionDays"`
+	PurgeInterval int  `json:"PurgeInterval"` // Duration in minutes
 }
 
 type AuditLogLine struct {
@@ -200,32 +414,54 @@ type AuditLogLine struct {
 	EventType     string    `json:"EventType"`
 	UUID          string    `json:"EventUUID"`
 	Detail        string    `json:"Detail"`
+	Severity      string    `json:"Severity,omitempty"`
+	Outcome       string    `json:"Outcome,omitempty"`
+	RequestID     string    `json:"RequestID,omitempty"`
 }
 
+// Load loads the configuration file, which can be JSON, YAML or TOML as indicated by its extension, and applies any
+// overrides from AWSFED_ prefixed environment variables.
 func Load(cfgPath string) (*Config, error) {
-	j, err := ioutil.ReadFile(cfgPath)
+	b, err := ioutil.ReadFile(cfgPath)
 	if err != nil {
 		return &Config{}, fmt.Errorf("could not load configuration: %v", err)
 	}
-	return Parse(j)
+	j, err := toJSON(b, FormatFromPath(cfgPath))
+	if err != nil {
+		return &Config{}, fmt.Errorf("configuration file could not be parsed as %s: %v", FormatFromPath(cfgPath), err)
+	}
+	return parse(j, SourceFile+" "+cfgPath)
 }
 
+// Parse parses the JSON configuration and applies any overrides from AWSFED_ prefixed environment variables.
 func Parse(b []byte) (c *Config, err error) {
+	return parse(b, SourceFile)
+}
+
+func parse(b []byte, src string) (c *Config, err error) {
 	c = NewConfig()
 	err = json.Unmarshal(b, &c)
 	if err != nil {
 		err = fmt.Errorf("configuration file could not be parsed: %v", err)
 		return
 	}
+	c.setFileSources(b, src)
+	err = c.applyEnv(os.Environ())
+	if err != nil {
+		err = fmt.Errorf("configuration could not be overridden from the environment: %v", err)
+		return
+	}
 	c.SetApplicationLogFile(c.Server.Logging.ApplicationFile)
 	c.SetAuditLogFile(c.Server.Logging.AuditFile)
 	c.SetAccessLogFile(c.Server.Logging.AccessLog)
-	c.Vault.Config.ReSTClientConfig.WithCAFilePath(*c.Vault.Config.ReSTClientConfig.TrustCACert)
-	if c.Vault.Credentials.UserID == "" {
+	if c.Vault.Config.ReSTClientConfig.TrustCACert != nil {
+		c.Vault.Config.ReSTClientConfig.WithCAFilePath(*c.Vault.Config.ReSTClientConfig.TrustCACert)
+	}
+	if strings.EqualFold(c.SecretStore.Type, SecretStoreVaultAppID) && c.Vault.Credentials.UserID == "" {
 		err = c.Vault.Credentials.ReadUserID()
 		if err != nil {
 			err = fmt.Errorf("error configuring vault client: %v", err)
-			c.ApplicationLogf(err.Error())
+			c.Logger().Error(err.Error())
 			return
 		}
 	}
@@ -233,9 +469,10 @@ func Parse(b []byte) (c *Config, err error) {
 }
 
 func NewConfig() *Config {
-	dl := log.New(os.Stdout, "AWS Federation Server: ", log.Ldate|log.Ltime)
+	dl := applog.New(os.Stdout, applog.FormatLogfmt, applog.LevelInfo)
 	je := json.NewEncoder(os.Stdout)
 	return &Config{
+		Sources: make(map[string]string),
 		Vault: Vault{
 			Config: &vaultclient.Config{
 				SecretsPath:      "/secret/",
@@ -245,10 +482,82 @@ func NewConfig() *Config {
 		},
 		Server: Server{
 			Socket: "0.0.0.0:8443",
+			Authentication: Authentication{
+				ActiveSessionTimeout: 15,
+				SessionDuration:      60,
+			},
+			Timeouts: Timeouts{
+				Read:     30,
+				Write:    60,
+				Idle:     120,
+				Shutdown: 30,
+			},
 			Logging: &Loggers{
 				AuditEncoder:      je,
+				ApplicationFormat: applog.FormatLogfmt,
+				ApplicationLevel:  applog.LevelInfo.String(),
 				ApplicationLogger: dl,
 				AccessEncoder:     je,
+				AuditChain: AuditChain{
+					CheckpointInterval: 100,
+					CheckpointPeriod:   60,
+				},
+				AuditStore: AuditStore{
+					PurgeInterval: 60,
+				},
+				ApplicationSink: logsink.NewConfig(),
+				AuditSink:       logsink.NewConfig(),
+				AccessSink:      logsink.NewConfig(),
+			},
+			RoleMappingExpiry: RoleMappingExpiry{
+				Action:   "Report",
+				Interval: 60,
+			},
+			AccessRequest: AccessRequest{
+				MaxDuration: 480,
+			},
+			BreakGlass: BreakGlass{
+				Domain:        "BREAKGLASS",
+				MaxDuration:   15,
+				AlertInterval: 300,
+			},
+			RateLimit: RateLimit{
+				Store:          "Database",
+				User:           Limit{Rate: 10, Burst: 20},
+				RoleMapping:    Limit{Rate: 60, Burst: 60},
+				FederationUser: Limit{Rate: 300, Burst: 300},
+			},
+			CredentialCache: CredentialCache{
+				MinRemaining: 5,
+			},
+			CatalogueSync: CatalogueSync{
+				Interval: 60,
+			},
+			Tracing: tracing.NewConfig(),
+		},
+		Database: Database{
+			DynamicCredentials: DynamicCredentials{
+				RenewBefore: 300,
+			},
+			TLS: DatabaseTLS{
+				VerifyMode: DBTLSDefaultMode,
+			},
+		},
+		Notification: Notification{
+			Webhook: Webhook{
+				Timeout:     10,
+				MaxInFlight: 16,
+				MaxBacklog:  256,
+			},
+		},
+		SecretStore: SecretStore{
+			Type: SecretStoreVaultAppID,
+			AppRole: VaultAppRole{
+				MountPath: "approle",
+			},
+			Kubernetes: VaultKubernetes{
+				MountPath:               "kubernetes",
+				ServiceAccountTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
 			},
 		},
 	}
@@ -256,7 +565,7 @@ func NewConfig() *Config {
 
 func (c *Config) SetSocket(s string) *Config {
 	if _, err := net.ResolveTCPAddr("tcp", s); err != nil {
-		c.ApplicationLogf("invalid listener socket defined for server: %v\n", err)
+		c.Logger().Errorf("invalid listener socket defined for server: %v", err)
 		return c
 	}
 	c.Server.Socket = s
@@ -265,7 +574,7 @@ func (c *Config) SetSocket(s string) *Config {
 
 func (c *Config) SetTLS(tlsConf TLS) *Config {
 	if err := isKeyPairVaild(tlsConf.CertificateFile, tlsConf.KeyFile); err != nil {
-		c.ApplicationLogf("%s\n", err)
+		c.Logger().Error(err.Error())
 	}
 	c.Server.TLS = tlsConf
 	return c
@@ -292,7 +601,12 @@ func (c *Config) SetVault(addr, caFilePath, appID, userID, secretsRoot string) *
 	return c
 }
 
-func (c *Config) logWriter(p string) (w io.Writer, err error) {
+// logWriter returns the writer for the logger. Syslog and HTTP sinks are used regardless of the path, which is
+// otherwise the logger's file or stdout, stderr or null.
+func (c *Config) logWriter(name, p string, sink logsink.Config) (w io.Writer, err error) {
+	if !sink.IsFile() {
+		return c.openSink(name, p, sink)
+	}
 	switch strings.ToLower(p) {
 	case "":
 		err = errors.New("log destination not specified, defaulting to stdout")
@@ -305,20 +619,75 @@ func (c *Config) logWriter(p string) (w io.Writer, err error) {
 	case "null":
 		w = ioutil.Discard
 	default:
-		w, err = os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if sink.Rotation.Enabled() {
+			return c.openSink(name, p, sink)
+		}
+		var f *os.File
+		f, err = os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return
+		}
+		c.Server.Logging.files = append(c.Server.Logging.files, f)
+		w = f
 	}
 	return
 }
 
+// openSink opens the log sink, which is closed with the log files. If the sink cannot be opened the logger writes to
+// stderr.
+func (c *Config) openSink(name, p string, sink logsink.Config) (io.Writer, error) {
+	w, err := logsink.Open(name, p, sink)
+	if err != nil {
+		return os.Stderr, err
+	}
+	c.Server.Logging.closers = append(c.Server.Logging.closers, w)
+	return w, nil
+}
+
+// CloseLogs flushes and closes the log files. Events logged afterwards are written to stderr.
+func (c *Config) CloseLogs() error {
+	l := c.Server.Logging
+	je := json.NewEncoder(os.Stderr)
+	l.AuditEncoder = je
+	l.AccessEncoder = je
+	l.ApplicationLogger = c.newApplicationLogger(os.Stderr)
+	var errs []string
+	for _, cl := range l.closers {
+		if err := cl.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	l.closers = nil
+	for _, f := range l.files {
+		if err := f.Sync(); err != nil {
+			errs = append(errs, fmt.Sprintf("could not flush %s: %v", f.Name(), err))
+		}
+		if err := f.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("could not close %s: %v", f.Name(), err))
+		}
+	}
+	l.files = nil
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 func (c *Config) SetAuditLogger(e *json.Encoder) *Config {
 	c.Server.Logging.AuditEncoder = e
 	return c
 }
 
 func (c *Config) SetAuditLogFile(p string) *Config {
-	w, err := c.logWriter(p)
+	var w io.Writer
+	var err error
+	if c.Server.Logging.AuditChain.Enabled {
+		w, err = c.auditChainWriter(p)
+	} else {
+		w, err = c.logWriter("audit", p, c.Server.Logging.AuditSink)
+	}
 	if err != nil {
-		c.ApplicationLogf("could not open audit log file: %v\n", err)
+		c.Logger().Errorf("could not open audit log file: %v", err)
 	}
 	c.Server.Logging.AuditFile = p
 	enc := json.NewEncoder(w)
@@ -326,26 +695,56 @@ func (c *Config) SetAuditLogFile(p string) *Config {
 	return c
 }
 
-func (c *Config) SetApplicationLogger(l *log.Logger) *Config {
+// auditChainWriter opens the audit log file to be written as a tamper-evident chain. If the chain cannot be opened
+// the events are written to stderr.
+func (c *Config) auditChainWriter(p string) (io.Writer, error) {
+	ac := c.Server.Logging.AuditChain
+	switch strings.ToLower(p) {
+	case "", "stdout", "stderr", "null":
+		return os.Stderr, fmt.Errorf("audit chain requires the audit log to be a file, not %s", p)
+	}
+	key, err := auditchain.LoadKey(ac.KeyFile)
+	if err != nil {
+		return os.Stderr, err
+	}
+	sk, err := auditchain.LoadSigningKey(ac.SigningKeyFile)
+	if err != nil {
+		return os.Stderr, err
+	}
+	w, err := auditchain.Open(p, key, sk, ac.CheckpointInterval, time.Duration(ac.CheckpointPeriod)*time.Second)
+	if err != nil {
+		return os.Stderr, err
+	}
+	c.Server.Logging.closers = append(c.Server.Logging.closers, w)
+	return w, nil
+}
+
+func (c *Config) SetApplicationLogger(l *applog.Logger) *Config {
 	c.Server.Logging.ApplicationLogger = l
 	return c
 }
 
 func (c *Config) SetApplicationLogFile(p string) *Config {
-	w, err := c.logWriter(p)
+	w, err := c.logWriter("application", p, c.Server.Logging.ApplicationSink)
 	if err != nil {
-		c.ApplicationLogf("could not open application log file: %v\n", err)
+		c.Logger().Errorf("could not open application log file: %v", err)
 	}
 	c.Server.Logging.ApplicationFile = p
-	l := log.New(w, "AWS Federation Server: ", log.Ldate|log.Ltime)
-	c.SetApplicationLogger(l)
+	c.SetApplicationLogger(c.newApplicationLogger(w))
 	return c
 }
 
+// newApplicationLogger returns a logger writing to w in the configured format and at the configured level. An invalid
+// level, which is reported by Validate, is treated as info.
+func (c *Config) newApplicationLogger(w io.Writer) *applog.Logger {
+	lvl, _ := applog.ParseLevel(c.Server.Logging.ApplicationLevel)
+	return applog.New(w, c.Server.Logging.ApplicationFormat, lvl)
+}
+
 func (c *Config) SetAccessLogFile(p string) *Config {
-	w, err := c.logWriter(p)
+	w, err := c.logWriter("access", p, c.Server.Logging.AccessSink)
 	if err != nil {
-		c.ApplicationLogf("could not open access log file: %v\n", err)
+		c.Logger().Errorf("could not open access log file: %v", err)
 	}
 	c.Server.Logging.AccessLog = p
 	enc := json.NewEncoder(w)
@@ -358,11 +757,6 @@ func (c *Config) SetAccessEncoder(e *json.Encoder) *Config {
 	return c
 }
 
-func (c *Config) ToString() string {
-	//TODO Format back into JSON but remove userID
-	return ""
-}
-
 func NewTLSConfig(cert, key string) (TLS, error) {
 	if err := isKeyPairVaild(cert, key); err != nil {
 		return TLS{}, err
@@ -379,7 +773,7 @@ func (c Config) AccessLog(v interface{}) {
 	if c.Server.Logging.AccessEncoder != nil {
 		err := c.Server.Logging.AccessEncoder.Encode(v)
 		if err != nil {
-			c.ApplicationLogf("could not log access event: %+v - Error: %v\n", err)
+			c.Logger().Error("could not log access event", "event", v, "error", err)
 		}
 	}
 }
@@ -388,17 +782,24 @@ func (c Config) AuditLog(v interface{}) {
 	if c.Server.Logging.AuditEncoder != nil {
 		err := c.Server.Logging.AuditEncoder.Encode(v)
 		if err != nil {
-			c.ApplicationLogf("could not log audit event: %+v - Error: %v\n", err)
+			c.Logger().Error("could not log audit event", "event", v, "error", err)
 		}
 	}
 }
 
-func (c Config) ApplicationLogf(format string, v ...interface{}) {
-	if c.Server.Logging.ApplicationLogger == nil {
-		l := log.New(os.Stdout, "AWS Federation Server: ", log.Ldate|log.Ltime)
-		c.Server.Logging.ApplicationLogger = l
+// Logger returns the application logger. Records are written to stdout if the logger has not been configured.
+func (c Config) Logger() *applog.Logger {
+	if c.Server.Logging == nil || c.Server.Logging.ApplicationLogger == nil {
+		return applog.New(os.Stdout, applog.FormatLogfmt, applog.LevelInfo)
 	}
-	c.Server.Logging.ApplicationLogger.Printf(format, v)
+	return c.Server.Logging.ApplicationLogger
+}
+
+// ApplicationLogf writes an info level record to the application log.
+//
+// Deprecated: use the leveled methods of Logger.
+func (c Config) ApplicationLogf(format string, v ...interface{}) {
+	c.Logger().Infof(format, v...)
 }
 
 func (c Config) Summary() string {
@@ -412,6 +813,7 @@ func (c Config) Summary() string {
 	Vault:
 		URL: %s
 		Secrets Path: %s
+	Environment Overrides: %s
 `,
 		c.Server.Socket,
 		c.Server.TLS.Enabled,
@@ -420,6 +822,7 @@ func (c Config) Summary() string {
 		c.Server.Logging.AccessLog,
 		*c.Vault.Config.ReSTClientConfig.EndPoint,
 		c.Vault.Config.SecretsPath,
+		strings.Join(c.EnvOverrides(), ", "),
 	)
 }
 
@@ -160,6 +160,34 @@ func TestLoad(t *testing.T) {
 	assert.Equal(t, TestDBCredsPath, c.Database.CredentialsVaultPath, "Database credentials path not as expected")
 }
 
+func TestConfig_CloseLogs(t *testing.T) {
+	auditLog, _ := ioutil.TempFile(os.TempDir(), "auditlog")
+	defer os.Remove(auditLog.Name())
+	auditLog.Close()
+	c := NewConfig()
+	c.SetAuditLogFile(auditLog.Name())
+	c.AuditLog(AuditLogLine{Username: "testuser", EventType: "Test"})
+	if err := c.CloseLogs(); err != nil {
+		t.Fatalf("Error closing logs: %v", err)
+	}
+	b, err := ioutil.ReadFile(auditLog.Name())
+	if err != nil {
+		t.Fatalf("Error reading audit log: %v", err)
+	}
+	assert.Contains(t, string(b), `"Username":"testuser"`, "Audit event not written to log file before it was closed")
+	// Logging after the files are closed should not fail
+	c.AuditLog(AuditLogLine{Username: "testuser", EventType: "Test"})
+	assert.NoError(t, c.CloseLogs(), "Closing logs a second time should not return an error")
+}
+
+func TestNewConfig_Timeouts(t *testing.T) {
+	c := NewConfig()
+	assert.Equal(t, 30, c.Server.Timeouts.Read, "Default read timeout not as expected")
+	assert.Equal(t, 60, c.Server.Timeouts.Write, "Default write timeout not as expected")
+	assert.Equal(t, 120, c.Server.Timeouts.Idle, "Default idle timeout not as expected")
+	assert.Equal(t, 30, c.Server.Timeouts.Shutdown, "Default shutdown timeout not as expected")
+}
+
 func populateVault(t *testing.T, addr string, certPool *x509.CertPool, test_app_id, test_user_id string) {
 	c := restclient.NewConfig().WithEndPoint(addr).WithCACertPool(certPool)
 	vconf := vaultclient.Config{
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"regexp"
+	"strings"
+)
+
+const redacted = "********"
+
+// redactedSettings hold secrets and are never included in a dump of the configuration.
+var redactedSettings = map[string]bool{
+	"Server.Authentication.Basic.LDAP.BindUserPassword": true,
+	"Server.Authentication.Basic.Static.RequiredSecret": true,
+	"Vault.Credentials.UserID":                          true,
+	"SecretStore.AppRole.SecretID":                      true,
+	"SecretStore.Token.Token":                           true,
+}
+
+// dsnPassword matches the password in a connection string of the form user:password@protocol(address)/dbname
+var dsnPassword = regexp.MustCompile(`^([^:@/]*):([^@]*)@`)
+
+// Dump returns the effective configuration, with secrets redacted, in JSON or YAML format. The source of each setting
+// is also included.
+func (c *Config) Dump(format string) ([]byte, error) {
+	m, err := c.redactedMap()
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return b, nil
+	case FormatYAML:
+		return yaml.JSONToYAML(b)
+	default:
+		return nil, fmt.Errorf("unsupported format for configuration dump: %s", format)
+	}
+}
+
+func (c *Config) ToString() string {
+	b, err := c.Dump(FormatJSON)
+	if err != nil {
+		return fmt.Sprintf("could not format configuration: %v", err)
+	}
+	return string(b)
+}
+
+func (c *Config) redactedMap() (map[string]interface{}, error) {
+	// Take a copy without the loaded keytabs, connections and loggers as these are not configuration settings.
+	d := *c
+	d.Vault = Vault{}
+	if c.Server.Logging != nil {
+		d.Server.Logging = &Loggers{
+			AuditFile:         c.Server.Logging.AuditFile,
+			AuditChain:        c.Server.Logging.AuditChain,
+			AuditStore:        c.Server.Logging.AuditStore,
+			ApplicationFile:   c.Server.Logging.ApplicationFile,
+			ApplicationFormat: c.Server.Logging.ApplicationFormat,
+			ApplicationLevel:  c.Server.Logging.ApplicationLevel,
+			AccessLog:         c.Server.Logging.AccessLog,
+			ApplicationSink:   c.Server.Logging.ApplicationSink,
+			AuditSink:         c.Server.Logging.AuditSink,
+			AccessSink:        c.Server.Logging.AccessSink,
+		}
+	}
+	d.Server.Authentication.Kerberos.Keytab = nil
+	d.Server.Authentication.Basic.Kerberos.Keytab = nil
+	d.Server.Authentication.Basic.Kerberos.Conf = nil
+	d.Server.Authentication.Basic.LDAP.LDAPConn = nil
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	m["Vault"] = c.vaultMap()
+	redact(m, nil)
+	if dbm, ok := m["Database"].(map[string]interface{}); ok {
+		if dsn, ok := dbm["ConnectionString"].(string); ok {
+			dbm["ConnectionString"] = redactDSN(dsn)
+		}
+	}
+	// The tracing headers are typically used to authenticate to the collector.
+	if sm, ok := m["Server"].(map[string]interface{}); ok {
+		if tm, ok := sm["Tracing"].(map[string]interface{}); ok {
+			if hm, ok := tm["Headers"].(map[string]interface{}); ok {
+				for k := range hm {
+					hm[k] = redacted
+				}
+			}
+		}
+	}
+	m["Sources"] = c.Sources
+	return m, nil
+}
+
+// vaultMap returns the vault settings. These are built explicitly as the vault client configuration also holds the
+// HTTP client.
+func (c *Config) vaultMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	if vc := c.Vault.Config; vc != nil {
+		conn := make(map[string]interface{})
+		if vc.ReSTClientConfig.EndPoint != nil {
+			conn["EndPoint"] = *vc.ReSTClientConfig.EndPoint
+		}
+		if vc.ReSTClientConfig.TrustCACert != nil {
+			conn["TrustCACert"] = *vc.ReSTClientConfig.TrustCACert
+		}
+		m["Config"] = map[string]interface{}{
+			"SecretsRoot":     vc.SecretsPath,
+			"VaultConnection": conn,
+		}
+	}
+	if vc := c.Vault.Credentials; vc != nil {
+		m["Credentials"] = map[string]interface{}{
+			"AppID":      vc.AppID,
+			"UserID":     vc.UserID,
+			"UserIDFile": vc.UserIDFile,
+		}
+	}
+	return m
+}
+
+// redact replaces the values of secret settings and removes those that are not set.
+func redact(m map[string]interface{}, path []string) {
+	for k, v := range m {
+		p := append(append([]string{}, path...), k)
+		switch t := v.(type) {
+		case nil:
+			delete(m, k)
+		case map[string]interface{}:
+			redact(t, p)
+		case string:
+			if t != "" && redactedSettings[strings.Join(p, ".")] {
+				m[k] = redacted
+			}
+		}
+	}
+}
+
+// redactDSN replaces a password in the connection string unless it is the placeholder for the password from the vault.
+func redactDSN(dsn string) string {
+	s := dsnPassword.FindStringSubmatch(dsn)
+	if s == nil || s[2] == DSNPasswordPlaceholder || s[2] == "" {
+		return dsn
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}:"+redacted+"@")
+}
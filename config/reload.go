@@ -0,0 +1,93 @@
+package config
+
+import (
+	"reflect"
+)
+
+// ReloadReport lists the settings applied when the configuration is reloaded and those that have changed but will
+// only take effect when the server is restarted.
+type ReloadReport struct {
+	Reloaded        []string `json:"Reloaded"`
+	RestartRequired []string `json:"RestartRequired"`
+}
+
+// Reload returns a copy of the configuration with the reloadable settings taken from the newly loaded configuration.
+// The settings that cannot be applied to the running server are kept from the current configuration and, if they
+// differ in the new configuration, reported as requiring a restart.
+// The keytabs and LDAP connection in the returned configuration are those of the new configuration and so must be
+// loaded before it is used.
+func (c *Config) Reload(nc *Config) (*Config, ReloadReport) {
+	rc := *c
+	rc.Server.Logging = nc.Server.Logging
+	rc.Server.TLS.CertificateFile = nc.Server.TLS.CertificateFile
+	rc.Server.TLS.KeyFile = nc.Server.TLS.KeyFile
+	rc.Server.Authentication = nc.Server.Authentication
+	rc.Server.AccessRequest = nc.Server.AccessRequest
+	rc.Server.RequireIfMatch = nc.Server.RequireIfMatch
+	// The sync job and its definitions are set up when the server starts, the rest is read on each sync.
+	cs := nc.Server.CatalogueSync
+	cs.Enabled, cs.Directory = c.Server.CatalogueSync.Enabled, c.Server.CatalogueSync.Directory
+	rc.Server.CatalogueSync = cs
+	rc.Notification = nc.Notification
+	rep := ReloadReport{
+		Reloaded: []string{
+			"Server.Logging",
+			"Server.TLS.CertificateFile",
+			"Server.TLS.KeyFile",
+			"Server.Authentication",
+			"Server.AccessRequest",
+			"Server.RequireIfMatch",
+			"Server.CatalogueSync.Interval",
+			"Server.CatalogueSync.DryRun",
+			"Server.CatalogueSync.Prune",
+			"Server.CatalogueSync.ReadOnly",
+			"Notification",
+		},
+		RestartRequired: []string{},
+	}
+
+	// The break-glass credentials are loaded from the vault so are not part of the comparison.
+	cbg, nbg := c.Server.BreakGlass, nc.Server.BreakGlass
+	cbg.Credentials, nbg.Credentials = nil, nil
+	for _, s := range []struct {
+		name     string
+		cur, new interface{}
+	}{
+		{"Server.Socket", c.Server.Socket, nc.Server.Socket},
+		{"Server.TLS.Enabled", c.Server.TLS.Enabled, nc.Server.TLS.Enabled},
+		{"Server.Timeouts", c.Server.Timeouts, nc.Server.Timeouts},
+		{"Server.RoleMappingExpiry", c.Server.RoleMappingExpiry, nc.Server.RoleMappingExpiry},
+		{"Server.BreakGlass", cbg, nbg},
+		{"Server.RateLimit", c.Server.RateLimit, nc.Server.RateLimit},
+		{"Server.CredentialCache", c.Server.CredentialCache, nc.Server.CredentialCache},
+		{"Server.CatalogueSync.Enabled", c.Server.CatalogueSync.Enabled, nc.Server.CatalogueSync.Enabled},
+		{"Server.CatalogueSync.Directory", c.Server.CatalogueSync.Directory, nc.Server.CatalogueSync.Directory},
+		{"Server.Tracing", c.Server.Tracing, nc.Server.Tracing},
+		{"Vault", vaultSettings(c.Vault), vaultSettings(nc.Vault)},
+		{"SecretStore", c.SecretStore, nc.SecretStore},
+		{"Database", c.Database, nc.Database},
+	} {
+		if !reflect.DeepEqual(s.cur, s.new) {
+			rep.RestartRequired = append(rep.RestartRequired, s.name)
+		}
+	}
+	return &rc, rep
+}
+
+// vaultSettings returns the settings of the vault client that are set from the configuration file.
+func vaultSettings(v Vault) []string {
+	var s []string
+	if v.Config != nil {
+		s = append(s, v.Config.SecretsPath)
+		if v.Config.ReSTClientConfig.EndPoint != nil {
+			s = append(s, *v.Config.ReSTClientConfig.EndPoint)
+		}
+		if v.Config.ReSTClientConfig.TrustCACert != nil {
+			s = append(s, *v.Config.ReSTClientConfig.TrustCACert)
+		}
+	}
+	if v.Credentials != nil {
+		s = append(s, v.Credentials.AppID, v.Credentials.UserIDFile)
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfig_Reload(t *testing.T) {
+	c := NewConfig()
+	c.Server.Authentication.SessionDuration = 60
+	c.Server.BreakGlass.Credentials = map[string]string{"admin": "digest"}
+
+	nc := NewConfig()
+	nc.Server.Authentication.SessionDuration = 30
+	nc.Server.TLS.CertificateFile = "/etc/awsfederation/new.crt"
+	nc.Server.Socket = "0.0.0.0:9443"
+	nc.Server.RateLimit.Enabled = true
+	nc.Server.RequireIfMatch = true
+	nc.Server.CatalogueSync.Enabled = true
+	nc.Server.CatalogueSync.ReadOnly = true
+	nc.Database.ConnectionString = "${username}:${password}@tcp(db:3306)/awsfederation"
+
+	rc, rep := c.Reload(nc)
+	assert.Equal(t, 30, rc.Server.Authentication.SessionDuration, "Authentication settings should be reloaded")
+	assert.Equal(t, "/etc/awsfederation/new.crt", rc.Server.TLS.CertificateFile, "TLS certificate should be reloaded")
+	assert.Equal(t, nc.Server.Logging, rc.Server.Logging, "Loggers should be reloaded")
+	assert.Equal(t, c.Server.Socket, rc.Server.Socket, "Socket should not be reloaded")
+	assert.True(t, rc.Server.RequireIfMatch, "If-Match requirement should be reloaded")
+	assert.True(t, rc.Server.CatalogueSync.ReadOnly, "Catalogue sync read-only setting should be reloaded")
+	assert.False(t, rc.Server.CatalogueSync.Enabled, "Catalogue sync should not be enabled by a reload")
+	assert.False(t, rc.Server.RateLimit.Enabled, "Rate limits should not be reloaded")
+	assert.Equal(t, c.Database, rc.Database, "Database settings should not be reloaded")
+	assert.Equal(t, "digest", rc.Server.BreakGlass.Credentials["admin"], "Break-glass credentials should be kept")
+	assert.Equal(t, []string{"Server.Socket", "Server.RateLimit", "Server.CatalogueSync.Enabled", "Database"}, rep.RestartRequired, "Settings requiring a restart not as expected")
+	assert.Contains(t, rep.Reloaded, "Server.Authentication", "Reloaded settings not as expected")
+	assert.Equal(t, 60, c.Server.Authentication.SessionDuration, "Current configuration should not be modified")
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+
+	// EnvPrefix is the prefix of environment variables that override settings in the configuration file.
+	// The remainder of the variable name is the path to the setting in upper case separated by underscores,
+	// for example AWSFED_SERVER_SOCKET or AWSFED_VAULT_CREDENTIALS_USERID.
+	EnvPrefix = "AWSFED_"
+
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "environment variable"
+)
+
+// FormatFromPath returns the format of the configuration file from its extension. JSON is assumed if the extension is
+// not recognised.
+func FormatFromPath(p string) string {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// toJSON converts the configuration to JSON so that all formats are decoded using the same field names.
+func toJSON(b []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return yaml.YAMLToJSON(b)
+	case FormatTOML:
+		m := make(map[string]interface{})
+		if _, err := toml.Decode(string(b), &m); err != nil {
+			return nil, err
+		}
+		return json.Marshal(m)
+	default:
+		return b, nil
+	}
+}
+
+// Source returns where the setting's value came from. The setting is identified by its path in the configuration
+// file, for example Server.Socket.
+func (c *Config) Source(setting string) string {
+	if s, ok := c.Sources[setting]; ok {
+		return s
+	}
+	return SourceDefault
+}
+
+// EnvOverrides returns the settings overridden by environment variables and the variable used for each.
+func (c *Config) EnvOverrides() []string {
+	var o []string
+	for k, v := range c.Sources {
+		if strings.HasPrefix(v, SourceEnv) {
+			o = append(o, fmt.Sprintf("%s (%s)", k, strings.TrimPrefix(v, SourceEnv+" ")))
+		}
+	}
+	sort.Strings(o)
+	return o
+}
+
+// setFileSources records the settings present in the configuration file.
+func (c *Config) setFileSources(j []byte, src string) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return
+	}
+	var walk func(m map[string]interface{}, path []string)
+	walk = func(m map[string]interface{}, path []string) {
+		for k, v := range m {
+			p := append(append([]string{}, path...), k)
+			if n, ok := v.(map[string]interface{}); ok {
+				walk(n, p)
+				continue
+			}
+			c.Sources[strings.Join(p, ".")] = src
+		}
+	}
+	walk(m, nil)
+}
+
+// envSetting is a setting that can be overridden by an environment variable.
+type envSetting struct {
+	path  string
+	value reflect.Value
+}
+
+// envSettings returns the settings in the configuration that can be overridden keyed by environment variable name.
+func (c *Config) envSettings() map[string]envSetting {
+	s := make(map[string]envSetting)
+	var walk func(v reflect.Value, path []string)
+	walk = func(v reflect.Value, path []string) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			if settable(v) {
+				s[EnvPrefix+strings.ToUpper(strings.Join(path, "_"))] = envSetting{path: strings.Join(path, "."), value: v}
+			}
+			return
+		}
+		// Structs from the standard library such as loggers and encoders are not settings.
+		if !strings.Contains(strings.Split(v.Type().PkgPath(), "/")[0], ".") {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				// Unexported
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			walk(v.Field(i), append(append([]string{}, path...), name))
+		}
+	}
+	walk(reflect.ValueOf(c), nil)
+	return s
+}
+
+func settable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// applyEnv overrides settings with the values of the AWSFED_ prefixed environment variables. The environment is given
+// as a list of key=value strings as returned by os.Environ.
+func (c *Config) applyEnv(env []string) error {
+	var s map[string]envSetting
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], EnvPrefix) {
+			continue
+		}
+		if s == nil {
+			s = c.envSettings()
+		}
+		set, ok := s[kv[0]]
+		if !ok {
+			return fmt.Errorf("environment variable %s does not match a configuration setting", kv[0])
+		}
+		if err := setValue(set.value, kv[1]); err != nil {
+			return fmt.Errorf("invalid value for %s from environment variable %s: %v", set.path, kv[0], err)
+		}
+		c.Sources[set.path] = SourceEnv + " " + kv[0]
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		// Comma separated list
+		var l []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				l = append(l, e)
+			}
+		}
+		v.Set(reflect.ValueOf(l))
+	default:
+		return fmt.Errorf("settings of type %v cannot be set from the environment", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testYAML = `
+Server:
+  Socket: 127.0.0.1:9443
+  Logging:
+    Application: stdout
+    Audit: stdout
+    Access: stdout
+  RateLimit:
+    Enabled: true
+    User:
+      Rate: 5
+      Burst: 10
+Vault:
+  Credentials:
+    AppID: testapp
+    UserID: testuser
+Database:
+  CredentialsVaultPath: /secret/db
+`
+	testTOML = `
+[Server]
+Socket = "127.0.0.1:9443"
+
+[Server.Logging]
+Application = "stdout"
+Audit = "stdout"
+Access = "stdout"
+
+[Server.RateLimit]
+Enabled = true
+
+[Server.RateLimit.User]
+Rate = 5.0
+Burst = 10
+
+[Vault.Credentials]
+AppID = "testapp"
+UserID = "testuser"
+
+[Database]
+CredentialsVaultPath = "/secret/db"
+`
+)
+
+func writeTestConfig(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing test configuration file: %v", err)
+	}
+	return p
+}
+
+func TestFormatFromPath(t *testing.T) {
+	assert.Equal(t, FormatYAML, FormatFromPath("/etc/awsfederation/config.yaml"), "Format not as expected")
+	assert.Equal(t, FormatYAML, FormatFromPath("config.YML"), "Format not as expected")
+	assert.Equal(t, FormatTOML, FormatFromPath("config.toml"), "Format not as expected")
+	assert.Equal(t, FormatJSON, FormatFromPath("config.json"), "Format not as expected")
+	assert.Equal(t, FormatJSON, FormatFromPath("config"), "Format not as expected")
+}
+
+func TestLoad_Formats(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "config")
+	defer os.RemoveAll(dir)
+	for _, p := range []string{
+		writeTestConfig(t, dir, "config.yaml", testYAML),
+		writeTestConfig(t, dir, "config.toml", testTOML),
+	} {
+		c, err := Load(p)
+		if err != nil {
+			t.Fatalf("Error loading %s: %v", p, err)
+		}
+		assert.Equal(t, "127.0.0.1:9443", c.Server.Socket, "Socket not as expected from %s", p)
+		assert.True(t, c.Server.RateLimit.Enabled, "Rate limit not enabled from %s", p)
+		assert.Equal(t, float64(5), c.Server.RateLimit.User.Rate, "Rate limit not as expected from %s", p)
+		assert.Equal(t, 10, c.Server.RateLimit.User.Burst, "Rate limit burst not as expected from %s", p)
+		assert.Equal(t, "testapp", c.Vault.Credentials.AppID, "Vault AppID not as expected from %s", p)
+		assert.Equal(t, "/secret/db", c.Database.CredentialsVaultPath, "Database credentials path not as expected from %s", p)
+		// Settings not in the file take their defaults
+		assert.Equal(t, 300, c.Server.RateLimit.FederationUser.Burst, "Default not applied from %s", p)
+		assert.Equal(t, SourceFile+" "+p, c.Source("Server.Socket"), "Source of setting not as expected from %s", p)
+		assert.Equal(t, SourceDefault, c.Source("Server.Timeouts.Read"), "Source of default setting not as expected from %s", p)
+	}
+	_, err := Load(writeTestConfig(t, dir, "invalid.yaml", "Server: [\n"))
+	assert.Error(t, err, "Expected error loading invalid YAML")
+}
+
+func TestLoad_EnvOverrides(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "config")
+	defer os.RemoveAll(dir)
+	p := writeTestConfig(t, dir, "config.yaml", testYAML)
+
+	env := map[string]string{
+		"AWSFED_SERVER_SOCKET":                                     "0.0.0.0:8443",
+		"AWSFED_SERVER_RATELIMIT_USER_BURST":                       "50",
+		"AWSFED_SERVER_BREAKGLASS_ROLEMAPPINGS":                    "rm1, rm2",
+		"AWSFED_SERVER_AUTHENTICATION_BASIC_STATIC_REQUIREDSECRET": "secret",
+		"AWSFED_VAULT_CONFIG_SECRETSROOT":                          "/kv/",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	c, err := Load(p)
+	if err != nil {
+		t.Fatalf("Error loading configuration: %v", err)
+	}
+	assert.Equal(t, "0.0.0.0:8443", c.Server.Socket, "Socket not overridden")
+	assert.Equal(t, 50, c.Server.RateLimit.User.Burst, "Rate limit burst not overridden")
+	assert.Equal(t, float64(5), c.Server.RateLimit.User.Rate, "Rate limit from file should not be changed")
+	assert.Equal(t, []string{"rm1", "rm2"}, c.Server.BreakGlass.RoleMappings, "List not overridden")
+	assert.Equal(t, "secret", c.Server.Authentication.Basic.Static.RequiredSecret, "Secret not overridden")
+	assert.Equal(t, "/kv/", c.Vault.Config.SecretsPath, "Vault secrets path not overridden")
+	assert.Equal(t, SourceEnv+" AWSFED_SERVER_SOCKET", c.Source("Server.Socket"), "Source of overridden setting not as expected")
+	assert.Contains(t, c.EnvOverrides(), "Server.Socket (AWSFED_SERVER_SOCKET)", "Overrides not as expected")
+
+	os.Setenv("AWSFED_SERVER_RATELIMIT_USER_BURST", "many")
+	_, err = Load(p)
+	assert.Error(t, err, "Expected error for an invalid value")
+	os.Setenv("AWSFED_SERVER_RATELIMIT_USER_BURST", "50")
+
+	os.Setenv("AWSFED_SERVER_UNKNOWN", "true")
+	defer os.Unsetenv("AWSFED_SERVER_UNKNOWN")
+	_, err = Load(p)
+	assert.Error(t, err, "Expected error for an unknown setting")
+}
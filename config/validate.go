@@ -0,0 +1,303 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/auditchain"
+	krb5config "gopkg.in/jcmturner/gokrb5.v4/config"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	DSNUsernamePlaceholder = "${username}"
+	DSNPasswordPlaceholder = "${password}"
+)
+
+var dsnPlaceholder = regexp.MustCompile(`\$\{[^}]*\}`)
+
+// ValidationError lists the problems found with the configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("configuration not valid: %s", strings.Join(e.Problems, "; "))
+}
+
+// validator collects the problems found with each setting along with where the setting's value came from.
+type validator struct {
+	c        *Config
+	problems []string
+}
+
+func (v *validator) check(setting string, err error) {
+	if err != nil {
+		v.problems = append(v.problems, fmt.Sprintf("%s (%s): %v", setting, v.c.Source(setting), err))
+	}
+}
+
+func (v *validator) required(setting, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.check(setting, errors.New("must be set"))
+	}
+}
+
+func (v *validator) minimum(setting string, value, min int) {
+	if value < min {
+		v.check(setting, fmt.Errorf("must be at least %d", min))
+	}
+}
+
+// Validate checks the configuration and returns a ValidationError listing all the problems found.
+// Settings belonging to other packages, such as the rate limits and break-glass access, are validated by those
+// packages.
+func (c *Config) Validate() error {
+	v := &validator{c: c}
+	c.validateServer(v)
+	c.validateAuthentication(v)
+	c.validateSecretStore(v)
+	c.validateDatabase(v)
+	c.validateNotification(v)
+	if len(v.problems) > 0 {
+		return ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (c *Config) validateServer(v *validator) {
+	s := c.Server
+	if _, err := net.ResolveTCPAddr("tcp", s.Socket); err != nil {
+		v.check("Server.Socket", fmt.Errorf("invalid listener socket: %v", err))
+	}
+	if s.TLS.Enabled {
+		v.check("Server.TLS", isKeyPairVaild(s.TLS.CertificateFile, s.TLS.KeyFile))
+	}
+	v.minimum("Server.Timeouts.Read", s.Timeouts.Read, 0)
+	v.minimum("Server.Timeouts.Write", s.Timeouts.Write, 0)
+	v.minimum("Server.Timeouts.Idle", s.Timeouts.Idle, 0)
+	v.minimum("Server.Timeouts.Shutdown", s.Timeouts.Shutdown, 0)
+	v.minimum("Server.AccessRequest.MaxDuration", s.AccessRequest.MaxDuration, 1)
+	v.check("Server.Tracing", s.Tracing.Validate())
+	if s.Logging != nil {
+		v.check("Server.Logging.ApplicationFormat", applog.ValidFormat(s.Logging.ApplicationFormat))
+		if _, err := applog.ParseLevel(s.Logging.ApplicationLevel); err != nil {
+			v.check("Server.Logging.ApplicationLevel", err)
+		}
+		v.check("Server.Logging.ApplicationSink", s.Logging.ApplicationSink.Validate())
+		v.check("Server.Logging.AuditSink", s.Logging.AuditSink.Validate())
+		v.check("Server.Logging.AccessSink", s.Logging.AccessSink.Validate())
+	}
+	if s.Logging != nil && s.Logging.AuditChain.Enabled {
+		ac := s.Logging.AuditChain
+		switch strings.ToLower(s.Logging.AuditFile) {
+		case "", "stdout", "stderr", "null":
+			v.check("Server.Logging.Audit", errors.New("must be a file when the audit chain is enabled"))
+		}
+		if !s.Logging.AuditSink.IsFile() || s.Logging.AuditSink.Rotation.Enabled() {
+			v.check("Server.Logging.AuditSink", errors.New("must be a file without rotation when the audit chain is enabled"))
+		}
+		_, err := auditchain.LoadKey(ac.KeyFile)
+		v.check("Server.Logging.AuditChain.KeyFile", err)
+		_, err = auditchain.LoadSigningKey(ac.SigningKeyFile)
+		v.check("Server.Logging.AuditChain.SigningKeyFile", err)
+		v.minimum("Server.Logging.AuditChain.CheckpointInterval", ac.CheckpointInterval, 1)
+		v.minimum("Server.Logging.AuditChain.CheckpointPeriod", ac.CheckpointPeriod, 1)
+	}
+	if s.Logging != nil && s.Logging.AuditStore.Enabled {
+		v.minimum("Server.Logging.AuditStore.RetentionDays", s.Logging.AuditStore.RetentionDays, 0)
+		v.minimum("Server.Logging.AuditStore.PurgeInterval", s.Logging.AuditStore.PurgeInterval, 1)
+	}
+	if s.CredentialCache.Enabled {
+		v.minimum("Server.CredentialCache.MinRemaining", s.CredentialCache.MinRemaining, 0)
+	}
+	if s.CatalogueSync.Enabled {
+		if fi, err := os.Stat(s.CatalogueSync.Directory); err != nil {
+			v.check("Server.CatalogueSync.Directory", err)
+		} else if !fi.IsDir() {
+			v.check("Server.CatalogueSync.Directory", errors.New("must be a directory"))
+		}
+		v.minimum("Server.CatalogueSync.Interval", s.CatalogueSync.Interval, 1)
+	}
+}
+
+func (c *Config) validateAuthentication(v *validator) {
+	a := c.Server.Authentication
+	v.minimum("Server.Authentication.ActiveSessionTimeout", a.ActiveSessionTimeout, 1)
+	v.minimum("Server.Authentication.SessionDuration", a.SessionDuration, 1)
+	if a.Kerberos.Enabled {
+		v.required("Server.Authentication.Kerberos.KeytabVaultPath", a.Kerberos.KeytabVaultPath)
+	}
+	if !a.Basic.Enabled {
+		return
+	}
+	switch strings.ToLower(a.Basic.Protocol) {
+	case "ldap":
+		l := a.Basic.LDAP
+		if _, _, err := net.SplitHostPort(l.EndPoint); err != nil {
+			v.check("Server.Authentication.Basic.LDAP.EndPoint", fmt.Errorf("must be in the format <host>:<port>: %v", err))
+		}
+		v.required("Server.Authentication.Basic.LDAP.BaseDN", l.BaseDN)
+		v.required("Server.Authentication.Basic.LDAP.UsernameAttribute", l.UsernameAttribute)
+		v.required("Server.Authentication.Basic.LDAP.UserObjectClass", l.UserObjectClass)
+		v.required("Server.Authentication.Basic.LDAP.BindUserDN", l.BindUserDN)
+		v.required("Server.Authentication.Basic.LDAP.BindUserPasswordVaultPath", l.BindUserPasswordVaultPath)
+		if l.TLSEnabled {
+			v.check("Server.Authentication.Basic.LDAP.TrustedCAPath", isValidPEMFile(l.TrustedCAPath))
+		}
+	case "kerberos":
+		k := a.Basic.Kerberos
+		if _, err := krb5config.Load(k.KRB5ConfPath); err != nil {
+			v.check("Server.Authentication.Basic.Kerberos.KRB5ConfPath", fmt.Errorf("invalid krb5 configuration: %v", err))
+		}
+		v.required("Server.Authentication.Basic.Kerberos.KeytabVaultPath", k.KeytabVaultPath)
+		v.required("Server.Authentication.Basic.Kerberos.SPN", k.SPN)
+	case "static":
+		v.required("Server.Authentication.Basic.Static.RequiredSecret", a.Basic.Static.RequiredSecret)
+	default:
+		v.check("Server.Authentication.Basic.Protocol", fmt.Errorf("invalid protocol (%s), must be Kerberos, LDAP or Static", a.Basic.Protocol))
+	}
+}
+
+func (c *Config) validateSecretStore(v *validator) {
+	s := c.SecretStore
+	switch strings.ToLower(s.Type) {
+	case strings.ToLower(SecretStoreVaultAppID):
+		c.validateVault(v)
+		if c.Vault.Credentials == nil {
+			v.check("Vault.Credentials", errors.New("must be set"))
+			return
+		}
+		v.required("Vault.Credentials.AppID", c.Vault.Credentials.AppID)
+		if c.Vault.Credentials.UserID == "" && c.Vault.Credentials.UserIDFile == "" {
+			v.check("Vault.Credentials.UserID", errors.New("either the UserID or UserIDFile must be set"))
+		}
+	case strings.ToLower(SecretStoreVaultAppRole):
+		c.validateVault(v)
+		v.required("SecretStore.AppRole.MountPath", s.AppRole.MountPath)
+		v.required("SecretStore.AppRole.RoleID", s.AppRole.RoleID)
+		if s.AppRole.SecretID == "" && s.AppRole.SecretIDFile == "" {
+			v.check("SecretStore.AppRole.SecretID", errors.New("either the SecretID or SecretIDFile must be set"))
+		}
+	case strings.ToLower(SecretStoreVaultToken):
+		c.validateVault(v)
+		if s.Token.Token == "" && s.Token.TokenFile == "" {
+			v.check("SecretStore.Token.Token", errors.New("either the Token or TokenFile must be set"))
+		}
+	case strings.ToLower(SecretStoreVaultKubernetes):
+		c.validateVault(v)
+		v.required("SecretStore.Kubernetes.MountPath", s.Kubernetes.MountPath)
+		v.required("SecretStore.Kubernetes.Role", s.Kubernetes.Role)
+		v.required("SecretStore.Kubernetes.ServiceAccountTokenFile", s.Kubernetes.ServiceAccountTokenFile)
+	case strings.ToLower(SecretStoreFile):
+		v.required("SecretStore.File.Path", s.File.Path)
+		v.required("SecretStore.File.KeyFile", s.File.KeyFile)
+	default:
+		v.check("SecretStore.Type", fmt.Errorf("invalid secret store type (%s), must be %s, %s, %s, %s or %s", s.Type,
+			SecretStoreVaultAppID, SecretStoreVaultAppRole, SecretStoreVaultToken, SecretStoreVaultKubernetes, SecretStoreFile))
+	}
+}
+
+func (c *Config) validateVault(v *validator) {
+	if c.Vault.Config == nil {
+		v.check("Vault.Config", errors.New("must be set"))
+	} else {
+		v.required("Vault.Config.SecretsRoot", c.Vault.Config.SecretsPath)
+		rc := c.Vault.Config.ReSTClientConfig
+		if rc.EndPoint == nil || *rc.EndPoint == "" {
+			v.check("Vault.Config.VaultConnection.EndPoint", errors.New("must be set"))
+		} else if u, err := url.Parse(*rc.EndPoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.check("Vault.Config.VaultConnection.EndPoint", errors.New("must be a http or https URL"))
+		}
+		if rc.TrustCACert != nil && *rc.TrustCACert != "" {
+			v.check("Vault.Config.VaultConnection.TrustCACert", isValidPEMFile(*rc.TrustCACert))
+		}
+	}
+}
+
+func (c *Config) validateDatabase(v *validator) {
+	v.required("Database.CredentialsVaultPath", c.Database.CredentialsVaultPath)
+	v.check("Database.ConnectionString", validateDSN(c.Database.ConnectionString))
+	if t := c.Database.TLS; t.Enabled {
+		switch strings.ToLower(t.VerifyMode) {
+		case strings.ToLower(DBTLSVerifyFull), strings.ToLower(DBTLSVerifyCA), strings.ToLower(DBTLSSkipVerify):
+		default:
+			v.check("Database.TLS.VerifyMode", fmt.Errorf("invalid verify mode (%s), must be %s, %s or %s", t.VerifyMode, DBTLSVerifyFull, DBTLSVerifyCA, DBTLSSkipVerify))
+		}
+		if t.CAFile != "" {
+			v.check("Database.TLS.CAFile", isValidCABundle(t.CAFile))
+		}
+		if (t.CertificateFile == "") != (t.KeyFile == "") {
+			v.check("Database.TLS.CertificateFile", errors.New("both the client certificate and key files must be set"))
+		} else if t.CertificateFile != "" {
+			if _, err := tls.LoadX509KeyPair(t.CertificateFile, t.KeyFile); err != nil {
+				v.check("Database.TLS.CertificateFile", fmt.Errorf("client key pair not valid: %v", err))
+			}
+		}
+	}
+	if d := c.Database.DynamicCredentials; d.Enabled {
+		v.minimum("Database.DynamicCredentials.RenewBefore", d.RenewBefore, 1)
+		switch strings.ToLower(c.SecretStore.Type) {
+		case strings.ToLower(SecretStoreVaultAppRole), strings.ToLower(SecretStoreVaultToken), strings.ToLower(SecretStoreVaultKubernetes):
+		default:
+			v.check("Database.DynamicCredentials.Enabled", fmt.Errorf("the %s secret store does not issue dynamic credentials, use %s, %s or %s",
+				c.SecretStore.Type, SecretStoreVaultAppRole, SecretStoreVaultToken, SecretStoreVaultKubernetes))
+		}
+	}
+}
+
+// validateDSN checks that the database connection string contains the placeholders for the credentials loaded from
+// the vault and is otherwise valid.
+func validateDSN(dsn string) error {
+	if dsn == "" {
+		return errors.New("must be set")
+	}
+	for _, p := range dsnPlaceholder.FindAllString(dsn, -1) {
+		if p != DSNUsernamePlaceholder && p != DSNPasswordPlaceholder {
+			return fmt.Errorf("unknown placeholder %s, only %s and %s are supported", p, DSNUsernamePlaceholder, DSNPasswordPlaceholder)
+		}
+	}
+	for _, p := range []string{DSNUsernamePlaceholder, DSNPasswordPlaceholder} {
+		if !strings.Contains(dsn, p) {
+			return fmt.Errorf("placeholder %s for the credentials from the vault is missing", p)
+		}
+	}
+	r := strings.NewReplacer(DSNUsernamePlaceholder, "username", DSNPasswordPlaceholder, "password")
+	if _, err := mysql.ParseDSN(r.Replace(dsn)); err != nil {
+		return fmt.Errorf("invalid connection string: %v", err)
+	}
+	return nil
+}
+
+// isValidCABundle checks the file contains at least one PEM encoded certificate.
+func isValidCABundle(p string) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("could not read CA file: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(b) {
+		return errors.New("no PEM encoded certificates found in CA file")
+	}
+	return nil
+}
+
+func (c *Config) validateNotification(v *validator) {
+	w := c.Notification.Webhook
+	if !w.Enabled {
+		return
+	}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.check("Notification.Webhook.URL", errors.New("must be a http or https URL"))
+	}
+	v.minimum("Notification.Webhook.Timeout", w.Timeout, 1)
+	v.minimum("Notification.Webhook.MaxInFlight", w.MaxInFlight, 1)
+	v.minimum("Notification.Webhook.MaxBacklog", w.MaxBacklog, 0)
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"github.com/jcmturner/awsfederation/logsink"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	c := IntgTest()
+	assert.NoError(t, c.Validate(), "Valid configuration returned an error")
+
+	c.Server.Socket = "notasocket"
+	c.Server.Authentication.Basic.Static.RequiredSecret = ""
+	c.Server.Authentication.SessionDuration = 0
+	c.Database.ConnectionString = "${username}:${passwd}@tcp(127.0.0.1:3306)/awsfederation"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected error for invalid configuration")
+	}
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Error not a ValidationError: %v", err)
+	}
+	assert.Equal(t, 4, len(verr.Problems), "Number of problems not as expected: %v", verr.Problems)
+	for i, s := range []string{
+		"Server.Socket (file)",
+		"Server.Authentication.SessionDuration (file)",
+		"Server.Authentication.Basic.Static.RequiredSecret (file)",
+		"Database.ConnectionString (file)",
+	} {
+		assert.True(t, strings.HasPrefix(verr.Problems[i], s), "Problem not as expected: %s", verr.Problems[i])
+	}
+}
+
+func TestConfig_ValidateLDAP(t *testing.T) {
+	c := IntgTest()
+	c.Server.Authentication.Basic.Protocol = "LDAP"
+	c.Server.Authentication.Basic.LDAP = LDAPBasic{
+		EndPoint:                  "ldap.test.gokrb5:389",
+		BaseDN:                    "dc=test,dc=gokrb5",
+		UsernameAttribute:         "uid",
+		UserObjectClass:           "posixAccount",
+		BindUserDN:                "cn=bind,dc=test,dc=gokrb5",
+		BindUserPasswordVaultPath: "/secret/ldapbind",
+	}
+	assert.NoError(t, c.Validate(), "Valid LDAP configuration returned an error")
+	c.Server.Authentication.Basic.LDAP.EndPoint = "ldap.test.gokrb5"
+	c.Server.Authentication.Basic.LDAP.TLSEnabled = true
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for invalid LDAP configuration") {
+		assert.Equal(t, 2, len(err.(ValidationError).Problems), "Number of problems not as expected: %v", err)
+	}
+}
+
+func TestValidateDSN(t *testing.T) {
+	var tests = []struct {
+		DSN   string
+		Valid bool
+	}{
+		{"${username}:${password}@tcp(127.0.0.1:3306)/awsfederation?parseTime=true", true},
+		{"", false},
+		{"${username}@tcp(127.0.0.1:3306)/awsfederation", false},
+		{"${username}:${password}@tcp(127.0.0.1:3306)/${database}", false},
+		{"${username}:${password}@tcp(127.0.0.1:3306)", false},
+	}
+	for _, test := range tests {
+		err := validateDSN(test.DSN)
+		if test.Valid {
+			assert.NoError(t, err, "DSN %s should be valid", test.DSN)
+		} else {
+			assert.Error(t, err, "DSN %s should not be valid", test.DSN)
+		}
+	}
+}
+
+func TestConfig_Dump(t *testing.T) {
+	c := IntgTest()
+	c.Database.ConnectionString = "awsfedapp:dbpasswd@tcp(127.0.0.1:3306)/awsfederation"
+	c.Server.Authentication.Basic.LDAP.BindUserPassword = "bindpasswd"
+	for _, f := range []string{FormatJSON, FormatYAML} {
+		b, err := c.Dump(f)
+		if err != nil {
+			t.Fatalf("Error dumping configuration as %s: %v", f, err)
+		}
+		s := string(b)
+		for _, secret := range []string{MockStaticSecret, "06ba5ac6-3d85-43df-81b5-cf56f4f4624e", "dbpasswd", "bindpasswd"} {
+			assert.False(t, strings.Contains(s, secret), "Secret %s not redacted from %s dump", secret, f)
+		}
+		for _, v := range []string{"127.0.0.1:8443", "6a1ab78a-0f5b-4287-9371-cca1fc70b0f1", "awsfedapp:" + redacted + "@tcp", "Sources"} {
+			assert.True(t, strings.Contains(s, v), "Setting %s not in %s dump", v, f)
+		}
+	}
+	_, err := c.Dump("xml")
+	assert.Error(t, err, "Expected error for an unsupported format")
+	assert.True(t, strings.HasPrefix(c.ToString(), "{"), "ToString should return the JSON dump")
+}
+
+func TestConfig_ValidateSecretStore(t *testing.T) {
+	c := IntgTest()
+	c.SecretStore.Type = SecretStoreVaultAppRole
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for AppRole without role and secret IDs") {
+		assert.Equal(t, 2, len(err.(ValidationError).Problems), "Number of problems not as expected: %v", err)
+	}
+	c.SecretStore.AppRole.RoleID = "role"
+	c.SecretStore.AppRole.SecretIDFile = "/etc/awsfederation/secretid"
+	assert.NoError(t, c.Validate(), "Valid AppRole configuration returned an error")
+
+	c.SecretStore.Type = SecretStoreVaultKubernetes
+	err = c.Validate()
+	if assert.Error(t, err, "Expected error for Kubernetes auth without a role") {
+		assert.True(t, strings.HasPrefix(err.(ValidationError).Problems[0], "SecretStore.Kubernetes.Role"), "Problem not as expected: %v", err)
+	}
+
+	c.SecretStore.Type = SecretStoreFile
+	c.SecretStore.File = FileStore{Path: "/tmp/secrets", KeyFile: "/tmp/secrets.key"}
+	assert.NoError(t, c.Validate(), "Valid file store configuration returned an error")
+
+	c.SecretStore.Type = "Unknown"
+	assert.Error(t, c.Validate(), "Expected error for unknown secret store type")
+}
+
+func TestConfig_ValidateDynamicCredentials(t *testing.T) {
+	c := IntgTest()
+	c.Database.DynamicCredentials.Enabled = true
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for dynamic credentials with the AppID secret store") {
+		assert.True(t, strings.HasPrefix(err.(ValidationError).Problems[0], "Database.DynamicCredentials.Enabled"), "Problem not as expected: %v", err)
+	}
+	c.SecretStore.Type = SecretStoreVaultToken
+	c.SecretStore.Token.TokenFile = "/etc/awsfederation/token"
+	assert.NoError(t, c.Validate(), "Valid dynamic credentials configuration returned an error")
+	c.Database.DynamicCredentials.RenewBefore = 0
+	assert.Error(t, c.Validate(), "Expected error for dynamic credentials with no renewal period")
+}
+
+func TestConfig_ValidateDatabaseTLS(t *testing.T) {
+	c := IntgTest()
+	c.Database.TLS.Enabled = true
+	assert.NoError(t, c.Validate(), "Valid database TLS configuration returned an error")
+	c.Database.TLS.VerifyMode = "Sometimes"
+	c.Database.TLS.CAFile = "/nonexistent/ca.pem"
+	c.Database.TLS.CertificateFile = "/etc/awsfederation/db.crt"
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for invalid database TLS configuration") {
+		assert.Equal(t, 3, len(err.(ValidationError).Problems), "Number of problems not as expected: %v", err)
+	}
+}
+
+func TestConfig_ValidateAuditStore(t *testing.T) {
+	c := IntgTest()
+	c.Server.Logging.AuditStore.Enabled = true
+	c.Server.Logging.AuditStore.RetentionDays = 90
+	assert.NoError(t, c.Validate(), "Valid audit store configuration returned an error")
+	c.Server.Logging.AuditStore.RetentionDays = -1
+	c.Server.Logging.AuditStore.PurgeInterval = 0
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for invalid audit store configuration") {
+		assert.Equal(t, 2, len(err.(ValidationError).Problems), "Number of problems not as expected: %v", err)
+	}
+}
+
+func TestConfig_ValidateLogSinks(t *testing.T) {
+	c := IntgTest()
+	c.Server.Logging.AccessSink.Type = logsink.TypeSyslog
+	c.Server.Logging.AccessSink.Syslog.Address = "127.0.0.1:514"
+	c.Server.Logging.ApplicationSink.Rotation.MaxSize = 100
+	assert.NoError(t, c.Validate(), "Valid log sink configuration returned an error")
+	c.Server.Logging.AuditSink.Type = logsink.TypeHTTP
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for HTTP sink without a URL") {
+		assert.True(t, strings.HasPrefix(err.(ValidationError).Problems[0], "Server.Logging.AuditSink"), "Problem not as expected: %v", err)
+	}
+}
+
+func TestConfig_ValidateApplicationLogging(t *testing.T) {
+	c := IntgTest()
+	c.Server.Logging.ApplicationFormat = "json"
+	c.Server.Logging.ApplicationLevel = "debug"
+	assert.NoError(t, c.Validate(), "Valid application logging configuration returned an error")
+	c.Server.Logging.ApplicationFormat = "xml"
+	c.Server.Logging.ApplicationLevel = "verbose"
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for unknown application log format and level") {
+		assert.Equal(t, 2, len(err.(ValidationError).Problems), "Problems not as expected: %v", err)
+	}
+}
+
+func TestConfig_ValidateCatalogueSync(t *testing.T) {
+	c := IntgTest()
+	c.Server.CatalogueSync.Enabled = true
+	c.Server.CatalogueSync.Directory = os.TempDir()
+	assert.NoError(t, c.Validate(), "Valid catalogue sync configuration returned an error")
+	c.Server.CatalogueSync.Directory = filepath.Join(os.TempDir(), "awsfederation-catalogue-missing")
+	c.Server.CatalogueSync.Interval = 0
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for missing catalogue directory and zero interval") {
+		assert.Equal(t, 2, len(err.(ValidationError).Problems), "Problems not as expected: %v", err)
+	}
+}
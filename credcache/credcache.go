@@ -0,0 +1,185 @@
+// Package credcache caches the credentials issued by federation so that repeated requests for the same role by the
+// same user session do not each call AWS STS.
+//
+// Each server has its own cache. A change to a role mapping or federation user invalidates the credentials cached by
+// the server that made it but not those cached by the other servers sharing the database. These other servers keep
+// returning the credentials issued before the change until they expire, or have less than the minimum remaining
+// lifetime left, unless the federation user of the role mapping has changed.
+package credcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	awssts "github.com/aws/aws-sdk-go/service/sts"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cache holds the issued credentials encrypted with a key that is generated when the cache is created and only held
+// in memory. A nil Cache caches nothing.
+type Cache struct {
+	mux          sync.Mutex
+	aead         cipher.AEAD
+	minRemaining time.Duration
+	entries      map[string]entry
+}
+
+type entry struct {
+	roleMappingID string
+	roleArn       string
+	fedUserArn    string
+	expiration    time.Time
+	nonce         []byte
+	sealed        []byte
+}
+
+// New returns a Cache that returns credentials while they have more than minRemaining of their lifetime left.
+func New(minRemaining time.Duration) (*Cache, error) {
+	if minRemaining < 0 {
+		return nil, errors.New("minimum remaining lifetime of cached credentials must not be negative")
+	}
+	k := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, fmt.Errorf("could not generate credential cache key: %v", err)
+	}
+	b, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		aead:         aead,
+		minRemaining: minRemaining,
+		entries:      make(map[string]entry),
+	}, nil
+}
+
+func key(u goidentity.Identity, roleMappingID string) string {
+	return u.SessionID() + "/" + roleMappingID
+}
+
+// Credentials are the credentials cached, the ARN of the role they are for and the ARN of the federation user that
+// issued them.
+type Credentials struct {
+	Output     *awssts.AssumeRoleOutput
+	RoleArn    string
+	FedUserArn string
+}
+
+// Get returns the credentials cached for the user's session and role mapping if they have sufficient lifetime left.
+func (c *Cache) Get(u goidentity.Identity, roleMappingID string, now time.Time) (Credentials, bool) {
+	if c == nil {
+		return Credentials{}, false
+	}
+	k := key(u, roleMappingID)
+	c.mux.Lock()
+	e, ok := c.entries[k]
+	if ok && e.expiration.Sub(now) <= c.minRemaining {
+		delete(c.entries, k)
+		ok = false
+	}
+	c.mux.Unlock()
+	if !ok {
+		return Credentials{}, false
+	}
+	// The role mapping ID is authenticated as additional data so an entry cannot be served for another mapping.
+	b, err := c.aead.Open(nil, e.nonce, e.sealed, []byte(k))
+	if err != nil {
+		return Credentials{}, false
+	}
+	var o awssts.AssumeRoleOutput
+	if err := json.Unmarshal(b, &o); err != nil {
+		return Credentials{}, false
+	}
+	return Credentials{Output: &o, RoleArn: e.roleArn, FedUserArn: e.fedUserArn}, true
+}
+
+// Put caches the credentials for the role issued by the federation user for the user's session and role mapping.
+func (c *Cache) Put(u goidentity.Identity, roleMappingID, roleArn, fedUserArn string, o *awssts.AssumeRoleOutput) error {
+	if c == nil {
+		return nil
+	}
+	if o == nil || o.Credentials == nil || o.Credentials.Expiration == nil {
+		return errors.New("credentials to cache have no expiration")
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("could not marshal credentials for cache: %v", err)
+	}
+	k := key(u, roleMappingID)
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("could not generate nonce for credential cache: %v", err)
+	}
+	e := entry{
+		roleMappingID: roleMappingID,
+		roleArn:       roleArn,
+		fedUserArn:    fedUserArn,
+		expiration:    *o.Credentials.Expiration,
+		nonce:         nonce,
+		sealed:        c.aead.Seal(nil, nonce, b, []byte(k)),
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.entries[k] = e
+	return nil
+}
+
+// InvalidateRoleMapping removes all credentials cached for the role mapping and returns the number removed.
+func (c *Cache) InvalidateRoleMapping(id string) int {
+	return c.invalidate(func(e entry) bool { return e.roleMappingID == id })
+}
+
+// InvalidateFederationUser removes all credentials cached that were issued by the federation user and returns the
+// number removed.
+func (c *Cache) InvalidateFederationUser(arn string) int {
+	return c.invalidate(func(e entry) bool { return e.fedUserArn == arn })
+}
+
+// InvalidateAll removes all credentials cached and returns the number removed.
+func (c *Cache) InvalidateAll() int {
+	return c.invalidate(func(e entry) bool { return true })
+}
+
+// Purge removes the cached credentials that can no longer be returned and returns the number removed.
+func (c *Cache) Purge(now time.Time) int {
+	if c == nil {
+		return 0
+	}
+	return c.invalidate(func(e entry) bool { return e.expiration.Sub(now) <= c.minRemaining })
+}
+
+// Len returns the number of credentials cached.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) invalidate(match func(entry) bool) int {
+	if c == nil {
+		return 0
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var n int
+	for k, e := range c.entries {
+		if match(e) {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package credcache
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	awssts "github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"testing"
+	"time"
+)
+
+const (
+	roleMappingID  = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	roleMappingID2 = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	fedUserArn     = "arn:aws:iam::012345678912:user/feduser"
+	fedUserArn2    = "arn:aws:iam::012345678912:user/feduser2"
+	roleArn        = "arn:aws:iam::201345678912:role/role-name"
+)
+
+func output(exp time.Time) *awssts.AssumeRoleOutput {
+	return &awssts.AssumeRoleOutput{
+		Credentials: &awssts.Credentials{
+			AccessKeyId:     aws.String("ASIAEXAMPLE"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(exp),
+		},
+	}
+}
+
+func TestCache(t *testing.T) {
+	c, err := New(time.Minute * 5)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	u := goidentity.NewUser("testuser")
+	now := time.Now().UTC()
+	exp := now.Add(time.Hour).Truncate(time.Second)
+
+	_, ok := c.Get(&u, roleMappingID, now)
+	assert.False(t, ok, "empty cache should not return credentials")
+
+	err = c.Put(&u, roleMappingID, roleArn, fedUserArn, output(exp))
+	if err != nil {
+		t.Fatalf("error caching credentials: %v", err)
+	}
+	cr, ok := c.Get(&u, roleMappingID, now)
+	if assert.True(t, ok, "cached credentials should be returned") {
+		assert.Equal(t, "ASIAEXAMPLE", aws.StringValue(cr.Output.Credentials.AccessKeyId), "access key not as expected")
+		assert.Equal(t, exp, cr.Output.Credentials.Expiration.UTC(), "expiration not as expected")
+		assert.Equal(t, roleArn, cr.RoleArn, "role not as expected")
+		assert.Equal(t, fedUserArn, cr.FedUserArn, "federation user not as expected")
+	}
+	_, ok = c.Get(&u, roleMappingID2, now)
+	assert.False(t, ok, "credentials should not be returned for another role mapping")
+	other := goidentity.NewUser("testuser")
+	if other.SessionID() != u.SessionID() {
+		_, ok = c.Get(&other, roleMappingID, now)
+		assert.False(t, ok, "credentials should not be returned for another session")
+	}
+
+	// Insufficient remaining lifetime
+	_, ok = c.Get(&u, roleMappingID, exp.Add(-time.Minute))
+	assert.False(t, ok, "credentials without sufficient remaining lifetime should not be returned")
+	assert.Equal(t, 0, c.Len(), "credentials without sufficient remaining lifetime should be removed")
+
+	assert.Error(t, c.Put(&u, roleMappingID, roleArn, fedUserArn, &awssts.AssumeRoleOutput{}), "credentials without expiration should not be cached")
+}
+
+func TestInvalidate(t *testing.T) {
+	c, err := New(time.Minute * 5)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	u := goidentity.NewUser("testuser")
+	now := time.Now().UTC()
+	c.Put(&u, roleMappingID, roleArn, fedUserArn, output(now.Add(time.Hour)))
+	c.Put(&u, roleMappingID2, roleArn, fedUserArn2, output(now.Add(time.Minute)))
+	assert.Equal(t, 2, c.Len(), "number of cached credentials not as expected")
+
+	assert.Equal(t, 1, c.Purge(now), "number purged not as expected")
+	assert.Equal(t, 1, c.InvalidateRoleMapping(roleMappingID), "number invalidated for role mapping not as expected")
+	assert.Equal(t, 0, c.Len(), "cache should be empty")
+
+	c.Put(&u, roleMappingID, roleArn, fedUserArn, output(now.Add(time.Hour)))
+	c.Put(&u, roleMappingID2, roleArn, fedUserArn2, output(now.Add(time.Hour)))
+	assert.Equal(t, 1, c.InvalidateFederationUser(fedUserArn2), "number invalidated for federation user not as expected")
+	_, ok := c.Get(&u, roleMappingID, now)
+	assert.True(t, ok, "credentials from other federation users should remain cached")
+	assert.Equal(t, 1, c.InvalidateAll(), "number invalidated not as expected")
+
+	var nc *Cache
+	_, ok = nc.Get(&u, roleMappingID, now)
+	assert.False(t, ok, "nil cache should not return credentials")
+	assert.NoError(t, nc.Put(&u, roleMappingID, roleArn, fedUserArn, output(now.Add(time.Hour))), "nil cache should accept credentials")
+	assert.Equal(t, 0, nc.InvalidateAll(), "nil cache should have nothing to invalidate")
+}
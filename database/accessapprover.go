@@ -0,0 +1,48 @@
+package database
+
+const (
+	StmtKeyAccessApproverSelect = 91
+	QueryAccessApproverSelect   = "SELECT id, account_id, accountClass_id, authz_attrib FROM accessApprover WHERE id = ?"
+	StmtKeyAccessApproverInsert = 92
+	QueryAccessApproverInsert   = "INSERT INTO accessApprover (id, account_id, accountClass_id, authz_attrib) VALUES (?, ?, ?, ?)"
+	StmtKeyAccessApproverDelete = 93
+	QueryAccessApproverDelete   = "DELETE FROM accessApprover WHERE id = ?"
+	StmtKeyAccessApproverUpdate = 94
+	QueryAccessApproverUpdate   = "UPDATE accessApprover SET account_id = ?, accountClass_id = ?, authz_attrib = ? WHERE id = ?"
+)
+
+// ListAccessApprover lists the access approvers, by default in order of their ID.
+var ListAccessApprover = ListTable{
+	Columns: "id, account_id, accountClass_id, authz_attrib",
+	From:    "accessApprover",
+	Fields: map[string]string{
+		"id":      "id",
+		"account": "account_id",
+		"class":   "accountClass_id",
+		"authz":   "authz_attrib",
+	},
+	Order: []ListOrder{{Field: "id"}},
+}
+
+type accessApprover struct{}
+
+func (p *accessApprover) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyAccessApproverSelect,
+			Query: QueryAccessApproverSelect,
+		},
+		{
+			ID:    StmtKeyAccessApproverInsert,
+			Query: QueryAccessApproverInsert,
+		},
+		{
+			ID:    StmtKeyAccessApproverDelete,
+			Query: QueryAccessApproverDelete,
+		},
+		{
+			ID:    StmtKeyAccessApproverUpdate,
+			Query: QueryAccessApproverUpdate,
+		},
+	}
+}
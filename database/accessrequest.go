@@ -0,0 +1,70 @@
+package database
+
+const (
+	accessRequestColumns          = "id, roleMapping_id, username, user_domain, justification, duration, status, requested, decided_by, decided, comment, valid_until"
+	StmtKeyAccessRequestSelect    = 81
+	QueryAccessRequestSelect      = "SELECT " + accessRequestColumns + " FROM accessRequest WHERE id = ?"
+	StmtKeyAccessRequestInsert    = 83
+	QueryAccessRequestInsert      = "INSERT INTO accessRequest (id, roleMapping_id, username, user_domain, justification, duration, status, requested) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	StmtKeyAccessRequestDecide    = 84
+	QueryAccessRequestDecide      = "UPDATE accessRequest SET status = ?, decided_by = ?, decided = ?, comment = ?, valid_until = ? WHERE id = ? AND status = ?"
+	StmtKeyAccessRequestExists    = 85
+	QueryAccessRequestExists      = "SELECT 1 FROM accessRequest WHERE roleMapping_id = ? AND username = ? AND user_domain = ? AND status = ? LIMIT 1"
+	StmtKeyAccessGrantCheck       = 86
+	QueryAccessGrantCheck         = "SELECT valid_until FROM accessRequest WHERE roleMapping_id = ? AND username = ? AND user_domain = ? AND status = ? AND valid_until > ? ORDER BY valid_until DESC LIMIT 1"
+	StmtKeyAccessRequestApprovers = 87
+	QueryAccessRequestApprovers   = "SELECT accessApprover.authz_attrib " +
+		"FROM roleMapping " +
+		"JOIN account ON roleMapping.account_id = account.id " +
+		"JOIN accountType ON account.accountType_id = accountType.id " +
+		"JOIN accessApprover ON (accessApprover.account_id = account.id OR accessApprover.accountClass_id = accountType.class_id) " +
+		"WHERE roleMapping.id = ? AND roleMapping.deleted IS NULL"
+)
+
+// ListAccessRequest lists the access requests, by default newest first.
+var ListAccessRequest = ListTable{
+	Columns: accessRequestColumns,
+	From:    "accessRequest",
+	Fields: map[string]string{
+		"id":          "id",
+		"rolemapping": "roleMapping_id",
+		"username":    "username",
+		"domain":      "user_domain",
+		"status":      "status",
+		"requested":   "requested",
+		"decided":     "decided",
+		"validuntil":  "valid_until",
+	},
+	Order: []ListOrder{{Field: "requested", Desc: true}, {Field: "id"}},
+}
+
+type accessRequest struct{}
+
+func (p *accessRequest) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyAccessRequestSelect,
+			Query: QueryAccessRequestSelect,
+		},
+		{
+			ID:    StmtKeyAccessRequestInsert,
+			Query: QueryAccessRequestInsert,
+		},
+		{
+			ID:    StmtKeyAccessRequestDecide,
+			Query: QueryAccessRequestDecide,
+		},
+		{
+			ID:    StmtKeyAccessRequestExists,
+			Query: QueryAccessRequestExists,
+		},
+		{
+			ID:    StmtKeyAccessGrantCheck,
+			Query: QueryAccessGrantCheck,
+		},
+		{
+			ID:    StmtKeyAccessRequestApprovers,
+			Query: QueryAccessRequestApprovers,
+		},
+	}
+}
@@ -1,38 +1,57 @@
 package database
 
 const (
-	StmtKeyAcctSelectList = 10
-	QueryAcctSelectList   = "SELECT account.id, email, name, " +
-		"accountType_id, accountType.type, " +
-		"accountClass.id, accountClass.class, " +
-		"accountStatus_id, accountStatus.status, " +
-		"federationUser_arn " +
-		"FROM account " +
-		"JOIN accountType ON account.accountType_id = accountType.id " +
-		"JOIN accountClass ON accountType.class_id = accountClass.id " +
-		"JOIN accountStatus ON account.accountStatus_id = accountStatus.id " +
-		"ORDER BY account.id ASC"
 	StmtKeyAcctSelect = 11
 	QueryAcctSelect   = "SELECT account.id, email, name, " +
 		"accountType_id, accountType.type, " +
 		"accountClass.id, accountClass.class, " +
 		"accountStatus_id, accountStatus.status, " +
-		"federationUser_arn " +
+		"federationUser_arn, account.version " +
 		"FROM account " +
 		"JOIN accountType ON account.accountType_id = accountType.id " +
 		"JOIN accountClass ON accountType.class_id = accountClass.id " +
 		"JOIN accountStatus ON account.accountStatus_id = accountStatus.id " +
-		"WHERE account.id = ?"
+		"WHERE account.id = ? AND account.deleted IS NULL"
 	StmtKeyAcctInsert      = 12
 	QueryAcctInsert        = "INSERT IGNORE INTO account (id, email, name, accountType_id, accountStatus_id, federationUser_arn) VALUES (?, ?, ?, ?, ?, ?)"
 	StmtKeyAcctDelete      = 13
-	QueryAcctDelete        = "DELETE FROM account WHERE id = ?"
+	QueryAcctDelete        = "UPDATE account SET deleted = UTC_TIMESTAMP(), version = version + 1 WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)"
 	StmtKeyAcctUpdate      = 14
-	QueryAcctUpdate        = "UPDATE account SET email = ?, name = ?, accountType_id = ?, accountStatus_id = ?, federationUser_arn = ? WHERE id = ?"
+	QueryAcctUpdate        = "UPDATE account SET email = ?, name = ?, accountType_id = ?, accountStatus_id = ?, federationUser_arn = ?, version = version + 1 WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)"
 	StmtKeyAcctCheckUnique = 15
-	QueryAcctCheckUnique   = "SELECT account.id FROM account WHERE account.id = ? OR email = ? OR name = ?"
+	QueryAcctCheckUnique   = "SELECT account.id, account.deleted IS NOT NULL FROM account WHERE account.id = ? OR email = ? OR name = ? ORDER BY account.deleted IS NOT NULL LIMIT 1"
+	StmtKeyAcctVersion     = 16
+	QueryAcctVersion       = "SELECT version FROM account WHERE id = ? AND deleted IS NULL"
+	StmtKeyAcctRestore     = 17
+	QueryAcctRestore       = "UPDATE account SET deleted = NULL, version = version + 1 WHERE id = ? AND deleted IS NOT NULL"
+	StmtKeyAcctInUse       = 18
+	QueryAcctInUse         = "SELECT 1 FROM roleMapping WHERE account_id = ? AND deleted IS NULL LIMIT 1"
 )
 
+// ListAcct lists the accounts, by default in order of their ID.
+var ListAcct = ListTable{
+	Columns: "account.id, email, name, " +
+		"accountType_id, accountType.type, " +
+		"accountClass.id, accountClass.class, " +
+		"accountStatus_id, accountStatus.status, " +
+		"federationUser_arn",
+	From: "account " +
+		"JOIN accountType ON account.accountType_id = accountType.id " +
+		"JOIN accountClass ON accountType.class_id = accountClass.id " +
+		"JOIN accountStatus ON account.accountStatus_id = accountStatus.id",
+	Where: "account.deleted IS NULL",
+	Fields: map[string]string{
+		"id":             "account.id",
+		"email":          "email",
+		"name":           "name",
+		"type":           "accountType_id",
+		"class":          "accountClass.id",
+		"status":         "accountStatus_id",
+		"federationuser": "federationUser_arn",
+	},
+	Order: []ListOrder{{Field: "id"}},
+}
+
 type account struct{}
 
 func (p *account) stmts() []Statement {
@@ -41,10 +60,6 @@ func (p *account) stmts() []Statement {
 			ID:    StmtKeyAcctSelect,
 			Query: QueryAcctSelect,
 		},
-		{
-			ID:    StmtKeyAcctSelectList,
-			Query: QueryAcctSelectList,
-		},
 		{
 			ID:    StmtKeyAcctInsert,
 			Query: QueryAcctInsert,
@@ -61,5 +76,17 @@ func (p *account) stmts() []Statement {
 			ID:    StmtKeyAcctCheckUnique,
 			Query: QueryAcctCheckUnique,
 		},
+		{
+			ID:    StmtKeyAcctVersion,
+			Query: QueryAcctVersion,
+		},
+		{
+			ID:    StmtKeyAcctRestore,
+			Query: QueryAcctRestore,
+		},
+		{
+			ID:    StmtKeyAcctInUse,
+			Query: QueryAcctInUse,
+		},
 	}
 }
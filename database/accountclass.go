@@ -1,20 +1,31 @@
 package database
 
 const (
-	StmtKeyAcctClassSelectList = 20
-	QueryAcctClassSelectList   = "SELECT id, class FROM accountClass ORDER BY id ASC"
-	StmtKeyAcctClassSelect     = 21
-	QueryAcctClassSelect       = "SELECT id, class FROM accountClass WHERE id = ?"
-	StmtKeyAcctClassByName     = 22
-	QueryAcctClassByName       = "SELECT id FROM accountClass WHERE class = ?"
-	StmtKeyAcctClassInsert     = 23
-	QueryAcctClassInsert       = "INSERT IGNORE INTO accountClass (class) VALUES (?)"
-	StmtKeyAcctClassDelete     = 24
-	QueryAcctClassDelete       = "DELETE FROM accountClass WHERE id = ?"
-	StmtKeyAcctClassUpdate     = 25
-	QueryAcctClassUpdate       = "UPDATE accountClass SET class = ? WHERE id = ?"
+	StmtKeyAcctClassSelect  = 21
+	QueryAcctClassSelect    = "SELECT id, class, version FROM accountClass WHERE id = ?"
+	StmtKeyAcctClassByName  = 22
+	QueryAcctClassByName    = "SELECT id FROM accountClass WHERE class = ?"
+	StmtKeyAcctClassInsert  = 23
+	QueryAcctClassInsert    = "INSERT IGNORE INTO accountClass (class) VALUES (?)"
+	StmtKeyAcctClassDelete  = 24
+	QueryAcctClassDelete    = "DELETE FROM accountClass WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctClassUpdate  = 25
+	QueryAcctClassUpdate    = "UPDATE accountClass SET class = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctClassVersion = 26
+	QueryAcctClassVersion   = "SELECT version FROM accountClass WHERE id = ?"
 )
 
+// ListAcctClass lists the account classes, by default in order of their ID.
+var ListAcctClass = ListTable{
+	Columns: "id, class",
+	From:    "accountClass",
+	Fields: map[string]string{
+		"id":    "id",
+		"class": "class",
+	},
+	Order: []ListOrder{{Field: "id"}},
+}
+
 type accountClass struct{}
 
 func (p *accountClass) stmts() []Statement {
@@ -23,10 +34,6 @@ func (p *accountClass) stmts() []Statement {
 			ID:    StmtKeyAcctClassSelect,
 			Query: QueryAcctClassSelect,
 		},
-		{
-			ID:    StmtKeyAcctClassSelectList,
-			Query: QueryAcctClassSelectList,
-		},
 		{
 			ID:    StmtKeyAcctClassByName,
 			Query: QueryAcctClassByName,
@@ -43,5 +50,9 @@ func (p *accountClass) stmts() []Statement {
 			ID:    StmtKeyAcctClassUpdate,
 			Query: QueryAcctClassUpdate,
 		},
+		{
+			ID:    StmtKeyAcctClassVersion,
+			Query: QueryAcctClassVersion,
+		},
 	}
 }
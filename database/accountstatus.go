@@ -1,20 +1,31 @@
 package database
 
 const (
-	StmtKeyAcctStatusSelectList = 30
-	QueryAcctStatusSelectList   = "SELECT id, status FROM accountStatus ORDER BY id ASC"
-	StmtKeyAcctStatusSelect     = 31
-	QueryAcctStatusSelect       = "SELECT id, status FROM accountStatus WHERE id = ?"
-	StmtKeyAcctStatusByName     = 32
-	QueryAcctStatusByName       = "SELECT id FROM accountStatus WHERE status = ?"
-	StmtKeyAcctStatusInsert     = 33
-	QueryAcctStatusInsert       = "INSERT IGNORE INTO accountStatus (status) VALUES (?)"
-	StmtKeyAcctStatusDelete     = 34
-	QueryAcctStatusDelete       = "DELETE FROM accountStatus WHERE id = ?"
-	StmtKeyAcctStatusUpdate     = 35
-	QueryAcctStatusUpdate       = "UPDATE accountStatus SET status = ? WHERE id = ?"
+	StmtKeyAcctStatusSelect  = 31
+	QueryAcctStatusSelect    = "SELECT id, status, version FROM accountStatus WHERE id = ?"
+	StmtKeyAcctStatusByName  = 32
+	QueryAcctStatusByName    = "SELECT id FROM accountStatus WHERE status = ?"
+	StmtKeyAcctStatusInsert  = 33
+	QueryAcctStatusInsert    = "INSERT IGNORE INTO accountStatus (status) VALUES (?)"
+	StmtKeyAcctStatusDelete  = 34
+	QueryAcctStatusDelete    = "DELETE FROM accountStatus WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctStatusUpdate  = 35
+	QueryAcctStatusUpdate    = "UPDATE accountStatus SET status = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctStatusVersion = 36
+	QueryAcctStatusVersion   = "SELECT version FROM accountStatus WHERE id = ?"
 )
 
+// ListAcctStatus lists the account statuses, by default in order of their ID.
+var ListAcctStatus = ListTable{
+	Columns: "id, status",
+	From:    "accountStatus",
+	Fields: map[string]string{
+		"id":     "id",
+		"status": "status",
+	},
+	Order: []ListOrder{{Field: "id"}},
+}
+
 type accountStatus struct{}
 
 func (p *accountStatus) stmts() []Statement {
@@ -23,10 +34,6 @@ func (p *accountStatus) stmts() []Statement {
 			ID:    StmtKeyAcctStatusSelect,
 			Query: QueryAcctStatusSelect,
 		},
-		{
-			ID:    StmtKeyAcctStatusSelectList,
-			Query: QueryAcctStatusSelectList,
-		},
 		{
 			ID:    StmtKeyAcctStatusByName,
 			Query: QueryAcctStatusByName,
@@ -43,5 +50,9 @@ func (p *accountStatus) stmts() []Statement {
 			ID:    StmtKeyAcctStatusUpdate,
 			Query: QueryAcctStatusUpdate,
 		},
+		{
+			ID:    StmtKeyAcctStatusVersion,
+			Query: QueryAcctStatusVersion,
+		},
 	}
 }
@@ -1,20 +1,32 @@
 package database
 
 const (
-	StmtKeyAcctTypeSelectList = 40
-	QueryAcctTypeSelectList   = "SELECT id, type, class_id FROM accountType ORDER BY id ASC"
-	StmtKeyAcctTypeSelect     = 41
-	QueryAcctTypeSelect       = "SELECT id, type, class_id FROM accountType WHERE id = ?"
-	StmtKeyAcctTypeByName     = 42
-	QueryAcctTypeByName       = "SELECT id FROM accountType WHERE type = ?"
-	StmtKeyAcctTypeInsert     = 43
-	QueryAcctTypeInsert       = "INSERT IGNORE INTO accountType (type, class_id) VALUES (?, ?)"
-	StmtKeyAcctTypeDelete     = 44
-	QueryAcctTypeDelete       = "DELETE FROM accountType WHERE id = ?"
-	StmtKeyAcctTypeUpdate     = 45
-	QueryAcctTypeUpdate       = "UPDATE accountType SET type = ?, class_id = ? WHERE id = ?"
+	StmtKeyAcctTypeSelect  = 41
+	QueryAcctTypeSelect    = "SELECT id, type, class_id, version FROM accountType WHERE id = ?"
+	StmtKeyAcctTypeByName  = 42
+	QueryAcctTypeByName    = "SELECT id FROM accountType WHERE type = ?"
+	StmtKeyAcctTypeInsert  = 43
+	QueryAcctTypeInsert    = "INSERT IGNORE INTO accountType (type, class_id) VALUES (?, ?)"
+	StmtKeyAcctTypeDelete  = 44
+	QueryAcctTypeDelete    = "DELETE FROM accountType WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctTypeUpdate  = 45
+	QueryAcctTypeUpdate    = "UPDATE accountType SET type = ?, class_id = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?)"
+	StmtKeyAcctTypeVersion = 46
+	QueryAcctTypeVersion   = "SELECT version FROM accountType WHERE id = ?"
 )
 
+// ListAcctType lists the account types, by default in order of their ID.
+var ListAcctType = ListTable{
+	Columns: "id, type, class_id",
+	From:    "accountType",
+	Fields: map[string]string{
+		"id":    "id",
+		"type":  "type",
+		"class": "class_id",
+	},
+	Order: []ListOrder{{Field: "id"}},
+}
+
 type accountType struct{}
 
 func (p *accountType) stmts() []Statement {
@@ -23,10 +35,6 @@ func (p *accountType) stmts() []Statement {
 			ID:    StmtKeyAcctTypeSelect,
 			Query: QueryAcctTypeSelect,
 		},
-		{
-			ID:    StmtKeyAcctTypeSelectList,
-			Query: QueryAcctTypeSelectList,
-		},
 		{
 			ID:    StmtKeyAcctTypeByName,
 			Query: QueryAcctTypeByName,
@@ -43,5 +51,9 @@ func (p *accountType) stmts() []Statement {
 			ID:    StmtKeyAcctTypeUpdate,
 			Query: QueryAcctTypeUpdate,
 		},
+		{
+			ID:    StmtKeyAcctTypeVersion,
+			Query: QueryAcctTypeVersion,
+		},
 	}
 }
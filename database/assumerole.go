@@ -2,13 +2,13 @@ package database
 
 const (
 	StmtKeyAuthzCheck        = 50
-	QueryAuthzCheck          = "SELECT authz_attrib FROM roleMapping WHERE id =?"
+	QueryAuthzCheck          = "SELECT authz_attrib, valid_from, valid_until, schedule FROM roleMapping WHERE id =? AND deleted IS NULL"
 	StmtKeyRoleMappingLookup = 51
 	QueryRoleMappingLookup   = "SELECT role_arn, federationUser.arn, duration, policy, session_name_format " +
 		"FROM roleMapping " +
 		"JOIN account ON roleMapping.account_id = account.id " +
 		"JOIN federationUser ON account.federationUser_arn = federationUser.arn " +
-		"WHERE roleMapping.id = ?"
+		"WHERE roleMapping.id = ? AND roleMapping.deleted IS NULL AND account.deleted IS NULL"
 )
 
 type assumeRole struct{}
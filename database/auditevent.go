@@ -0,0 +1,41 @@
+package database
+
+const (
+	auditEventColumns           = "id, uuid, time, event_type, username, user_domain, user_session_id, severity, outcome, roleMapping_id, account_id, federation_user, detail"
+	StmtKeyAuditEventInsert     = 110
+	QueryAuditEventInsert       = "INSERT INTO auditEvent (uuid, time, event_type, username, user_domain, user_session_id, severity, outcome, roleMapping_id, account_id, federation_user, detail) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	StmtKeyAuditEventSelectList = 111
+	// Each filter is passed twice, an empty value matches all events. Events are returned newest first and pages are
+	// continued from the ID of the last event of the previous page.
+	QueryAuditEventSelectList = "SELECT " + auditEventColumns + " FROM auditEvent " +
+		"WHERE (? = '' OR username = ?) " +
+		"AND (? = '' OR user_domain = ?) " +
+		"AND (? = '' OR event_type = ?) " +
+		"AND (? = '' OR roleMapping_id = ?) " +
+		"AND (? = '' OR account_id = ?) " +
+		"AND (? = '' OR federation_user = ?) " +
+		"AND (? = '' OR outcome = ?) " +
+		"AND time >= ? AND time < ? AND id < ? " +
+		"ORDER BY id DESC LIMIT ?"
+	StmtKeyAuditEventPurge = 112
+	QueryAuditEventPurge   = "DELETE FROM auditEvent WHERE time < ? ORDER BY id LIMIT ?"
+)
+
+type auditEvent struct{}
+
+func (p *auditEvent) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyAuditEventInsert,
+			Query: QueryAuditEventInsert,
+		},
+		{
+			ID:    StmtKeyAuditEventSelectList,
+			Query: QueryAuditEventSelectList,
+		},
+		{
+			ID:    StmtKeyAuditEventPurge,
+			Query: QueryAuditEventPurge,
+		},
+	}
+}
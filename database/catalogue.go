@@ -0,0 +1,94 @@
+package database
+
+// The catalogue statements read the whole catalogue and create entities with the IDs given in an import. An account or
+// role mapping that was deleted is revived, rather than inserted again, when created by an import. The other changes
+// made by an import use the statements of the API. An import locks the row of the catalogueLock table until it is
+// committed or rolled back so that imports, including the catalogue syncs of the servers sharing the database, are made
+// one at a time.
+const (
+	StmtKeyCatalogueAcctClasses       = 120
+	QueryCatalogueAcctClasses         = "SELECT id, class FROM accountClass ORDER BY id"
+	StmtKeyCatalogueAcctTypes         = 121
+	QueryCatalogueAcctTypes           = "SELECT id, type, class_id FROM accountType ORDER BY id"
+	StmtKeyCatalogueAcctStatuses      = 122
+	QueryCatalogueAcctStatuses        = "SELECT id, status FROM accountStatus ORDER BY id"
+	StmtKeyCatalogueFedUsers          = 123
+	QueryCatalogueFedUsers            = "SELECT arn, name, ttl FROM federationUser ORDER BY arn"
+	StmtKeyCatalogueAccts             = 124
+	QueryCatalogueAccts               = "SELECT id, email, name, accountType_id, accountStatus_id, federationUser_arn FROM account WHERE deleted IS NULL ORDER BY id"
+	StmtKeyCatalogueRoleMappings      = 125
+	QueryCatalogueRoleMappings        = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format, valid_from, valid_until, schedule FROM roleMapping WHERE deleted IS NULL ORDER BY account_id, id"
+	StmtKeyCatalogueAcctClassAdd      = 126
+	QueryCatalogueAcctClassAdd        = "INSERT INTO accountClass (id, class) VALUES (?, ?)"
+	StmtKeyCatalogueAcctTypeAdd       = 127
+	QueryCatalogueAcctTypeAdd         = "INSERT INTO accountType (id, type, class_id) VALUES (?, ?, ?)"
+	StmtKeyCatalogueAcctStatusAdd     = 128
+	QueryCatalogueAcctStatusAdd       = "INSERT INTO accountStatus (id, status) VALUES (?, ?)"
+	StmtKeyCatalogueFedUserUpdate     = 129
+	QueryCatalogueFedUserUpdate       = "UPDATE federationUser SET name = ?, ttl = ? WHERE arn = ?"
+	StmtKeyCatalogueAcctRevive        = 130
+	QueryCatalogueAcctRevive          = "UPDATE account SET email = ?, name = ?, accountType_id = ?, accountStatus_id = ?, federationUser_arn = ?, deleted = NULL, version = version + 1 WHERE id = ? AND deleted IS NOT NULL"
+	StmtKeyCatalogueRoleMappingRevive = 131
+	QueryCatalogueRoleMappingRevive   = "UPDATE roleMapping SET account_id = ?, role_arn = ?, authz_attrib = ?, policy = ?, duration = ?, session_name_format = ?, valid_from = ?, valid_until = ?, schedule = ?, deleted = NULL, version = version + 1 WHERE id = ? AND deleted IS NOT NULL"
+	StmtKeyCatalogueLock              = 132
+	QueryCatalogueLock                = "SELECT id FROM catalogueLock WHERE id = 1 FOR UPDATE"
+)
+
+type catalogue struct{}
+
+func (p *catalogue) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyCatalogueAcctClasses,
+			Query: QueryCatalogueAcctClasses,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctTypes,
+			Query: QueryCatalogueAcctTypes,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctStatuses,
+			Query: QueryCatalogueAcctStatuses,
+		},
+		{
+			ID:    StmtKeyCatalogueFedUsers,
+			Query: QueryCatalogueFedUsers,
+		},
+		{
+			ID:    StmtKeyCatalogueAccts,
+			Query: QueryCatalogueAccts,
+		},
+		{
+			ID:    StmtKeyCatalogueRoleMappings,
+			Query: QueryCatalogueRoleMappings,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctClassAdd,
+			Query: QueryCatalogueAcctClassAdd,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctTypeAdd,
+			Query: QueryCatalogueAcctTypeAdd,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctStatusAdd,
+			Query: QueryCatalogueAcctStatusAdd,
+		},
+		{
+			ID:    StmtKeyCatalogueFedUserUpdate,
+			Query: QueryCatalogueFedUserUpdate,
+		},
+		{
+			ID:    StmtKeyCatalogueAcctRevive,
+			Query: QueryCatalogueAcctRevive,
+		},
+		{
+			ID:    StmtKeyCatalogueRoleMappingRevive,
+			Query: QueryCatalogueRoleMappingRevive,
+		},
+		{
+			ID:    StmtKeyCatalogueLock,
+			Query: QueryCatalogueLock,
+		},
+	}
+}
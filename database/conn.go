@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Conn is a connection to the database and the statements prepared on it.
+type Conn struct {
+	DB    *sql.DB
+	Stmts *StmtMap
+}
+
+// Source provides the connection to the database to use for an operation.
+type Source interface {
+	// Acquire returns the connection to use. The function returned must be called once the operation has finished with
+	// the connection.
+	Acquire() (*Conn, func())
+}
+
+// Acquire returns the connection itself as it is never replaced.
+func (c *Conn) Acquire() (*Conn, func()) {
+	return c, func() {}
+}
+
+// Close closes the prepared statements and the connection.
+func (c *Conn) Close() error {
+	if c.Stmts != nil {
+		c.Stmts.Close()
+	}
+	return c.DB.Close()
+}
+
+// Current holds the connection in use, which can be replaced while operations are running, for example when the
+// database credentials are rotated. Each operation acquires the connection once so that it uses the same statements
+// throughout, and a connection that has been replaced is only closed once every operation using it has finished.
+type Current struct {
+	mux  sync.RWMutex
+	conn *heldConn
+}
+
+type heldConn struct {
+	*Conn
+	inUse sync.WaitGroup
+}
+
+// NewCurrent returns a holder of the connection given.
+func NewCurrent(c *Conn) *Current {
+	return &Current{conn: &heldConn{Conn: c}}
+}
+
+// Acquire returns the connection in use.
+func (c *Current) Acquire() (*Conn, func()) {
+	c.mux.RLock()
+	h := c.conn
+	h.inUse.Add(1)
+	c.mux.RUnlock()
+	var once sync.Once
+	return h.Conn, func() { once.Do(h.inUse.Done) }
+}
+
+// Replace switches new operations to the connection given. The connection replaced is closed in the background once
+// the operations using it have finished, after which closed is called if it is not nil.
+func (c *Current) Replace(n *Conn, closed func()) {
+	c.mux.Lock()
+	old := c.conn
+	c.conn = &heldConn{Conn: n}
+	c.mux.Unlock()
+	go func() {
+		old.inUse.Wait()
+		old.Close()
+		if closed != nil {
+			closed()
+		}
+	}()
+}
+
+// Close closes the connection in use without waiting for the operations using it, for example once the server has
+// shut down.
+func (c *Current) Close() error {
+	c.mux.RLock()
+	h := c.conn
+	c.mux.RUnlock()
+	return h.Close()
+}
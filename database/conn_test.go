@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func TestCurrent_Replace(t *testing.T) {
+	db1, mock1, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening mock database: %v", err)
+	}
+	db2, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening mock database: %v", err)
+	}
+	defer db2.Close()
+	mock1.ExpectClose()
+	c1, c2 := &Conn{DB: db1}, &Conn{DB: db2}
+	cur := NewCurrent(c1)
+
+	conn, release := cur.Acquire()
+	assert.Equal(t, c1, conn, "connection acquired not as expected")
+	closed := make(chan struct{})
+	cur.Replace(c2, func() { close(closed) })
+	conn2, release2 := cur.Acquire()
+	assert.Equal(t, c2, conn2, "operation after the replacement should use the new connection")
+	release2()
+
+	select {
+	case <-closed:
+		t.Fatal("connection closed while still in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	// Releasing more than once has no effect
+	release()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection replaced not closed once released")
+	}
+	assert.NoError(t, mock1.ExpectationsWereMet(), "connection replaced not closed")
+}
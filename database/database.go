@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"github.com/jcmturner/awsfederation/metrics"
 )
 
 type StmtMap map[int]*sql.Stmt
@@ -31,6 +32,12 @@ func Statements() []Statement {
 		new(accountStatus),
 		new(roleMapping),
 		new(account),
+		new(accessRequest),
+		new(accessApprover),
+		new(rateLimit),
+		new(auditEvent),
+		new(catalogue),
+		new(history),
 	}
 	var s []Statement
 	for _, p := range ps {
@@ -43,8 +50,10 @@ func addStmt(stmtMap *StmtMap, stmts []Statement, db *sql.DB) error {
 	for _, stmt := range stmts {
 		s, err := db.Prepare(stmt.Query)
 		if err != nil {
+			metrics.PreparedStatementErrors.Inc()
 			return fmt.Errorf("Error preparing statement ID %d: %v", stmt.ID, err)
 		}
+		register(s, stmt)
 		(*stmtMap)[stmt.ID] = s
 	}
 	return nil
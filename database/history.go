@@ -0,0 +1,23 @@
+package database
+
+const (
+	StmtKeyHistoryInsert = 140
+	QueryHistoryInsert   = "INSERT INTO history (resource, resource_id, action, actor, time, before_value, after_value) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	StmtKeyHistorySelect = 141
+	QueryHistorySelect   = "SELECT id, resource, resource_id, action, actor, time, before_value, after_value FROM history WHERE resource = ? AND resource_id = ? ORDER BY id"
+)
+
+type history struct{}
+
+func (p *history) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyHistoryInsert,
+			Query: QueryHistoryInsert,
+		},
+		{
+			ID:    StmtKeyHistorySelect,
+			Query: QueryHistorySelect,
+		},
+	}
+}
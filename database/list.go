@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsfederation/tracing"
+	"strings"
+)
+
+// ListTable describes how a resource is listed. Fields maps the names of the fields the list can be filtered and
+// sorted on to their columns. Only these columns are written into the list queries, the filter values are always bound
+// as parameters. Order is the sort applied after any requested, it must include a unique column so that the pages of
+// the list are stable. Where, if set, is a condition every row listed must meet.
+type ListTable struct {
+	Columns string
+	From    string
+	Where   string
+	Fields  map[string]string
+	Order   []ListOrder
+}
+
+// ListFilter selects the rows where the field has any of the values.
+type ListFilter struct {
+	Field  string
+	Values []string
+}
+
+// ListOrder sorts the rows by the field.
+type ListOrder struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery is a page of a list of a resource.
+type ListQuery struct {
+	Table   ListTable
+	Filters []ListFilter
+	Order   []ListOrder
+	Limit   int
+	Offset  int
+}
+
+var errNoConnection = errors.New("no database connection")
+
+// Field returns the column of the field, checking the list can be filtered and sorted on it.
+func (t ListTable) Field(name string) (string, error) {
+	if col, ok := t.Fields[name]; ok {
+		return col, nil
+	}
+	return "", fmt.Errorf("unknown field %s", name)
+}
+
+func (q ListQuery) where() (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+	if q.Table.Where != "" {
+		conds = append(conds, q.Table.Where)
+	}
+	for _, f := range q.Filters {
+		col, err := q.Table.Field(f.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(f.Values) < 1 {
+			continue
+		}
+		conds = append(conds, col+" IN (?"+strings.Repeat(", ?", len(f.Values)-1)+")")
+		for _, v := range f.Values {
+			args = append(args, v)
+		}
+	}
+	if len(conds) < 1 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+func (q ListQuery) orderBy() (string, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, o := range append(append([]ListOrder{}, q.Order...), q.Table.Order...) {
+		col, err := q.Table.Field(o.Field)
+		if err != nil {
+			return "", err
+		}
+		if seen[col] {
+			continue
+		}
+		seen[col] = true
+		dir := "ASC"
+		if o.Desc {
+			dir = "DESC"
+		}
+		terms = append(terms, col+" "+dir)
+	}
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
+
+// SQL returns the query selecting the page of the list and its arguments.
+func (q ListQuery) SQL() (string, []interface{}, error) {
+	where, args, err := q.where()
+	if err != nil {
+		return "", nil, err
+	}
+	order, err := q.orderBy()
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, q.Limit, q.Offset)
+	return "SELECT " + q.Table.Columns + " FROM " + q.Table.From + where + order + " LIMIT ? OFFSET ?", args, nil
+}
+
+// CountSQL returns the query counting the rows of the list matching the filters and its arguments.
+func (q ListQuery) CountSQL() (string, []interface{}, error) {
+	where, args, err := q.where()
+	if err != nil {
+		return "", nil, err
+	}
+	return "SELECT COUNT(*) FROM " + q.Table.From + where, args, nil
+}
+
+// List returns the rows of the page of the list and the total number of rows matching the filters. The filters and
+// ordering vary between requests so the queries cannot be prepared in advance. They are run on the connection of the
+// connection given, which must be the one the statements in use were prepared on so that new credentials are also
+// used once these are rotated.
+func List(ctx context.Context, db *sql.DB, q ListQuery) (*sql.Rows, int, error) {
+	if db == nil {
+		return nil, 0, errNoConnection
+	}
+	cq, cargs, err := q.CountSQL()
+	if err != nil {
+		return nil, 0, err
+	}
+	sq, sargs, err := q.SQL()
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	cctx, span := querySpan(ctx, cq)
+	err = db.QueryRowContext(cctx, cq, cargs...).Scan(&total)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, 0, err
+	}
+	sctx, span := querySpan(ctx, sq)
+	rows, err := db.QueryContext(sctx, sq, sargs...)
+	tracing.End(span, err)
+	return rows, total, err
+}
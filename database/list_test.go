@@ -0,0 +1,47 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestListQuery_SQL(t *testing.T) {
+	q := ListQuery{
+		Table: ListRoleMapping,
+		Filters: []ListFilter{
+			{Field: "account", Values: []string{"123456789012", "210987654321"}},
+			{Field: "authz", Values: []string{"group1"}},
+		},
+		Order:  []ListOrder{{Field: "validuntil", Desc: true}, {Field: "id"}},
+		Limit:  10,
+		Offset: 20,
+	}
+	s, args, err := q.SQL()
+	if err != nil {
+		t.Fatalf("error building list query: %v", err)
+	}
+	assert.Equal(t, "SELECT "+ListRoleMapping.Columns+" FROM roleMapping WHERE roleMapping.deleted IS NULL AND account_id IN (?, ?) AND authz_attrib IN (?) "+
+		"ORDER BY valid_until DESC, id ASC, account_id ASC LIMIT ? OFFSET ?", s, "list query not as expected")
+	assert.Equal(t, []interface{}{"123456789012", "210987654321", "group1", 10, 20}, args, "list query arguments not as expected")
+
+	s, args, err = q.CountSQL()
+	if err != nil {
+		t.Fatalf("error building count query: %v", err)
+	}
+	assert.Equal(t, "SELECT COUNT(*) FROM roleMapping WHERE roleMapping.deleted IS NULL AND account_id IN (?, ?) AND authz_attrib IN (?)", s, "count query not as expected")
+	assert.Equal(t, []interface{}{"123456789012", "210987654321", "group1"}, args, "count query arguments not as expected")
+
+	// No filters and the default order
+	s, args, err = ListQuery{Table: ListAccessRequest, Limit: 100}.SQL()
+	if err != nil {
+		t.Fatalf("error building list query: %v", err)
+	}
+	assert.Equal(t, "SELECT "+accessRequestColumns+" FROM accessRequest ORDER BY requested DESC, id ASC LIMIT ? OFFSET ?", s, "default list query not as expected")
+	assert.Equal(t, []interface{}{100, 0}, args, "default list query arguments not as expected")
+
+	// Only the fields of the table can be used
+	_, _, err = ListQuery{Table: ListAcctClass, Order: []ListOrder{{Field: "class; DROP TABLE account"}}}.SQL()
+	assert.Error(t, err, "unknown sort field accepted")
+	_, _, err = ListQuery{Table: ListAcctClass, Filters: []ListFilter{{Field: "nope", Values: []string{"x"}}}}.CountSQL()
+	assert.Error(t, err, "unknown filter field accepted")
+}
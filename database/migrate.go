@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SchemaVersion is the version of the schema DBCreateTables creates. It is recorded in the metadata table so that
+// Upgrade knows which migrations a database created by an earlier release still needs.
+const SchemaVersion = 6
+
+// schemaChange is a change to an existing table. The change is skipped if the check query, if any, counts any rows
+// so that a migration that was interrupted can be applied again.
+type schemaChange struct {
+	check string
+	args  []interface{}
+	stmt  string
+}
+
+type migration struct {
+	version int
+	changes []schemaChange
+}
+
+const (
+	columnExists = "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = 'awsfederation' AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+	indexExists  = "SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = 'awsfederation' AND TABLE_NAME = ? AND INDEX_NAME = ?"
+)
+
+func addColumn(table, column, definition string) schemaChange {
+	return schemaChange{
+		check: columnExists,
+		args:  []interface{}{table, column},
+		stmt:  fmt.Sprintf("ALTER TABLE awsfederation.%s ADD COLUMN %s %s", table, column, definition),
+	}
+}
+
+func addIndex(table, index, columns string) schemaChange {
+	return schemaChange{
+		check: indexExists,
+		args:  []interface{}{table, index},
+		stmt:  fmt.Sprintf("ALTER TABLE awsfederation.%s ADD INDEX %s (%s)", table, index, columns),
+	}
+}
+
+// migrations upgrade the tables of a database created by an earlier release. The tables added by a release are
+// created by DBCreateTables so only the changes to existing tables are needed here. Add a migration, and increment
+// SchemaVersion, for each release that changes an existing table.
+var migrations = []migration{
+	// Recording the schema version, which databases created before the upgrade path do not have.
+	{
+		version: 1,
+		changes: []schemaChange{
+			addColumn("metadata", "schema_version", "INT NULL"),
+			// Authorization expressions are longer than the plain attributes the column was sized for.
+			{stmt: "ALTER TABLE awsfederation.roleMapping MODIFY COLUMN authz_attrib VARCHAR(1024) NOT NULL"},
+		},
+	},
+	// Role mapping validity periods and schedules.
+	{
+		version: 2,
+		changes: []schemaChange{
+			addColumn("roleMapping", "valid_from", "DATETIME NULL"),
+			addColumn("roleMapping", "valid_until", "DATETIME NULL"),
+			addColumn("roleMapping", "schedule", "VARCHAR(1024) NULL"),
+			addIndex("roleMapping", "roleMapping_valid_until_idx", "valid_until ASC"),
+		},
+	},
+	// Versions for the ETags and If-Match checks on accounts and role mappings.
+	{
+		version: 3,
+		changes: []schemaChange{
+			addColumn("account", "version", "BIGINT NOT NULL DEFAULT 1"),
+			addColumn("roleMapping", "version", "BIGINT NOT NULL DEFAULT 1"),
+		},
+	},
+	// Soft delete of accounts and role mappings.
+	{
+		version: 4,
+		changes: []schemaChange{
+			addColumn("account", "deleted", "DATETIME NULL"),
+			addColumn("roleMapping", "deleted", "DATETIME NULL"),
+		},
+	},
+	// Expired role mappings that have been audited, so that each is reported once.
+	{
+		version: 5,
+		changes: []schemaChange{
+			addColumn("roleMapping", "expiry_reported", "DATETIME NULL"),
+		},
+	},
+	// Versions for the ETags and If-Match checks on account classes, types and statuses.
+	{
+		version: 6,
+		changes: []schemaChange{
+			addColumn("accountClass", "version", "BIGINT NOT NULL DEFAULT 1"),
+			addColumn("accountType", "version", "BIGINT NOT NULL DEFAULT 1"),
+			addColumn("accountStatus", "version", "BIGINT NOT NULL DEFAULT 1"),
+		},
+	},
+}
+
+// CurrentSchemaVersion returns the schema version recorded in the metadata table. Databases created before the
+// version was recorded are at version 0.
+func CurrentSchemaVersion(db *sql.DB) (int, error) {
+	var n int
+	if err := db.QueryRow(columnExists, "metadata", "schema_version").Scan(&n); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	var v int
+	err := db.QueryRow("SELECT COALESCE(MAX(schema_version), 0) FROM awsfederation.metadata").Scan(&v)
+	return v, err
+}
+
+// Upgrade creates the tables missing from the database and applies the migrations after the schema version recorded
+// in the metadata table. It returns the schema version the database was at. The connection must allow multiple
+// statements. Recording the new schema version in the metadata table is left to the caller.
+func Upgrade(db *sql.DB) (int, error) {
+	from, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("could not read the schema version: %v", err)
+	}
+	if from > SchemaVersion {
+		return from, fmt.Errorf("database schema version %d is newer than version %d supported by this release", from, SchemaVersion)
+	}
+	if _, err := db.Exec(DBCreateTables); err != nil {
+		return from, fmt.Errorf("could not create the tables: %v", err)
+	}
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		for _, c := range m.changes {
+			if c.check != "" {
+				var n int
+				if err := db.QueryRow(c.check, c.args...).Scan(&n); err != nil {
+					return from, fmt.Errorf("schema migration %d failed: %v", m.version, err)
+				}
+				if n > 0 {
+					continue
+				}
+			}
+			if _, err := db.Exec(c.stmt); err != nil {
+				return from, fmt.Errorf("schema migration %d failed: %v", m.version, err)
+			}
+		}
+	}
+	return from, nil
+}
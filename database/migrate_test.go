@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening mock database: %v", err)
+	}
+	defer db.Close()
+	count := func(n int) *sqlmock.Rows { return sqlmock.NewRows([]string{"count"}).AddRow(n) }
+
+	// The database is at version 3 so only the migrations after version 3 are applied
+	mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs("metadata", "schema_version").WillReturnRows(count(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(schema_version), 0) FROM awsfederation.metadata")).WillReturnRows(count(3))
+	mock.ExpectExec(regexp.QuoteMeta(DBCreateTables)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs("account", "deleted").WillReturnRows(count(0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE awsfederation.account ADD COLUMN deleted DATETIME NULL")).WillReturnResult(sqlmock.NewResult(0, 0))
+	// The column was added before the upgrade was interrupted
+	mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs("roleMapping", "deleted").WillReturnRows(count(1))
+	mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs("roleMapping", "expiry_reported").WillReturnRows(count(0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE awsfederation.roleMapping ADD COLUMN expiry_reported DATETIME NULL")).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"accountClass", "accountType", "accountStatus"} {
+		mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs(table, "version").WillReturnRows(count(0))
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE awsfederation." + table + " ADD COLUMN version BIGINT NOT NULL DEFAULT 1")).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	from, err := Upgrade(db)
+	if err != nil {
+		t.Fatalf("error upgrading: %v", err)
+	}
+	assert.Equal(t, 3, from, "schema version upgraded from not as expected")
+	assert.NoError(t, mock.ExpectationsWereMet(), "upgrade not as expected")
+
+	// A database newer than the release is not changed
+	mock.ExpectQuery(regexp.QuoteMeta(columnExists)).WithArgs("metadata", "schema_version").WillReturnRows(count(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(schema_version), 0) FROM awsfederation.metadata")).WillReturnRows(count(SchemaVersion + 1))
+	_, err = Upgrade(db)
+	assert.Error(t, err, "upgrade of a newer schema should fail")
+	assert.NoError(t, mock.ExpectationsWereMet(), "newer schema should not be changed")
+}
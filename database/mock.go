@@ -24,3 +24,13 @@ func Mock(t *testing.T) (*sql.DB, sqlmock.Sqlmock, map[int]*sqlmock.ExpectedPrep
 	}
 	return db, mock, ep, stmtMap
 }
+
+// ExpectList sets the expectations of listing the table, the count of the rows matching the filters returning the total
+// given followed by the selection of the page returning the rows given. The expectations are returned so that their
+// arguments can be checked.
+func ExpectList(mock sqlmock.Sqlmock, t ListTable, total int, rows *sqlmock.Rows) (count, page *sqlmock.ExpectedQuery) {
+	count = mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM " + t.From)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+	page = mock.ExpectQuery(regexp.QuoteMeta("SELECT " + t.Columns + " FROM " + t.From)).WillReturnRows(rows)
+	return
+}
@@ -0,0 +1,35 @@
+package database
+
+const (
+	StmtKeyRateLimitSelect     = 100
+	QueryRateLimitSelect       = "SELECT bucket_key, tokens, updated, version FROM rateLimitBucket WHERE bucket_key = ?"
+	StmtKeyRateLimitInsert     = 101
+	QueryRateLimitInsert       = "INSERT IGNORE INTO rateLimitBucket (bucket_key, tokens, updated, version) VALUES (?, ?, ?, 1)"
+	StmtKeyRateLimitUpdate     = 102
+	QueryRateLimitUpdate       = "UPDATE rateLimitBucket SET tokens = ?, updated = ?, version = version + 1 WHERE bucket_key = ? AND version = ?"
+	StmtKeyRateLimitSelectList = 103
+	QueryRateLimitSelectList   = "SELECT bucket_key, tokens, updated, version FROM rateLimitBucket ORDER BY bucket_key ASC"
+)
+
+type rateLimit struct{}
+
+func (p *rateLimit) stmts() []Statement {
+	return []Statement{
+		{
+			ID:    StmtKeyRateLimitSelect,
+			Query: QueryRateLimitSelect,
+		},
+		{
+			ID:    StmtKeyRateLimitInsert,
+			Query: QueryRateLimitInsert,
+		},
+		{
+			ID:    StmtKeyRateLimitUpdate,
+			Query: QueryRateLimitUpdate,
+		},
+		{
+			ID:    StmtKeyRateLimitSelectList,
+			Query: QueryRateLimitSelectList,
+		},
+	}
+}
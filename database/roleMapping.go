@@ -1,26 +1,46 @@
 package database
 
 const (
-	StmtKeyRoleMappingSelectList = 70
-	QueryRoleMappingSelectList   = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format FROM roleMapping ORDER BY account_id ASC"
-	StmtKeyRoleMappingSelect     = 71
-	QueryRoleMappingSelect       = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format FROM roleMapping WHERE id = ?"
-	StmtKeyRoleMappingByAuthz    = 72
-	QueryRoleMappingByAuthz      = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format FROM roleMapping WHERE authz_attrib IN (?)"
-	StmtKeyRoleMappingByARN      = 73
-	QueryRoleMappingByARN        = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format FROM roleMapping WHERE role_arn IN (?)"
-	StmtKeyRoleMappingByAcct     = 74
-	QueryRoleMappingByAcct       = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format FROM roleMapping WHERE account_id IN (?)"
-	StmtKeyRoleMappingInsert     = 75
-	QueryRoleMappingInsert       = "INSERT INTO roleMapping (id, account_id, role_arn, authz_attrib, policy, duration, session_name_format) VALUES (?, ?, ?, ?, ?, ?, ?)"
-	StmtKeyRoleMappingDelete     = 76
-	QueryRoleMappingDelete       = "DELETE FROM roleMapping WHERE id = ?"
-	StmtKeyRoleMappingIDExists   = 77
-	QueryRoleMappingIDExists     = "SELECT 1 FROM roleMapping WHERE id = ? LIMIT 1"
-	StmtKeyRoleMappingUpdate     = 78
-	QueryRoleMappingUpdate       = "UPDATE roleMapping SET account_id = ?, role_arn = ?, authz_attrib = ?, policy = ?, duration = ?, session_name_format = ? WHERE id = ?"
+	StmtKeyRoleMappingSelect  = 71
+	QueryRoleMappingSelect    = "SELECT id, account_id, role_arn, authz_attrib, policy, duration, session_name_format, valid_from, valid_until, schedule, version FROM roleMapping WHERE id = ? AND deleted IS NULL"
+	StmtKeyRoleMappingVersion = 72
+	QueryRoleMappingVersion   = "SELECT version FROM roleMapping WHERE id = ? AND deleted IS NULL"
+	StmtKeyRoleMappingRestore = 73
+	// A role mapping is only restored if its account has not been deleted.
+	QueryRoleMappingRestore = "UPDATE roleMapping SET deleted = NULL, version = roleMapping.version + 1 " +
+		"WHERE id = ? AND deleted IS NOT NULL " +
+		"AND EXISTS (SELECT 1 FROM account WHERE account.id = roleMapping.account_id AND account.deleted IS NULL)"
+	StmtKeyRoleMappingInsert   = 75
+	QueryRoleMappingInsert     = "INSERT INTO roleMapping (id, account_id, role_arn, authz_attrib, policy, duration, session_name_format, valid_from, valid_until, schedule) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	StmtKeyRoleMappingDelete   = 76
+	QueryRoleMappingDelete     = "UPDATE roleMapping SET deleted = UTC_TIMESTAMP(), version = version + 1 WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)"
+	StmtKeyRoleMappingIDExists = 77
+	QueryRoleMappingIDExists   = "SELECT 1 FROM roleMapping WHERE id = ? LIMIT 1"
+	StmtKeyRoleMappingUpdate   = 78
+	// An updated role mapping's expiry is reported again if it is still expired.
+	QueryRoleMappingUpdate           = "UPDATE roleMapping SET account_id = ?, role_arn = ?, authz_attrib = ?, policy = ?, duration = ?, session_name_format = ?, valid_from = ?, valid_until = ?, schedule = ?, expiry_reported = NULL, version = version + 1 WHERE id = ? AND deleted IS NULL AND (? = 0 OR version = ?)"
+	StmtKeyRoleMappingExpired        = 79
+	QueryRoleMappingExpired          = "SELECT id, account_id, role_arn, authz_attrib, valid_until, expiry_reported IS NOT NULL FROM roleMapping WHERE valid_until <= ? AND deleted IS NULL"
+	StmtKeyRoleMappingExpiryReported = 80
+	QueryRoleMappingExpiryReported   = "UPDATE roleMapping SET expiry_reported = UTC_TIMESTAMP() WHERE id = ? AND expiry_reported IS NULL"
 )
 
+// ListRoleMapping lists the role mappings, by default in order of their account.
+var ListRoleMapping = ListTable{
+	Columns: "id, account_id, role_arn, authz_attrib, policy, duration, session_name_format, valid_from, valid_until, schedule, version",
+	From:    "roleMapping",
+	Where:   "roleMapping.deleted IS NULL",
+	Fields: map[string]string{
+		"id":         "id",
+		"account":    "account_id",
+		"arn":        "role_arn",
+		"authz":      "authz_attrib",
+		"validfrom":  "valid_from",
+		"validuntil": "valid_until",
+	},
+	Order: []ListOrder{{Field: "account"}, {Field: "id"}},
+}
+
 type roleMapping struct{}
 
 func (p *roleMapping) stmts() []Statement {
@@ -30,20 +50,12 @@ func (p *roleMapping) stmts() []Statement {
 			Query: QueryRoleMappingSelect,
 		},
 		{
-			ID:    StmtKeyRoleMappingSelectList,
-			Query: QueryRoleMappingSelectList,
-		},
-		{
-			ID:    StmtKeyRoleMappingByAuthz,
-			Query: QueryRoleMappingByAuthz,
-		},
-		{
-			ID:    StmtKeyRoleMappingByARN,
-			Query: QueryRoleMappingByARN,
+			ID:    StmtKeyRoleMappingVersion,
+			Query: QueryRoleMappingVersion,
 		},
 		{
-			ID:    StmtKeyRoleMappingByAcct,
-			Query: QueryRoleMappingByAcct,
+			ID:    StmtKeyRoleMappingRestore,
+			Query: QueryRoleMappingRestore,
 		},
 		{
 			ID:    StmtKeyRoleMappingInsert,
@@ -61,5 +73,13 @@ func (p *roleMapping) stmts() []Statement {
 			ID:    StmtKeyRoleMappingUpdate,
 			Query: QueryRoleMappingUpdate,
 		},
+		{
+			ID:    StmtKeyRoleMappingExpired,
+			Query: QueryRoleMappingExpired,
+		},
+		{
+			ID:    StmtKeyRoleMappingExpiryReported,
+			Query: QueryRoleMappingExpiryReported,
+		},
 	}
 }
@@ -31,6 +31,7 @@ ENGINE = InnoDB;
 CREATE TABLE IF NOT EXISTS awsfederation.accountClass (
   id INT NOT NULL AUTO_INCREMENT,
   class VARCHAR(45) NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
   PRIMARY KEY (id),
   UNIQUE INDEX class_UNIQUE (class ASC))
 ENGINE = InnoDB;
@@ -43,6 +44,7 @@ CREATE TABLE IF NOT EXISTS awsfederation.accountType (
   id INT NOT NULL,
   type VARCHAR(45) NOT NULL,
   class_id INT NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
   PRIMARY KEY (id),
   INDEX fk_accountType_accountClass1_idx (class_id ASC),
   CONSTRAINT fk_accountType_accountClass1
@@ -59,6 +61,7 @@ ENGINE = InnoDB;
 CREATE TABLE IF NOT EXISTS awsfederation.accountStatus (
   id INT NOT NULL AUTO_INCREMENT,
   status VARCHAR(45) NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
   PRIMARY KEY (id))
 ENGINE = InnoDB;
 
@@ -73,6 +76,8 @@ CREATE TABLE IF NOT EXISTS awsfederation.account (
   accountType_id INT NOT NULL,
   accountStatus_id INT NOT NULL,
   federationUser_arn VARCHAR(128) NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
+  deleted DATETIME NULL,
   PRIMARY KEY (id),
   INDEX fk_account_accountType1_idx (accountType_id ASC),
   INDEX fk_account_accountStatus1_idx (accountStatus_id ASC),
@@ -102,13 +107,20 @@ CREATE TABLE IF NOT EXISTS awsfederation.roleMapping (
   id VARCHAR(36) NOT NULL,
   account_id VARCHAR(45) NOT NULL,
   role_arn VARCHAR(128) NOT NULL,
-  authz_attrib VARCHAR(128) NOT NULL,
+  authz_attrib VARCHAR(1024) NOT NULL,
   policy VARCHAR(2048) NULL,
   duration INT NULL,
   session_name_format VARCHAR(256) NULL,
+  valid_from DATETIME NULL,
+  valid_until DATETIME NULL,
+  schedule VARCHAR(1024) NULL,
+  version BIGINT NOT NULL DEFAULT 1,
+  deleted DATETIME NULL,
+  expiry_reported DATETIME NULL,
   PRIMARY KEY (id),
   UNIQUE INDEX id_UNIQUE (id ASC),
   INDEX fk_roleMapping_account1_idx (account_id ASC),
+  INDEX roleMapping_valid_until_idx (valid_until ASC),
   CONSTRAINT fk_roleMapping_account1
     FOREIGN KEY (account_id)
     REFERENCES awsfederation.account (id)
@@ -117,6 +129,123 @@ CREATE TABLE IF NOT EXISTS awsfederation.roleMapping (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table awsfederation.accessApprover
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.accessApprover (
+  id VARCHAR(36) NOT NULL,
+  account_id VARCHAR(12) NULL,
+  accountClass_id INT NULL,
+  authz_attrib VARCHAR(1024) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX fk_accessApprover_account1_idx (account_id ASC),
+  INDEX fk_accessApprover_accountClass1_idx (accountClass_id ASC),
+  CONSTRAINT fk_accessApprover_account1
+    FOREIGN KEY (account_id)
+    REFERENCES awsfederation.account (id)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT,
+  CONSTRAINT fk_accessApprover_accountClass1
+    FOREIGN KEY (accountClass_id)
+    REFERENCES awsfederation.accountClass (id)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table awsfederation.accessRequest
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.accessRequest (
+  id VARCHAR(36) NOT NULL,
+  roleMapping_id VARCHAR(36) NOT NULL,
+  username VARCHAR(128) NOT NULL,
+  user_domain VARCHAR(128) NOT NULL,
+  justification VARCHAR(1024) NOT NULL,
+  duration INT NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  requested DATETIME NOT NULL,
+  decided_by VARCHAR(256) NULL,
+  decided DATETIME NULL,
+  comment VARCHAR(1024) NULL,
+  valid_until DATETIME NULL,
+  PRIMARY KEY (id),
+  INDEX accessRequest_user_idx (roleMapping_id ASC, username ASC, user_domain ASC, status ASC),
+  INDEX accessRequest_status_idx (status ASC, requested ASC),
+  CONSTRAINT fk_accessRequest_roleMapping1
+    FOREIGN KEY (roleMapping_id)
+    REFERENCES awsfederation.roleMapping (id)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table awsfederation.rateLimitBucket
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.rateLimitBucket (
+  bucket_key VARCHAR(255) NOT NULL,
+  tokens DOUBLE NOT NULL,
+  updated DATETIME(6) NOT NULL,
+  version BIGINT NOT NULL,
+  PRIMARY KEY (bucket_key))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table awsfederation.auditEvent
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.auditEvent (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  uuid VARCHAR(36) NOT NULL,
+  time DATETIME(6) NOT NULL,
+  event_type VARCHAR(64) NOT NULL,
+  username VARCHAR(128) NOT NULL,
+  user_domain VARCHAR(128) NOT NULL,
+  user_session_id VARCHAR(36) NOT NULL,
+  severity VARCHAR(16) NOT NULL,
+  outcome VARCHAR(16) NOT NULL,
+  roleMapping_id VARCHAR(36) NOT NULL,
+  account_id VARCHAR(12) NOT NULL,
+  federation_user VARCHAR(128) NOT NULL,
+  detail TEXT NULL,
+  PRIMARY KEY (id),
+  UNIQUE INDEX uuid_UNIQUE (uuid ASC),
+  INDEX auditEvent_time_idx (time ASC),
+  INDEX auditEvent_user_idx (username ASC, user_domain ASC),
+  INDEX auditEvent_roleMapping_idx (roleMapping_id ASC),
+  INDEX auditEvent_account_idx (account_id ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table awsfederation.history
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.history (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  resource VARCHAR(32) NOT NULL,
+  resource_id VARCHAR(128) NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  actor VARCHAR(256) NOT NULL,
+  time DATETIME(6) NOT NULL,
+  before_value TEXT NULL,
+  after_value TEXT NULL,
+  PRIMARY KEY (id),
+  INDEX history_resource_idx (resource ASC, resource_id ASC))
+ENGINE = InnoDB;
+
+-- -----------------------------------------------------
+-- Table awsfederation.catalogueLock
+-- The row is locked by catalogue imports so that the servers sharing the database import one at a time.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS awsfederation.catalogueLock (
+  id INT NOT NULL,
+  PRIMARY KEY (id))
+ENGINE = InnoDB;
+
+INSERT IGNORE INTO awsfederation.catalogueLock (id) VALUES (1);
+
+
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
\ No newline at end of file
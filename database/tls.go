@@ -0,0 +1,99 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jcmturner/awsfederation/config"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSConfigName is the name the database TLS configuration is registered with in the MySQL driver.
+const TLSConfigName = "awsfederation"
+
+// TLSConfig returns the TLS configuration for connections to the database.
+func TLSConfig(t config.DatabaseTLS) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: t.ServerName,
+	}
+	if t.CAFile != "" {
+		b, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read database CA file: %v", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("no PEM encoded certificates found in database CA file")
+		}
+	}
+	if t.CertificateFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertificateFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load database client certificate: %v", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	switch strings.ToLower(t.VerifyMode) {
+	case strings.ToLower(config.DBTLSVerifyFull), "":
+	case strings.ToLower(config.DBTLSVerifyCA):
+		// The standard verification also checks the server name so is replaced with one that only checks the chain.
+		tc.InsecureSkipVerify = true
+		tc.VerifyPeerCertificate = verifyChain(tc.RootCAs)
+	case strings.ToLower(config.DBTLSSkipVerify):
+		tc.InsecureSkipVerify = true
+	default:
+		return nil, fmt.Errorf("invalid database TLS verify mode %s", t.VerifyMode)
+	}
+	return tc, nil
+}
+
+// verifyChain returns a function that checks the server's certificate is issued by one of the trusted CAs without
+// checking the server name.
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) < 1 {
+			return errors.New("database server presented no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, b := range rawCerts {
+			c, err := x509.ParseCertificate(b)
+			if err != nil {
+				return fmt.Errorf("could not parse database server certificate: %v", err)
+			}
+			certs[i] = c
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// DSN returns the connection string with TLS applied according to the configuration. The TLS configuration is
+// registered with the MySQL driver and the connection string modified to use it.
+func DSN(dsn string, t config.DatabaseTLS) (string, error) {
+	if !t.Enabled {
+		return dsn, nil
+	}
+	tc, err := TLSConfig(t)
+	if err != nil {
+		return "", err
+	}
+	if err := mysql.RegisterTLSConfig(TLSConfigName, tc); err != nil {
+		return "", fmt.Errorf("could not register database TLS configuration: %v", err)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid database connection string: %v", err)
+	}
+	cfg.TLSConfig = TLSConfigName
+	return cfg.FormatDSN(), nil
+}
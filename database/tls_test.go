@@ -0,0 +1,101 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDSN = "user:pass@tcp(db.example.com:3306)/awsfederation?parseTime=true"
+
+// testCA returns a self signed CA certificate and a server certificate it issued for the name given.
+func testCA(t *testing.T, name string) (*x509.Certificate, []byte, []byte) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+	srv := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	srvDER, err := x509.CreateCertificate(rand.Reader, srv, ca, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), srvDER
+}
+
+func TestDSN(t *testing.T) {
+	dsn, err := DSN(testDSN, config.DatabaseTLS{})
+	if err != nil {
+		t.Fatalf("Error with TLS disabled: %v", err)
+	}
+	assert.Equal(t, testDSN, dsn, "Connection string changed with TLS disabled")
+
+	dsn, err = DSN(testDSN, config.DatabaseTLS{Enabled: true, VerifyMode: config.DBTLSVerifyFull})
+	if err != nil {
+		t.Fatalf("Error with TLS enabled: %v", err)
+	}
+	assert.True(t, strings.Contains(dsn, "tls="+TLSConfigName), "TLS configuration not in connection string: %s", dsn)
+	assert.True(t, strings.HasPrefix(dsn, "user:pass@tcp(db.example.com:3306)/awsfederation?"), "Connection string not as expected: %s", dsn)
+
+	_, err = DSN(testDSN, config.DatabaseTLS{Enabled: true, CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err, "Expected error with missing CA file")
+	_, err = DSN(testDSN, config.DatabaseTLS{Enabled: true, VerifyMode: "Sometimes"})
+	assert.Error(t, err, "Expected error with invalid verify mode")
+}
+
+func TestTLSConfig_VerifyCA(t *testing.T) {
+	_, caPEM, srvDER := testCA(t, "db.example.com")
+	f, _ := ioutil.TempFile(os.TempDir(), "ca")
+	defer os.Remove(f.Name())
+	f.Write(caPEM)
+	f.Close()
+
+	tc, err := TLSConfig(config.DatabaseTLS{Enabled: true, CAFile: f.Name(), VerifyMode: config.DBTLSVerifyCA})
+	if err != nil {
+		t.Fatalf("Error creating TLS configuration: %v", err)
+	}
+	assert.True(t, tc.InsecureSkipVerify, "Standard verification not replaced")
+	assert.NoError(t, tc.VerifyPeerCertificate([][]byte{srvDER}, nil), "Certificate issued by trusted CA not accepted")
+
+	_, _, otherDER := testCA(t, "db.example.com")
+	assert.Error(t, tc.VerifyPeerCertificate([][]byte{otherDER}, nil), "Certificate issued by untrusted CA accepted")
+
+	tc, err = TLSConfig(config.DatabaseTLS{Enabled: true, CAFile: f.Name(), ServerName: "db.example.com", VerifyMode: config.DBTLSVerifyFull})
+	if err != nil {
+		t.Fatalf("Error creating TLS configuration: %v", err)
+	}
+	assert.False(t, tc.InsecureSkipVerify, "Verification skipped for VerifyFull")
+	assert.Equal(t, "db.example.com", tc.ServerName, "Server name not as expected")
+	assert.NotNil(t, tc.RootCAs, "Trusted CAs not loaded")
+}
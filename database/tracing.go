@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jcmturner/awsfederation/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"sync"
+)
+
+// prepared holds the statement each prepared statement was created from, so that its executions can be identified in
+// the spans.
+var (
+	preparedMux sync.RWMutex
+	prepared    = make(map[*sql.Stmt]Statement)
+)
+
+func register(s *sql.Stmt, stmt Statement) {
+	preparedMux.Lock()
+	defer preparedMux.Unlock()
+	prepared[s] = stmt
+}
+
+// Close closes the prepared statements of the map.
+func (m StmtMap) Close() {
+	preparedMux.Lock()
+	defer preparedMux.Unlock()
+	for _, s := range m {
+		delete(prepared, s)
+		s.Close()
+	}
+}
+
+// startSpan starts the span of an execution of the prepared statement. The span is named after the SQL operation.
+func startSpan(ctx context.Context, s *sql.Stmt) (context.Context, trace.Span) {
+	preparedMux.RLock()
+	p, ok := prepared[s]
+	preparedMux.RUnlock()
+	if !ok {
+		return querySpan(ctx, "")
+	}
+	return querySpan(ctx, p.Query, attribute.Int("db.statement.id", p.ID))
+}
+
+// querySpan starts the span of an execution of the query.
+func querySpan(ctx context.Context, query string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	op := "SQL"
+	if f := strings.Fields(query); len(f) > 0 {
+		op = strings.ToUpper(f[0])
+	}
+	attrs = append(attrs, attribute.String("db.system", "mysql"))
+	if query != "" {
+		attrs = append(attrs, attribute.String("db.statement", query))
+	}
+	return tracing.Start(ctx, "db."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// Exec executes the prepared statement, recording the execution in a span that is a child of the span in the context.
+func Exec(ctx context.Context, s *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	ctx, span := startSpan(ctx, s)
+	res, err := s.ExecContext(ctx, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+// Query executes the prepared query, recording the execution in a span that is a child of the span in the context.
+// The span does not include reading the rows.
+func Query(ctx context.Context, s *sql.Stmt, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startSpan(ctx, s)
+	rows, err := s.QueryContext(ctx, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// QueryRow executes the prepared query, recording the execution in a span that is a child of the span in the context.
+// No rows being found is not recorded as an error.
+func QueryRow(ctx context.Context, s *sql.Stmt, args ...interface{}) *sql.Row {
+	ctx, span := startSpan(ctx, s)
+	row := s.QueryRowContext(ctx, args...)
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	tracing.End(span, err)
+	return row
+}
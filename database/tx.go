@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jcmturner/awsfederation/tracing"
+)
+
+// Begin starts a transaction on the connection. The prepared statements used within it must have been prepared on the
+// same connection.
+func Begin(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (*sql.Tx, error) {
+	if db == nil {
+		return nil, errNoConnection
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+// TxExec executes the prepared statement within the transaction, recording the execution in a span that is a child of
+// the span in the context.
+func TxExec(ctx context.Context, tx *sql.Tx, s *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	ctx, span := startSpan(ctx, s)
+	res, err := tx.StmtContext(ctx, s).ExecContext(ctx, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+// TxQuery executes the prepared query within the transaction, recording the execution in a span that is a child of
+// the span in the context. The span does not include reading the rows.
+func TxQuery(ctx context.Context, tx *sql.Tx, s *sql.Stmt, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startSpan(ctx, s)
+	rows, err := tx.StmtContext(ctx, s).QueryContext(ctx, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// TxQueryRow executes the prepared query within the transaction, recording the execution in a span that is a child of
+// the span in the context. No rows being found is not recorded as an error.
+func TxQueryRow(ctx context.Context, tx *sql.Tx, s *sql.Stmt, args ...interface{}) *sql.Row {
+	ctx, span := startSpan(ctx, s)
+	row := tx.StmtContext(ctx, s).QueryRowContext(ctx, args...)
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	tracing.End(span, err)
+	return row
+}
@@ -1,6 +1,7 @@
 package federationuser
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +9,11 @@ import (
 	"github.com/jcmturner/awsfederation/awscredential"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
-	"github.com/jcmturner/awsvaultcredsprovider"
+	"github.com/jcmturner/awsfederation/metrics"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"github.com/jcmturner/awsfederation/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"time"
 )
@@ -18,14 +23,14 @@ const (
 )
 
 type FederationUser struct {
-	Name            string                                    `json:"Name"`
-	ARNString       string                                    `json:"Arn"`
-	Credentials     awscredential.Credentials                 `json:"Credentials"`
-	TTL             int64                                     `json:"TTL"`
-	MFASerialNumber string                                    `json:"MFASerialNumber"`
-	MFASecret       string                                    `json:"MFASecret"`
-	ARN             awsarn.ARN                                `json:"-"`
-	Provider        *awsvaultcredsprovider.VaultCredsProvider `json:"-"`
+	Name            string                    `json:"Name"`
+	ARNString       string                    `json:"Arn"`
+	Credentials     awscredential.Credentials `json:"Credentials"`
+	TTL             int64                     `json:"TTL"`
+	MFASerialNumber string                    `json:"MFASerialNumber"`
+	MFASecret       string                    `json:"MFASecret"`
+	ARN             awsarn.ARN                `json:"-"`
+	Provider        *Provider                 `json:"-"`
 }
 
 type FederationUserList struct {
@@ -38,11 +43,12 @@ func NewFederationUser(c *config.Config, arn string) (u FederationUser, err erro
 		return
 	}
 	u.ARNString = arn
-	u.Provider, err = awsvaultcredsprovider.NewVaultCredsProvider(arn, *c.Vault.Config, *c.Vault.Credentials)
+	s, err := secretstore.ForConfig(c)
 	if err != nil {
 		err = fmt.Errorf("error creating credentials provider: %v", err)
 		return
 	}
+	u.Provider = NewProvider(s, arn)
 	return
 }
 
@@ -58,10 +64,11 @@ func FederationUserFromReader(c *config.Config, r io.Reader) (u FederationUser,
 		err = fmt.Errorf("invalid ARN: %v", err)
 		return
 	}
-	u.Provider, err = awsvaultcredsprovider.NewVaultCredsProvider(u.ARNString, *c.Vault.Config, *c.Vault.Credentials)
+	s, err := secretstore.ForConfig(c)
 	if err != nil {
 		return FederationUser{}, fmt.Errorf("Error creating credentials provider: %v", err)
 	}
+	u.Provider = NewProvider(s, u.ARNString)
 	u.Provider.SetAccessKey(u.Credentials.AccessKeyID).
 		SetSecretAccessKey(u.Credentials.SecretAccessKey).
 		SetSessionToken(u.Credentials.SessionToken).
@@ -72,12 +79,12 @@ func FederationUserFromReader(c *config.Config, r io.Reader) (u FederationUser,
 	return u, nil
 }
 
-func LoadFederationUser(c *config.Config, arn string) (FederationUser, error) {
+func LoadFederationUser(ctx context.Context, c *config.Config, arn string) (FederationUser, error) {
 	u, err := NewFederationUser(c, arn)
 	if err != nil {
 		return u, err
 	}
-	err = u.Load()
+	err = u.Load(ctx)
 	return u, err
 }
 
@@ -108,19 +115,29 @@ func (u *FederationUser) SetName(name string) *FederationUser {
 	return u
 }
 
-func (u *FederationUser) Store(stmtMap database.StmtMap) error {
+// secretSpan starts the span of an operation on the federation user's credentials in the secret store.
+func (u *FederationUser) secretSpan(ctx context.Context, op string) trace.Span {
+	_, span := tracing.Start(ctx, "secretstore."+op, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(attribute.String("federation_user", u.ARNString))
+	return span
+}
+
+func (u *FederationUser) Store(ctx context.Context, stmtMap database.StmtMap) error {
 	if u.Provider == nil {
 		return errors.New("Provider not defined, cannot store credentials")
 	}
 	if u.Provider.Credential.AccessKeyId == "" {
 		return errors.New("User does not have credentials defined to be stored")
 	}
+	span := u.secretSpan(ctx, "write")
 	err := u.Provider.Store()
+	tracing.End(span, err)
 	if err != nil {
+		metrics.VaultErrors.Inc()
 		return err
 	}
 	if stmt, ok := stmtMap[database.StmtKeyFedUserInsert]; ok {
-		_, err := stmt.Exec(u.ARNString, u.Name, u.TTL)
+		_, err := database.Exec(ctx, stmt, u.ARNString, u.Name, u.TTL)
 		if err != nil {
 			return err
 		}
@@ -129,11 +146,17 @@ func (u *FederationUser) Store(stmtMap database.StmtMap) error {
 	return errors.New("Prepared statement for DB authorization check not found")
 }
 
-func (u *FederationUser) Load() error {
+func (u *FederationUser) Load(ctx context.Context) error {
 	if u.Provider == nil {
 		return errors.New("Provider not defined, cannot load credentials")
 	}
-	if err := u.Provider.Read(); err != nil {
+	span := u.secretSpan(ctx, "read")
+	err := u.Provider.Read()
+	tracing.End(span, err)
+	if err != nil {
+		if _, notFound := err.(secretstore.ErrSecretNotFound); !notFound {
+			metrics.VaultErrors.Inc()
+		}
 		return err
 	}
 	u.Name = u.Provider.Name
@@ -149,14 +172,14 @@ func (u *FederationUser) Load() error {
 	return nil
 }
 
-func (u *FederationUser) Delete(stmtMap database.StmtMap) error {
+func (u *FederationUser) Delete(ctx context.Context, stmtMap database.StmtMap) error {
 	if u.Provider == nil {
 		return errors.New("Provider not defined, cannot delete credentials")
 	}
 	if stmt, ok := stmtMap[database.StmtKeyFedUserDelete]; !ok {
 		return errors.New("Prepared statement for DB authorization check not found")
 	} else {
-		r, err := stmt.Exec(u.ARNString)
+		r, err := database.Exec(ctx, stmt, u.ARNString)
 		if err != nil {
 			return err
 		}
@@ -164,7 +187,14 @@ func (u *FederationUser) Delete(stmtMap database.StmtMap) error {
 			return fmt.Errorf("Expected 1 and only 1 row to be affected. Number affected was: %v", i)
 		}
 	}
-	return u.Provider.Delete()
+	span := u.secretSpan(ctx, "delete")
+	err := u.Provider.Delete()
+	tracing.End(span, err)
+	if err != nil {
+		metrics.VaultErrors.Inc()
+		return err
+	}
+	return nil
 }
 
 type FedUserCache map[string]*FederationUser
@@ -1,6 +1,7 @@
 package federationuser
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/test"
@@ -57,12 +58,12 @@ func TestFederationUser_StoreLoadDelete(t *testing.T) {
 
 	// Set the expected database call
 	ep[database.StmtKeyFedUserInsert].ExpectExec().WithArgs(testFedUserARN1, testFedUserName1, int64(testFedUserTTL1)).WillReturnResult(sqlmock.NewResult(0, 1))
-	err = fu.Store(*stmtMap)
+	err = fu.Store(context.Background(), *stmtMap)
 	if err != nil {
 		t.Fatalf("Error storing Federation user: %v", err)
 	}
 
-	m, err := fu.Provider.VaultClient.Read(testFedUserARN1)
+	m, err := fu.Provider.SecretStore.Read(testFedUserARN1)
 	assert.Equal(t, testFedUserName1, m["Name"].(string), "Stored name not as expected")
 	assert.Equal(t, testFedUserAccessKeyId1, m["AccessKeyID"].(string), "Stored AccessKeyID not as expected")
 	assert.Equal(t, testFedUserSecretAccessKey1, m["SecretAccessKey"].(string), "Stored SecretAccessKey not as expected")
@@ -77,7 +78,7 @@ func TestFederationUser_StoreLoadDelete(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating FederationUser for loading into: %v", err)
 	}
-	err = fuLoad.Load()
+	err = fuLoad.Load(context.Background())
 	if err != nil {
 		t.Fatalf("Error loading federation user: %v", err)
 	}
@@ -95,13 +96,13 @@ func TestFederationUser_StoreLoadDelete(t *testing.T) {
 	//Store the same again
 	// Set the expected database call
 	ep[database.StmtKeyFedUserInsert].ExpectExec().WithArgs(testFedUserARN1, testFedUserName1, int64(testFedUserTTL1)).WillReturnResult(sqlmock.NewResult(0, 0))
-	err = fu.Store(*stmtMap)
+	err = fu.Store(context.Background(), *stmtMap)
 	if err != nil {
 		t.Fatalf("Error storing Federation user 2nd time: %v", err)
 	}
 
 	ep[database.StmtKeyFedUserDelete].ExpectExec().WithArgs(testFedUserARN1).WillReturnResult(sqlmock.NewResult(0, 1))
-	err = fuLoad.Delete(*stmtMap)
+	err = fuLoad.Delete(context.Background(), *stmtMap)
 	if err != nil {
 		t.Fatalf("Error deleting federation user: %v", err)
 	}
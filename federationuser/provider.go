@@ -0,0 +1,182 @@
+package federationuser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/jcmturner/awsfederation/secretstore"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ProviderName = "SecretStoreProvider"
+
+// Provider holds a federation user's AWS credentials in the secret store at the path of the user's ARN.
+// It implements the AWS SDK credentials.Provider interface.
+type Provider struct {
+	SecretStore secretstore.Store
+	Arn         string
+	Name        string
+	Credential  Credential
+	mux         sync.Mutex
+	retrieved   time.Time
+}
+
+// Credential is the AWS credential of a federation user.
+type Credential struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+	TTL             int64
+	MFASerialNumber string
+	MFASecret       string
+}
+
+func NewProvider(s secretstore.Store, arn string) *Provider {
+	return &Provider{
+		SecretStore: s,
+		Arn:         arn,
+	}
+}
+
+func (p *Provider) SetAccessKey(s string) *Provider {
+	p.Credential.AccessKeyId = s
+	return p
+}
+
+func (p *Provider) SetSecretAccessKey(s string) *Provider {
+	p.Credential.SecretAccessKey = s
+	return p
+}
+
+func (p *Provider) SetSessionToken(s string) *Provider {
+	p.Credential.SessionToken = s
+	return p
+}
+
+func (p *Provider) SetExpiration(t time.Time) *Provider {
+	p.Credential.Expiration = t
+	return p
+}
+
+func (p *Provider) SetTTL(ttl int64) *Provider {
+	p.Credential.TTL = ttl
+	return p
+}
+
+func (p *Provider) WithMFA(serialNumber, secret string) *Provider {
+	p.Credential.MFASerialNumber = serialNumber
+	p.Credential.MFASecret = secret
+	return p
+}
+
+// Store writes the credential to the secret store.
+func (p *Provider) Store() error {
+	m := map[string]interface{}{
+		"Name":            p.Name,
+		"AccessKeyID":     p.Credential.AccessKeyId,
+		"SecretAccessKey": p.Credential.SecretAccessKey,
+		"SessionToken":    p.Credential.SessionToken,
+		"TTL":             p.Credential.TTL,
+		"MFASerialNumber": p.Credential.MFASerialNumber,
+		"MFASecret":       p.Credential.MFASecret,
+	}
+	if !p.Credential.Expiration.IsZero() {
+		m["Expiration"] = p.Credential.Expiration.Format(time.RFC3339)
+	}
+	return p.SecretStore.Write(p.Arn, m)
+}
+
+// Read loads the credential from the secret store.
+func (p *Provider) Read() error {
+	m, err := p.SecretStore.Read(p.Arn)
+	if err != nil {
+		return err
+	}
+	var c Credential
+	c.AccessKeyId = stringValue(m, "AccessKeyID")
+	c.SecretAccessKey = stringValue(m, "SecretAccessKey")
+	c.SessionToken = stringValue(m, "SessionToken")
+	c.MFASerialNumber = stringValue(m, "MFASerialNumber")
+	c.MFASecret = stringValue(m, "MFASecret")
+	if e := stringValue(m, "Expiration"); e != "" {
+		c.Expiration, err = time.Parse(time.RFC3339, e)
+		if err != nil {
+			return fmt.Errorf("expiration of stored credential not valid: %v", err)
+		}
+	}
+	if ttl, ok := m["TTL"]; ok {
+		c.TTL, err = int64Value(ttl)
+		if err != nil {
+			return fmt.Errorf("TTL of stored credential not valid: %v", err)
+		}
+	}
+	p.Name = stringValue(m, "Name")
+	p.Credential = c
+	return nil
+}
+
+// Delete removes the credential from the secret store.
+func (p *Provider) Delete() error {
+	return p.SecretStore.Delete(p.Arn)
+}
+
+// Retrieve returns the credential, reading it from the secret store if it has not been read within its TTL.
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.Credential.AccessKeyId == "" || p.stale() {
+		if err := p.Read(); err != nil {
+			return credentials.Value{ProviderName: ProviderName}, err
+		}
+		p.retrieved = time.Now().UTC()
+	}
+	if p.Credential.AccessKeyId == "" || p.Credential.SecretAccessKey == "" {
+		return credentials.Value{ProviderName: ProviderName}, errors.New("federation user has no credential")
+	}
+	return credentials.Value{
+		AccessKeyID:     p.Credential.AccessKeyId,
+		SecretAccessKey: p.Credential.SecretAccessKey,
+		SessionToken:    p.Credential.SessionToken,
+		ProviderName:    ProviderName,
+	}, nil
+}
+
+// IsExpired indicates if the credential should be retrieved again.
+func (p *Provider) IsExpired() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.stale()
+}
+
+func (p *Provider) stale() bool {
+	now := time.Now().UTC()
+	if !p.Credential.Expiration.IsZero() && now.After(p.Credential.Expiration) {
+		return true
+	}
+	return p.Credential.TTL > 0 && now.After(p.retrieved.Add(time.Duration(p.Credential.TTL)*time.Second))
+}
+
+func stringValue(m map[string]interface{}, k string) string {
+	s, _ := m[k].(string)
+	return s
+}
+
+func int64Value(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case json.Number:
+		return t.Int64()
+	case int64:
+		return t, nil
+	case int:
+		return int64(t), nil
+	case float64:
+		return int64(t), nil
+	case string:
+		return strconv.ParseInt(t, 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
@@ -0,0 +1,142 @@
+// Package health runs the dependency checks reported by the readiness endpoint.
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK     = "OK"
+	StatusFailed = "Failed"
+
+	DefaultTimeout = 5 * time.Second
+	DefaultMaxAge  = 5 * time.Second
+)
+
+// Check is a named dependency check. The function returns an error if the dependency is not usable.
+type Check struct {
+	Name string
+	Func func() error
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name    string  `json:"Name"`
+	Status  string  `json:"Status"`
+	Latency float64 `json:"LatencyMs,omitempty"`
+	Error   string  `json:"Error,omitempty"`
+}
+
+// Report is the outcome of all the checks. The Status is only OK if all checks are OK.
+type Report struct {
+	Status string   `json:"Status"`
+	Checks []Result `json:"Checks"`
+}
+
+// Summary returns a copy of the report with only the name and status of each check, for callers that should not see
+// the details of the server's dependencies.
+func (r Report) Summary() Report {
+	s := Report{
+		Status: r.Status,
+		Checks: make([]Result, len(r.Checks)),
+	}
+	for i, chk := range r.Checks {
+		s.Checks[i] = Result{Name: chk.Name, Status: chk.Status}
+	}
+	return s
+}
+
+// Checker holds the checks for the server's dependencies. A nil Checker has no checks and is always OK.
+//
+// The report is reused for MaxAge after the checks are run so that frequent probes do not load the dependencies.
+type Checker struct {
+	Checks  []Check
+	Timeout time.Duration
+	MaxAge  time.Duration
+
+	mux  sync.Mutex
+	last Report
+	ran  time.Time
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		Timeout: DefaultTimeout,
+		MaxAge:  DefaultMaxAge,
+	}
+}
+
+// Add adds a check.
+func (h *Checker) Add(name string, f func() error) *Checker {
+	h.Checks = append(h.Checks, Check{Name: name, Func: f})
+	return h
+}
+
+// Run runs all the checks concurrently, or returns the last report if it is less than MaxAge old. A check that does not
+// complete within the timeout is reported as failed.
+func (h *Checker) Run() Report {
+	rep := Report{
+		Status: StatusOK,
+		Checks: []Result{},
+	}
+	if h == nil {
+		return rep
+	}
+	// Concurrent callers wait for the checks in progress rather than running their own.
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if !h.ran.IsZero() && time.Since(h.ran) < h.MaxAge {
+		return h.last.copy()
+	}
+	rep.Checks = make([]Result, len(h.Checks))
+	var wg sync.WaitGroup
+	wg.Add(len(h.Checks))
+	for i, chk := range h.Checks {
+		go func(i int, chk Check) {
+			defer wg.Done()
+			rep.Checks[i] = run(chk, h.Timeout)
+		}(i, chk)
+	}
+	wg.Wait()
+	for _, r := range rep.Checks {
+		if r.Status != StatusOK {
+			rep.Status = StatusFailed
+		}
+	}
+	h.last = rep
+	h.ran = time.Now()
+	return rep.copy()
+}
+
+func (r Report) copy() Report {
+	c := r
+	c.Checks = append([]Result{}, r.Checks...)
+	return c
+}
+
+func run(chk Check, timeout time.Duration) Result {
+	r := Result{
+		Name:   chk.Name,
+		Status: StatusOK,
+	}
+	start := time.Now()
+	// Buffered so that a check that times out does not block forever once it completes.
+	done := make(chan error, 1)
+	go func() {
+		done <- chk.Func()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("check did not complete within %v", timeout)
+	}
+	r.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChecker_Run(t *testing.T) {
+	hc := NewChecker()
+	hc.MaxAge = 0
+	hc.Add("ok", func() error { return nil })
+	rep := hc.Run()
+	assert.Equal(t, StatusOK, rep.Status, "report status not as expected")
+	assert.Equal(t, 1, len(rep.Checks), "number of check results not as expected")
+	assert.Equal(t, "ok", rep.Checks[0].Name, "check name not as expected")
+	assert.Equal(t, StatusOK, rep.Checks[0].Status, "check status not as expected")
+	assert.Equal(t, "", rep.Checks[0].Error, "check error should be empty")
+
+	hc.Add("broken", func() error { return errors.New("dependency unavailable") })
+	rep = hc.Run()
+	assert.Equal(t, StatusFailed, rep.Status, "report status should be failed if any check fails")
+	assert.Equal(t, StatusOK, rep.Checks[0].Status, "results should be in the order the checks were added")
+	assert.Equal(t, StatusFailed, rep.Checks[1].Status, "check status not as expected")
+	assert.Equal(t, "dependency unavailable", rep.Checks[1].Error, "check error not as expected")
+}
+
+func TestChecker_RunTimeout(t *testing.T) {
+	hc := NewChecker()
+	hc.Timeout = 10 * time.Millisecond
+	hc.Add("slow", func() error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	rep := hc.Run()
+	assert.Equal(t, StatusFailed, rep.Status, "report status should be failed if a check times out")
+	assert.True(t, rep.Checks[0].Latency < 1000, "check should not wait for a slow dependency")
+}
+
+func TestChecker_RunCached(t *testing.T) {
+	hc := NewChecker()
+	var runs int
+	hc.Add("counted", func() error {
+		runs++
+		return errors.New("dependency unavailable")
+	})
+	rep := hc.Run()
+	rep.Checks[0].Status = StatusOK
+	rep = hc.Run()
+	assert.Equal(t, 1, runs, "checks should not be run again within the maximum age of the report")
+	assert.Equal(t, StatusFailed, rep.Checks[0].Status, "cached report should not be changed by callers")
+
+	hc.MaxAge = 0
+	hc.Run()
+	assert.Equal(t, 2, runs, "checks should be run again once the report is older than the maximum age")
+
+	s := rep.Summary()
+	assert.Equal(t, StatusFailed, s.Status, "summary status not as expected")
+	assert.Equal(t, "counted", s.Checks[0].Name, "summary check name not as expected")
+	assert.Equal(t, "", s.Checks[0].Error, "summary should not include check errors")
+}
+
+func TestChecker_RunNil(t *testing.T) {
+	var hc *Checker
+	rep := hc.Run()
+	assert.Equal(t, StatusOK, rep.Status, "a nil checker should report OK")
+	assert.Equal(t, 0, len(rep.Checks), "a nil checker should have no check results")
+}
@@ -0,0 +1,129 @@
+// Package history records the changes made to the catalogue entities so that it can be seen who changed an entity,
+// when, and what it was before and after. Each entry holds the whole entity before and after the change in the form of
+// the catalogue document.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/awsfederation/database"
+	"time"
+)
+
+const (
+	ActionCreate  = "Create"
+	ActionUpdate  = "Update"
+	ActionDelete  = "Delete"
+	ActionRestore = "Restore"
+
+	// The resources are named as in the paths of the API.
+	ResourceAccountClass  = "accountclass"
+	ResourceAccountType   = "accounttype"
+	ResourceAccountStatus = "accountstatus"
+	ResourceAccount       = "account"
+	ResourceRoleMapping   = "rolemapping"
+
+	// SystemActor is the actor of the changes the service makes itself, such as those of the catalogue sync.
+	SystemActor = "awsfederation"
+)
+
+var errStmtNotFound = errors.New("prepared statement for history not found")
+
+// Entry is a change of an entity. Before is absent when the entity is created and After when it is deleted.
+type Entry struct {
+	ID         int64           `json:"ID"`
+	Resource   string          `json:"Resource"`
+	ResourceID string          `json:"ResourceID"`
+	Action     string          `json:"Action"`
+	Actor      string          `json:"Actor"`
+	Time       time.Time       `json:"Time"`
+	Before     json.RawMessage `json:"Before,omitempty"`
+	After      json.RawMessage `json:"After,omitempty"`
+}
+
+// New returns the entry of a change made now. A nil before or after value is left absent.
+func New(resource, id, action, actor string, before, after interface{}) (e Entry, err error) {
+	e = Entry{
+		Resource:   resource,
+		ResourceID: id,
+		Action:     action,
+		Actor:      actor,
+		Time:       time.Now().UTC(),
+	}
+	if e.Before, err = marshal(before); err != nil {
+		return
+	}
+	e.After, err = marshal(after)
+	return
+}
+
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	return json.RawMessage(b), err
+}
+
+// args returns the arguments of the statement inserting the entry.
+func (e Entry) args() []interface{} {
+	return []interface{}{e.Resource, e.ResourceID, e.Action, e.Actor, e.Time, value(e.Before), value(e.After)}
+}
+
+func value(v json.RawMessage) interface{} {
+	if v == nil {
+		return nil
+	}
+	return string(v)
+}
+
+// Record stores the entry.
+func Record(ctx context.Context, stmtMap database.StmtMap, e Entry) error {
+	stmt, ok := stmtMap[database.StmtKeyHistoryInsert]
+	if !ok {
+		return errStmtNotFound
+	}
+	_, err := database.Exec(ctx, stmt, e.args()...)
+	return err
+}
+
+// RecordTx stores the entry within the transaction, so that it is only kept if the change it records is committed.
+func RecordTx(ctx context.Context, tx *sql.Tx, stmtMap database.StmtMap, e Entry) error {
+	stmt, ok := stmtMap[database.StmtKeyHistoryInsert]
+	if !ok {
+		return errStmtNotFound
+	}
+	_, err := database.TxExec(ctx, tx, stmt, e.args()...)
+	return err
+}
+
+// List returns the history of the entity, oldest change first.
+func List(ctx context.Context, stmtMap database.StmtMap, resource, id string) ([]Entry, error) {
+	stmt, ok := stmtMap[database.StmtKeyHistorySelect]
+	if !ok {
+		return nil, errStmtNotFound
+	}
+	rows, err := database.Query(ctx, stmt, resource, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	es := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.Resource, &e.ResourceID, &e.Action, &e.Actor, &e.Time, &before, &after); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
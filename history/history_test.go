@@ -0,0 +1,70 @@
+package history
+
+import (
+	"context"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+const testAccountID = "012345678912"
+
+var entryColumns = []string{"id", "resource", "resource_id", "action", "actor", "time", "before_value", "after_value"}
+
+type testAccount struct {
+	ID   string
+	Name string
+}
+
+func TestNew(t *testing.T) {
+	e, err := New(ResourceAccount, testAccountID, ActionUpdate, "testuser@TESTING", testAccount{testAccountID, "One"}, testAccount{testAccountID, "Two"})
+	if err != nil {
+		t.Fatalf("error creating history entry: %v", err)
+	}
+	assert.Equal(t, `{"ID":"012345678912","Name":"One"}`, string(e.Before), "before value not as expected")
+	assert.Equal(t, `{"ID":"012345678912","Name":"Two"}`, string(e.After), "after value not as expected")
+	assert.WithinDuration(t, time.Now().UTC(), e.Time, time.Minute, "time not as expected")
+
+	e, err = New(ResourceAccount, testAccountID, ActionCreate, "testuser@TESTING", nil, testAccount{testAccountID, "One"})
+	if err != nil {
+		t.Fatalf("error creating history entry: %v", err)
+	}
+	assert.Nil(t, e.Before, "before value of a create should be absent")
+	assert.Equal(t, []interface{}{ResourceAccount, testAccountID, ActionCreate, "testuser@TESTING", e.Time, nil, `{"ID":"012345678912","Name":"One"}`}, e.args(), "insert arguments not as expected")
+}
+
+func TestRecordAndList(t *testing.T) {
+	db, mock, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	e, _ := New(ResourceAccount, testAccountID, ActionDelete, SystemActor, testAccount{testAccountID, "One"}, nil)
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WithArgs(ResourceAccount, testAccountID, ActionDelete, SystemActor, e.Time, `{"ID":"012345678912","Name":"One"}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := Record(ctx, *stmtMap, e); err != nil {
+		t.Fatalf("error recording history entry: %v", err)
+	}
+
+	now := time.Now().UTC()
+	ep[database.StmtKeyHistorySelect].ExpectQuery().WithArgs(ResourceAccount, testAccountID).WillReturnRows(sqlmock.NewRows(entryColumns).
+		AddRow(1, ResourceAccount, testAccountID, ActionCreate, "testuser@TESTING", now, nil, `{"ID":"012345678912","Name":"One"}`).
+		AddRow(2, ResourceAccount, testAccountID, ActionDelete, SystemActor, now, `{"ID":"012345678912","Name":"One"}`, nil))
+	es, err := List(ctx, *stmtMap, ResourceAccount, testAccountID)
+	if err != nil {
+		t.Fatalf("error listing history: %v", err)
+	}
+	assert.Len(t, es, 2, "number of entries not as expected")
+	assert.Equal(t, ActionCreate, es[0].Action, "entries not in order")
+	assert.Nil(t, es[0].Before, "before value should be absent")
+	assert.Equal(t, `{"ID":"012345678912","Name":"One"}`, string(es[1].Before), "before value not as expected")
+
+	ep[database.StmtKeyHistorySelect].ExpectQuery().WithArgs(ResourceAccount, "210987654321").WillReturnRows(sqlmock.NewRows(entryColumns))
+	es, err = List(ctx, *stmtMap, ResourceAccount, "210987654321")
+	if err != nil {
+		t.Fatalf("error listing history: %v", err)
+	}
+	assert.NotNil(t, es, "history of an entity without changes should be empty rather than nil")
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
@@ -0,0 +1,315 @@
+package httphandling
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"io"
+	"net/http"
+)
+
+const (
+	MuxVarAccessApproverID      = "accessApproverID"
+	AccessApproverAPI           = "accessapprover"
+	AccessApproverAcctPOSTTmpl  = "{\"AccountID\":\"%s\",\"AuthzAttribute\":\"%s\"}"
+	AccessApproverClassPOSTTmpl = "{\"AccountClassID\":%d,\"AuthzAttribute\":\"%s\"}"
+	AccessApproverAcctGETTmpl   = "{\"ID\":\"%s\",\"AccountID\":\"%s\",\"AuthzAttribute\":\"%s\"}"
+	AccessApproverClassGETTmpl  = "{\"ID\":\"%s\",\"AccountClassID\":%d,\"AuthzAttribute\":\"%s\"}"
+)
+
+// accessApprover defines the authorization value users must satisfy to approve access requests for role mappings
+// under an account or account class. Exactly one of AccountID and AccountClassID is set.
+type accessApprover struct {
+	ID             string `json:"ID,omitempty"`
+	AccountID      string `json:"AccountID,omitempty"`
+	AccountClassID int    `json:"AccountClassID,omitempty"`
+	AuthzAttribute string `json:"AuthzAttribute"`
+}
+
+type accessApproverList struct {
+	AccessApprovers []accessApprover `json:"AccessApprovers"`
+	listPage
+}
+
+func (a *accessApprover) scan(row rowScanner) error {
+	var acct sql.NullString
+	var class sql.NullInt64
+	err := row.Scan(&a.ID, &acct, &class, &a.AuthzAttribute)
+	a.AccountID = acct.String
+	a.AccountClassID = int(class.Int64)
+	return err
+}
+
+// values returns the account and account class in the form stored in the database.
+func (a accessApprover) values() (acct, class interface{}) {
+	if a.AccountID != "" {
+		acct = a.AccountID
+	}
+	if a.AccountClassID != 0 {
+		class = a.AccountClassID
+	}
+	return
+}
+
+func listAccessApproverFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAccessApprover, "access approvers")
+		if !ok {
+			return
+		}
+		defer rows.Close()
+		var as accessApproverList
+		for rows.Next() {
+			var a accessApprover
+			err := a.scan(rows)
+			if err != nil {
+				requestLogger(r, c).Errorf("error processing rows of access approvers from database: %v", err)
+				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+				return
+			}
+			as.AccessApprovers = append(as.AccessApprovers, a)
+		}
+		as.listPage = newListPage(lq.Offset, len(as.AccessApprovers), total)
+		respondWithJSON(w, http.StatusOK, as)
+		return
+	})
+}
+
+func getAccessApproverFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id := accessApproverID(r)
+		if id == "" {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "access approver ID not in request")
+			return
+		}
+		stmtKey := database.StmtKeyAccessApproverSelect
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for getting an access approver not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		var a accessApprover
+		err := a.scan(database.QueryRow(r.Context(), stmt, id))
+		if err == sql.ErrNoRows {
+			respondGeneric(w, http.StatusNotFound, appcodes.AccessApproverUnknown, "Access approver ID not found.")
+			return
+		}
+		if err != nil {
+			requestLogger(r, c).Errorf("error processing access approver from database: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, a)
+		return
+	})
+}
+
+func updateAccessApproverFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id := accessApproverID(r)
+		if id == "" {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "access approver ID not in request")
+			return
+		}
+		a, err := accessApproverFromPost(c, r)
+		if err != nil {
+			if e, ok := err.(appcodes.ErrBadPostData); ok {
+				respondGeneric(w, http.StatusBadRequest, e.Code, e.Error())
+				return
+			}
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
+			return
+		}
+		stmtKey := database.StmtKeyAccessApproverUpdate
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for updating an access approver not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		acct, class := a.values()
+		res, err := database.Exec(r.Context(), stmt, acct, class, a.AuthzAttribute, id)
+		if err != nil {
+			requestLogger(r, c).Errorf("error executing database statement for updating access approver: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		if i, e := res.RowsAffected(); i != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of access approver: expected (1) row affected, got (%d); error: %v", i, e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
+			return
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Access approver %s updated.", id))
+		return
+	})
+}
+
+func createAccessApproverFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		a, err := accessApproverFromPost(c, r)
+		if err != nil {
+			if e, ok := err.(appcodes.ErrBadPostData); ok {
+				respondGeneric(w, http.StatusBadRequest, e.Code, e.Error())
+				return
+			}
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
+			return
+		}
+		a.ID, err = uuid.GenerateUUID()
+		if err != nil {
+			e := fmt.Errorf("error generating UUID for new access approver: %v", err)
+			requestLogger(r, c).Error(e.Error())
+			respondGeneric(w, http.StatusInternalServerError, appcodes.UUIDGenerationError, e.Error())
+			return
+		}
+		stmtKey := database.StmtKeyAccessApproverInsert
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for creating an access approver not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		acct, class := a.values()
+		res, err := database.Exec(r.Context(), stmt, a.ID, acct, class, a.AuthzAttribute)
+		if err != nil {
+			requestLogger(r, c).Errorf("error executing database statement for creating access approver: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		if i, e := res.RowsAffected(); i != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database for creating access approver: expected (1) row affected, got (%d); error: %v", i, e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
+			return
+		}
+		respondCreated(w, a.ID, fmt.Sprintf("Access approver %s created.", a.ID))
+		return
+	})
+}
+
+func deleteAccessApproverFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id := accessApproverID(r)
+		if id == "" {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "access approver ID not in request")
+			return
+		}
+		stmtKey := database.StmtKeyAccessApproverDelete
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for deleting an access approver not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		res, err := database.Exec(r.Context(), stmt, id)
+		if err != nil {
+			requestLogger(r, c).Errorf("error executing database statement for deleting access approver: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		i, e := res.RowsAffected()
+		if e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting access approver: expected (1) row affected, got (%d); error: %v", i, e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
+			return
+		}
+		if i != 1 {
+			respondGeneric(w, http.StatusNotFound, appcodes.AccessApproverUnknown, "Access approver ID not found.")
+			return
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Access approver with ID %s deleted.", id))
+		return
+	})
+}
+
+func getAccessApproverRoutes(c *config.Config, db database.Source) []Route {
+	return []Route{
+		{
+			Name:           "AccessApproverAllList",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/accessapprover",
+			HandlerFunc:    listAccessApproverFunc(c, db),
+			Authentication: false,
+		},
+		{
+			Name:           "AccessApproverGet",
+			Method:         "GET",
+			Pattern:        fmt.Sprintf(`/%s/accessapprover/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarAccessApproverID),
+			HandlerFunc:    getAccessApproverFunc(c, db),
+			Authentication: false,
+		},
+		{
+			Name:           "AccessApproverUpdate",
+			Method:         "PUT",
+			Pattern:        fmt.Sprintf(`/%s/accessapprover/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarAccessApproverID),
+			HandlerFunc:    updateAccessApproverFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessApproverDelete",
+			Method:         "DELETE",
+			Pattern:        fmt.Sprintf(`/%s/accessapprover/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarAccessApproverID),
+			HandlerFunc:    deleteAccessApproverFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessApproverCreate",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/accessapprover",
+			HandlerFunc:    createAccessApproverFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessApproverCreateNotAllowed",
+			Method:         "POST",
+			Pattern:        fmt.Sprintf(`/%s/accessapprover/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarAccessApproverID),
+			HandlerFunc:    MethodNotAllowed(),
+			Authentication: true,
+		},
+	}
+}
+
+func accessApproverID(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars[MuxVarAccessApproverID]
+}
+
+func accessApproverFromPost(c *config.Config, r *http.Request) (a accessApprover, err error) {
+	reader := io.LimitReader(r.Body, 2048)
+	defer r.Body.Close()
+	dec := json.NewDecoder(reader)
+	err = dec.Decode(&a)
+	if err != nil {
+		requestLogger(r, c).Errorf("error decoding provided JSON into accessApprover: %v", err)
+		return
+	}
+	if (a.AccountID == "") == (a.AccountClassID == 0) {
+		err = appcodes.ErrBadPostData{}.Errorf("exactly one of an account ID or account class ID must be provided")
+		return
+	}
+	if e := authz.Validate(a.AuthzAttribute); e != nil {
+		err = appcodes.ErrBadPostData{}.Errorf("invalid authorization attribute or expression: %v", e)
+		requestLogger(r, c).Errorf("invalid authorization [%s] in accessApprover provided: %v", a.AuthzAttribute, e)
+	}
+	return
+}
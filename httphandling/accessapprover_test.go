@@ -0,0 +1,101 @@
+package httphandling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessApprover(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+
+	var tests = []struct {
+		Method         string
+		Endpoint       string
+		AuthRequired   bool
+		Path           string
+		PostPayload    string
+		HttpCode       int
+		ResponseString string
+	}{
+		// Create
+		{"POST", AccessApproverAPI, true, "", fmt.Sprintf(AccessApproverAcctPOSTTmpl, test.AWSAccountID1, test.AuthzAttrib1), http.StatusCreated, fmt.Sprintf(test.CreatedResponseTmpl, "", "")},
+		{"POST", AccessApproverAPI, true, "", fmt.Sprintf(AccessApproverClassPOSTTmpl, test.AccountClassID1, test.AuthzAttrib2), http.StatusCreated, fmt.Sprintf(test.CreatedResponseTmpl, "", "")},
+		// Create invalid
+		{"POST", AccessApproverAPI, true, "", `{"AccountID":"` + test.AWSAccountID1 + `","AccountClassID":1,"AuthzAttribute":"` + test.AuthzAttrib1 + `"}`, http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "exactly one of an account ID or account class ID must be provided", http.StatusBadRequest, appcodes.BadData)},
+		// List
+		{"GET", AccessApproverAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccessApprovers":[`+AccessApproverAcctGETTmpl+`,`+AccessApproverClassGETTmpl+`],"Total":2}`, test.UUID3, test.AWSAccountID1, test.AuthzAttrib1, test.UUID4, test.AccountClassID1, test.AuthzAttrib2)},
+		// Get
+		{"GET", AccessApproverAPI, false, "/" + test.UUID3, "", http.StatusOK, fmt.Sprintf(AccessApproverAcctGETTmpl, test.UUID3, test.AWSAccountID1, test.AuthzAttrib1)},
+		{"GET", AccessApproverAPI, false, "/" + test.UUID5, "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Access approver ID not found.", http.StatusNotFound, appcodes.AccessApproverUnknown)},
+		// Method not allowed
+		{"POST", AccessApproverAPI, true, "/" + test.UUID3, fmt.Sprintf(AccessApproverAcctPOSTTmpl, test.AWSAccountID1, test.AuthzAttrib1), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
+		{"PUT", AccessApproverAPI, true, "/" + test.UUID3, fmt.Sprintf(AccessApproverAcctPOSTTmpl, test.AWSAccountID2, test.AuthzAttrib1), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Access approver "+test.UUID3+" updated.", http.StatusOK, appcodes.Info)},
+		{"DELETE", AccessApproverAPI, true, "/" + test.UUID4, "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Access approver with ID "+test.UUID4+" deleted.", http.StatusOK, appcodes.Info)},
+		{"DELETE", AccessApproverAPI, true, "/" + test.UUID4, "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Access approver ID not found.", http.StatusNotFound, appcodes.AccessApproverUnknown)},
+	}
+
+	// Set the expected database calls that are performed as part of the table tests
+	aaCols := []string{"id", "accountid", "classid", "authz"}
+	ep[database.StmtKeyAccessApproverInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.AWSAccountID1, nil, test.AuthzAttrib1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAccessApproverInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), nil, test.AccountClassID1, test.AuthzAttrib2).WillReturnResult(sqlmock.NewResult(0, 1))
+	database.ExpectList(mock, database.ListAccessApprover, 2, sqlmock.NewRows(aaCols).
+		AddRow(test.UUID3, test.AWSAccountID1, nil, test.AuthzAttrib1).
+		AddRow(test.UUID4, nil, test.AccountClassID1, test.AuthzAttrib2))
+	ep[database.StmtKeyAccessApproverSelect].ExpectQuery().WithArgs(test.UUID3).WillReturnRows(sqlmock.NewRows(aaCols).
+		AddRow(test.UUID3, test.AWSAccountID1, nil, test.AuthzAttrib1))
+	ep[database.StmtKeyAccessApproverSelect].ExpectQuery().WithArgs(test.UUID5).WillReturnRows(sqlmock.NewRows(aaCols))
+	ep[database.StmtKeyAccessApproverUpdate].ExpectExec().WithArgs(test.AWSAccountID2, nil, test.AuthzAttrib1, test.UUID3).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAccessApproverDelete].ExpectExec().WithArgs(test.UUID4).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAccessApproverDelete].ExpectExec().WithArgs(test.UUID4).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(test.PostPayload))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		if test.AuthRequired {
+			// Check it was unauthorized before passing auth creds
+			assert.Equal(t, http.StatusUnauthorized, response.Code, "Expected unauthorized error")
+			// Now authenticated (using testing static auth)
+			response = httptest.NewRecorder()
+			request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret)))
+			rt.ServeHTTP(response, request)
+		}
+		assert.Equal(t, test.HttpCode, response.Code, fmt.Sprintf("Expected HTTP code: %d got: %d (%s %s)", test.HttpCode, response.Code, test.Method, url))
+		respStr := response.Body.String()
+		// For created access approvers the uuid is dynamically generated so we need to wipe it out in the response to compare.
+		if response.Code == http.StatusCreated {
+			var j JSONCreatedResponse
+			err = json.Unmarshal([]byte(respStr), &j)
+			if err != nil {
+				t.Errorf("could not unmarshal created entity response: %v", err)
+			}
+			j.CreatedEntity = ""
+			j.Message = ""
+			b, err := json.Marshal(j)
+			if err != nil {
+				t.Errorf("could not marshal created entity response: %v", err)
+			}
+			respStr = string(b)
+		}
+		assert.Equal(t, test.ResponseString, respStr, fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
+	}
+}
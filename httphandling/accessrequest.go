@@ -0,0 +1,200 @@
+package httphandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jcmturner/awsfederation/accessrequest"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"io"
+	"net/http"
+)
+
+const (
+	MuxVarAccessRequestID     = "accessRequestID"
+	FilterStatus              = "status"
+	AccessRequestAPI          = "accessrequest"
+	AccessRequestPOSTTmpl     = "{\"RoleMappingID\":\"%s\",\"Duration\":%d,\"Justification\":\"%s\"}"
+	AccessRequestDecisionTmpl = "{\"Comment\":\"%s\"}"
+)
+
+type accessRequestPost struct {
+	RoleMappingID string `json:"RoleMappingID"`
+	Duration      int    `json:"Duration"`
+	Justification string `json:"Justification"`
+}
+
+type accessRequestDecision struct {
+	Comment string `json:"Comment"`
+}
+
+type accessRequestList struct {
+	AccessRequests []accessrequest.AccessRequest `json:"AccessRequests"`
+	listPage
+}
+
+func listAccessRequestFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAccessRequest, "access requests")
+		if !ok {
+			return
+		}
+		defer rows.Close()
+		var as accessRequestList
+		for rows.Next() {
+			a, err := accessrequest.Scan(rows)
+			if err != nil {
+				requestLogger(r, c).Errorf("error processing rows of access requests from database: %v", err)
+				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+				return
+			}
+			as.AccessRequests = append(as.AccessRequests, a)
+		}
+		as.listPage = newListPage(lq.Offset, len(as.AccessRequests), total)
+		respondWithJSON(w, http.StatusOK, as)
+		return
+	})
+}
+
+func getAccessRequestFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		a, err := accessrequest.Get(r.Context(), accessRequestID(r), *stmtMap)
+		if err != nil {
+			respondAccessRequestError(w, r, c, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, a)
+		return
+	})
+}
+
+func createAccessRequestFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		u, err := GetIdentity(r.Context())
+		if err != nil {
+			respondUnauthorized(w, c)
+			return
+		}
+		var p accessRequestPost
+		reader := io.LimitReader(r.Body, 2048)
+		defer r.Body.Close()
+		err = json.NewDecoder(reader).Decode(&p)
+		if err != nil {
+			requestLogger(r, c).Errorf("error decoding provided JSON into access request: %v", err)
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
+			return
+		}
+		a, err := accessrequest.New(r.Context(), u, p.RoleMappingID, p.Justification, p.Duration, *stmtMap, c)
+		if err != nil {
+			respondAccessRequestError(w, r, c, err)
+			return
+		}
+		respondCreated(w, a.ID, fmt.Sprintf("Access request %s created.", a.ID))
+		return
+	})
+}
+
+func decideAccessRequestFunc(c *config.Config, db database.Source, approve bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		u, err := GetIdentity(r.Context())
+		if err != nil {
+			respondUnauthorized(w, c)
+			return
+		}
+		// The comment is optional so an empty body is accepted.
+		var d accessRequestDecision
+		reader := io.LimitReader(r.Body, 2048)
+		defer r.Body.Close()
+		if err := json.NewDecoder(reader).Decode(&d); err != nil && err != io.EOF {
+			requestLogger(r, c).Errorf("error decoding provided JSON into access request decision: %v", err)
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
+			return
+		}
+		a, err := accessrequest.Decide(r.Context(), u, accessRequestID(r), approve, d.Comment, *stmtMap, c)
+		if err != nil {
+			respondAccessRequestError(w, r, c, err)
+			return
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Access request %s %s.", a.ID, a.Status))
+		return
+	})
+}
+
+func respondAccessRequestError(w http.ResponseWriter, r *http.Request, c *config.Config, err error) {
+	switch e := err.(type) {
+	case appcodes.ErrBadPostData:
+		respondGeneric(w, http.StatusBadRequest, e.Code, e.Error())
+	case appcodes.ErrUnauthorized:
+		respondGeneric(w, http.StatusUnauthorized, e.AppCode, e.Error())
+	case appcodes.ErrAccessRequest:
+		switch e.AppCode {
+		case appcodes.AccessRequestUnknown:
+			respondGeneric(w, http.StatusNotFound, e.AppCode, e.Error())
+		case appcodes.AccessRequestNotPending:
+			respondGeneric(w, http.StatusConflict, e.AppCode, e.Error())
+		default:
+			respondGeneric(w, http.StatusBadRequest, e.AppCode, e.Error())
+		}
+	default:
+		requestLogger(r, c).Errorf("error processing access request: %v", err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.AccessRequestError, err.Error())
+	}
+}
+
+func getAccessRequestRoutes(c *config.Config, db database.Source) []Route {
+	return []Route{
+		{
+			Name:           "AccessRequestAllList",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/accessrequest",
+			HandlerFunc:    listAccessRequestFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessRequestGet",
+			Method:         "GET",
+			Pattern:        fmt.Sprintf(`/%s/accessrequest/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarAccessRequestID),
+			HandlerFunc:    getAccessRequestFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessRequestCreate",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/accessrequest",
+			HandlerFunc:    createAccessRequestFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessRequestApprove",
+			Method:         "POST",
+			Pattern:        fmt.Sprintf(`/%s/accessrequest/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}/approve`, APIVersion, MuxVarAccessRequestID),
+			HandlerFunc:    decideAccessRequestFunc(c, db, true),
+			Authentication: true,
+		},
+		{
+			Name:           "AccessRequestDeny",
+			Method:         "POST",
+			Pattern:        fmt.Sprintf(`/%s/accessrequest/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}/deny`, APIVersion, MuxVarAccessRequestID),
+			HandlerFunc:    decideAccessRequestFunc(c, db, false),
+			Authentication: true,
+		},
+	}
+}
+
+func accessRequestID(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars[MuxVarAccessRequestID]
+}
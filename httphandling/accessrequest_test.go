@@ -0,0 +1,130 @@
+package httphandling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/awsfederation/accessrequest"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessRequest(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+
+	requested := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	pending := accessrequest.AccessRequest{
+		ID:            test.UUID4,
+		RoleMappingID: test.UUID3,
+		Username:      "requester",
+		UserDomain:    "TESTING",
+		Justification: "incident 123",
+		Duration:      60,
+		Status:        accessrequest.StatusPending,
+		Requested:     requested,
+	}
+	pendingJSON, _ := json.Marshal(accessRequestList{AccessRequests: []accessrequest.AccessRequest{pending}, listPage: listPage{Total: 1}})
+
+	var tests = []struct {
+		Method         string
+		Endpoint       string
+		AuthRequired   bool
+		Path           string
+		PostPayload    string
+		HttpCode       int
+		ResponseString string
+	}{
+		// Create
+		{"POST", AccessRequestAPI, true, "", fmt.Sprintf(AccessRequestPOSTTmpl, test.UUID3, 60, "incident 123"), http.StatusCreated, fmt.Sprintf(test.CreatedResponseTmpl, "", "")},
+		// Create when a request is already pending
+		{"POST", AccessRequestAPI, true, "", fmt.Sprintf(AccessRequestPOSTTmpl, test.UUID3, 60, "incident 123"), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "a pending access request for role mapping "+test.UUID3+" already exists", http.StatusBadRequest, appcodes.AccessRequestAlreadyExists)},
+		// Create without approvers
+		{"POST", AccessRequestAPI, true, "", fmt.Sprintf(AccessRequestPOSTTmpl, test.UUID5, 60, "incident 123"), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "no approvers are defined for role mapping "+test.UUID5, http.StatusBadRequest, appcodes.AccessRequestNoApprovers)},
+		// Create invalid
+		{"POST", AccessRequestAPI, true, "", fmt.Sprintf(AccessRequestPOSTTmpl, test.UUID3, 0, "incident 123"), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "duration must be between 1 and 480 minutes", http.StatusBadRequest, appcodes.BadData)},
+		{"POST", AccessRequestAPI, true, "", fmt.Sprintf(AccessRequestPOSTTmpl, test.UUID3, 60, ""), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "a justification of no more than 1024 characters must be provided", http.StatusBadRequest, appcodes.BadData)},
+		// List pending
+		{"GET", AccessRequestAPI, true, "?status=Pending", "", http.StatusOK, string(pendingJSON)},
+		// Approve
+		{"POST", AccessRequestAPI, true, "/" + test.UUID4 + "/approve", fmt.Sprintf(AccessRequestDecisionTmpl, "approved for incident"), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Access request "+test.UUID4+" Approved.", http.StatusOK, appcodes.Info)},
+		// Deny once decided
+		{"POST", AccessRequestAPI, true, "/" + test.UUID4 + "/deny", "", http.StatusConflict, fmt.Sprintf(test.GenericResponseTmpl, "access request "+test.UUID4+" is not pending, status is Approved", http.StatusConflict, appcodes.AccessRequestNotPending)},
+		// Decide own request
+		{"POST", AccessRequestAPI, true, "/" + test.UUID6 + "/approve", "", http.StatusUnauthorized, fmt.Sprintf(test.GenericResponseTmpl, "users cannot decide their own access requests", http.StatusUnauthorized, appcodes.Unauthorized)},
+		// Get unknown
+		{"GET", AccessRequestAPI, true, "/" + test.UUID5, "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "access request "+test.UUID5+" not found", http.StatusNotFound, appcodes.AccessRequestUnknown)},
+	}
+
+	// Set the expected database calls that are performed as part of the table tests
+	arCols := []string{"id", "rolemappingid", "username", "userdomain", "justification", "duration", "status", "requested", "decidedby", "decided", "comment", "validuntil"}
+	ep[database.StmtKeyAccessRequestApprovers].ExpectQuery().WithArgs(test.UUID3).WillReturnRows(sqlmock.NewRows([]string{"authz"}).AddRow(config.MockStaticAttribute))
+	ep[database.StmtKeyAccessRequestExists].ExpectQuery().WithArgs(test.UUID3, "testuser", "TESTING", accessrequest.StatusPending).WillReturnRows(sqlmock.NewRows([]string{"exists"}))
+	ep[database.StmtKeyAccessRequestInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.UUID3, "testuser", "TESTING", "incident 123", 60, accessrequest.StatusPending, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAccessRequestApprovers].ExpectQuery().WithArgs(test.UUID3).WillReturnRows(sqlmock.NewRows([]string{"authz"}).AddRow(config.MockStaticAttribute))
+	ep[database.StmtKeyAccessRequestExists].ExpectQuery().WithArgs(test.UUID3, "testuser", "TESTING", accessrequest.StatusPending).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(1))
+	ep[database.StmtKeyAccessRequestApprovers].ExpectQuery().WithArgs(test.UUID5).WillReturnRows(sqlmock.NewRows([]string{"authz"}))
+	count, page := database.ExpectList(mock, database.ListAccessRequest, 1, sqlmock.NewRows(arCols).
+		AddRow(test.UUID4, test.UUID3, "requester", "TESTING", "incident 123", 60, accessrequest.StatusPending, requested, nil, nil, nil, nil))
+	count.WithArgs(accessrequest.StatusPending)
+	page.WithArgs(accessrequest.StatusPending, DefaultListLimit, 0)
+	ep[database.StmtKeyAccessRequestSelect].ExpectQuery().WithArgs(test.UUID4).WillReturnRows(sqlmock.NewRows(arCols).
+		AddRow(test.UUID4, test.UUID3, "requester", "TESTING", "incident 123", 60, accessrequest.StatusPending, requested, nil, nil, nil, nil))
+	ep[database.StmtKeyAccessRequestApprovers].ExpectQuery().WithArgs(test.UUID3).WillReturnRows(sqlmock.NewRows([]string{"authz"}).AddRow(config.MockStaticAttribute))
+	ep[database.StmtKeyAccessRequestDecide].ExpectExec().WithArgs(accessrequest.StatusApproved, "testuser@TESTING", sqlmock.AnyArg(), "approved for incident", sqlmock.AnyArg(), test.UUID4, accessrequest.StatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+	decided := requested.Add(time.Minute)
+	validUntil := decided.Add(time.Hour)
+	ep[database.StmtKeyAccessRequestSelect].ExpectQuery().WithArgs(test.UUID4).WillReturnRows(sqlmock.NewRows(arCols).
+		AddRow(test.UUID4, test.UUID3, "requester", "TESTING", "incident 123", 60, accessrequest.StatusApproved, requested, "testuser@TESTING", decided, "approved for incident", validUntil))
+	ep[database.StmtKeyAccessRequestSelect].ExpectQuery().WithArgs(test.UUID6).WillReturnRows(sqlmock.NewRows(arCols).
+		AddRow(test.UUID6, test.UUID3, "testuser", "TESTING", "incident 123", 60, accessrequest.StatusPending, requested, nil, nil, nil, nil))
+	ep[database.StmtKeyAccessRequestSelect].ExpectQuery().WithArgs(test.UUID5).WillReturnRows(sqlmock.NewRows(arCols))
+
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(test.PostPayload))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		if test.AuthRequired {
+			// Check it was unauthorized before passing auth creds
+			assert.Equal(t, http.StatusUnauthorized, response.Code, "Expected unauthorized error")
+			// Now authenticated (using testing static auth)
+			response = httptest.NewRecorder()
+			request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret)))
+			rt.ServeHTTP(response, request)
+		}
+		assert.Equal(t, test.HttpCode, response.Code, fmt.Sprintf("Expected HTTP code: %d got: %d (%s %s)", test.HttpCode, response.Code, test.Method, url))
+		respStr := response.Body.String()
+		// For created access requests the uuid is dynamically generated so we need to wipe it out in the response to compare.
+		if response.Code == http.StatusCreated {
+			var j JSONCreatedResponse
+			err = json.Unmarshal([]byte(respStr), &j)
+			if err != nil {
+				t.Errorf("could not unmarshal created entity response: %v", err)
+			}
+			j.CreatedEntity = ""
+			j.Message = ""
+			b, err := json.Marshal(j)
+			if err != nil {
+				t.Errorf("could not marshal created entity response: %v", err)
+			}
+			respStr = string(b)
+		}
+		assert.Equal(t, test.ResponseString, respStr, fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
+	}
+}
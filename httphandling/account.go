@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
 	"io"
 	"net/http"
 )
@@ -26,25 +28,20 @@ type account struct {
 	Type              accountType   `json:"Type"`
 	Status            accountStatus `json:"Status"`
 	FederationUserARN string        `json:"FederationUserARN"`
+	Version           int64         `json:"-"`
 }
 
 type accountList struct {
 	Accounts []account `json:"Accounts"`
+	listPage
 }
 
-func listAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func listAccountFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stmtKey := database.StmtKeyAcctSelectList
-		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for listing accounts not found")
-			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
-			return
-		}
-		stmt := (*stmtMap)[stmtKey]
-		rows, err := stmt.Query()
-		if err != nil {
-			c.ApplicationLogf("error retrieving accounts from database: %v", err)
-			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAcct, "accounts")
+		if !ok {
 			return
 		}
 		defer rows.Close()
@@ -58,19 +55,23 @@ func listAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFu
 				&a.FederationUserARN,
 			)
 			if err != nil {
-				c.ApplicationLogf("error processing rows of accounts from database: %v", err)
+				requestLogger(r, c).Errorf("error processing rows of accounts from database: %v", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 				return
 			}
 			as.Accounts = append(as.Accounts, a)
 		}
+		as.listPage = newListPage(lq.Offset, len(as.Accounts), total)
 		respondWithJSON(w, http.StatusOK, as)
 		return
 	})
 }
 
-func getAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func getAccountFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		id, ok := accountID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "account ID not in request")
@@ -78,65 +79,100 @@ func getAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFun
 		}
 		stmtKey := database.StmtKeyAcctSelect
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
 		var a account
-		err := stmt.QueryRow(id).Scan(&a.ID, &a.Email, &a.Name,
+		err := database.QueryRow(r.Context(), stmt, id).Scan(&a.ID, &a.Email, &a.Name,
 			&a.Type.ID, &a.Type.Type,
 			&a.Type.Class.ID, &a.Type.Class.Class,
 			&a.Status.ID, &a.Status.Status,
-			&a.FederationUserARN,
+			&a.FederationUserARN, &a.Version,
 		)
 		if err != nil {
-			c.ApplicationLogf("error processing account from database: %v", err)
+			requestLogger(r, c).Errorf("error processing account from database: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
+		setETag(w, a.Version)
 		respondWithJSON(w, http.StatusOK, a)
 		return
 	})
 }
 
-func updateAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateAccountFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		i, ok := accountID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "account ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccount, i, "account") {
+			return
+		}
 		a, err := accountFromRequest(c, r)
 		if err != nil || a.ID != i {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctVersion, i, "Account")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccount, i)
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctUpdate
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for updating an account not found")
+			requestLogger(r, c).Errorf("error, prepared statement for updating an account not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Email, a.Name, a.Type.ID, a.Status.ID, a.FederationUserARN, i)
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Email, a.Name, a.Type.ID, a.Status.ID, a.FederationUserARN, i, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for updating account: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for updating account: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
-		if i, e := res.RowsAffected(); i != 1 || e != nil {
-			c.ApplicationLogf("error unexpected result from database update of account: expected (1) row affected, got (%d); error: %v", i, e)
+		n, e := res.RowsAffected()
+		if e == nil && n == 0 && v != 0 {
+			// Changed by another request since the version was checked.
+			respondPreconditionFailed(w, 0, "Account", i)
+			return
+		}
+		if n != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of account: expected (1) row affected, got (%d); error: %v", n, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccount, i, history.ActionUpdate, before) {
+			return
+		}
+		if v != 0 {
+			setETag(w, v+1)
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account %s updated.", a.ID))
 		return
 	})
 }
 
-func createAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createAccountFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a, err := accountFromRequest(c, r)
 		if err != nil {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
@@ -146,12 +182,19 @@ func createAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.Handler
 		// Check it does not already exist
 		stmtKey := database.StmtKeyAcctCheckUnique
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account status by name not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account status by name not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		err = stmt.QueryRow(a.ID, a.Email, a.Name).Scan()
+		var existing string
+		var deleted bool
+		err = database.QueryRow(r.Context(), stmt, a.ID, a.Email, a.Name).Scan(&existing, &deleted)
+		if err == nil && deleted {
+			// Only a deleted account holds the ID, email or name and it cannot be created again.
+			respondGeneric(w, http.StatusConflict, appcodes.AccountDeleted, fmt.Sprintf("An Account with either the ID %s, email %s or name %s was deleted. Restore Account %s with POST /%s/account/%s/restore.", a.ID, a.Email, a.Name, existing, APIVersion, existing))
+			return
+		}
 		if err != sql.ErrNoRows {
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountAlreadyExists, fmt.Sprintf("An Account with either the ID %s, email %s or name %s already exists.", a.ID, a.Email, a.Name))
 			return
@@ -159,20 +202,25 @@ func createAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.Handler
 
 		stmtKey = database.StmtKeyAcctInsert
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for creating an account not found")
+			requestLogger(r, c).Errorf("error, prepared statement for creating an account not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt = (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.ID, a.Email, a.Name, a.Type.ID, a.Status.ID, a.FederationUserARN)
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, a.ID, a.Email, a.Name, a.Type.ID, a.Status.ID, a.FederationUserARN)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for creating account: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for creating account: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil || (i != 1 && i != 0) {
-			c.ApplicationLogf("error unexpected result from database for creating account: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for creating account: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
@@ -180,81 +228,177 @@ func createAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.Handler
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountAlreadyExists, fmt.Sprintf("Creating new account with ID %s failed.", a.ID))
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccount, a.ID, history.ActionCreate, nil) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account %s created.", a.ID))
 		return
 	})
 }
 
-func deleteAccountFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteAccountFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		id, ok := accountID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "account ID not in request")
 			return
 		}
-		stmtKey := database.StmtKeyAcctDelete
+		if readOnly(w, c, sy, catalogue.KindAccount, id, "account") {
+			return
+		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctVersion, id, "Account")
+		if !ok {
+			return
+		}
+		// Check it has no role mappings
+		stmtKey := database.StmtKeyAcctInUse
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for checking if an account has role mappings not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		var inUse int
+		err := database.QueryRow(r.Context(), (*stmtMap)[stmtKey], id).Scan(&inUse)
+		if err == nil {
+			respondGeneric(w, http.StatusConflict, appcodes.AccountInUse, fmt.Sprintf("Account %s has role mappings, these must be deleted first.", id))
+			return
+		}
+		if err != sql.ErrNoRows {
+			requestLogger(r, c).Errorf("error executing database statement for checking if an account has role mappings: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccount, id)
+		if !ok {
+			return
+		}
+		stmtKey = database.StmtKeyAcctDelete
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for deleting an account not found")
+			requestLogger(r, c).Errorf("error, prepared statement for deleting an account not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(id)
+		res, err := database.TxExec(r.Context(), tx, stmt, id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for deleting account: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for deleting account: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil {
-			c.ApplicationLogf("error unexpected result from database for deleting account: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting account: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if i != 1 && v != 0 {
+			respondPreconditionFailed(w, 0, "Account", id)
+			return
+		}
 		if i != 1 {
 			respondGeneric(w, http.StatusNotFound, appcodes.AccountUnknown, "Account ID not found.")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccount, id, history.ActionDelete, before) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account with ID %s deleted.", id))
 		return
 	})
 }
 
-func getAccountRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func restoreAccountFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id, ok := accountID(r)
+		if !ok {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "account ID not in request")
+			return
+		}
+		if readOnly(w, c, sy, catalogue.KindAccount, id, "account") {
+			return
+		}
+		stmtKey := database.StmtKeyAcctRestore
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for restoring an account not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, id)
+		if err != nil {
+			requestLogger(r, c).Errorf("error executing database statement for restoring account: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		i, e := res.RowsAffected()
+		if e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database for restoring account: expected (1) row affected, got (%d); error: %v", i, e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
+			return
+		}
+		if i != 1 {
+			respondGeneric(w, http.StatusNotFound, appcodes.AccountUnknown, "Deleted account ID not found.")
+			return
+		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccount, id, history.ActionRestore, nil) {
+			return
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account with ID %s restored.", id))
+		return
+	})
+}
+
+func getAccountRoutes(c *config.Config, db database.Source, sy *catalogue.Syncer) []Route {
 	return []Route{
 		{
 			Name:           "AccountAllList",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/account",
-			HandlerFunc:    listAccountFunc(c, stmtMap),
+			HandlerFunc:    listAccountFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountGet",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/account/{" + MuxVarAccountID + ":[0-9]{12}}",
-			HandlerFunc:    getAccountFunc(c, stmtMap),
+			HandlerFunc:    getAccountFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountUpdate",
 			Method:         "PUT",
 			Pattern:        "/" + APIVersion + "/account/{" + MuxVarAccountID + ":[0-9]{12}}",
-			HandlerFunc:    updateAccountFunc(c, stmtMap),
+			HandlerFunc:    updateAccountFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountDelete",
 			Method:         "DELETE",
 			Pattern:        "/" + APIVersion + "/account/{" + MuxVarAccountID + ":[0-9]{12}}",
-			HandlerFunc:    deleteAccountFunc(c, stmtMap),
+			HandlerFunc:    deleteAccountFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountCreate",
 			Method:         "POST",
 			Pattern:        "/" + APIVersion + "/account",
-			HandlerFunc:    createAccountFunc(c, stmtMap),
+			HandlerFunc:    createAccountFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -264,6 +408,20 @@ func getAccountRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
 			HandlerFunc:    MethodNotAllowed(),
 			Authentication: true,
 		},
+		{
+			Name:           "AccountRestore",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/account/{" + MuxVarAccountID + ":[0-9]{12}}/restore",
+			HandlerFunc:    restoreAccountFunc(c, db, sy),
+			Authentication: true,
+		},
+		{
+			Name:           "AccountHistory",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/account/{" + MuxVarAccountID + ":[0-9]{12}}/history",
+			HandlerFunc:    getHistoryFunc(c, db, catalogue.KindAccount, MuxVarAccountID),
+			Authentication: true,
+		},
 	}
 }
 
@@ -279,7 +437,7 @@ func accountFromRequest(c *config.Config, r *http.Request) (a account, err error
 	dec := json.NewDecoder(reader)
 	err = dec.Decode(&a)
 	if err != nil {
-		c.ApplicationLogf("error dcoding provided JSON into account: %v", err)
+		requestLogger(r, c).Errorf("error dcoding provided JSON into account: %v", err)
 	}
 	return
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
 	"github.com/jcmturner/awsfederation/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -17,10 +18,10 @@ import (
 )
 
 func TestAccount(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -35,13 +36,15 @@ func TestAccount(t *testing.T) {
 		{"POST", AccountAPI, true, "", fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account "+test.AWSAccountID1+" created.", http.StatusOK, appcodes.Info)},
 		// Handle create duplicate
 		{"POST", AccountAPI, true, "", fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "An Account with either the ID "+test.AWSAccountID1+", email "+test.AccountEmail1+" or name "+test.AccountName1+" already exists.", http.StatusBadRequest, appcodes.AccountAlreadyExists)},
+		// Handle create of a deleted account
+		{"POST", AccountAPI, true, "", fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1), http.StatusConflict, fmt.Sprintf(test.GenericResponseTmpl, "An Account with either the ID "+test.AWSAccountID1+", email "+test.AccountEmail1+" or name "+test.AccountName1+" was deleted. Restore Account "+test.AWSAccountID1+" with POST /"+APIVersion+"/account/"+test.AWSAccountID1+"/restore.", http.StatusConflict, appcodes.AccountDeleted)},
 		// List 1 entry
-		{"GET", AccountAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"Accounts":[`+AccountGETTmpl+`]}`, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)},
+		{"GET", AccountAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"Accounts":[`+AccountGETTmpl+`],"Total":1}`, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)},
 		// Get
 		{"GET", AccountAPI, false, "/" + test.AWSAccountID1, "", http.StatusOK, fmt.Sprintf(AccountGETTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)},
 		{"POST", AccountAPI, true, "", fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountStatusID2, test.FedUserArn2), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account "+test.AWSAccountID2+" created.", http.StatusOK, appcodes.Info)},
 		//// List multiple
-		{"GET", AccountAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"Accounts":[`+AccountGETTmpl+","+AccountGETTmpl+`]}`, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1, test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2, test.AccountClassName2, test.AccountStatusID2, test.AccountStatusName2, test.FedUserArn2)},
+		{"GET", AccountAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"Accounts":[`+AccountGETTmpl+","+AccountGETTmpl+`],"Total":2}`, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1, test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2, test.AccountClassName2, test.AccountStatusID2, test.AccountStatusName2, test.FedUserArn2)},
 		//// Method not allowed
 		{"POST", AccountAPI, true, "/" + test.AWSAccountID1, fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
 		{"DELETE", AccountAPI, true, "/" + test.AWSAccountID2, "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account with ID "+test.AWSAccountID2+" deleted.", http.StatusOK, appcodes.Info)},
@@ -49,31 +52,54 @@ func TestAccount(t *testing.T) {
 		{"PUT", AccountAPI, true, "/" + test.AWSAccountID1, fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("Account %s updated.", test.AWSAccountID1), http.StatusOK, appcodes.Info)},
 	}
 	// Set the expected database calls that are performed as part of the table tests
-	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID1, test.AccountEmail1, test.AccountName1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID1, test.AccountEmail1, test.AccountName1).WillReturnRows(sqlmock.NewRows([]string{"id", "deleted"}))
+	mock.ExpectBegin()
 	ep[database.StmtKeyAcctInsert].ExpectExec().WithArgs(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID1))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID1, history.ActionCreate)
 
-	rows := sqlmock.NewRows([]string{"id"}).
-		AddRow(test.AWSAccountID1)
+	rows := sqlmock.NewRows([]string{"id", "deleted"}).
+		AddRow(test.AWSAccountID1, false)
+	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID1, test.AccountEmail1, test.AccountName1).WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "deleted"}).
+		AddRow(test.AWSAccountID1, true)
 	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID1, test.AccountEmail1, test.AccountName1).WillReturnRows(rows)
 
 	rows = sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser"}).
 		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)
-	ep[database.StmtKeyAcctSelectList].ExpectQuery().WillReturnRows(rows)
+	database.ExpectList(mock, database.ListAcct, 1, rows)
 
-	rows = sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser"}).
-		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)
+	rows = sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser", "version"}).
+		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1, 1)
 	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(rows)
 
-	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID2, test.AccountEmail2, test.AccountName2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	ep[database.StmtKeyAcctCheckUnique].ExpectQuery().WithArgs(test.AWSAccountID2, test.AccountEmail2, test.AccountName2).WillReturnRows(sqlmock.NewRows([]string{"id", "deleted"}))
+	mock.ExpectBegin()
 	ep[database.StmtKeyAcctInsert].ExpectExec().WithArgs(test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountStatusID2, test.FedUserArn2).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID2).WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser", "version"}).
+		AddRow(test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2, test.AccountClassName2, test.AccountStatusID2, test.AccountStatusName2, test.FedUserArn2, 1))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID2, history.ActionCreate)
 
 	rows = sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser"}).
 		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1).
 		AddRow(test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2, test.AccountClassName2, test.AccountStatusID2, test.AccountStatusName2, test.FedUserArn2)
-	ep[database.StmtKeyAcctSelectList].ExpectQuery().WillReturnRows(rows)
-	ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(test.AWSAccountID2).WillReturnResult(sqlmock.NewResult(0, 1))
-	ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(test.AWSAccountID2).WillReturnResult(sqlmock.NewResult(0, 0))
-	ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs(test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1, test.AWSAccountID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	database.ExpectList(mock, database.ListAcct, 2, rows)
+	ep[database.StmtKeyAcctInUse].ExpectQuery().WithArgs(test.AWSAccountID2).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID2).WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser", "version"}).
+		AddRow(test.AWSAccountID2, test.AccountEmail2, test.AccountName2, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2, test.AccountClassName2, test.AccountStatusID2, test.AccountStatusName2, test.FedUserArn2, 1))
+	ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(test.AWSAccountID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID2, history.ActionDelete)
+	ep[database.StmtKeyAcctInUse].ExpectQuery().WithArgs(test.AWSAccountID2).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(test.AWSAccountID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID1))
+	ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs(test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1, test.AWSAccountID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID2))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID1, history.ActionUpdate)
 
 	for _, test := range tests {
 		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
@@ -95,3 +121,75 @@ func TestAccount(t *testing.T) {
 		assert.Equal(t, test.ResponseString, response.Body.String(), fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
 	}
 }
+
+func TestAccount_IfMatch(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret))
+	url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s/%s", APIVersion, AccountAPI, test.AWSAccountID1)
+	put := fmt.Sprintf(AccountPOSTTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1)
+
+	var tests = []struct {
+		Name           string
+		Method         string
+		IfMatch        string
+		Require        bool
+		HttpCode       int
+		ETag           string
+		ResponseString string
+	}{
+		{"get", "GET", "", false, http.StatusOK, `"3"`, fmt.Sprintf(AccountGETTmpl, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1)},
+		{"update current version", "PUT", `"3"`, false, http.StatusOK, `"4"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("Account %s updated.", test.AWSAccountID1), http.StatusOK, appcodes.Info)},
+		{"update old version", "PUT", `"2", W/"3"`, false, http.StatusPreconditionFailed, `"4"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("The Account %s has been modified.", test.AWSAccountID1), http.StatusPreconditionFailed, appcodes.PreconditionFailed)},
+		{"update concurrently", "PUT", `*`, false, http.StatusPreconditionFailed, "", fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("The Account %s has been modified.", test.AWSAccountID1), http.StatusPreconditionFailed, appcodes.PreconditionFailed)},
+		{"update without If-Match", "PUT", "", true, http.StatusPreconditionRequired, "", fmt.Sprintf(test.GenericResponseTmpl, "The ETag of the Account to be changed must be given in the If-Match header.", http.StatusPreconditionRequired, appcodes.PreconditionRequired)},
+		{"delete current version", "DELETE", `"1", "5"`, true, http.StatusOK, "", fmt.Sprintf(test.GenericResponseTmpl, "Account with ID "+test.AWSAccountID1+" deleted.", http.StatusOK, appcodes.Info)},
+		{"delete deleted", "DELETE", `"5"`, true, http.StatusPreconditionFailed, "", fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("The Account %s does not exist.", test.AWSAccountID1), http.StatusPreconditionFailed, appcodes.PreconditionFailed)},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	rows := sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser", "version"}).
+		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1, 3)
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(rows)
+	ep[database.StmtKeyAcctVersion].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID1))
+	ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs(test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1, test.AWSAccountID1, 3, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID2))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID1, history.ActionUpdate)
+	ep[database.StmtKeyAcctVersion].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	ep[database.StmtKeyAcctVersion].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID2))
+	ep[database.StmtKeyAcctUpdate].ExpectExec().WithArgs(test.AccountEmail1, test.AccountName1, test.AccountTypeID2, test.AccountStatusID1, test.FedUserArn1, test.AWSAccountID1, 4, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	ep[database.StmtKeyAcctVersion].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	ep[database.StmtKeyAcctInUse].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID2))
+	ep[database.StmtKeyAcctDelete].ExpectExec().WithArgs(test.AWSAccountID1, 5, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID1, history.ActionDelete)
+	ep[database.StmtKeyAcctVersion].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+	for _, test := range tests {
+		c.Server.RequireIfMatch = test.Require
+		var body string
+		if test.Method == "PUT" {
+			body = put
+		}
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		request.Header.Set("Authorization", auth)
+		if test.IfMatch != "" {
+			request.Header.Set(HeaderIfMatch, test.IfMatch)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, "HTTP code not as expected (%s)", test.Name)
+		assert.Equal(t, test.ETag, response.Header().Get(HeaderETag), "ETag not as expected (%s)", test.Name)
+		assert.Equal(t, test.ResponseString, response.Body.String(), "Response not as expected (%s)", test.Name)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
 	"io"
 	"net/http"
 	"strconv"
@@ -21,27 +23,22 @@ const (
 )
 
 type accountClass struct {
-	ID    int    `json:"ID,omitempty"`
-	Class string `json:"Class,omitempty"`
+	ID      int    `json:"ID,omitempty"`
+	Class   string `json:"Class,omitempty"`
+	Version int64  `json:"-"`
 }
 
 type accountClassList struct {
 	AccountClasses []accountClass `json:"AccountClasses"`
+	listPage
 }
 
-func listAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func listAccountClassFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stmtKey := database.StmtKeyAcctClassSelectList
-		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for listing account classes not found")
-			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
-			return
-		}
-		stmt := (*stmtMap)[stmtKey]
-		rows, err := stmt.Query()
-		if err != nil {
-			c.ApplicationLogf("error retrieving account classes from database: %v", err)
-			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAcctClass, "account classes")
+		if !ok {
 			return
 		}
 		defer rows.Close()
@@ -50,19 +47,23 @@ func listAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.Hand
 			var a accountClass
 			err := rows.Scan(&a.ID, &a.Class)
 			if err != nil {
-				c.ApplicationLogf("error processing rows of account classes from database: %v", err)
+				requestLogger(r, c).Errorf("error processing rows of account classes from database: %v", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 				return
 			}
 			as.AccountClasses = append(as.AccountClasses, a)
 		}
+		as.listPage = newListPage(lq.Offset, len(as.AccountClasses), total)
 		respondWithJSON(w, http.StatusOK, as)
 		return
 	})
 }
 
-func getAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func getAccountClassFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountClassID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "class ID not in request")
@@ -70,60 +71,95 @@ func getAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.Handl
 		}
 		stmtKey := database.StmtKeyAcctClassSelect
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account classes not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account classes not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
 		var a accountClass
-		err := stmt.QueryRow(id).Scan(&a.ID, &a.Class)
+		err := database.QueryRow(r.Context(), stmt, id).Scan(&a.ID, &a.Class, &a.Version)
 		if err != nil {
-			c.ApplicationLogf("error processing account class from database: %v", err)
+			requestLogger(r, c).Errorf("error processing account class from database: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
+		setETag(w, a.Version)
 		respondWithJSON(w, http.StatusOK, a)
 		return
 	})
 }
 
-func updateAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateAccountClassFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, i, ok := accountClassID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "class ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountClass, strconv.Itoa(i), "account class") {
+			return
+		}
 		a, err := accountClassFromRequest(c, r)
 		if err != nil || a.ID != i {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctClassVersion, strconv.Itoa(i), "Account Class")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountClass, strconv.Itoa(i))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctClassUpdate
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for updating an account class not found")
+			requestLogger(r, c).Errorf("error, prepared statement for updating an account class not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Class, a.ID)
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Class, a.ID, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for updating account class: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for updating account class: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
-		if i, e := res.RowsAffected(); i != 1 || e != nil {
-			c.ApplicationLogf("error unexpected result from database update of account class: expected (1) row affected, got (%d); error: %v", i, e)
+		n, e := res.RowsAffected()
+		if e == nil && n == 0 && v != 0 {
+			// Changed by another request since the version was checked.
+			respondPreconditionFailed(w, 0, "Account Class", strconv.Itoa(i))
+			return
+		}
+		if n != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of account class: expected (1) row affected, got (%d); error: %v", n, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountClass, strconv.Itoa(i), history.ActionUpdate, before) {
+			return
+		}
+		if v != 0 {
+			setETag(w, v+1)
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account class %d updated.", a.ID))
 		return
 	})
 }
 
-func createAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createAccountClassFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a, err := accountClassFromRequest(c, r)
 		if err != nil {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
@@ -133,12 +169,12 @@ func createAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.Ha
 		// Check it does not already exist
 		stmtKey := database.StmtKeyAcctClassByName
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account status by name not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account status by name not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		err = stmt.QueryRow(a.Class).Scan()
+		err = database.QueryRow(r.Context(), stmt, a.Class).Scan()
 		if err != sql.ErrNoRows {
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountClassAlreadyExists, fmt.Sprintf("Account class with name %s already exists.", a.Class))
 			return
@@ -146,20 +182,25 @@ func createAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.Ha
 
 		stmtKey = database.StmtKeyAcctClassInsert
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for creating an account class not found")
+			requestLogger(r, c).Errorf("error, prepared statement for creating an account class not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt = (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Class)
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Class)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for creating account class: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for creating account class: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil || (i != 1 && i != 0) {
-			c.ApplicationLogf("error unexpected result from database for creating account class: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for creating account class: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
@@ -167,81 +208,116 @@ func createAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.Ha
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountClassAlreadyExists, fmt.Sprintf("Account class with name %s already exists.", a.Class))
 			return
 		}
+		id, e := res.LastInsertId()
+		if e != nil {
+			requestLogger(r, c).Errorf("error reading ID of new account class for its history: %v", e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, e.Error())
+			return
+		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountClass, strconv.FormatInt(id, 10), history.ActionCreate, nil) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account class %s created.", a.Class))
 		return
 	})
 }
 
-func deleteAccountClassFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteAccountClassFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountClassID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "Account class ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountClass, strconv.Itoa(id), "account class") {
+			return
+		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctClassVersion, strconv.Itoa(id), "Account Class")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountClass, strconv.Itoa(id))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctClassDelete
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for deleting an account class not found")
+			requestLogger(r, c).Errorf("error, prepared statement for deleting an account class not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(id)
+		res, err := database.TxExec(r.Context(), tx, stmt, id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for deleting account class: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for deleting account class: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil {
-			c.ApplicationLogf("error unexpected result from database for deleting account class: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting account class: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if i != 1 && v != 0 {
+			respondPreconditionFailed(w, 0, "Account Class", strconv.Itoa(id))
+			return
+		}
 		if i != 1 {
 			respondGeneric(w, http.StatusNotFound, appcodes.AccountClassUnknown, "Account class ID not found.")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountClass, strconv.Itoa(id), history.ActionDelete, before) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account class with ID %d deleted.", id))
 		return
 	})
 }
 
-func getAccountClassRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func getAccountClassRoutes(c *config.Config, db database.Source, sy *catalogue.Syncer) []Route {
 	return []Route{
 		{
 			Name:           "AccountClassAllList",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accountclass",
-			HandlerFunc:    listAccountClassFunc(c, stmtMap),
+			HandlerFunc:    listAccountClassFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountClassGet",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accountclass/{" + MuxVarAccountClassID + ":[0-9]+}",
-			HandlerFunc:    getAccountClassFunc(c, stmtMap),
+			HandlerFunc:    getAccountClassFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountClassUpdate",
 			Method:         "PUT",
 			Pattern:        "/" + APIVersion + "/accountclass/{" + MuxVarAccountClassID + ":[0-9]+}",
-			HandlerFunc:    updateAccountClassFunc(c, stmtMap),
+			HandlerFunc:    updateAccountClassFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountClassDelete",
 			Method:         "DELETE",
 			Pattern:        "/" + APIVersion + "/accountclass/{" + MuxVarAccountClassID + ":[0-9]+}",
-			HandlerFunc:    deleteAccountClassFunc(c, stmtMap),
+			HandlerFunc:    deleteAccountClassFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountClassCreate",
 			Method:         "POST",
 			Pattern:        "/" + APIVersion + "/accountclass",
-			HandlerFunc:    createAccountClassFunc(c, stmtMap),
+			HandlerFunc:    createAccountClassFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -251,6 +327,13 @@ func getAccountClassRoutes(c *config.Config, stmtMap *database.StmtMap) []Route
 			HandlerFunc:    MethodNotAllowed(),
 			Authentication: true,
 		},
+		{
+			Name:           "AccountClassHistory",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/accountclass/{" + MuxVarAccountClassID + ":[0-9]+}/history",
+			HandlerFunc:    getHistoryFunc(c, db, catalogue.KindAccountClass, MuxVarAccountClassID),
+			Authentication: true,
+		},
 	}
 }
 
@@ -270,7 +353,7 @@ func accountClassFromRequest(c *config.Config, r *http.Request) (a accountClass,
 	dec := json.NewDecoder(reader)
 	err = dec.Decode(&a)
 	if err != nil {
-		c.ApplicationLogf("error dcoding provided JSON into accountClass: %v", err)
+		requestLogger(r, c).Errorf("error dcoding provided JSON into accountClass: %v", err)
 	}
 	return
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
 	"github.com/jcmturner/awsfederation/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -17,10 +18,10 @@ import (
 )
 
 func TestAccountClass(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -36,12 +37,12 @@ func TestAccountClass(t *testing.T) {
 		// Handle create duplicate
 		{"POST", AccountClassAPI, true, "", fmt.Sprintf(AccountClassPOSTTmpl, test.AccountClassName1), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "Account class with name "+test.AccountClassName1+" already exists.", http.StatusBadRequest, appcodes.AccountClassAlreadyExists)},
 		// List 1 entry
-		{"GET", AccountClassAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountClasses":[{"ID":%d,"Class":"%s"}]}`, test.AccountClassID1, test.AccountClassName1)},
+		{"GET", AccountClassAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountClasses":[{"ID":%d,"Class":"%s"}],"Total":1}`, test.AccountClassID1, test.AccountClassName1)},
 		// Get
 		{"GET", AccountClassAPI, false, "/1", "", http.StatusOK, fmt.Sprintf(`{"ID":%d,"Class":"%s"}`, test.AccountClassID1, test.AccountClassName1)},
 		{"POST", AccountClassAPI, true, "", fmt.Sprintf(AccountClassPOSTTmpl, test.AccountClassName2), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account class "+test.AccountClassName2+" created.", http.StatusOK, appcodes.Info)},
 		//// List multiple
-		{"GET", AccountClassAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountClasses":[{"ID":%d,"Class":"%s"},{"ID":%d,"Class":"%s"}]}`, test.AccountClassID1, test.AccountClassName1, test.AccountClassID2, test.AccountClassName2)},
+		{"GET", AccountClassAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountClasses":[{"ID":%d,"Class":"%s"},{"ID":%d,"Class":"%s"}],"Total":2}`, test.AccountClassID1, test.AccountClassName1, test.AccountClassID2, test.AccountClassName2)},
 		//// Method not allowed
 		{"POST", AccountClassAPI, true, "/1", fmt.Sprintf(AccountClassPOSTTmpl, "somethingelse"), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
 		{"DELETE", AccountClassAPI, true, "/2", "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account class with ID 2 deleted.", http.StatusOK, appcodes.Info)},
@@ -50,28 +51,44 @@ func TestAccountClass(t *testing.T) {
 	}
 	// Set the expected database calls that are performed as part of the table tests
 	ep[database.StmtKeyAcctClassByName].ExpectQuery().WithArgs(test.AccountClassName1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctClassInsert].ExpectExec().WithArgs(test.AccountClassName1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassInsert].ExpectExec().WithArgs(test.AccountClassName1).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(1, test.AccountClassName1, 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "1", history.ActionCreate)
 
 	rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
 	ep[database.StmtKeyAcctClassByName].ExpectQuery().WithArgs(test.AccountClassName1).WillReturnRows(rows)
 
 	rows = sqlmock.NewRows([]string{"id", "class"}).
 		AddRow(1, test.AccountClassName1)
-	ep[database.StmtKeyAcctClassSelectList].ExpectQuery().WillReturnRows(rows)
+	database.ExpectList(mock, database.ListAcctClass, 1, rows)
 
-	rows = sqlmock.NewRows([]string{"id", "class"}).
-		AddRow(1, test.AccountClassName1)
+	rows = sqlmock.NewRows([]string{"id", "class", "version"}).
+		AddRow(1, test.AccountClassName1, 1)
 	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs(test.AccountClassID1).WillReturnRows(rows)
 
 	ep[database.StmtKeyAcctClassByName].ExpectQuery().WithArgs(test.AccountClassName2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctClassInsert].ExpectExec().WithArgs(test.AccountClassName2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassInsert].ExpectExec().WithArgs(test.AccountClassName2).WillReturnResult(sqlmock.NewResult(2, 1))
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(2, test.AccountClassName2, 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "2", history.ActionCreate)
 	rows2 := sqlmock.NewRows([]string{"id", "class"}).
 		AddRow(1, test.AccountClassName1).
 		AddRow(2, test.AccountClassName2)
-	ep[database.StmtKeyAcctClassSelectList].ExpectQuery().WillReturnRows(rows2)
-	ep[database.StmtKeyAcctClassDelete].ExpectExec().WithArgs(test.AccountClassID2).WillReturnResult(sqlmock.NewResult(0, 1))
-	ep[database.StmtKeyAcctClassDelete].ExpectExec().WithArgs(test.AccountClassID2).WillReturnResult(sqlmock.NewResult(0, 0))
-	ep[database.StmtKeyAcctClassUpdate].ExpectExec().WithArgs("somethingelse", test.AccountClassID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	database.ExpectList(mock, database.ListAcctClass, 2, rows2)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(2, test.AccountClassName2, 1))
+	ep[database.StmtKeyAcctClassDelete].ExpectExec().WithArgs(test.AccountClassID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "2", history.ActionDelete)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}))
+	ep[database.StmtKeyAcctClassDelete].ExpectExec().WithArgs(test.AccountClassID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(1, test.AccountClassName1, 1))
+	ep[database.StmtKeyAcctClassUpdate].ExpectExec().WithArgs("somethingelse", test.AccountClassID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(1, "somethingelse", 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "1", history.ActionUpdate)
 
 	for _, test := range tests {
 		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
@@ -93,3 +110,57 @@ func TestAccountClass(t *testing.T) {
 		assert.Equal(t, test.ResponseString, response.Body.String(), fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
 	}
 }
+
+func TestAccountClass_IfMatch(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret))
+	url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s/%d", APIVersion, AccountClassAPI, test.AccountClassID1)
+	put := fmt.Sprintf(AccountClassPUTTmpl, test.AccountClassID1, "somethingelse")
+
+	var tests = []struct {
+		Name           string
+		Method         string
+		IfMatch        string
+		HttpCode       int
+		ETag           string
+		ResponseString string
+	}{
+		{"get", "GET", "", http.StatusOK, `"3"`, fmt.Sprintf(`{"ID":%d,"Class":"%s"}`, test.AccountClassID1, test.AccountClassName1)},
+		{"update current version", "PUT", `"3"`, http.StatusOK, `"4"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("Account class %d updated.", test.AccountClassID1), http.StatusOK, appcodes.Info)},
+		{"delete old version", "DELETE", `"3"`, http.StatusPreconditionFailed, `"4"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("The Account Class %d has been modified.", test.AccountClassID1), http.StatusPreconditionFailed, appcodes.PreconditionFailed)},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	cols := []string{"id", "class", "version"}
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs(test.AccountClassID1).WillReturnRows(sqlmock.NewRows(cols).AddRow(1, test.AccountClassName1, 3))
+	ep[database.StmtKeyAcctClassVersion].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows(cols).AddRow(1, test.AccountClassName1, 3))
+	ep[database.StmtKeyAcctClassUpdate].ExpectExec().WithArgs("somethingelse", test.AccountClassID1, 3, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows(cols).AddRow(1, "somethingelse", 4))
+	expectHistory(mock, ep, history.ResourceAccountClass, "1", history.ActionUpdate)
+	ep[database.StmtKeyAcctClassVersion].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+
+	for _, test := range tests {
+		var body string
+		if test.Method == "PUT" {
+			body = put
+		}
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		request.Header.Set("Authorization", auth)
+		if test.IfMatch != "" {
+			request.Header.Set(HeaderIfMatch, test.IfMatch)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, "HTTP code not as expected (%s)", test.Name)
+		assert.Equal(t, test.ETag, response.Header().Get(HeaderETag), "ETag not as expected (%s)", test.Name)
+		assert.Equal(t, test.ResponseString, response.Body.String(), "Response not as expected (%s)", test.Name)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
 	"io"
 	"net/http"
 	"strconv"
@@ -21,27 +23,22 @@ const (
 )
 
 type accountStatus struct {
-	ID     int    `json:"ID,omitempty"`
-	Status string `json:"Status"`
+	ID      int    `json:"ID,omitempty"`
+	Status  string `json:"Status"`
+	Version int64  `json:"-"`
 }
 
 type accountStatusList struct {
 	AccountStatuses []accountStatus `json:"AccountStatuses"`
+	listPage
 }
 
-func listAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func listAccountStatusFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stmtKey := database.StmtKeyAcctStatusSelectList
-		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for listing account statuses not found")
-			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
-			return
-		}
-		stmt := (*stmtMap)[stmtKey]
-		rows, err := stmt.Query()
-		if err != nil {
-			c.ApplicationLogf("error retrieving account statuses from database: %v", err)
-			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAcctStatus, "account statuses")
+		if !ok {
 			return
 		}
 		defer rows.Close()
@@ -50,19 +47,23 @@ func listAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.Han
 			var a accountStatus
 			err := rows.Scan(&a.ID, &a.Status)
 			if err != nil {
-				c.ApplicationLogf("error processing rows of account statuses from database: %v", err)
+				requestLogger(r, c).Errorf("error processing rows of account statuses from database: %v", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 				return
 			}
 			as.AccountStatuses = append(as.AccountStatuses, a)
 		}
+		as.listPage = newListPage(lq.Offset, len(as.AccountStatuses), total)
 		respondWithJSON(w, http.StatusOK, as)
 		return
 	})
 }
 
-func getAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func getAccountStatusFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountStatusID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "status ID not in request")
@@ -70,60 +71,95 @@ func getAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.Hand
 		}
 		stmtKey := database.StmtKeyAcctStatusSelect
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account statuses not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account statuses not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
 		var a accountStatus
-		err := stmt.QueryRow(id).Scan(&a.ID, &a.Status)
+		err := database.QueryRow(r.Context(), stmt, id).Scan(&a.ID, &a.Status, &a.Version)
 		if err != nil {
-			c.ApplicationLogf("error processing account status from database: %v", err)
+			requestLogger(r, c).Errorf("error processing account status from database: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
+		setETag(w, a.Version)
 		respondWithJSON(w, http.StatusOK, a)
 		return
 	})
 }
 
-func updateAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateAccountStatusFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, i, ok := accountStatusID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "status ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountStatus, strconv.Itoa(i), "account status") {
+			return
+		}
 		a, err := accountStatusFromRequest(c, r)
 		if err != nil || a.ID != i {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctStatusVersion, strconv.Itoa(i), "Account Status")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountStatus, strconv.Itoa(i))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctStatusUpdate
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for updating an account status not found")
+			requestLogger(r, c).Errorf("error, prepared statement for updating an account status not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Status, a.ID)
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Status, a.ID, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for updating account status: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for updating account status: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
-		if i, e := res.RowsAffected(); i != 1 || e != nil {
-			c.ApplicationLogf("error unexpected result from database update of account status: expected (1) row affected, got (%d); error: %v", i, e)
+		n, e := res.RowsAffected()
+		if e == nil && n == 0 && v != 0 {
+			// Changed by another request since the version was checked.
+			respondPreconditionFailed(w, 0, "Account Status", strconv.Itoa(i))
+			return
+		}
+		if n != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of account status: expected (1) row affected, got (%d); error: %v", n, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountStatus, strconv.Itoa(i), history.ActionUpdate, before) {
+			return
+		}
+		if v != 0 {
+			setETag(w, v+1)
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account status %d updated.", a.ID))
 		return
 	})
 }
 
-func createAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createAccountStatusFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a, err := accountStatusFromRequest(c, r)
 		if err != nil {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
@@ -133,12 +169,12 @@ func createAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.H
 		// Check it does not already exist
 		stmtKey := database.StmtKeyAcctStatusByName
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account status by name not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account status by name not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		err = stmt.QueryRow(a.Status).Scan()
+		err = database.QueryRow(r.Context(), stmt, a.Status).Scan()
 		if err != sql.ErrNoRows {
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountStatusAlreadyExists, fmt.Sprintf("Account status with name %s already exists.", a.Status))
 			return
@@ -146,20 +182,25 @@ func createAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.H
 
 		stmtKey = database.StmtKeyAcctStatusInsert
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for creating an account status not found")
+			requestLogger(r, c).Errorf("error, prepared statement for creating an account status not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt = (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Status)
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Status)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for creating account status: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for creating account status: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil || (i != 1 && i != 0) {
-			c.ApplicationLogf("error unexpected result from database for creating account status: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for creating account status: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
@@ -167,81 +208,116 @@ func createAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.H
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountStatusAlreadyExists, fmt.Sprintf("Account status with name %s already exists.", a.Status))
 			return
 		}
+		id, e := res.LastInsertId()
+		if e != nil {
+			requestLogger(r, c).Errorf("error reading ID of new account status for its history: %v", e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, e.Error())
+			return
+		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountStatus, strconv.FormatInt(id, 10), history.ActionCreate, nil) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account status %s created.", a.Status))
 		return
 	})
 }
 
-func deleteAccountStatusFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteAccountStatusFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountStatusID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "Account status ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountStatus, strconv.Itoa(id), "account status") {
+			return
+		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctStatusVersion, strconv.Itoa(id), "Account Status")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountStatus, strconv.Itoa(id))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctStatusDelete
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for deleting an account status not found")
+			requestLogger(r, c).Errorf("error, prepared statement for deleting an account status not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(id)
+		res, err := database.TxExec(r.Context(), tx, stmt, id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for deleting account status: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for deleting account status: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil {
-			c.ApplicationLogf("error unexpected result from database for deleting account status: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting account status: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if i != 1 && v != 0 {
+			respondPreconditionFailed(w, 0, "Account Status", strconv.Itoa(id))
+			return
+		}
 		if i != 1 {
 			respondGeneric(w, http.StatusNotFound, appcodes.AccountStatusUnknown, "Account status ID not found.")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountStatus, strconv.Itoa(id), history.ActionDelete, before) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account status with ID %d deleted.", id))
 		return
 	})
 }
 
-func getAccountStatusRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func getAccountStatusRoutes(c *config.Config, db database.Source, sy *catalogue.Syncer) []Route {
 	return []Route{
 		{
 			Name:           "AccountStatusAllList",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accountstatus",
-			HandlerFunc:    listAccountStatusFunc(c, stmtMap),
+			HandlerFunc:    listAccountStatusFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountStatusGet",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accountstatus/{" + MuxVarAccountStatusID + ":[0-9]+}",
-			HandlerFunc:    getAccountStatusFunc(c, stmtMap),
+			HandlerFunc:    getAccountStatusFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountStatusUpdate",
 			Method:         "PUT",
 			Pattern:        "/" + APIVersion + "/accountstatus/{" + MuxVarAccountStatusID + ":[0-9]+}",
-			HandlerFunc:    updateAccountStatusFunc(c, stmtMap),
+			HandlerFunc:    updateAccountStatusFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountStatusDelete",
 			Method:         "DELETE",
 			Pattern:        "/" + APIVersion + "/accountstatus/{" + MuxVarAccountStatusID + ":[0-9]+}",
-			HandlerFunc:    deleteAccountStatusFunc(c, stmtMap),
+			HandlerFunc:    deleteAccountStatusFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountStatusCreate",
 			Method:         "POST",
 			Pattern:        "/" + APIVersion + "/accountstatus",
-			HandlerFunc:    createAccountStatusFunc(c, stmtMap),
+			HandlerFunc:    createAccountStatusFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -251,6 +327,13 @@ func getAccountStatusRoutes(c *config.Config, stmtMap *database.StmtMap) []Route
 			HandlerFunc:    MethodNotAllowed(),
 			Authentication: true,
 		},
+		{
+			Name:           "AccountStatusHistory",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/accountstatus/{" + MuxVarAccountStatusID + ":[0-9]+}/history",
+			HandlerFunc:    getHistoryFunc(c, db, catalogue.KindAccountStatus, MuxVarAccountStatusID),
+			Authentication: true,
+		},
 	}
 }
 
@@ -270,7 +353,7 @@ func accountStatusFromRequest(c *config.Config, r *http.Request) (a accountStatu
 	dec := json.NewDecoder(reader)
 	err = dec.Decode(&a)
 	if err != nil {
-		c.ApplicationLogf("error dcoding provided JSON into accountStatus: %v", err)
+		requestLogger(r, c).Errorf("error dcoding provided JSON into accountStatus: %v", err)
 	}
 	return
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
 	"github.com/jcmturner/awsfederation/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -17,10 +18,10 @@ import (
 )
 
 func TestAccountStatus(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -36,12 +37,12 @@ func TestAccountStatus(t *testing.T) {
 		// Handle create duplicate
 		{"POST", AccountStatusAPI, true, "", fmt.Sprintf(AccountStatusPOSTTmpl, test.AccountStatusName1), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "Account status with name "+test.AccountStatusName1+" already exists.", http.StatusBadRequest, appcodes.AccountStatusAlreadyExists)},
 		// List 1 entry
-		{"GET", AccountStatusAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountStatuses":[{"ID":%d,"Status":"%s"}]}`, test.AccountStatusID1, test.AccountStatusName1)},
+		{"GET", AccountStatusAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountStatuses":[{"ID":%d,"Status":"%s"}],"Total":1}`, test.AccountStatusID1, test.AccountStatusName1)},
 		// Get
 		{"GET", AccountStatusAPI, false, "/1", "", http.StatusOK, fmt.Sprintf(`{"ID":%d,"Status":"%s"}`, test.AccountStatusID1, test.AccountStatusName1)},
 		{"POST", AccountStatusAPI, true, "", fmt.Sprintf(AccountStatusPOSTTmpl, test.AccountStatusName2), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account status "+test.AccountStatusName2+" created.", http.StatusOK, appcodes.Info)},
 		// List multiple
-		{"GET", AccountStatusAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountStatuses":[{"ID":%d,"Status":"%s"},{"ID":%d,"Status":"%s"}]}`, test.AccountStatusID1, test.AccountStatusName1, test.AccountStatusID2, test.AccountStatusName2)},
+		{"GET", AccountStatusAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountStatuses":[{"ID":%d,"Status":"%s"},{"ID":%d,"Status":"%s"}],"Total":2}`, test.AccountStatusID1, test.AccountStatusName1, test.AccountStatusID2, test.AccountStatusName2)},
 		// Method not allowed
 		{"POST", AccountStatusAPI, true, "/1", fmt.Sprintf(AccountStatusPOSTTmpl, "somethingelse"), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
 		{"DELETE", AccountStatusAPI, true, "/2", "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account status with ID 2 deleted.", http.StatusOK, appcodes.Info)},
@@ -50,7 +51,10 @@ func TestAccountStatus(t *testing.T) {
 	}
 	// Set the expected database calls that are performed as part of the table tests
 	ep[database.StmtKeyAcctStatusByName].ExpectQuery().WithArgs(test.AccountStatusName1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctStatusInsert].ExpectExec().WithArgs(test.AccountStatusName1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctStatusInsert].ExpectExec().WithArgs(test.AccountStatusName1).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}).AddRow(1, test.AccountStatusName1, 1))
+	expectHistory(mock, ep, history.ResourceAccountStatus, "1", history.ActionCreate)
 
 	rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
 	ep[database.StmtKeyAcctStatusByName].ExpectQuery().WithArgs(test.AccountStatusName1).WillReturnRows(rows)
@@ -58,22 +62,35 @@ func TestAccountStatus(t *testing.T) {
 
 	rows = sqlmock.NewRows([]string{"id", "status"}).
 		AddRow(1, test.AccountStatusName1)
-	ep[database.StmtKeyAcctStatusSelectList].ExpectQuery().WillReturnRows(rows)
+	database.ExpectList(mock, database.ListAcctStatus, 1, rows)
 
-	rows = sqlmock.NewRows([]string{"id", "status"}).
-		AddRow(1, test.AccountStatusName1)
+	rows = sqlmock.NewRows([]string{"id", "status", "version"}).
+		AddRow(1, test.AccountStatusName1, 1)
 	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs(test.AccountStatusID1).WillReturnRows(rows)
 
 	ep[database.StmtKeyAcctStatusByName].ExpectQuery().WithArgs(test.AccountStatusName2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctStatusInsert].ExpectExec().WithArgs(test.AccountStatusName2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctStatusInsert].ExpectExec().WithArgs(test.AccountStatusName2).WillReturnResult(sqlmock.NewResult(2, 1))
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}).AddRow(2, test.AccountStatusName2, 1))
+	expectHistory(mock, ep, history.ResourceAccountStatus, "2", history.ActionCreate)
 
 	rows2 := sqlmock.NewRows([]string{"id", "status"}).
 		AddRow(1, test.AccountStatusName1).
 		AddRow(2, test.AccountStatusName2)
-	ep[database.StmtKeyAcctStatusSelectList].ExpectQuery().WillReturnRows(rows2)
-	ep[database.StmtKeyAcctStatusDelete].ExpectExec().WithArgs(test.AccountStatusID2).WillReturnResult(sqlmock.NewResult(0, 1))
-	ep[database.StmtKeyAcctStatusDelete].ExpectExec().WithArgs(test.AccountStatusID2).WillReturnResult(sqlmock.NewResult(0, 0))
-	ep[database.StmtKeyAcctStatusUpdate].ExpectExec().WithArgs("somethingelse", test.AccountStatusID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	database.ExpectList(mock, database.ListAcctStatus, 2, rows2)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}).AddRow(2, test.AccountStatusName2, 1))
+	ep[database.StmtKeyAcctStatusDelete].ExpectExec().WithArgs(test.AccountStatusID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccountStatus, "2", history.ActionDelete)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}))
+	ep[database.StmtKeyAcctStatusDelete].ExpectExec().WithArgs(test.AccountStatusID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}).AddRow(1, test.AccountStatusName1, 1))
+	ep[database.StmtKeyAcctStatusUpdate].ExpectExec().WithArgs("somethingelse", test.AccountStatusID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctStatusSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version"}).AddRow(1, "somethingelse", 1))
+	expectHistory(mock, ep, history.ResourceAccountStatus, "1", history.ActionUpdate)
 
 	for _, test := range tests {
 		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
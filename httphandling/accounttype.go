@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
 	"io"
 	"net/http"
 	"strconv"
@@ -22,28 +24,23 @@ const (
 )
 
 type accountType struct {
-	ID    int          `json:"ID,omitempty"`
-	Type  string       `json:"Type"`
-	Class accountClass `json:"Class,omitempty"`
+	ID      int          `json:"ID,omitempty"`
+	Type    string       `json:"Type"`
+	Class   accountClass `json:"Class,omitempty"`
+	Version int64        `json:"-"`
 }
 
 type accountTypeList struct {
 	AccountTypes []accountType `json:"AccountTypes"`
+	listPage
 }
 
-func listAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func listAccountTypeFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stmtKey := database.StmtKeyAcctTypeSelectList
-		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for listing account types not found")
-			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
-			return
-		}
-		stmt := (*stmtMap)[stmtKey]
-		rows, err := stmt.Query()
-		if err != nil {
-			c.ApplicationLogf("error retrieving account types from database: %v", err)
-			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListAcctType, "account types")
+		if !ok {
 			return
 		}
 		defer rows.Close()
@@ -52,19 +49,23 @@ func listAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.Handl
 			var a accountType
 			err := rows.Scan(&a.ID, &a.Type, &a.Class.ID)
 			if err != nil {
-				c.ApplicationLogf("error processing rows of account types from database: %v", err)
+				requestLogger(r, c).Errorf("error processing rows of account types from database: %v", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 				return
 			}
 			as.AccountTypes = append(as.AccountTypes, a)
 		}
+		as.listPage = newListPage(lq.Offset, len(as.AccountTypes), total)
 		respondWithJSON(w, http.StatusOK, as)
 		return
 	})
 }
 
-func getAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func getAccountTypeFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountTypeID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "type ID not in request")
@@ -72,60 +73,95 @@ func getAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.Handle
 		}
 		stmtKey := database.StmtKeyAcctTypeSelect
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account types not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account types not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
 		var a accountType
-		err := stmt.QueryRow(id).Scan(&a.ID, &a.Type, &a.Class.ID)
+		err := database.QueryRow(r.Context(), stmt, id).Scan(&a.ID, &a.Type, &a.Class.ID, &a.Version)
 		if err != nil {
-			c.ApplicationLogf("error processing account type from database: %v", err)
+			requestLogger(r, c).Errorf("error processing account type from database: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
+		setETag(w, a.Version)
 		respondWithJSON(w, http.StatusOK, a)
 		return
 	})
 }
 
-func updateAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateAccountTypeFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, i, ok := accountTypeID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "type ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountType, strconv.Itoa(i), "account type") {
+			return
+		}
 		a, err := accountTypeFromRequest(c, r)
 		if err != nil || a.ID != i {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctTypeVersion, strconv.Itoa(i), "Account Type")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountType, strconv.Itoa(i))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctTypeUpdate
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for updating an account type not found")
+			requestLogger(r, c).Errorf("error, prepared statement for updating an account type not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Type, a.ID, a.Class.ID)
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Type, a.Class.ID, a.ID, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for updating account Type: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for updating account Type: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
-		if i, e := res.RowsAffected(); i != 1 || e != nil {
-			c.ApplicationLogf("error unexpected result from database update of account type: expected (1) row affected, got (%d); error: %v", i, e)
+		n, e := res.RowsAffected()
+		if e == nil && n == 0 && v != 0 {
+			// Changed by another request since the version was checked.
+			respondPreconditionFailed(w, 0, "Account Type", strconv.Itoa(i))
+			return
+		}
+		if n != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of account type: expected (1) row affected, got (%d); error: %v", n, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountType, strconv.Itoa(i), history.ActionUpdate, before) {
+			return
+		}
+		if v != 0 {
+			setETag(w, v+1)
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account Type %d updated.", a.ID))
 		return
 	})
 }
 
-func createAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createAccountTypeFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a, err := accountTypeFromRequest(c, r)
 		if err != nil {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
@@ -135,12 +171,12 @@ func createAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.Han
 		// Check it does not already exist
 		stmtKey := database.StmtKeyAcctTypeByName
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting an account type by name not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting an account type by name not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		err = stmt.QueryRow(a.Type).Scan()
+		err = database.QueryRow(r.Context(), stmt, a.Type).Scan()
 		if err != sql.ErrNoRows {
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountTypeAlreadyExists, fmt.Sprintf("Account Type with name %s already exists.", a.Type))
 			return
@@ -148,20 +184,25 @@ func createAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.Han
 
 		stmtKey = database.StmtKeyAcctTypeInsert
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for creating an account type not found")
+			requestLogger(r, c).Errorf("error, prepared statement for creating an account type not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt = (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.Type, a.Class.ID)
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, a.Type, a.Class.ID)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for creating account type: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for creating account type: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil || (i != 1 && i != 0) {
-			c.ApplicationLogf("error unexpected result from database for creating account type: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for creating account type: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
@@ -169,81 +210,116 @@ func createAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.Han
 			respondGeneric(w, http.StatusBadRequest, appcodes.AccountTypeAlreadyExists, fmt.Sprintf("Account Type with name %s already exists.", a.Type))
 			return
 		}
+		id, e := res.LastInsertId()
+		if e != nil {
+			requestLogger(r, c).Errorf("error reading ID of new account type for its history: %v", e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, e.Error())
+			return
+		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountType, strconv.FormatInt(id, 10), history.ActionCreate, nil) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account Type %s created.", a.Type))
 		return
 	})
 }
 
-func deleteAccountTypeFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteAccountTypeFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		_, id, ok := accountTypeID(r)
 		if !ok {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "account type ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindAccountType, strconv.Itoa(id), "account type") {
+			return
+		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyAcctTypeVersion, strconv.Itoa(id), "Account Type")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindAccountType, strconv.Itoa(id))
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyAcctTypeDelete
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for deleting an account Type not found")
+			requestLogger(r, c).Errorf("error, prepared statement for deleting an account Type not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(id)
+		res, err := database.TxExec(r.Context(), tx, stmt, id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for deleting account Type: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for deleting account Type: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil {
-			c.ApplicationLogf("error unexpected result from database for deleting account type: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting account type: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if i != 1 && v != 0 {
+			respondPreconditionFailed(w, 0, "Account Type", strconv.Itoa(id))
+			return
+		}
 		if i != 1 {
 			respondGeneric(w, http.StatusNotFound, appcodes.AccountTypeUnknown, "Account Type ID not found.")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindAccountType, strconv.Itoa(id), history.ActionDelete, before) {
+			return
+		}
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Account Type with ID %d deleted.", id))
 		return
 	})
 }
 
-func getAccountTypeRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func getAccountTypeRoutes(c *config.Config, db database.Source, sy *catalogue.Syncer) []Route {
 	return []Route{
 		{
 			Name:           "AccountTypeAllList",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accounttype",
-			HandlerFunc:    listAccountTypeFunc(c, stmtMap),
+			HandlerFunc:    listAccountTypeFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountTypeGet",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/accounttype/{" + MuxVarAccountTypeID + ":[0-9]+}",
-			HandlerFunc:    getAccountTypeFunc(c, stmtMap),
+			HandlerFunc:    getAccountTypeFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "AccountTypeUpdate",
 			Method:         "PUT",
 			Pattern:        "/" + APIVersion + "/accounttype/{" + MuxVarAccountTypeID + ":[0-9]+}",
-			HandlerFunc:    updateAccountTypeFunc(c, stmtMap),
+			HandlerFunc:    updateAccountTypeFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountTypeDelete",
 			Method:         "DELETE",
 			Pattern:        "/" + APIVersion + "/accounttype/{" + MuxVarAccountTypeID + ":[0-9]+}",
-			HandlerFunc:    deleteAccountTypeFunc(c, stmtMap),
+			HandlerFunc:    deleteAccountTypeFunc(c, db, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "AccountTypeCreate",
 			Method:         "POST",
 			Pattern:        "/" + APIVersion + "/accounttype",
-			HandlerFunc:    createAccountTypeFunc(c, stmtMap),
+			HandlerFunc:    createAccountTypeFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -253,6 +329,13 @@ func getAccountTypeRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
 			HandlerFunc:    MethodNotAllowed(),
 			Authentication: true,
 		},
+		{
+			Name:           "AccountTypeHistory",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/accounttype/{" + MuxVarAccountTypeID + ":[0-9]+}/history",
+			HandlerFunc:    getHistoryFunc(c, db, catalogue.KindAccountType, MuxVarAccountTypeID),
+			Authentication: true,
+		},
 	}
 }
 
@@ -272,7 +355,7 @@ func accountTypeFromRequest(c *config.Config, r *http.Request) (a accountType, e
 	dec := json.NewDecoder(reader)
 	err = dec.Decode(&a)
 	if err != nil {
-		c.ApplicationLogf("error decoding provided JSON into accountType: %v", err)
+		requestLogger(r, c).Errorf("error decoding provided JSON into accountType: %v", err)
 	}
 	return
 }
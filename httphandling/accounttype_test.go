@@ -7,6 +7,7 @@ import (
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
 	"github.com/jcmturner/awsfederation/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -17,10 +18,10 @@ import (
 )
 
 func TestAccountType(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -36,12 +37,12 @@ func TestAccountType(t *testing.T) {
 		// Handle create duplicate
 		{"POST", AccountTypeAPI, true, "", fmt.Sprintf(AccountTypePOSTTmpl, test.AccountTypeName1, test.AccountClassID1), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "Account Type with name "+test.AccountTypeName1+" already exists.", http.StatusBadRequest, appcodes.AccountTypeAlreadyExists)},
 		// List 1 entry
-		{"GET", AccountTypeAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountTypes":[`+AccountTypeGETTmpl+`]}`, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1)},
+		{"GET", AccountTypeAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountTypes":[`+AccountTypeGETTmpl+`],"Total":1}`, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1)},
 		// Get
 		{"GET", AccountTypeAPI, false, "/1", "", http.StatusOK, fmt.Sprintf(AccountTypeGETTmpl, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1)},
 		{"POST", AccountTypeAPI, true, "", fmt.Sprintf(AccountTypePOSTTmpl, test.AccountTypeName2, test.AccountClassID2), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account Type "+test.AccountTypeName2+" created.", http.StatusOK, appcodes.Info)},
 		//// List multiple
-		{"GET", AccountTypeAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountTypes":[`+AccountTypeGETTmpl+","+AccountTypeGETTmpl+`]}`, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2)},
+		{"GET", AccountTypeAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"AccountTypes":[`+AccountTypeGETTmpl+","+AccountTypeGETTmpl+`],"Total":2}`, test.AccountTypeID1, test.AccountTypeName1, test.AccountClassID1, test.AccountTypeID2, test.AccountTypeName2, test.AccountClassID2)},
 		//// Method not allowed
 		{"POST", AccountTypeAPI, true, "/1", fmt.Sprintf(AccountTypePOSTTmpl, "somethingelse", test.AccountClassID1), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
 		{"DELETE", AccountTypeAPI, true, "/2", "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account Type with ID 2 deleted.", http.StatusOK, appcodes.Info)},
@@ -50,29 +51,45 @@ func TestAccountType(t *testing.T) {
 	}
 	// Set the expected database calls that are performed as part of the table tests
 	ep[database.StmtKeyAcctTypeByName].ExpectQuery().WithArgs(test.AccountTypeName1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctTypeInsert].ExpectExec().WithArgs(test.AccountTypeName1, test.AccountClassID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctTypeInsert].ExpectExec().WithArgs(test.AccountTypeName1, test.AccountClassID1).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).AddRow(1, test.AccountTypeName1, test.AccountClassID1, 1))
+	expectHistory(mock, ep, history.ResourceAccountType, "1", history.ActionCreate)
 
 	rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
 	ep[database.StmtKeyAcctTypeByName].ExpectQuery().WithArgs(test.AccountTypeName1).WillReturnRows(rows)
 
 	rows = sqlmock.NewRows([]string{"id", "type", "class_id"}).
 		AddRow(1, test.AccountTypeName1, test.AccountClassID1)
-	ep[database.StmtKeyAcctTypeSelectList].ExpectQuery().WillReturnRows(rows)
+	database.ExpectList(mock, database.ListAcctType, 1, rows)
 
-	rows = sqlmock.NewRows([]string{"id", "type", "class_id"}).
-		AddRow(1, test.AccountTypeName1, test.AccountClassID1)
+	rows = sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).
+		AddRow(1, test.AccountTypeName1, test.AccountClassID1, 1)
 	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs(test.AccountTypeID1).WillReturnRows(rows)
 
 	ep[database.StmtKeyAcctTypeByName].ExpectQuery().WithArgs(test.AccountTypeName2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-	ep[database.StmtKeyAcctTypeInsert].ExpectExec().WithArgs(test.AccountTypeName2, test.AccountClassID2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctTypeInsert].ExpectExec().WithArgs(test.AccountTypeName2, test.AccountClassID2).WillReturnResult(sqlmock.NewResult(2, 1))
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).AddRow(2, test.AccountTypeName2, test.AccountClassID2, 1))
+	expectHistory(mock, ep, history.ResourceAccountType, "2", history.ActionCreate)
 
 	rows = sqlmock.NewRows([]string{"id", "type", "class_id"}).
 		AddRow(1, test.AccountTypeName1, test.AccountClassID1).
 		AddRow(2, test.AccountTypeName2, test.AccountClassID2)
-	ep[database.StmtKeyAcctTypeSelectList].ExpectQuery().WillReturnRows(rows)
-	ep[database.StmtKeyAcctTypeDelete].ExpectExec().WithArgs(test.AccountTypeID2).WillReturnResult(sqlmock.NewResult(0, 1))
-	ep[database.StmtKeyAcctTypeDelete].ExpectExec().WithArgs(test.AccountTypeID2).WillReturnResult(sqlmock.NewResult(0, 0))
-	ep[database.StmtKeyAcctTypeUpdate].ExpectExec().WithArgs("somethingelse", test.AccountTypeID1, test.AccountClassID2).WillReturnResult(sqlmock.NewResult(0, 1))
+	database.ExpectList(mock, database.ListAcctType, 2, rows)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).AddRow(2, test.AccountTypeName2, test.AccountClassID2, 1))
+	ep[database.StmtKeyAcctTypeDelete].ExpectExec().WithArgs(test.AccountTypeID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccountType, "2", history.ActionDelete)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}))
+	ep[database.StmtKeyAcctTypeDelete].ExpectExec().WithArgs(test.AccountTypeID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).AddRow(1, test.AccountTypeName1, test.AccountClassID1, 1))
+	ep[database.StmtKeyAcctTypeUpdate].ExpectExec().WithArgs("somethingelse", test.AccountClassID2, test.AccountTypeID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctTypeSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "class_id", "version"}).AddRow(1, "somethingelse", test.AccountClassID2, 1))
+	expectHistory(mock, ep, history.ResourceAccountType, "1", history.ActionUpdate)
 
 	for _, test := range tests {
 		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
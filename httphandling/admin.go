@@ -0,0 +1,56 @@
+package httphandling
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"net/http"
+	"strings"
+)
+
+// Reloader reloads the server's configuration.
+type Reloader func() (config.ReloadReport, error)
+
+func reloadConfigFunc(c *config.Config, reload Reloader) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reload == nil {
+			respondGeneric(w, http.StatusNotImplemented, appcodes.ServerConfigurationError, "configuration reload is not available")
+			return
+		}
+		rep, err := reload()
+		msg := fmt.Sprintf("configuration reloaded. Settings requiring a restart: %s", strings.Join(rep.RestartRequired, ", "))
+		if err != nil {
+			msg = fmt.Sprintf("configuration reload failed: %v", err)
+		}
+		if l, e := newAuditLogLine("Configuration Reload", c); e == nil {
+			if u, e := GetIdentity(r.Context()); e == nil {
+				l.Username = u.UserName()
+				l.UserDomain = u.Domain()
+				l.UserSessionID = u.SessionID()
+			}
+			l.Outcome = config.AuditOutcomeSuccess
+			if err != nil {
+				l.Outcome = config.AuditOutcomeFailure
+			}
+			auditLog(l, msg, r, c)
+		}
+		if err != nil {
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, msg)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, rep)
+		return
+	})
+}
+
+func getAdminRoutes(c *config.Config, reload Reloader) []Route {
+	return []Route{
+		{
+			Name:           "ConfigReload",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/admin/reload",
+			HandlerFunc:    reloadConfigFunc(c, reload),
+			Authentication: true,
+		},
+	}
+}
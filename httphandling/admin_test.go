@@ -0,0 +1,52 @@
+package httphandling
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	c, db, _, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	var reloadErr error
+	reload := func() (config.ReloadReport, error) {
+		return config.ReloadReport{
+			Reloaded:        []string{"Server.Logging"},
+			RestartRequired: []string{"Server.Socket"},
+		}, reloadErr
+	}
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc, Reload: reload})
+
+	request, _ := http.NewRequest("POST", "/"+APIVersion+"/admin/reload", nil)
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "configuration reload should require authentication")
+
+	request, _ = http.NewRequest("POST", "/"+APIVersion+"/admin/reload", nil)
+	request.SetBasicAuth("testuser@TESTING", config.MockStaticSecret)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "status code not as expected")
+	var rep config.ReloadReport
+	if err := json.Unmarshal(response.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("could not unmarshal reload response: %v", err)
+	}
+	assert.Equal(t, []string{"Server.Socket"}, rep.RestartRequired, "settings requiring a restart not as expected")
+
+	reloadErr = errors.New("invalid configuration")
+	request, _ = http.NewRequest("POST", "/"+APIVersion+"/admin/reload", nil)
+	request.SetBasicAuth("testuser@TESTING", config.MockStaticSecret)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusInternalServerError, response.Code, "status code not as expected when reload fails")
+	assert.Equal(t, `{"Message":"configuration reload failed: invalid configuration","HTTPCode":500,"ApplicationCode":5}`, response.Body.String(), "response not as expected when reload fails")
+}
@@ -6,29 +6,51 @@ import (
 	"github.com/jcmturner/awsfederation/appcodes"
 	"github.com/jcmturner/awsfederation/assumerole"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/ratelimit"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 const (
-	MuxVarRoleUUID = "roleUUID"
+	MuxVarRoleUUID     = "roleUUID"
+	QueryJustification = "justification"
 )
 
-func getAssumeRoleFunc(c *config.Config, stmtMap *database.StmtMap, fc *federationuser.FedUserCache) http.HandlerFunc {
+func getAssumeRoleFunc(c *config.Config, db database.Source, fc *federationuser.FedUserCache, rl *ratelimit.Limiter, cc *credcache.Cache) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		roleID := requestToRoleUUID(r)
 		u, err := GetIdentity(r.Context())
 		if err != nil {
 			respondUnauthorized(w, c)
 			return
 		}
-		o, err := assumerole.Federate(u, roleID, *stmtMap, fc, c)
+		o, err := assumerole.Federate(r.Context(), u, roleID, r.URL.Query().Get(QueryJustification), *stmtMap, fc, rl, cc, c)
 		if err != nil {
 			if e, NotAuthz := err.(appcodes.ErrUnauthorized); NotAuthz {
 				respondGeneric(w, http.StatusUnauthorized, e.AppCode, e.Error())
 				return
 			}
+			if e, limited := err.(appcodes.ErrRateLimited); limited {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+				respondGeneric(w, http.StatusTooManyRequests, e.AppCode, e.Error())
+				return
+			}
+			if e, bg := err.(appcodes.ErrBreakGlass); bg {
+				switch e.AppCode {
+				case appcodes.BreakGlassNotJustified:
+					respondGeneric(w, http.StatusBadRequest, e.AppCode, e.Error())
+				default:
+					respondGeneric(w, http.StatusUnauthorized, e.AppCode, e.Error())
+				}
+				return
+			}
 			respondGeneric(w, http.StatusInternalServerError, appcodes.AssumeRoleError, err.Error())
 			return
 		}
@@ -37,13 +59,13 @@ func getAssumeRoleFunc(c *config.Config, stmtMap *database.StmtMap, fc *federati
 	})
 }
 
-func getAssumeRoleRoutes(c *config.Config, stmtMap *database.StmtMap, fc *federationuser.FedUserCache) []Route {
+func getAssumeRoleRoutes(c *config.Config, db database.Source, fc *federationuser.FedUserCache, rl *ratelimit.Limiter, cc *credcache.Cache) []Route {
 	return []Route{
 		{
 			Name:           "AssumeRoleGet",
 			Method:         "GET",
-			Pattern:        fmt.Sprintf(`/%s/assumerole/{%s}:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}`, APIVersion, MuxVarRoleUUID),
-			HandlerFunc:    getAssumeRoleFunc(c, stmtMap, fc),
+			Pattern:        fmt.Sprintf(`/%s/assumerole/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarRoleUUID),
+			HandlerFunc:    getAssumeRoleFunc(c, db, fc, rl, cc),
 			Authentication: true,
 		},
 	}
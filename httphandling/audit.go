@@ -0,0 +1,112 @@
+package httphandling
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditAPI             = "audit"
+	FilterUsername       = "username"
+	FilterUserDomain     = "domain"
+	FilterEventType      = "eventtype"
+	FilterRoleMapping    = "rolemapping"
+	FilterFederationUser = "federationuser"
+	FilterOutcome        = "outcome"
+	FilterFrom           = "from"
+	FilterTo             = "to"
+)
+
+type auditEventList struct {
+	AuditEvents []auditstore.Event `json:"AuditEvents"`
+	// NextPage is the value of the page query parameter to request the next page of events. It is empty when there
+	// are no more events.
+	NextPage string `json:"NextPage,omitempty"`
+}
+
+// auditFilter returns the filter defined by the query parameters. The time range is given as RFC3339 times.
+func auditFilter(q url.Values) (f auditstore.Filter, err error) {
+	f = auditstore.Filter{
+		Username:       q.Get(FilterUsername),
+		UserDomain:     q.Get(FilterUserDomain),
+		EventType:      q.Get(FilterEventType),
+		RoleMappingID:  q.Get(FilterRoleMapping),
+		AccountID:      q.Get(FilterAccountIDs),
+		FederationUser: q.Get(FilterFederationUser),
+		Outcome:        q.Get(FilterOutcome),
+	}
+	if v := q.Get(FilterFrom); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			err = fmt.Errorf("%s must be an RFC3339 time", FilterFrom)
+			return
+		}
+	}
+	if v := q.Get(FilterTo); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			err = fmt.Errorf("%s must be an RFC3339 time", FilterTo)
+			return
+		}
+	}
+	if v := q.Get(QueryLimit); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > auditstore.MaxLimit {
+			err = fmt.Errorf("%s must be a number from 1 to %d", QueryLimit, auditstore.MaxLimit)
+			return
+		}
+	}
+	if q.Get(QueryPage) != "" {
+		if f.Before, err = pagePosition(q); err != nil || f.Before < 1 {
+			err = fmt.Errorf("%s is not valid", QueryPage)
+			return
+		}
+	}
+	return
+}
+
+func listAuditEventFunc(c *config.Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := auditstore.ForConfig(c)
+		if !ok {
+			respondGeneric(w, http.StatusNotImplemented, appcodes.ServerConfigurationError, "the audit store is not enabled")
+			return
+		}
+		f, err := auditFilter(r.URL.Query())
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+			return
+		}
+		es, err := s.List(r.Context(), f)
+		if err != nil {
+			requestLogger(r, c).Errorf("error retrieving audit events from database: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		l := auditEventList{AuditEvents: es}
+		limit := f.Limit
+		if limit < 1 {
+			limit = auditstore.DefaultLimit
+		}
+		if len(es) == limit {
+			l.NextPage = pageCursor(es[len(es)-1].ID)
+		}
+		respondWithJSON(w, http.StatusOK, l)
+		return
+	})
+}
+
+func getAuditRoutes(c *config.Config) []Route {
+	return []Route{
+		{
+			Name:           "AuditEventList",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/" + AuditAPI,
+			HandlerFunc:    listAuditEventFunc(c),
+			Authentication: true,
+		},
+	}
+}
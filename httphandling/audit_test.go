@@ -0,0 +1,109 @@
+package httphandling
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuditFilter(t *testing.T) {
+	q := url.Values{}
+	q.Set(FilterUsername, "testuser")
+	q.Set(FilterAccountIDs, test.AWSAccountID1)
+	q.Set(FilterOutcome, config.AuditOutcomeFailure)
+	q.Set(FilterFrom, "2017-06-01T00:00:00Z")
+	q.Set(QueryLimit, "10")
+	q.Set(QueryPage, pageCursor(25))
+	f, err := auditFilter(q)
+	if err != nil {
+		t.Fatalf("error parsing filter: %v", err)
+	}
+	assert.Equal(t, "testuser", f.Username, "username not as expected")
+	assert.Equal(t, test.AWSAccountID1, f.AccountID, "account not as expected")
+	assert.Equal(t, config.AuditOutcomeFailure, f.Outcome, "outcome not as expected")
+	assert.Equal(t, time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC), f.From, "from not as expected")
+	assert.True(t, f.To.IsZero(), "to should not be set")
+	assert.Equal(t, 10, f.Limit, "limit not as expected")
+	assert.Equal(t, int64(25), f.Before, "page not as expected")
+
+	for _, v := range []struct{ key, value string }{
+		{FilterFrom, "yesterday"},
+		{FilterTo, "2017-06-01"},
+		{QueryLimit, "0"},
+		{QueryLimit, "100000"},
+		{QueryPage, "abc"},
+	} {
+		q := url.Values{}
+		q.Set(v.key, v.value)
+		_, err := auditFilter(q)
+		assert.Error(t, err, "expected error for %s=%s", v.key, v.value)
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	c, db, _, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret))
+
+	// Audit store not enabled
+	request, _ := http.NewRequest("GET", "/"+APIVersion+"/"+AuditAPI, nil)
+	request.Header.Set("Authorization", auth)
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusNotImplemented, response.Code, "expected not implemented when the audit store is not enabled")
+
+	auditstore.Set(c, auditstore.New(&database.Conn{DB: db, Stmts: stmtMap}))
+	defer auditstore.Release(c)
+	// The authentication of each request is also recorded in the audit store.
+	args := make([]driver.Value, 12)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	now := time.Now().UTC()
+	cols := []string{"id", "uuid", "time", "event_type", "username", "user_domain", "user_session_id", "severity", "outcome", "roleMapping_id", "account_id", "federation_user", "detail"}
+	ep[database.StmtKeyAuditEventInsert].ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyAuditEventSelectList].ExpectQuery().WillReturnRows(sqlmock.NewRows(cols).
+		AddRow(7, test.UUID4, now, "AssumeRoleFederation", "testuser", "TESTING", "00000000-0000-0000-0000-000000000000", "", config.AuditOutcomeSuccess, test.UUID3, test.AWSAccountID1, test.FedUserArn1, `{"Successful":true}`).
+		AddRow(6, test.UUID5, now, "AssumeRoleFederation", "testuser", "TESTING", "00000000-0000-0000-0000-000000000000", "", config.AuditOutcomeFailure, test.UUID3, test.AWSAccountID1, "", nil))
+
+	request, _ = http.NewRequest("GET", "/"+APIVersion+"/"+AuditAPI+"?"+FilterRoleMapping+"="+test.UUID3+"&"+QueryLimit+"=2", nil)
+	request.Header.Set("Authorization", auth)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "expected status OK")
+	var l auditEventList
+	if err := json.NewDecoder(response.Body).Decode(&l); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, l.AuditEvents, 2, "number of events not as expected") {
+		assert.Equal(t, test.FedUserArn1, l.AuditEvents[0].FederationUser, "federation user not as expected")
+		assert.Equal(t, config.AuditOutcomeFailure, l.AuditEvents[1].Outcome, "outcome not as expected")
+	}
+	assert.Equal(t, pageCursor(6), l.NextPage, "next page not as expected")
+
+	// Invalid filter
+	ep[database.StmtKeyAuditEventInsert].ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(2, 1))
+	request, _ = http.NewRequest("GET", "/"+APIVersion+"/"+AuditAPI+"?"+FilterFrom+"=yesterday", nil)
+	request.Header.Set("Authorization", auth)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusBadRequest, response.Code, "expected bad request for an invalid filter")
+	var r JSONGenericResponse
+	json.NewDecoder(response.Body).Decode(&r)
+	assert.Equal(t, appcodes.BadData, r.ApplicationCode, "application code not as expected")
+}
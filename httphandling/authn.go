@@ -6,7 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/breakglass"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/metrics"
+	"github.com/jcmturner/awsfederation/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	goidentity "gopkg.in/jcmturner/goidentity.v1"
 	"gopkg.in/jcmturner/gokrb5.v4/service"
 	"gopkg.in/ldap.v2"
@@ -30,16 +36,25 @@ func AuthnHandler(inner http.Handler, c *config.Config) http.Handler {
 			respondGeneric(w, http.StatusInternalServerError, appcodes.AuthenticationError, "Error processing authentication")
 		}
 
+		// The span covers establishing the identity of the user, not the handling of the request that follows.
+		actx, span := tracing.Start(r.Context(), "authenticate")
+		defer span.End()
+
 		var id goidentity.Identity
 		if sid, ok, _ := getSession(r, c); ok {
 			// Request contains cookie for a valid session. Use ID from session cache.
 			id = sid
 			auditLine.EventType = "Authenication via session"
+			span.SetAttributes(attribute.String("auth.mechanism", "Session"))
+			metrics.ObserveAuthentication("Session", metrics.OutcomeSuccess)
 		} else {
 			// Get the authenticator based on what the client specifies in the Authorization header and the server's configuration
-			authenticator, err := getAuthenticator(r, c)
+			authenticator, err := getAuthenticator(r.WithContext(actx), c)
 			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				metrics.ObserveAuthentication("Unsupported", metrics.OutcomeFailure)
 				auditLine.EventType = "Failed Authentication"
+				auditLine.Outcome = config.AuditOutcomeFailure
 				auditLog(auditLine, err.Error(), r, c)
 				respondUnauthorized(w, c)
 				return
@@ -47,32 +62,51 @@ func AuthnHandler(inner http.Handler, c *config.Config) http.Handler {
 
 			// Authenitcate the user
 			var authed bool
+			span.SetAttributes(attribute.String("auth.mechanism", authenticator.Mechanism()))
 			id, authed, err = authenticator.Authenticate()
 			if err != nil {
+				tracing.End(span, err)
 				e := "Authentication error with mechanism " + authenticator.Mechanism()
-				c.ApplicationLogf("%s: %v", e, err)
+				metrics.ObserveAuthentication(authenticator.Mechanism(), metrics.OutcomeError)
+				requestLogger(r, c).Error(e, "error", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.AuthenticationError, e)
 				return
 			}
 			if !authed {
+				span.SetStatus(codes.Error, "authentication failed")
+				metrics.ObserveAuthentication(authenticator.Mechanism(), metrics.OutcomeFailure)
 				auditLine.EventType = "Authentication Failed"
-				auditLog(auditLine, "Client credentials invalid", r, c)
+				auditLine.Outcome = config.AuditOutcomeFailure
+				if _, bg := authenticator.(*BreakGlassAuthenticator); bg {
+					auditLine.EventType = "Break-glass Authentication Failed"
+					alertLog(auditLine, "Client credentials invalid", r, c)
+				} else {
+					auditLog(auditLine, "Client credentials invalid", r, c)
+				}
 				respondUnauthorized(w, c)
 				return
 			}
+			metrics.ObserveAuthentication(authenticator.Mechanism(), metrics.OutcomeSuccess)
 			auditLine.EventType = "Authentication Successful"
 			// Set the session cookie
 			err = setSession(w, id, c)
 			if err != nil {
-				c.ApplicationLogf("error setting user's session: %v", err)
+				requestLogger(r, c).Errorf("error setting user's session: %v", err)
 			}
 		}
 
 		// Audit log
+		auditLine.Outcome = config.AuditOutcomeSuccess
 		auditLine.Username = id.UserName()
 		auditLine.UserDomain = id.Domain()
 		auditLine.UserSessionID = id.SessionID()
-		auditLog(auditLine, "Client credentials valid", r, c)
+		if breakglass.EmergencyIdentity(id, c) {
+			alertLog(auditLine, "Emergency identity in use", r, c)
+		} else {
+			auditLog(auditLine, "Client credentials valid", r, c)
+		}
+
+		span.End()
 
 		// Set the request context
 		ctx := r.Context()
@@ -104,6 +138,14 @@ func getAuthenticator(r *http.Request, c *config.Config) (authenticator goidenti
 		a.SPNEGOHeaderValue = value
 		authenticator = a
 	case AuthMechanismBasic:
+		// Emergency identities must be usable when the configured identity provider is not.
+		if d, _, _, e := ParseBasicHeaderValue(value); e == nil && breakglass.IsDomain(d, c) {
+			a := new(BreakGlassAuthenticator)
+			a.BasicHeaderValue = value
+			a.Config = c
+			authenticator = a
+			return
+		}
 		if !c.Server.Authentication.Basic.Enabled {
 			err = fmt.Errorf("%s mechanism attempted by client but disabled in server configuration", mech)
 			return
@@ -111,6 +153,7 @@ func getAuthenticator(r *http.Request, c *config.Config) (authenticator goidenti
 		switch strings.ToLower(c.Server.Authentication.Basic.Protocol) {
 		case "ldap":
 			a := new(LDAPBasicAuthenticator)
+			a.ctx = r.Context()
 			a.BasicHeaderValue = value
 			a.LDAPConfig = c.Server.Authentication.Basic.LDAP
 			authenticator = a
@@ -130,7 +173,7 @@ func getAuthenticator(r *http.Request, c *config.Config) (authenticator goidenti
 			authenticator = a
 		default:
 			err = fmt.Errorf("Configuration for basic authentication not valid. Protocol specified as: %v", c.Server.Authentication.Basic.Protocol)
-			c.ApplicationLogf(err.Error())
+			requestLogger(r, c).Error(err.Error())
 			return
 		}
 	//case AuthMechanismBearer:
@@ -143,6 +186,7 @@ func getAuthenticator(r *http.Request, c *config.Config) (authenticator goidenti
 }
 
 type LDAPBasicAuthenticator struct {
+	ctx              context.Context
 	BasicHeaderValue string
 	domain           string
 	username         string
@@ -156,7 +200,7 @@ func (a LDAPBasicAuthenticator) Authenticate() (i goidentity.Identity, ok bool,
 		err = fmt.Errorf("could not parse basic authentication header: %v", err)
 		return
 	}
-	err = a.LDAPConfig.LDAPConn.Bind(a.LDAPConfig.BindUserDN, a.LDAPConfig.BindUserPassword)
+	err = a.bind(a.LDAPConfig.BindUserDN, a.LDAPConfig.BindUserPassword)
 	if err != nil {
 		err = fmt.Errorf("could not bind to LDAP as %s: %v", a.LDAPConfig.BindUserDN, err)
 		return
@@ -177,7 +221,10 @@ func (a LDAPBasicAuthenticator) Authenticate() (i goidentity.Identity, ok bool,
 		[]string{"dn", a.LDAPConfig.MembershipAttribute, a.LDAPConfig.DisplayNameAttribute},
 		nil,
 	)
+	_, span := tracing.Start(a.ctx, "ldap.search", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("ldap.base_dn", a.LDAPConfig.BaseDN), attribute.String("ldap.filter", filter)))
 	usRes, err := a.LDAPConfig.LDAPConn.Search(usReq)
+	tracing.End(span, err)
 	if err != nil {
 		err = fmt.Errorf("could not find user %s in LDAP: %v", a.username, err)
 		return
@@ -187,7 +234,7 @@ func (a LDAPBasicAuthenticator) Authenticate() (i goidentity.Identity, ok bool,
 		return
 	}
 
-	err = a.LDAPConfig.LDAPConn.Bind(usRes.Entries[0].DN, a.password)
+	err = a.bind(usRes.Entries[0].DN, a.password)
 	if err != nil {
 		err = fmt.Errorf("authentication failed for user %s: %v", a.username, err)
 		return
@@ -204,6 +251,15 @@ func (a LDAPBasicAuthenticator) Authenticate() (i goidentity.Identity, ok bool,
 	return
 }
 
+// bind binds to the LDAP server as the DN given, recording the bind in a span.
+func (a LDAPBasicAuthenticator) bind(dn, password string) error {
+	_, span := tracing.Start(a.ctx, "ldap.bind", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("ldap.bind_dn", dn)))
+	err := a.LDAPConfig.LDAPConn.Bind(dn, password)
+	tracing.End(span, err)
+	return err
+}
+
 func (a LDAPBasicAuthenticator) Mechanism() string {
 	return "LDAP Basic"
 }
@@ -243,6 +299,39 @@ func (a StaticAuthenticator) Mechanism() string {
 	return "Static Basic"
 }
 
+// BreakGlassAuthenticator authenticates the emergency identities whose secrets are held in the vault.
+type BreakGlassAuthenticator struct {
+	BasicHeaderValue string
+	domain           string
+	username         string
+	password         string
+	Config           *config.Config
+}
+
+func (a BreakGlassAuthenticator) Authenticate() (i goidentity.Identity, ok bool, err error) {
+	a.domain, a.username, a.password, err = ParseBasicHeaderValue(a.BasicHeaderValue)
+	if err != nil {
+		err = fmt.Errorf("could not parse basic authentication header: %v", err)
+		return
+	}
+	if !breakglass.Authenticate(a.username, a.password, a.Config) {
+		return
+	}
+	u := goidentity.NewUser(a.username)
+	u.SetAuthTime(time.Now().UTC())
+	u.SetAuthenticated(true)
+	u.SetDisplayName(a.domain + "@" + a.username)
+	u.SetDomain(a.Config.Server.BreakGlass.Domain)
+	u.SetHuman(true)
+	ok = true
+	i = &u
+	return
+}
+
+func (a BreakGlassAuthenticator) Mechanism() string {
+	return "Break-glass Basic"
+}
+
 func ParseAuthorizationHeader(r *http.Request) (mechanism, value string, err error) {
 	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 	if len(s) != 2 {
@@ -303,6 +392,11 @@ func GetIdentity(ctx context.Context) (id goidentity.Identity, err error) {
 		err = errors.New("no identity found in context")
 		return
 	}
+	// The authentication handler stores the identity as returned by the authenticator.
+	if i, ok := u.(goidentity.Identity); ok {
+		id = i
+		return
+	}
 	v := reflect.Indirect(reflect.New(reflect.TypeOf(u)))
 	v.Set(reflect.ValueOf(u))
 	p := v.Addr().Interface()
@@ -41,6 +41,39 @@ func TestStaticAuthenticator(t *testing.T) {
 	assert.Equal(t, "Static Basic", s.Mechanism(), "Mechanism string not as expected")
 }
 
+func TestBreakGlassAuthenticator(t *testing.T) {
+	var b BreakGlassAuthenticator
+	a := new(goidentity.Authenticator)
+	assert.Implements(t, a, b, "BreakGlassAuthenticator does not implement the goidentity.Authenticator interface")
+	assert.Equal(t, "Break-glass Basic", b.Mechanism(), "Mechanism string not as expected")
+
+	c, _ := config.Mock()
+	c.Server.BreakGlass.Enabled = true
+	c.Server.BreakGlass.Credentials = map[string]string{"emergency1": "sealedsecret"}
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("emergency1@BREAKGLASS:sealedsecret")))
+	authenticator, err := getAuthenticator(r, c)
+	if err != nil {
+		t.Fatalf("error getting authenticator: %v", err)
+	}
+	assert.IsType(t, &BreakGlassAuthenticator{}, authenticator, "break-glass domain should use the break-glass authenticator")
+	id, ok, err := authenticator.Authenticate()
+	if err != nil {
+		t.Fatalf("error authenticating emergency identity: %v", err)
+	}
+	assert.True(t, ok, "emergency identity should be authenticated")
+	assert.Equal(t, "BREAKGLASS", id.Domain(), "emergency identity domain not as expected")
+
+	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("emergency1@BREAKGLASS:wrong")))
+	authenticator, _ = getAuthenticator(r, c)
+	_, ok, _ = authenticator.Authenticate()
+	assert.False(t, ok, "emergency identity should not be authenticated with the wrong secret")
+
+	c.Server.BreakGlass.Enabled = false
+	authenticator, _ = getAuthenticator(r, c)
+	assert.IsType(t, &StaticAuthenticator{}, authenticator, "break-glass authenticator should not be used when disabled")
+}
+
 func TestParseBasicHeaderValue(t *testing.T) {
 	var tests = []struct {
 		testname  string
@@ -0,0 +1,255 @@
+package httphandling
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
+	"github.com/jcmturner/awsfederation/database"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	CatalogueAPI = "catalogue"
+	QueryFormat  = "format"
+	QueryDryRun  = "dryrun"
+	QueryUpsert  = "upsert"
+	QueryPrune   = "prune"
+	// MaxCatalogueSize is the largest catalogue document accepted for import in bytes.
+	MaxCatalogueSize = 8 << 20
+)
+
+func exportCatalogueFunc(c *config.Config, db database.Source) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		format := strings.ToLower(r.URL.Query().Get(QueryFormat))
+		ct := "application/json; charset=UTF-8"
+		switch format {
+		case "", catalogue.FormatJSON:
+			format = catalogue.FormatJSON
+		case catalogue.FormatYAML:
+			ct = "application/yaml; charset=UTF-8"
+		default:
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, fmt.Sprintf("%s must be %s or %s", QueryFormat, catalogue.FormatJSON, catalogue.FormatYAML))
+			return
+		}
+		cat, err := catalogue.Export(r.Context(), conn)
+		if err != nil {
+			requestLogger(r, c).Errorf("error exporting the catalogue: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		b, err := cat.Marshal(format)
+		if err != nil {
+			requestLogger(r, c).Errorf("error marshaling the catalogue: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.CatalogueError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", ct)
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	})
+}
+
+func importCatalogueFunc(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		opts, err := catalogueOptions(r.URL.Query())
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+			return
+		}
+		opts.Actor = historyActor(r)
+		if sy != nil && c.Server.CatalogueSync.ReadOnly && !opts.DryRun {
+			respondGeneric(w, http.StatusForbidden, appcodes.CatalogueManaged, "The catalogue is managed by the catalogue sync and can only be imported as a dry run.")
+			return
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxCatalogueSize+1))
+		r.Body.Close()
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, fmt.Sprintf("error reading the catalogue: %v", err))
+			return
+		}
+		if len(b) > MaxCatalogueSize {
+			respondGeneric(w, http.StatusRequestEntityTooLarge, appcodes.BadData, fmt.Sprintf("The catalogue must not be larger than %d bytes.", MaxCatalogueSize))
+			return
+		}
+		doc, err := catalogue.Parse(b)
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+			return
+		}
+		rep, err := catalogue.Import(r.Context(), conn, doc, opts)
+		if !opts.DryRun {
+			auditCatalogueImport(r, c, rep, err)
+		}
+		if err != nil {
+			if _, ok := err.(catalogue.ValidationError); ok {
+				respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+				return
+			}
+			requestLogger(r, c).Errorf("error importing the catalogue: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		if !opts.DryRun {
+			invalidateImportedCredentials(r, c, cc, rep)
+		}
+		respondWithJSON(w, http.StatusOK, rep)
+		return
+	})
+}
+
+// catalogueOptions returns the options of an import given as query parameters.
+func catalogueOptions(q url.Values) (opts catalogue.Options, err error) {
+	for _, o := range []struct {
+		name string
+		v    *bool
+	}{
+		{QueryDryRun, &opts.DryRun},
+		{QueryUpsert, &opts.Upsert},
+		{QueryPrune, &opts.Prune},
+	} {
+		s := q.Get(o.name)
+		if s == "" {
+			continue
+		}
+		*o.v, err = strconv.ParseBool(s)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be true or false", o.name)
+		}
+	}
+	return
+}
+
+func auditCatalogueImport(r *http.Request, c *config.Config, rep catalogue.Report, err error) {
+	l, e := newAuditLogLine("Catalogue Import", c)
+	if e != nil {
+		return
+	}
+	if u, e := GetIdentity(r.Context()); e == nil {
+		l.Username = u.UserName()
+		l.UserDomain = u.Domain()
+		l.UserSessionID = u.SessionID()
+	}
+	l.Outcome = config.AuditOutcomeSuccess
+	msg := fmt.Sprintf("catalogue imported: %d created, %d updated, %d deleted, %d conflicts", rep.Created, rep.Updated, rep.Deleted, rep.Conflicts)
+	if err != nil {
+		l.Outcome = config.AuditOutcomeFailure
+		msg = fmt.Sprintf("catalogue import failed: %v", err)
+	}
+	auditLog(l, msg, r, c)
+}
+
+// invalidateImportedCredentials removes the cached credentials that an import may have made stale.
+func invalidateImportedCredentials(r *http.Request, c *config.Config, cc *credcache.Cache, rep catalogue.Report) {
+	all, rms := rep.StaleCredentials()
+	if all {
+		invalidateCredentials(r, c, cc, credentialCacheAll, "")
+		return
+	}
+	for _, id := range rms {
+		invalidateCredentials(r, c, cc, credentialCacheRoleMapping, id)
+	}
+}
+
+func catalogueSyncStatusFunc(sy *catalogue.Syncer) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sy == nil {
+			respondGeneric(w, http.StatusNotImplemented, appcodes.ServerConfigurationError, "catalogue sync is not enabled")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, sy.Status())
+		return
+	})
+}
+
+func syncCatalogueFunc(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		if sy == nil {
+			respondGeneric(w, http.StatusNotImplemented, appcodes.ServerConfigurationError, "catalogue sync is not enabled")
+			return
+		}
+		st, err := sy.Sync(r.Context(), conn, c)
+		msg := fmt.Sprintf("catalogue sync of revision %s requested", st.Revision)
+		if err != nil {
+			msg = fmt.Sprintf("catalogue sync failed: %v", err)
+		}
+		if l, e := newAuditLogLine("Catalogue Sync Requested", c); e == nil {
+			if u, e := GetIdentity(r.Context()); e == nil {
+				l.Username = u.UserName()
+				l.UserDomain = u.Domain()
+				l.UserSessionID = u.SessionID()
+			}
+			l.Outcome = config.AuditOutcomeSuccess
+			if err != nil {
+				l.Outcome = config.AuditOutcomeFailure
+			}
+			auditLog(l, msg, r, c)
+		}
+		if err != nil {
+			requestLogger(r, c).Errorf("error syncing the catalogue: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.CatalogueError, msg)
+			return
+		}
+		if !st.Drift.DryRun {
+			invalidateImportedCredentials(r, c, cc, *st.Drift)
+		}
+		respondWithJSON(w, http.StatusOK, st)
+		return
+	})
+}
+
+// readOnly responds that the entity cannot be changed through the API if it is managed by the catalogue sync and
+// managed entities are read-only.
+func readOnly(w http.ResponseWriter, c *config.Config, sy *catalogue.Syncer, kind, id, name string) bool {
+	if !c.Server.CatalogueSync.ReadOnly || !sy.Managed(kind, id) {
+		return false
+	}
+	respondGeneric(w, http.StatusForbidden, appcodes.CatalogueManaged, fmt.Sprintf("The %s %s is managed by the catalogue sync and cannot be changed through the API.", name, id))
+	return true
+}
+
+func getCatalogueRoutes(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) []Route {
+	return []Route{
+		{
+			Name:           "CatalogueExport",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/" + CatalogueAPI,
+			HandlerFunc:    exportCatalogueFunc(c, db),
+			Authentication: true,
+		},
+		{
+			Name:           "CatalogueImport",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/" + CatalogueAPI,
+			HandlerFunc:    importCatalogueFunc(c, db, cc, sy),
+			Authentication: true,
+		},
+		{
+			Name:           "CatalogueSyncStatus",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/" + CatalogueAPI + "/sync",
+			HandlerFunc:    catalogueSyncStatusFunc(sy),
+			Authentication: true,
+		},
+		{
+			Name:           "CatalogueSync",
+			Method:         "POST",
+			Pattern:        "/" + APIVersion + "/" + CatalogueAPI + "/sync",
+			HandlerFunc:    syncCatalogueFunc(c, db, cc, sy),
+			Authentication: true,
+		},
+	}
+}
@@ -0,0 +1,185 @@
+package httphandling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCatalogue(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+
+	var tests = []struct {
+		Method         string
+		Query          string
+		PostPayload    string
+		HttpCode       int
+		ContentType    string
+		ResponseString string
+	}{
+		{"GET", "?format=yaml", "", http.StatusOK, "application/yaml; charset=UTF-8", "AccountClasses: []\nAccountStatuses: []\nAccountTypes: []\nAccounts: []\nFederationUsers: []\nRoleMappings: []\n"},
+		{"GET", "?format=xml", "", http.StatusBadRequest, "application/json; charset=UTF-8", fmt.Sprintf(test.GenericResponseTmpl, "format must be json or yaml", http.StatusBadRequest, appcodes.BadData)},
+		{"POST", "?dryrun=true", "AccountClasses:\n- ID: 1\n  Class: Production\n", http.StatusOK, "application/json; charset=UTF-8",
+			`{"DryRun":true,"Upsert":false,"Prune":false,"Created":1,"Updated":0,"Deleted":0,"Conflicts":0,"Unchanged":0,"Changes":[{"Kind":"AccountClass","ID":"1","Action":"Create","After":{"ID":1,"Class":"Production"}}]}`},
+		{"POST", "", "AccountClasses:\n- ID: 0\n  Class: Production\n", http.StatusBadRequest, "application/json; charset=UTF-8", fmt.Sprintf(test.GenericResponseTmpl, "catalogue not valid: AccountClass 0 must have an ID greater than 0 and a class", http.StatusBadRequest, appcodes.BadData)},
+		{"POST", "?upsert=maybe", "", http.StatusBadRequest, "application/json; charset=UTF-8", fmt.Sprintf(test.GenericResponseTmpl, "upsert must be true or false", http.StatusBadRequest, appcodes.BadData)},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	expectEmptyCatalogue := func() {
+		for _, k := range []int{
+			database.StmtKeyCatalogueAcctClasses,
+			database.StmtKeyCatalogueAcctTypes,
+			database.StmtKeyCatalogueAcctStatuses,
+			database.StmtKeyCatalogueFedUsers,
+			database.StmtKeyCatalogueAccts,
+			database.StmtKeyCatalogueRoleMappings,
+		} {
+			ep[k].ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}
+	}
+	mock.ExpectBegin()
+	expectEmptyCatalogue()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectCatalogueLock(ep)
+	expectEmptyCatalogue()
+	ep[database.StmtKeyCatalogueAcctClassAdd].ExpectExec().WithArgs(1, "Production").WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WithArgs(history.ResourceAccountClass, "1", history.ActionCreate, testActor, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, CatalogueAPI, test.Query)
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(test.PostPayload))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		// Check it was unauthorized before passing auth creds
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Expected unauthorized error")
+		// Now authenticated (using testing static auth)
+		response = httptest.NewRecorder()
+		request, _ = http.NewRequest(test.Method, url, strings.NewReader(test.PostPayload))
+		request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret)))
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, fmt.Sprintf("Expected HTTP code: %d got: %d (%s %s)", test.HttpCode, response.Code, test.Method, url))
+		assert.Equal(t, test.ContentType, response.Header().Get("Content-Type"), fmt.Sprintf("Content type not as expected (%s %s)", test.Method, url))
+		assert.Equal(t, test.ResponseString, response.Body.String(), fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
+
+func TestCatalogue_Sync(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	dir, err := ioutil.TempDir("", "catalogue")
+	if err != nil {
+		t.Fatalf("error creating definitions directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "classes.yaml"), []byte("AccountClasses:\n- ID: 1\n  Class: Production\n"), 0644)
+	c.Server.CatalogueSync.ReadOnly = true
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret))
+
+	// Without a syncer the sync is not available
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	request, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:8443/%s/%s/sync", APIVersion, CatalogueAPI), nil)
+	request.Header.Set("Authorization", auth)
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusNotImplemented, response.Code, "sync should not be available without a syncer")
+
+	sy := catalogue.NewSyncer(dir)
+	rt = NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc, CatalogueSyncer: sy})
+	var tests = []struct {
+		Method         string
+		Path           string
+		PostPayload    string
+		HttpCode       int
+		ResponseString string
+	}{
+		{"PUT", AccountClassAPI + "/1", fmt.Sprintf(AccountClassPUTTmpl, 1, "somethingelse"), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account class 1 updated.", http.StatusOK, appcodes.Info)},
+		{"POST", CatalogueAPI + "/sync", "", http.StatusOK, ""},
+		{"PUT", AccountClassAPI + "/1", fmt.Sprintf(AccountClassPUTTmpl, 1, "somethingelse"), http.StatusForbidden, fmt.Sprintf(test.GenericResponseTmpl, "The account class 1 is managed by the catalogue sync and cannot be changed through the API.", http.StatusForbidden, appcodes.CatalogueManaged)},
+		{"DELETE", AccountClassAPI + "/1", "", http.StatusForbidden, fmt.Sprintf(test.GenericResponseTmpl, "The account class 1 is managed by the catalogue sync and cannot be changed through the API.", http.StatusForbidden, appcodes.CatalogueManaged)},
+		{"DELETE", AccountClassAPI + "/2", "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account class with ID 2 deleted.", http.StatusOK, appcodes.Info)},
+		{"POST", CatalogueAPI, "AccountClasses: []\n", http.StatusForbidden, fmt.Sprintf(test.GenericResponseTmpl, "The catalogue is managed by the catalogue sync and can only be imported as a dry run.", http.StatusForbidden, appcodes.CatalogueManaged)},
+		{"GET", CatalogueAPI + "/sync", "", http.StatusOK, ""},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(1, "Production", 1))
+	ep[database.StmtKeyAcctClassUpdate].ExpectExec().WithArgs("somethingelse", 1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(1, "somethingelse", 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "1", history.ActionUpdate)
+	mock.ExpectBegin()
+	expectCatalogueLock(ep)
+	ep[database.StmtKeyCatalogueAcctClasses].ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id", "class"}).AddRow(1, "somethingelse"))
+	for _, k := range []int{
+		database.StmtKeyCatalogueAcctTypes,
+		database.StmtKeyCatalogueAcctStatuses,
+		database.StmtKeyCatalogueFedUsers,
+		database.StmtKeyCatalogueAccts,
+		database.StmtKeyCatalogueRoleMappings,
+	} {
+		ep[k].ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	ep[database.StmtKeyAcctClassUpdate].ExpectExec().WithArgs("Production", 1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WithArgs(history.ResourceAccountClass, "1", history.ActionUpdate, history.SystemActor, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctClassSelect].ExpectQuery().WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "class", "version"}).AddRow(2, test.AccountClassName2, 1))
+	ep[database.StmtKeyAcctClassDelete].ExpectExec().WithArgs(2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceAccountClass, "2", history.ActionDelete)
+
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s", APIVersion, test.Path)
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(test.PostPayload))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		request.Header.Set("Authorization", auth)
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, fmt.Sprintf("Expected HTTP code: %d got: %d (%s %s)", test.HttpCode, response.Code, test.Method, url))
+		if test.ResponseString != "" {
+			assert.Equal(t, test.ResponseString, response.Body.String(), fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
+			continue
+		}
+		var st catalogue.SyncStatus
+		if err := json.Unmarshal(response.Body.Bytes(), &st); err != nil {
+			t.Fatalf("error decoding sync status: %v", err)
+		}
+		assert.True(t, st.InSync, fmt.Sprintf("catalogue should be in sync (%s %s)", test.Method, url))
+		assert.Equal(t, 1, st.Drift.Updated, fmt.Sprintf("drift not as expected (%s %s)", test.Method, url))
+		assert.Equal(t, 1, st.Managed, fmt.Sprintf("managed entities not as expected (%s %s)", test.Method, url))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
+
+// expectCatalogueLock sets the expectation of an import locking the catalogue.
+func expectCatalogueLock(ep map[int]*sqlmock.ExpectedPrepare) {
+	ep[database.StmtKeyCatalogueLock].ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
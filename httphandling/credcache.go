@@ -0,0 +1,100 @@
+package httphandling
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
+	"net/http"
+)
+
+const (
+	credentialCacheAll            = "all"
+	credentialCacheRoleMapping    = "rolemapping"
+	credentialCacheFederationUser = "federationuser"
+)
+
+type credentialCacheStatus struct {
+	Enabled bool `json:"Enabled"`
+	Entries int  `json:"Entries"`
+}
+
+func getCredentialCacheFunc(c *config.Config, cc *credcache.Cache) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, credentialCacheStatus{
+			Enabled: cc != nil,
+			Entries: cc.Len(),
+		})
+		return
+	})
+}
+
+// deleteCredentialCacheFunc invalidates the credentials cached for the role mapping or federation user given as a query
+// parameter, or all cached credentials if neither is given.
+func deleteCredentialCacheFunc(c *config.Config, cc *credcache.Cache) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		rm, fu := q.Get(credentialCacheRoleMapping), q.Get(credentialCacheFederationUser)
+		var n int
+		switch {
+		case rm != "" && fu != "":
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "only one of a role mapping or federation user can be specified")
+			return
+		case rm != "":
+			n = invalidateCredentials(r, c, cc, credentialCacheRoleMapping, rm)
+		case fu != "":
+			n = invalidateCredentials(r, c, cc, credentialCacheFederationUser, fu)
+		default:
+			n = invalidateCredentials(r, c, cc, credentialCacheAll, "")
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("%d cached credentials invalidated.", n))
+		return
+	})
+}
+
+// invalidateCredentials removes the matching cached credentials and audits the invalidation.
+func invalidateCredentials(r *http.Request, c *config.Config, cc *credcache.Cache, scope, id string) int {
+	if cc == nil {
+		return 0
+	}
+	var n int
+	switch scope {
+	case credentialCacheRoleMapping:
+		n = cc.InvalidateRoleMapping(id)
+	case credentialCacheFederationUser:
+		n = cc.InvalidateFederationUser(id)
+	default:
+		n = cc.InvalidateAll()
+	}
+	l, err := newAuditLogLine("Credential Cache Invalidated", c)
+	if err != nil {
+		return n
+	}
+	if u, err := GetIdentity(r.Context()); err == nil {
+		l.Username = u.UserName()
+		l.UserDomain = u.Domain()
+		l.UserSessionID = u.SessionID()
+	}
+	l.Outcome = config.AuditOutcomeSuccess
+	auditLog(l, fmt.Sprintf("%d cached credentials invalidated for %s %s", n, scope, id), r, c)
+	return n
+}
+
+func getCredentialCacheRoutes(c *config.Config, cc *credcache.Cache) []Route {
+	return []Route{
+		{
+			Name:           "CredentialCacheGet",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/credentialcache",
+			HandlerFunc:    getCredentialCacheFunc(c, cc),
+			Authentication: true,
+		},
+		{
+			Name:           "CredentialCacheDelete",
+			Method:         "DELETE",
+			Pattern:        "/" + APIVersion + "/credentialcache",
+			HandlerFunc:    deleteCredentialCacheFunc(c, cc),
+			Authentication: true,
+		},
+	}
+}
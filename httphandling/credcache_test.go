@@ -0,0 +1,62 @@
+package httphandling
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	awssts "github.com/aws/aws-sdk-go/service/sts"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCredentialCache(t *testing.T) {
+	c, db, _, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	cc, err := credcache.New(time.Minute)
+	if err != nil {
+		t.Fatalf("error creating credential cache: %v", err)
+	}
+	u := goidentity.NewUser("testuser")
+	o := &awssts.AssumeRoleOutput{Credentials: &awssts.Credentials{Expiration: aws.Time(time.Now().UTC().Add(time.Hour))}}
+	cc.Put(&u, test.UUID3, test.RoleARN1, test.FedUserArn1, o)
+	cc.Put(&u, test.UUID4, test.RoleARN1, test.FedUserArn2, o)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc, CredCache: cc})
+
+	var tests = []struct {
+		Method         string
+		Query          string
+		HttpCode       int
+		ResponseString string
+	}{
+		{"GET", "", http.StatusOK, `{"Enabled":true,"Entries":2}`},
+		{"DELETE", "?rolemapping=" + test.UUID3 + "&federationuser=" + test.FedUserArn2, http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "only one of a role mapping or federation user can be specified", http.StatusBadRequest, appcodes.BadData)},
+		{"DELETE", "?rolemapping=" + test.UUID3, http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "1 cached credentials invalidated.", http.StatusOK, appcodes.Info)},
+		{"DELETE", "?federationuser=" + test.FedUserArn2, http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "1 cached credentials invalidated.", http.StatusOK, appcodes.Info)},
+		{"GET", "", http.StatusOK, `{"Enabled":true,"Entries":0}`},
+	}
+	for _, tst := range tests {
+		request, err := http.NewRequest(tst.Method, "/"+APIVersion+"/credentialcache"+tst.Query, nil)
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "expected unauthorized error for %s %s", tst.Method, tst.Query)
+		response = httptest.NewRecorder()
+		request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret)))
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, tst.HttpCode, response.Code, "status code not as expected for %s %s", tst.Method, tst.Query)
+		assert.Equal(t, tst.ResponseString, response.Body.String(), "response not as expected for %s %s", tst.Method, tst.Query)
+	}
+}
@@ -0,0 +1,94 @@
+package httphandling
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// etag returns the entity tag of the version of a resource.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// setETag sets the ETag header of the response to the version of the resource.
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set(HeaderETag, etag(version))
+}
+
+// ifMatchTags returns the entity tags listed in the If-Match headers of the request and whether any version is
+// accepted by a *. Weak tags are dropped as they never match when the resource is to be changed.
+func ifMatchTags(h http.Header) (tags []string, all bool) {
+	for _, v := range h[HeaderIfMatch] {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			switch {
+			case t == "*":
+				all = true
+			case strings.HasPrefix(t, `"`):
+				tags = append(tags, t)
+			}
+		}
+	}
+	return
+}
+
+// ifMatch checks the If-Match precondition of a request to change the resource with the ID given. It returns the
+// version the change must be made to, which is 0 for an unconditional change if the request has no If-Match header
+// and one is not required. The statement given selects the current version of the resource. If the precondition is
+// not met the error response is written and false returned.
+func ifMatch(w http.ResponseWriter, r *http.Request, c *config.Config, stmtMap *database.StmtMap, stmtKey int, id, name string) (int64, bool) {
+	if _, ok := r.Header[HeaderIfMatch]; !ok {
+		if c.Server.RequireIfMatch {
+			respondGeneric(w, http.StatusPreconditionRequired, appcodes.PreconditionRequired, fmt.Sprintf("The ETag of the %s to be changed must be given in the If-Match header.", name))
+			return 0, false
+		}
+		return 0, true
+	}
+	if _, ok := (*stmtMap)[stmtKey]; !ok {
+		requestLogger(r, c).Errorf("error, prepared statement for getting the version of %s not found", name)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+		return 0, false
+	}
+	var v int64
+	err := database.QueryRow(r.Context(), (*stmtMap)[stmtKey], id).Scan(&v)
+	if err == sql.ErrNoRows {
+		respondGeneric(w, http.StatusPreconditionFailed, appcodes.PreconditionFailed, fmt.Sprintf("The %s %s does not exist.", name, id))
+		return 0, false
+	}
+	if err != nil {
+		requestLogger(r, c).Errorf("error getting the version of %s %s from database: %v", name, id, err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return 0, false
+	}
+	tags, all := ifMatchTags(r.Header)
+	if all {
+		return v, true
+	}
+	for _, t := range tags {
+		if t == etag(v) {
+			return v, true
+		}
+	}
+	respondPreconditionFailed(w, v, name, id)
+	return 0, false
+}
+
+// respondPreconditionFailed responds that the resource has been changed since the client retrieved it. The ETag of
+// the current version is returned so that the client can decide whether to retry its change.
+func respondPreconditionFailed(w http.ResponseWriter, version int64, name, id string) {
+	if version > 0 {
+		setETag(w, version)
+	}
+	respondGeneric(w, http.StatusPreconditionFailed, appcodes.PreconditionFailed, fmt.Sprintf("The %s %s has been modified.", name, id))
+}
@@ -6,11 +6,14 @@ import (
 	"github.com/jcmturner/awsarn"
 	"github.com/jcmturner/awsfederation/appcodes"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
-	"github.com/jcmturner/vaultclient"
+	"github.com/jcmturner/awsfederation/secretstore"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 )
 
 const (
@@ -20,6 +23,46 @@ const (
 	FederationUserPOSTTmpl     = "{\"Name\":\"%s\",\"Arn\":\"%s\",\"Credentials\":{\"SecretAccessKey\":\"%s\",\"SessionToken\":\"%s\",\"Expiration\":\"%s\",\"AccessKeyId\":\"%s\"},\"TTL\":%d,\"MFASerialNumber\":\"%s\",\"MFASecret\":\"%s\"}"
 )
 
+type federationUserList struct {
+	federationuser.FederationUserList
+	listPage
+}
+
+// federationUserFields are the fields the list of federation users can be sorted on. The users are held in the secret
+// store rather than the database so the list is sorted and paged here.
+var federationUserFields = map[string]string{"arn": "arn"}
+
+// federationUserPage returns the page of the federation users requested by the query parameters.
+func federationUserPage(q url.Values, us []string) (ul federationUserList, err error) {
+	limit, err := listLimit(q)
+	if err != nil {
+		return
+	}
+	offset, err := listOffset(q)
+	if err != nil {
+		return
+	}
+	order, err := listOrder(q, federationUserFields)
+	if err != nil {
+		return
+	}
+	sort.Strings(us)
+	if len(order) > 0 && order[0].Desc {
+		sort.Sort(sort.Reverse(sort.StringSlice(us)))
+	}
+	page := []string{}
+	if offset < len(us) {
+		end := offset + limit
+		if end > len(us) {
+			end = len(us)
+		}
+		page = us[offset:end]
+	}
+	ul.FederationUsers = page
+	ul.listPage = newListPage(offset, len(page), len(us))
+	return
+}
+
 func listAllFederationUserFunc(c *config.Config) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		al := []string{}
@@ -27,19 +70,16 @@ func listAllFederationUserFunc(c *config.Config) http.HandlerFunc {
 		if as, ok := qv["account"]; ok {
 			al = as
 		} else {
-			cl, err := vaultclient.NewClient(c.Vault.Config, c.Vault.Credentials)
+			s, err := secretstore.ForConfig(c)
 			if err != nil {
-				respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, fmt.Sprintf("Error accessing the vault: %v", err))
+				respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, fmt.Sprintf("Error accessing the secret store: %v", err))
 				return
 			}
-			m, err := cl.List("")
-			if m != nil {
-				keys := m["keys"].([]interface{})
-				for _, v := range keys {
-					a, err := awsarn.Parse(v.(string), nil)
-					if err == nil {
-						al = append(al, a.AccountID)
-					}
+			keys, _ := s.List("")
+			for _, v := range keys {
+				a, err := awsarn.Parse(v, nil)
+				if err == nil {
+					al = append(al, a.AccountID)
 				}
 			}
 		}
@@ -52,8 +92,10 @@ func listAllFederationUserFunc(c *config.Config) http.HandlerFunc {
 			respondGeneric(w, http.StatusNotFound, appcodes.FederationUserUnknown, "No federation users found.")
 			return
 		}
-		ul := federationuser.FederationUserList{
-			FederationUsers: us,
+		ul, err := federationUserPage(r.URL.Query(), us)
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+			return
 		}
 		respondWithJSON(w, http.StatusOK, ul)
 		return
@@ -73,8 +115,10 @@ func listAccountFederationUserFunc(c *config.Config) http.HandlerFunc {
 			respondGeneric(w, http.StatusNotFound, appcodes.FederationUserUnknown, "No federation users found.")
 			return
 		}
-		ul := federationuser.FederationUserList{
-			FederationUsers: us,
+		ul, err := federationUserPage(r.URL.Query(), us)
+		if err != nil {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+			return
 		}
 		respondWithJSON(w, http.StatusOK, ul)
 		return
@@ -84,9 +128,9 @@ func listAccountFederationUserFunc(c *config.Config) http.HandlerFunc {
 func getFederationUserFunc(c *config.Config) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		a := requestToARN(r)
-		u, err := federationuser.LoadFederationUser(c, a)
+		u, err := federationuser.LoadFederationUser(r.Context(), c, a)
 		if err != nil {
-			if _, is404 := err.(vaultclient.ErrSecretNotFound); is404 {
+			if _, is404 := err.(secretstore.ErrSecretNotFound); is404 {
 				respondGeneric(w, http.StatusNotFound, appcodes.FederationUserUnknown, "Federation user not found.")
 				return
 			}
@@ -98,12 +142,15 @@ func getFederationUserFunc(c *config.Config) http.HandlerFunc {
 	})
 }
 
-func updateFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateFederationUserFunc(c *config.Config, db database.Source, cc *credcache.Cache) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a := requestToARN(r)
-		_, err := federationuser.LoadFederationUser(c, a)
+		_, err := federationuser.LoadFederationUser(r.Context(), c, a)
 		if err != nil {
-			if _, is404 := err.(vaultclient.ErrSecretNotFound); is404 {
+			if _, is404 := err.(secretstore.ErrSecretNotFound); is404 {
 				respondGeneric(w, http.StatusNotFound, appcodes.FederationUserUnknown, "Federation user not found.")
 				return
 			}
@@ -121,40 +168,48 @@ func updateFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.
 			respondGeneric(w, http.StatusConflict, appcodes.BadData, "ARN in posted data does not match the API path")
 			return
 		}
-		err = fu.Store(*stmtMap)
+		err = fu.Store(r.Context(), *stmtMap)
 		if err != nil {
 			respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, fmt.Sprintf("Error storing federation user in vault: %v", err))
 			return
 		}
+		invalidateCredentials(r, c, cc, credentialCacheFederationUser, fu.ARNString)
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Federation user %s updated.", fu.ARNString))
 		return
 	})
 }
 
-func deleteFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteFederationUserFunc(c *config.Config, db database.Source, cc *credcache.Cache) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a := requestToARN(r)
-		u, err := federationuser.LoadFederationUser(c, a)
+		u, err := federationuser.LoadFederationUser(r.Context(), c, a)
 		if err != nil {
-			if _, is404 := err.(vaultclient.ErrSecretNotFound); is404 {
+			if _, is404 := err.(secretstore.ErrSecretNotFound); is404 {
 				respondGeneric(w, http.StatusNotFound, appcodes.FederationUserUnknown, "Federation user not found.")
 				return
 			}
 			respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, err.Error())
 			return
 		}
-		err = u.Delete(*stmtMap)
+		err = u.Delete(r.Context(), *stmtMap)
 		if err != nil {
 			respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, err.Error())
 			return
 		}
+		invalidateCredentials(r, c, cc, credentialCacheFederationUser, u.ARNString)
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Federation user %s deleted.", u.ARNString))
 		return
 	})
 }
 
-func createFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createFederationUserFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		reader := io.LimitReader(r.Body, 1024)
 		defer r.Body.Close()
 		fu, err := federationuser.FederationUserFromReader(c, reader)
@@ -162,13 +217,13 @@ func createFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
 			return
 		}
-		_, err = federationuser.LoadFederationUser(c, fu.ARNString)
+		_, err = federationuser.LoadFederationUser(r.Context(), c, fu.ARNString)
 		// Check that the federation user doesn't already exist
 		if err == nil {
 			respondGeneric(w, http.StatusConflict, appcodes.FederationUserAlreadyExists, "Federation user already exists.")
 			return
 		}
-		err = fu.Store(*stmtMap)
+		err = fu.Store(r.Context(), *stmtMap)
 		if err != nil {
 			respondGeneric(w, http.StatusInternalServerError, appcodes.FederationUserError, fmt.Sprintf("Error storing federation user in vault: %v", err))
 			return
@@ -178,7 +233,7 @@ func createFederationUserFunc(c *config.Config, stmtMap *database.StmtMap) http.
 	})
 }
 
-func getFederationUserRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func getFederationUserRoutes(c *config.Config, db database.Source, cc *credcache.Cache) []Route {
 	return []Route{
 		{
 			Name:           "FederationUserAllList",
@@ -205,21 +260,21 @@ func getFederationUserRoutes(c *config.Config, stmtMap *database.StmtMap) []Rout
 			Name:           "FederationUserUpdate",
 			Method:         "PUT",
 			Pattern:        fmt.Sprintf("/"+APIVersion+"/federationuser/"+federationuser.FedUserARNFormat, "{"+MuxVarAccountID+":[0-9]{12}}", "{"+MuxVarUsername+"}"),
-			HandlerFunc:    updateFederationUserFunc(c, stmtMap),
+			HandlerFunc:    updateFederationUserFunc(c, db, cc),
 			Authentication: true,
 		},
 		{
 			Name:           "FederationUserDelete",
 			Method:         "DELETE",
 			Pattern:        fmt.Sprintf("/"+APIVersion+"/federationuser/"+federationuser.FedUserARNFormat, "{"+MuxVarAccountID+":[0-9]{12}}", "{"+MuxVarUsername+"}"),
-			HandlerFunc:    deleteFederationUserFunc(c, stmtMap),
+			HandlerFunc:    deleteFederationUserFunc(c, db, cc),
 			Authentication: true,
 		},
 		{
 			Name:           "FederationUserCreate",
 			Method:         "POST",
 			Pattern:        fmt.Sprintf("/" + APIVersion + "/federationuser"),
-			HandlerFunc:    createFederationUserFunc(c, stmtMap),
+			HandlerFunc:    createFederationUserFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -243,16 +298,13 @@ func getListIAMUsers(c *config.Config, accountIDs []string) ([]string, error) {
 	var iamUsers []string
 	for _, accountID := range accountIDs {
 		iamUserPath := fmt.Sprintf(federationuser.FedUserARNFormat, accountID, "")
-		cl, err := vaultclient.NewClient(c.Vault.Config, c.Vault.Credentials)
+		s, err := secretstore.ForConfig(c)
 		if err != nil {
 			return iamUsers, err
 		}
-		m, _ := cl.List(iamUserPath)
-		if m != nil {
-			keys := m["keys"].([]interface{})
-			for _, v := range keys {
-				iamUsers = append(iamUsers, fmt.Sprintf(federationuser.FedUserARNFormat, accountID, v.(string)))
-			}
+		keys, _ := s.List(iamUserPath)
+		for _, v := range keys {
+			iamUsers = append(iamUsers, fmt.Sprintf(federationuser.FedUserARNFormat, accountID, v))
 		}
 	}
 	return iamUsers, nil
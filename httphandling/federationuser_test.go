@@ -12,16 +12,17 @@ import (
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 )
 
 func TestFederationUser(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, _, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -34,8 +35,8 @@ func TestFederationUser(t *testing.T) {
 	}{
 		{"POST", FederationUserAPI, true, "", fmt.Sprintf(FederationUserPOSTTmpl, test.FedUserName1, test.FedUserArn1, test.IAMUser1SecretAccessKey, test.IAMUser1SessionToken, test.IAMUser1Expiration, test.IAMUser1AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial, test.IAMUser1MFASecret), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Federation user "+test.FedUserArn1+" created.", http.StatusOK, appcodes.Info)},
 		{"GET", FederationUserAPI, false, "/" + test.FedUserArn1, "", http.StatusOK, fmt.Sprintf(FederationUserResponseTmpl, test.FedUserArn1, test.IAMUser1Expiration, test.IAMUser1AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial)},
-		{"GET", FederationUserAPI, false, "", "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"]}"},
-		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID1), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"]}"},
+		{"GET", FederationUserAPI, false, "", "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"],\"Total\":1}"},
+		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID1), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"],\"Total\":1}"},
 		{"GET", FederationUserAPI, false, "/arn:aws:iam::123456789012:user/notexist", "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Federation user not found.", http.StatusNotFound, appcodes.FederationUserUnknown)},
 		{"POST", FederationUserAPI, true, "", fmt.Sprintf(FederationUserPOSTTmpl, test.FedUserName1, test.FedUserArn1, test.IAMUser1SecretAccessKey, test.IAMUser1SessionToken, test.IAMUser1Expiration, test.IAMUser1AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial, test.IAMUser1MFASecret), http.StatusConflict, fmt.Sprintf(test.GenericResponseTmpl, "Federation user already exists.", http.StatusConflict, appcodes.FederationUserAlreadyExists)},
 		{"POST", FederationUserAPI, true, "/" + test.FedUserArn1, fmt.Sprintf(FederationUserPOSTTmpl, test.FedUserName1, test.FedUserArn1, test.IAMUser1SecretAccessKey, test.IAMUser1SessionToken, test.IAMUser1Expiration, test.IAMUser1AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial, test.IAMUser1MFASecret), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
@@ -43,9 +44,9 @@ func TestFederationUser(t *testing.T) {
 		{"DELETE", FederationUserAPI, true, "/" + test.FedUserArn2, "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Federation user "+test.FedUserArn2+" deleted.", http.StatusOK, appcodes.Info)},
 		{"GET", FederationUserAPI, false, "/" + test.FedUserArn2, "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Federation user not found.", http.StatusNotFound, appcodes.FederationUserUnknown)},
 		{"POST", FederationUserAPI, true, "", fmt.Sprintf(FederationUserPOSTTmpl, test.FedUserName2, test.FedUserArn2, test.IAMUser2SecretAccessKey, test.IAMUser2SessionToken, test.IAMUser2Expiration, test.IAMUser2AccessKeyId, test.FedUserTTL2, test.IAMUser2MFASerial, test.IAMUser2MFASecret), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Federation user "+test.FedUserArn2+" created.", http.StatusOK, appcodes.Info)},
-		{"GET", FederationUserAPI, false, "", "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\",\"" + test.FedUserArn2 + "\"]}"},
-		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID1), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"]}"},
-		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID2), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn2 + "\"]}"},
+		{"GET", FederationUserAPI, false, "", "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\",\"" + test.FedUserArn2 + "\"],\"Total\":2}"},
+		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID1), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn1 + "\"],\"Total\":1}"},
+		{"GET", FederationUserAPI, false, fmt.Sprintf("/arn:aws:iam::%s:user", test.AWSAccountID2), "", http.StatusOK, "{\"FederationUsers\":[\"" + test.FedUserArn2 + "\"],\"Total\":1}"},
 		{"PUT", FederationUserAPI, true, "/arn:aws:iam::123456789012:user/blah", fmt.Sprintf(FederationUserPOSTTmpl, "blah", "arn:aws:iam::123456789012:user/blah", test.IAMUser1SecretAccessKey, test.IAMUser1SessionToken, test.IAMUser1Expiration, test.IAMUser1AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial, test.IAMUser1MFASecret), http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Federation user not found.", http.StatusNotFound, appcodes.FederationUserUnknown)},
 		{"PUT", FederationUserAPI, true, "/" + test.FedUserArn1, fmt.Sprintf(FederationUserPOSTTmpl, test.FedUserName1, test.FedUserArn1, test.IAMUser1SecretAccessKey, test.IAMUser1SessionToken, test.IAMUser1Expiration, test.IAMUser2AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial, test.IAMUser1MFASecret), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Federation user "+test.FedUserArn1+" updated.", http.StatusOK, appcodes.Info)},
 		{"GET", FederationUserAPI, false, "/" + test.FedUserArn1, "", http.StatusOK, fmt.Sprintf(FederationUserResponseTmpl, test.FedUserArn1, test.IAMUser1Expiration, test.IAMUser2AccessKeyId, test.FedUserTTL1, test.IAMUser1MFASerial)},
@@ -86,10 +87,42 @@ func TestFederationUser(t *testing.T) {
 	//Test backend storage directly
 	assert.Equal(t, test.FedUserArn2, fu.ARNString, "ARN not stored as expected")
 	assert.Equal(t, test.IAMUser2AccessKeyId, fu.Provider.Credential.AccessKeyId, "ARN not stored as expected")
-	assert.Equal(t, test.IAMUser2SessionToken, fu.Provider.Credential.GetSessionToken(), "SessionToken not stored as expected")
-	assert.Equal(t, test.IAMUser2SecretAccessKey, fu.Provider.Credential.GetSecretAccessKey(), "SecretAccessKey not stored as expected")
+	assert.Equal(t, test.IAMUser2SessionToken, fu.Provider.Credential.SessionToken, "SessionToken not stored as expected")
+	assert.Equal(t, test.IAMUser2SecretAccessKey, fu.Provider.Credential.SecretAccessKey, "SecretAccessKey not stored as expected")
 	et, _ := time.Parse(time.RFC3339, test.IAMUser2Expiration)
 	assert.Equal(t, et, fu.Provider.Credential.Expiration, "Expiration not stored as expected")
 	assert.Equal(t, test.IAMUser2MFASerial, fu.Provider.Credential.MFASerialNumber, "MFA serial not stored as expected")
-	assert.Equal(t, test.IAMUser2MFASecret, fu.Provider.Credential.GetMFASecret(), "MFA secret not stored as expected")
+	assert.Equal(t, test.IAMUser2MFASecret, fu.Provider.Credential.MFASecret, "MFA secret not stored as expected")
+}
+
+func TestFederationUserPage(t *testing.T) {
+	us := []string{test.FedUserArn2, test.FedUserArn1, "arn:aws:iam::345678901234:user/TestFedUser3"}
+	var tests = []struct {
+		Query    string
+		Users    []string
+		Total    int
+		NextPage string
+	}{
+		{"", []string{test.FedUserArn1, test.FedUserArn2, "arn:aws:iam::345678901234:user/TestFedUser3"}, 3, ""},
+		{"limit=2", []string{test.FedUserArn1, test.FedUserArn2}, 3, pageCursor(2)},
+		{"limit=2&page=" + pageCursor(2), []string{"arn:aws:iam::345678901234:user/TestFedUser3"}, 3, ""},
+		{"sort=-arn&limit=1", []string{"arn:aws:iam::345678901234:user/TestFedUser3"}, 3, pageCursor(1)},
+		{"page=" + pageCursor(5), []string{}, 3, ""},
+	}
+	for _, test := range tests {
+		q, _ := url.ParseQuery(test.Query)
+		ul, err := federationUserPage(q, append([]string{}, us...))
+		if err != nil {
+			t.Fatalf("error paging federation users (%s): %v", test.Query, err)
+		}
+		assert.Equal(t, test.Users, ul.FederationUsers, "federation users not as expected (%s)", test.Query)
+		assert.Equal(t, test.Total, ul.Total, "total not as expected (%s)", test.Query)
+		assert.Equal(t, test.NextPage, ul.NextPage, "next page not as expected (%s)", test.Query)
+	}
+	q, _ := url.ParseQuery("sort=name")
+	_, err := federationUserPage(q, us)
+	assert.Error(t, err, "unknown sort field accepted")
+	q, _ = url.ParseQuery("page=2")
+	_, err = federationUserPage(q, us)
+	assert.Error(t, err, "page that is not a cursor accepted")
 }
@@ -3,12 +3,47 @@ package httphandling
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/hashicorp/go-uuid"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/applog"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/metrics"
+	"github.com/jcmturner/awsfederation/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"regexp"
+	"time"
 )
 
-func WrapCommonHandler(inner http.Handler, authn bool, c *config.Config) http.Handler {
+const (
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength limits the length of a request ID accepted from the client.
+	maxRequestIDLength = 128
+)
+
+// validRequestID matches the request IDs accepted from clients so that they cannot be used to inject into the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]+$`)
+
+// requestID returns the ID of the request. The ID sent by the client, for example by a proxy in front of the server,
+// is used if it is valid, otherwise a new ID is generated.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= maxRequestIDLength && validRequestID.MatchString(id) {
+		return id
+	}
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return id
+}
+
+// WrapCommonHandler wraps the route's handler with authentication, if required, access logging, metrics and tracing.
+// Each request is given an ID which is returned in the X-Request-ID response header and recorded in the access, audit
+// and application log records of the request. The request's span continues the W3C trace context sent by the caller.
+func WrapCommonHandler(inner http.Handler, name string, authn bool, c *config.Config) http.Handler {
 
 	//Wrap in authentication
 	if authn {
@@ -18,8 +53,27 @@ func WrapCommonHandler(inner http.Handler, authn bool, c *config.Config) http.Ha
 	inner = accessLogger(inner, c)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w = setHeaders(w)
-		inner.ServeHTTP(w, r)
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method+" "+name, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", name),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("request.id", id),
+			))
+		defer span.End()
+		r = r.WithContext(applog.WithRequestID(ctx, id))
+		ww := NewResponseWriterWrapper(setHeaders(w))
+		inner.ServeHTTP(ww, r)
+		span.SetAttributes(attribute.Int("http.response.status_code", ww.Status()))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
+		metrics.ObserveHTTPRequest(name, r.Method, ww.Status(), time.Since(start))
 		return
 	})
 }
@@ -46,6 +100,13 @@ type JSONCreatedResponse struct {
 }
 
 func respondGeneric(w http.ResponseWriter, httpCode, appCode int, message string) {
+	switch appCode {
+	case appcodes.DatabaseError:
+		metrics.DatabaseErrors.Inc()
+	case appcodes.ServerConfigurationError:
+		// Reported when a prepared statement needed by the handler is not found.
+		metrics.PreparedStatementErrors.Inc()
+	}
 	e := JSONGenericResponse{
 		Message:         message,
 		HTTPCode:        httpCode,
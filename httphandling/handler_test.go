@@ -0,0 +1,133 @@
+package httphandling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWrapCommonHandler_RequestID(t *testing.T) {
+	c, _ := config.Mock()
+	var ab, lb bytes.Buffer
+	c.SetAccessEncoder(json.NewEncoder(&ab))
+	c.SetApplicationLogger(applog.New(&lb, applog.FormatJSON, applog.LevelInfo))
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r, c).Error("something failed")
+		respondGeneric(w, http.StatusInternalServerError, 0, "something failed")
+	})
+	handler := WrapCommonHandler(inner, "Test", false, c)
+
+	var tests = []struct {
+		Name     string
+		Inbound  string
+		Accepted bool
+	}{
+		{"no inbound ID", "", false},
+		{"inbound ID", "proxy-1234.abc", true},
+		{"invalid inbound ID", "bad id\nlevel=error", false},
+		{"inbound ID too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	generated := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	for _, test := range tests {
+		ab.Reset()
+		lb.Reset()
+		request, err := http.NewRequest("GET", "/url", nil)
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		if test.Inbound != "" {
+			request.Header.Set(RequestIDHeader, test.Inbound)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		id := response.Header().Get(RequestIDHeader)
+		if test.Accepted {
+			assert.Equal(t, test.Inbound, id, "inbound request ID not used (%s)", test.Name)
+		} else {
+			assert.True(t, generated.MatchString(id), "request ID not generated (%s): %s", test.Name, id)
+		}
+
+		var l AccessLog
+		if err := json.NewDecoder(&ab).Decode(&l); err != nil {
+			t.Fatalf("could not decode access log (%s): %v", test.Name, err)
+		}
+		assert.Equal(t, id, l.RequestID, "request ID not in access log (%s)", test.Name)
+
+		var m map[string]interface{}
+		if err := json.NewDecoder(&lb).Decode(&m); err != nil {
+			t.Fatalf("could not decode application log (%s): %v", test.Name, err)
+		}
+		assert.Equal(t, id, m[applog.KeyRequestID], "request ID not in application log (%s)", test.Name)
+	}
+}
+
+func TestWrapCommonHandler_Tracing(t *testing.T) {
+	c, _ := config.Mock()
+	var ab bytes.Buffer
+	c.SetAccessEncoder(json.NewEncoder(&ab))
+	stop, err := tracing.Init(tracing.NewConfig())
+	if err != nil {
+		t.Fatalf("error initialising tracing: %v", err)
+	}
+	defer stop(context.Background())
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	orig := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(orig)
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "inner")
+		span.End()
+		respondGeneric(w, http.StatusInternalServerError, 0, "something failed")
+	})
+	handler := WrapCommonHandler(inner, "Test", false, c)
+
+	request, err := http.NewRequest("GET", "/url", nil)
+	if err != nil {
+		t.Fatalf("error building request: %v", err)
+	}
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a1ce929065e4736a-00f067aa0ba902b7-01")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	in, server := spans[0], spans[1]
+	assert.Equal(t, "GET Test", server.Name(), "server span name not as expected")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind(), "server span kind not as expected")
+	assert.Equal(t, "4bf92f3577b34da6a1ce929065e4736a", server.SpanContext().TraceID().String(), "caller's trace not continued")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String(), "server span parent is not the caller's span")
+	assert.Equal(t, server.SpanContext().SpanID(), in.Parent().SpanID(), "inner span is not a child of the server span")
+	assert.Equal(t, "Error", server.Status().Code.String(), "server span status not set for the server error")
+	var status int64
+	for _, a := range server.Attributes() {
+		if a.Key == "http.response.status_code" {
+			status = a.Value.AsInt64()
+		}
+	}
+	assert.Equal(t, int64(http.StatusInternalServerError), status, "status code not recorded on server span")
+
+	var l AccessLog
+	if err := json.NewDecoder(&ab).Decode(&l); err != nil {
+		t.Fatalf("could not decode access log: %v", err)
+	}
+	assert.Equal(t, "4bf92f3577b34da6a1ce929065e4736a", l.TraceID, "trace ID not in access log")
+}
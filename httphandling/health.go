@@ -0,0 +1,57 @@
+package httphandling
+
+import (
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/health"
+	"net/http"
+)
+
+// getLivenessFunc reports that the server is running and able to serve requests.
+func getLivenessFunc() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, health.Report{
+			Status: health.StatusOK,
+			Checks: []health.Result{},
+		})
+		return
+	})
+}
+
+// getReadinessFunc reports whether the server's dependencies are usable. If any are not the server should not be sent
+// requests so the status code returned is 503. The endpoint is unauthenticated so only whether each check passed is
+// returned; the errors are logged.
+func getReadinessFunc(c *config.Config, hc *health.Checker) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := hc.Run()
+		if rep.Status != health.StatusOK {
+			for _, chk := range rep.Checks {
+				if chk.Status != health.StatusOK {
+					requestLogger(r, c).Warn("readiness check failed", "check", chk.Name, "error", chk.Error)
+				}
+			}
+			respondWithJSON(w, http.StatusServiceUnavailable, rep.Summary())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, rep.Summary())
+		return
+	})
+}
+
+func getHealthRoutes(c *config.Config, hc *health.Checker) []Route {
+	return []Route{
+		{
+			Name:           "Healthz",
+			Method:         "GET",
+			Pattern:        "/healthz",
+			HandlerFunc:    getLivenessFunc(),
+			Authentication: false,
+		},
+		{
+			Name:           "Readyz",
+			Method:         "GET",
+			Pattern:        "/readyz",
+			HandlerFunc:    getReadinessFunc(c, hc),
+			Authentication: false,
+		},
+	}
+}
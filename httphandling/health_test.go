@@ -0,0 +1,52 @@
+package httphandling
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/health"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	c, db, _, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	var dbErr error
+	hc := health.NewChecker()
+	hc.MaxAge = 0
+	hc.Add("database", func() error { return dbErr })
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc, HealthChecker: hc})
+
+	request, _ := http.NewRequest("GET", "/healthz", nil)
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "liveness should not require authentication")
+
+	request, _ = http.NewRequest("GET", "/readyz", nil)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "readiness status code not as expected")
+	var rep health.Report
+	if err := json.Unmarshal(response.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("could not unmarshal readiness response: %v", err)
+	}
+	assert.Equal(t, health.StatusOK, rep.Status, "readiness status not as expected")
+	assert.Equal(t, "database", rep.Checks[0].Name, "check name not as expected")
+
+	dbErr = errors.New("connection refused")
+	request, _ = http.NewRequest("GET", "/readyz", nil)
+	response = httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code, "readiness should fail when a dependency is unavailable")
+	if err := json.Unmarshal(response.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("could not unmarshal readiness response: %v", err)
+	}
+	assert.Equal(t, health.StatusFailed, rep.Checks[0].Status, "check status not as expected")
+	assert.Equal(t, "", rep.Checks[0].Error, "check error should not be returned to unauthenticated callers")
+}
@@ -0,0 +1,97 @@
+package httphandling
+
+import (
+	"database/sql"
+	"github.com/gorilla/mux"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/catalogue"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
+	"net/http"
+)
+
+type historyList struct {
+	History []history.Entry `json:"History"`
+}
+
+func getHistoryFunc(c *config.Config, db database.Source, kind, muxVar string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id := mux.Vars(r)[muxVar]
+		resource, _ := catalogue.HistoryResource(kind)
+		es, err := history.List(r.Context(), *stmtMap, resource, id)
+		if err != nil {
+			requestLogger(r, c).Errorf("error retrieving the history of %s %s: %v", kind, id, err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, historyList{History: es})
+		return
+	})
+}
+
+// historyActor returns who made the request, as recorded in the history of the entities it changes.
+func historyActor(r *http.Request) string {
+	u, err := GetIdentity(r.Context())
+	if err != nil {
+		return "unknown"
+	}
+	return u.UserName() + "@" + u.Domain()
+}
+
+// beginChange starts the transaction in which the request changes an entity, reads its value before and after the
+// change and records the change in its history, so that the change is only made if it is also recorded. If the
+// transaction cannot be started the error response is written and false returned.
+func beginChange(w http.ResponseWriter, r *http.Request, c *config.Config, conn *database.Conn) (*sql.Tx, bool) {
+	tx, err := database.Begin(r.Context(), conn.DB, nil)
+	if err != nil {
+		requestLogger(r, c).Errorf("error starting database transaction: %v", err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return nil, false
+	}
+	return tx, true
+}
+
+// currentValue returns the entity as it is within the transaction, in the form recorded in its history. Nil is returned
+// if it does not exist. If it could not be read the error response is written and false returned.
+func currentValue(w http.ResponseWriter, r *http.Request, c *config.Config, tx *sql.Tx, stmtMap *database.StmtMap, kind, id string) (interface{}, bool) {
+	e, err := catalogue.LookupTx(r.Context(), tx, *stmtMap, kind, id)
+	if err != nil {
+		requestLogger(r, c).Errorf("error reading %s %s for its history: %v", kind, id, err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return nil, false
+	}
+	return e, true
+}
+
+// commitChange records the change the request made to the entity in its history, reading its value after the change
+// within the transaction, and commits the transaction. If either fails the error response is written and false
+// returned, and the change is rolled back when the transaction is.
+func commitChange(w http.ResponseWriter, r *http.Request, c *config.Config, tx *sql.Tx, stmtMap *database.StmtMap, kind, id, action string, before interface{}) bool {
+	var after interface{}
+	if action != history.ActionDelete {
+		var ok bool
+		if after, ok = currentValue(w, r, c, tx, stmtMap, kind, id); !ok {
+			return false
+		}
+	}
+	resource, _ := catalogue.HistoryResource(kind)
+	e, err := history.New(resource, id, action, historyActor(r), before, after)
+	if err == nil {
+		err = history.RecordTx(r.Context(), tx, *stmtMap, e)
+	}
+	if err != nil {
+		requestLogger(r, c).Errorf("error recording %s of %s %s in its history: %v", action, kind, id, err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		requestLogger(r, c).Errorf("error committing %s of %s %s: %v", action, kind, id, err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package httphandling
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testActor = "testuser@TESTING"
+
+var historyColumns = []string{"id", "resource", "resource_id", "action", "actor", "time", "before_value", "after_value"}
+
+// expectHistory sets the expectation of a change made by the test user being recorded in the history of the entity and
+// committed.
+func expectHistory(mock sqlmock.Sqlmock, ep map[int]*sqlmock.ExpectedPrepare, resource string, id interface{}, action string) {
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WithArgs(resource, id, action, testActor, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+// accountRows returns the rows of the account as selected by its ID.
+func accountRows(typeID int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "email", "name", "typeid", "type", "classid", "class", "statusid", "status", "feduser", "version"}).
+		AddRow(test.AWSAccountID1, test.AccountEmail1, test.AccountName1, typeID, test.AccountTypeName1, test.AccountClassID1, test.AccountClassName1, test.AccountStatusID1, test.AccountStatusName1, test.FedUserArn1, 1)
+}
+
+func TestHistory(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	t1 := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	t2 := time.Date(2018, 3, 2, 9, 0, 0, 0, time.UTC)
+	acct := fmt.Sprintf(`{"ID":"%s","Email":"%s","Name":"%s","TypeID":%d,"StatusID":%d,"FederationUserARN":"%s"}`, test.AWSAccountID1, test.AccountEmail1, test.AccountName1, test.AccountTypeID1, test.AccountStatusID1, test.FedUserArn1)
+
+	var tests = []struct {
+		Method         string
+		Path           string
+		HttpCode       int
+		ResponseString string
+	}{
+		{"GET", fmt.Sprintf("%s/%s/history", AccountAPI, test.AWSAccountID1), http.StatusOK, fmt.Sprintf(`{"History":[`+
+			`{"ID":1,"Resource":"account","ResourceID":"%[1]s","Action":"Create","Actor":"%[2]s","Time":"2018-03-01T09:00:00Z","After":%[3]s},`+
+			`{"ID":2,"Resource":"account","ResourceID":"%[1]s","Action":"Delete","Actor":"%[2]s","Time":"2018-03-02T09:00:00Z","Before":%[3]s}]}`, test.AWSAccountID1, testActor, acct)},
+		{"GET", fmt.Sprintf("%s/%d/history", AccountClassAPI, test.AccountClassID1), http.StatusOK, `{"History":[]}`},
+		{"POST", fmt.Sprintf("%s/%s/restore", AccountAPI, test.AWSAccountID1), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Account with ID "+test.AWSAccountID1+" restored.", http.StatusOK, appcodes.Info)},
+		{"POST", fmt.Sprintf("%s/%s/restore", AccountAPI, test.AWSAccountID1), http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Deleted account ID not found.", http.StatusNotFound, appcodes.AccountUnknown)},
+		{"POST", fmt.Sprintf("%s/%s/restore", RoleMappingAPI, test.UUID1), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Role Mapping with ID "+test.UUID1+" restored.", http.StatusOK, appcodes.Info)},
+		{"POST", fmt.Sprintf("%s/%s/restore", RoleMappingAPI, test.UUID1), http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Deleted Role Mapping ID not found or its account is deleted.", http.StatusNotFound, appcodes.RoleMappingUnknown)},
+		{"DELETE", fmt.Sprintf("%s/%s", AccountAPI, test.AWSAccountID1), http.StatusConflict, fmt.Sprintf(test.GenericResponseTmpl, "Account "+test.AWSAccountID1+" has role mappings, these must be deleted first.", http.StatusConflict, appcodes.AccountInUse)},
+		// The change is rolled back if it cannot be recorded in the history
+		{"POST", fmt.Sprintf("%s/%s/restore", AccountAPI, test.AWSAccountID1), http.StatusInternalServerError, fmt.Sprintf(test.GenericResponseTmpl, "history unavailable", http.StatusInternalServerError, appcodes.DatabaseError)},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	ep[database.StmtKeyHistorySelect].ExpectQuery().WithArgs(history.ResourceAccount, test.AWSAccountID1).WillReturnRows(sqlmock.NewRows(historyColumns).
+		AddRow(1, history.ResourceAccount, test.AWSAccountID1, history.ActionCreate, testActor, t1, nil, acct).
+		AddRow(2, history.ResourceAccount, test.AWSAccountID1, history.ActionDelete, testActor, t2, acct, nil))
+	ep[database.StmtKeyHistorySelect].ExpectQuery().WithArgs(history.ResourceAccountClass, fmt.Sprintf("%d", test.AccountClassID1)).WillReturnRows(sqlmock.NewRows(historyColumns))
+
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctRestore].ExpectExec().WithArgs(test.AWSAccountID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID1))
+	expectHistory(mock, ep, history.ResourceAccount, test.AWSAccountID1, history.ActionRestore)
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctRestore].ExpectExec().WithArgs(test.AWSAccountID1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingRestore].ExpectExec().WithArgs(test.UUID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows([]string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt", "validfrom", "validuntil", "schedule", "version"}).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 2))
+	expectHistory(mock, ep, history.ResourceRoleMapping, test.UUID1, history.ActionRestore)
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingRestore].ExpectExec().WithArgs(test.UUID1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	ep[database.StmtKeyAcctInUse].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+
+	mock.ExpectBegin()
+	ep[database.StmtKeyAcctRestore].ExpectExec().WithArgs(test.AWSAccountID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyAcctSelect].ExpectQuery().WithArgs(test.AWSAccountID1).WillReturnRows(accountRows(test.AccountTypeID1))
+	ep[database.StmtKeyHistoryInsert].ExpectExec().WillReturnError(errors.New("history unavailable"))
+	mock.ExpectRollback()
+
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s", APIVersion, test.Path)
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		// Check it was unauthorized before passing auth creds
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Expected unauthorized error")
+		response = httptest.NewRecorder()
+		request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(testActor+":"+config.MockStaticSecret)))
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, fmt.Sprintf("Expected HTTP code: %d got: %d (%s %s)", test.HttpCode, response.Code, test.Method, url))
+		assert.Equal(t, test.ResponseString, response.Body.String(), fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "database calls not as expected")
+}
@@ -0,0 +1,163 @@
+package httphandling
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	QueryLimit = "limit"
+	QueryPage  = "page"
+	QuerySort  = "sort"
+	// DefaultListLimit is the number of items returned by a list when the request does not set a limit.
+	DefaultListLimit = 100
+	// MaxListLimit is the maximum number of items returned by a list at once.
+	MaxListLimit = 1000
+	// MaxListFilterValues is the maximum number of filter values, across all the fields, a list request can give.
+	MaxListFilterValues = 100
+)
+
+// listPage is the paging of a list response. Total is the number of items matching the filters across all pages.
+// NextPage is the value of the page query parameter to request the next page. It is empty on the last page.
+type listPage struct {
+	Total    int    `json:"Total"`
+	NextPage string `json:"NextPage,omitempty"`
+}
+
+// newListPage returns the paging of the response holding n items from the offset given.
+func newListPage(offset, n, total int) listPage {
+	p := listPage{Total: total}
+	if offset+n < total && n > 0 {
+		p.NextPage = pageCursor(int64(offset + n))
+	}
+	return p
+}
+
+// listLimit returns the limit requested or the default if there is none.
+func listLimit(q url.Values) (int, error) {
+	v := q.Get(QueryLimit)
+	if v == "" {
+		return DefaultListLimit, nil
+	}
+	l, err := strconv.Atoi(v)
+	if err != nil || l < 1 || l > MaxListLimit {
+		return 0, fmt.Errorf("%s must be a number from 1 to %d", QueryLimit, MaxListLimit)
+	}
+	return l, nil
+}
+
+// pageCursor returns the value of the page query parameter to request the page continuing from the position given.
+// The value is opaque so that clients follow the NextPage of the previous response rather than working out pages
+// themselves, leaving how the position is held free to change.
+func pageCursor(pos int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(pos, 10)))
+}
+
+// pagePosition returns the position the page requested continues from, or zero if no page is requested.
+func pagePosition(q url.Values) (int64, error) {
+	v := q.Get(QueryPage)
+	if v == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	var pos int64
+	if err == nil {
+		pos, err = strconv.ParseInt(string(b), 10, 64)
+	}
+	if err != nil || pos < 0 {
+		return 0, fmt.Errorf("%s is not valid", QueryPage)
+	}
+	return pos, nil
+}
+
+// listOffset returns the offset of the first item of the page requested.
+func listOffset(q url.Values) (int, error) {
+	pos, err := pagePosition(q)
+	return int(pos), err
+}
+
+// listOrder returns the order requested by the sort query parameters. Fields are separated by commas or given in
+// separate parameters and are prefixed with a - to sort in descending order.
+func listOrder(q url.Values, fields map[string]string) ([]database.ListOrder, error) {
+	var order []database.ListOrder
+	for _, v := range q[QuerySort] {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" {
+				continue
+			}
+			o := database.ListOrder{Field: f}
+			if strings.HasPrefix(f, "-") {
+				o = database.ListOrder{Field: f[1:], Desc: true}
+			}
+			if _, ok := fields[o.Field]; !ok {
+				return nil, fmt.Errorf("cannot sort on %s, must be one of %s", o.Field, strings.Join(fieldNames(fields), ", "))
+			}
+			order = append(order, o)
+		}
+	}
+	return order, nil
+}
+
+// listQuery returns the query for the page of the list requested. Each field of the list given as a query parameter
+// filters the list to the items with any of the values of the parameter. At most MaxListFilterValues values can be
+// given.
+func listQuery(q url.Values, t database.ListTable) (lq database.ListQuery, err error) {
+	lq.Table = t
+	if lq.Limit, err = listLimit(q); err != nil {
+		return
+	}
+	if lq.Offset, err = listOffset(q); err != nil {
+		return
+	}
+	if lq.Order, err = listOrder(q, t.Fields); err != nil {
+		return
+	}
+	n := 0
+	for _, f := range fieldNames(t.Fields) {
+		if vs, ok := q[f]; ok {
+			n += len(vs)
+			lq.Filters = append(lq.Filters, database.ListFilter{Field: f, Values: vs})
+		}
+	}
+	if n > MaxListFilterValues {
+		err = fmt.Errorf("at most %d filter values can be given", MaxListFilterValues)
+	}
+	return
+}
+
+// fieldNames returns the names of the fields in order.
+func fieldNames(fields map[string]string) []string {
+	ns := make([]string, 0, len(fields))
+	for n := range fields {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// listRows returns the rows of the page of the list requested. If the request is not valid or the query fails the
+// error response is written and false returned.
+func listRows(w http.ResponseWriter, r *http.Request, c *config.Config, db *sql.DB, t database.ListTable, name string) (*sql.Rows, database.ListQuery, int, bool) {
+	lq, err := listQuery(r.URL.Query(), t)
+	if err != nil {
+		respondGeneric(w, http.StatusBadRequest, appcodes.BadData, err.Error())
+		return nil, lq, 0, false
+	}
+	rows, total, err := database.List(r.Context(), db, lq)
+	if err != nil {
+		requestLogger(r, c).Errorf("error retrieving %s from database: %v", name, err)
+		respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		return nil, lq, 0, false
+	}
+	return rows, lq, total, true
+}
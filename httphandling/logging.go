@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/applog"
+	"github.com/jcmturner/awsfederation/auditstore"
+	"github.com/jcmturner/awsfederation/breakglass"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/tracing"
 	"net/http"
 	"net/url"
 	"time"
@@ -21,6 +25,8 @@ type AccessLog struct {
 	QueryString string        `json:"QueryString"`
 	Time        time.Time     `json:"Time"`
 	Duration    time.Duration `json:"Duration"`
+	RequestID   string        `json:"RequestID"`
+	TraceID     string        `json:"TraceID,omitempty"`
 }
 
 func accessLogger(inner http.Handler, c *config.Config) http.Handler {
@@ -39,6 +45,8 @@ func accessLogger(inner http.Handler, c *config.Config) http.Handler {
 			QueryString: r.URL.RawQuery,
 			Time:        start,
 			Duration:    time.Since(start),
+			RequestID:   applog.RequestID(r.Context()),
+			TraceID:     tracing.TraceID(r.Context()),
 		}
 		id, err := GetIdentity(r.Context())
 		if err == nil {
@@ -51,6 +59,11 @@ func accessLogger(inner http.Handler, c *config.Config) http.Handler {
 	})
 }
 
+// requestLogger returns the application logger for records about the request, which include the request's ID.
+func requestLogger(r *http.Request, c *config.Config) *applog.Logger {
+	return c.Logger().ForRequest(r.Context())
+}
+
 type auditDetail struct {
 	RemoteAddr string
 	RequestURI string
@@ -63,16 +76,33 @@ func auditLog(l config.AuditLogLine, msg string, r *http.Request, c *config.Conf
 		RequestURI: r.RequestURI,
 		Message:    msg,
 	}
+	l.RequestID = applog.RequestID(r.Context())
 	b, _ := json.Marshal(d)
 	l.Detail = url.QueryEscape(string(b))
 	c.AuditLog(l)
+	auditstore.Record(r.Context(), c, l, auditstore.Reference{})
+}
+
+// alertLog writes a high severity audit log line and raises an alert for it.
+func alertLog(l config.AuditLogLine, msg string, r *http.Request, c *config.Config) {
+	d := auditDetail{
+		RemoteAddr: r.RemoteAddr,
+		RequestURI: r.RequestURI,
+		Message:    msg,
+	}
+	l.RequestID = applog.RequestID(r.Context())
+	b, _ := json.Marshal(d)
+	l.Detail = url.QueryEscape(string(b))
+	breakglass.Alert(l, r.RemoteAddr, d, c)
+	l.Severity = config.AuditSeverityHigh
+	auditstore.Record(r.Context(), c, l, auditstore.Reference{})
 }
 
 func newAuditLogLine(eventType string, c *config.Config) (config.AuditLogLine, error) {
 	eventUUID, err := uuid.GenerateUUID()
 	if err != nil {
 		err := fmt.Errorf("error generating uuid for audit log event of type %s: %v", eventType, err)
-		c.ApplicationLogf(err.Error())
+		c.Logger().Error(err.Error())
 		return config.AuditLogLine{}, err
 	}
 	return config.AuditLogLine{
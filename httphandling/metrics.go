@@ -0,0 +1,17 @@
+package httphandling
+
+import (
+	"github.com/jcmturner/awsfederation/metrics"
+)
+
+func getMetricsRoutes() []Route {
+	return []Route{
+		{
+			Name:           "Metrics",
+			Method:         "GET",
+			Pattern:        "/metrics",
+			HandlerFunc:    metrics.Handler().ServeHTTP,
+			Authentication: false,
+		},
+	}
+}
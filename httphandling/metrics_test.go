@@ -0,0 +1,39 @@
+package httphandling
+
+import (
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	c, db, _, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+
+	// Request requiring authentication to generate some metrics
+	request, _ := http.NewRequest("GET", "/"+APIVersion+"/ratelimit", nil)
+	rt.ServeHTTP(httptest.NewRecorder(), request)
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("error building request: %v", err)
+	}
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "metrics should not require authentication")
+	b := response.Body.String()
+	for _, m := range []string{
+		`awsfederation_http_requests_total{method="GET",route="RateLimitUsageGet",status="401"}`,
+		`awsfederation_authentication_attempts_total{mechanism="Unsupported",outcome="failure"}`,
+		"awsfederation_session_cache_entries",
+	} {
+		assert.True(t, strings.Contains(b, m), "metric %s not found in output", m)
+	}
+}
@@ -0,0 +1,41 @@
+package httphandling
+
+import (
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/ratelimit"
+	"net/http"
+)
+
+type rateLimitUsage struct {
+	Enabled bool              `json:"Enabled"`
+	Buckets []ratelimit.Usage `json:"Buckets"`
+}
+
+func getRateLimitUsageFunc(c *config.Config, rl *ratelimit.Limiter) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		us, err := rl.Usage(r.Context())
+		if err != nil {
+			requestLogger(r, c).Errorf("error retrieving rate limit usage: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, rateLimitUsage{
+			Enabled: rl != nil,
+			Buckets: us,
+		})
+		return
+	})
+}
+
+func getRateLimitRoutes(c *config.Config, rl *ratelimit.Limiter) []Route {
+	return []Route{
+		{
+			Name:           "RateLimitUsageGet",
+			Method:         "GET",
+			Pattern:        "/" + APIVersion + "/ratelimit",
+			HandlerFunc:    getRateLimitUsageFunc(c, rl),
+			Authentication: true,
+		},
+	}
+}
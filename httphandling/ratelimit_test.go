@@ -0,0 +1,51 @@
+package httphandling
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/ratelimit"
+	"github.com/jcmturner/awsfederation/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitUsage(t *testing.T) {
+	c, db, _, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rl := ratelimit.NewLimiterWithStore(config.RateLimit{
+		Store: ratelimit.StoreMemory,
+		User:  config.Limit{Rate: 10, Burst: 20},
+	}, ratelimit.NewMemoryStore())
+	rl.Take(context.Background(), ratelimit.KindUser, "TESTING/testuser", time.Now().UTC())
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc, RateLimiter: rl})
+
+	request, err := http.NewRequest("GET", "/"+APIVersion+"/ratelimit", nil)
+	if err != nil {
+		t.Fatalf("error building request: %v", err)
+	}
+	response := httptest.NewRecorder()
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "expected unauthorized error")
+
+	response = httptest.NewRecorder()
+	request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret)))
+	rt.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "expected status OK")
+	var u rateLimitUsage
+	if err := json.NewDecoder(response.Body).Decode(&u); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.True(t, u.Enabled, "rate limiting should be reported as enabled")
+	if assert.Len(t, u.Buckets, 1, "number of buckets not as expected") {
+		assert.Equal(t, "user:TESTING/testuser", u.Buckets[0].Key, "bucket key not as expected")
+		assert.Equal(t, 20, u.Buckets[0].Burst, "bucket burst not as expected")
+	}
+}
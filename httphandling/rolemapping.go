@@ -1,102 +1,121 @@
 package httphandling
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/go-uuid"
 	"github.com/jcmturner/awsarn"
 	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/authz"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
+	"github.com/jcmturner/awsfederation/history"
 	"io"
 	"net/http"
-	"strings"
+	"time"
 )
 
 const (
-	FilterAuthz         = "authz"
-	FilterARN           = "arn"
-	FilterAccountIDs    = "account"
-	RoleMappingAPI      = "rolemapping"
-	RoleMappingPOSTTmpl = "{\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\"}"
-	RoleMappingPUTTmpl  = "{\"ID\":\"%s\",\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\"}"
-	RoleMappingGETTmpl  = "{\"ID\":\"%s\",\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\",\"AccountID\":\"%s\"}"
+	FilterAuthz                 = "authz"
+	FilterARN                   = "arn"
+	FilterAccountIDs            = "account"
+	RoleMappingAPI              = "rolemapping"
+	RoleMappingPOSTTmpl         = "{\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\"}"
+	RoleMappingPUTTmpl          = "{\"ID\":\"%s\",\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\"}"
+	RoleMappingGETTmpl          = "{\"ID\":\"%s\",\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\",\"AccountID\":\"%s\"}"
+	RoleMappingValidityPOSTTmpl = "{\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\",\"ValidFrom\":\"%s\",\"ValidUntil\":\"%s\",\"Schedule\":{\"Timezone\":\"Europe/London\",\"Windows\":[{\"Days\":[\"Mon\",\"Tue\",\"Wed\",\"Thu\",\"Fri\"],\"Start\":\"%s\",\"End\":\"%s\"}]}}"
+	RoleMappingValidityGETTmpl  = "{\"ID\":\"%s\",\"RoleARN\":\"%s\",\"AuthzAttribute\":\"%s\",\"AccountID\":\"%s\",\"ValidFrom\":\"%s\",\"ValidUntil\":\"%s\",\"Active\":%t,\"RemainingValidity\":%d}"
 )
 
 type roleMapping struct {
-	ID                string `json:"ID,omitempty"`
-	RoleARN           string `json:"RoleARN"`
-	AuthzAttribute    string `json:"AuthzAttribute"`
-	AccountID         string `json:"AccountID,omitempty"`
-	Policy            string `json:"Policy,omitempty"`
-	Duration          int    `json:"Duration,omitempty"`
-	SessionNameFormat string `json:"SessionNameFormat,omitempty"`
+	ID                string          `json:"ID,omitempty"`
+	RoleARN           string          `json:"RoleARN"`
+	AuthzAttribute    string          `json:"AuthzAttribute"`
+	AccountID         string          `json:"AccountID,omitempty"`
+	Policy            string          `json:"Policy,omitempty"`
+	Duration          int             `json:"Duration,omitempty"`
+	SessionNameFormat string          `json:"SessionNameFormat,omitempty"`
+	ValidFrom         *time.Time      `json:"ValidFrom,omitempty"`
+	ValidUntil        *time.Time      `json:"ValidUntil,omitempty"`
+	Schedule          *authz.Schedule `json:"Schedule,omitempty"`
+	Active            *bool           `json:"Active,omitempty"`
+	RemainingValidity *int64          `json:"RemainingValidity,omitempty"` // Seconds the role mapping can continue to be used for.
+	Version           int64           `json:"-"`
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scan populates the roleMapping from a database row and calculates its current validity.
+func (rm *roleMapping) scan(row rowScanner) error {
+	var sch *string
+	err := row.Scan(&rm.ID, &rm.AccountID, &rm.RoleARN, &rm.AuthzAttribute, &rm.Policy, &rm.Duration, &rm.SessionNameFormat, &rm.ValidFrom, &rm.ValidUntil, &sch, &rm.Version)
+	if err != nil {
+		return err
+	}
+	v, err := authz.NewValidity(rm.ValidFrom, rm.ValidUntil, sch)
+	if err != nil {
+		return fmt.Errorf("invalid schedule stored against Role Mapping %s: %v", rm.ID, err)
+	}
+	rm.Schedule = v.Schedule
+	now := time.Now().UTC()
+	if d, ok := v.Remaining(now); ok {
+		active := v.Active(now)
+		secs := int64(d / time.Second)
+		rm.Active = &active
+		rm.RemainingValidity = &secs
+	}
+	return nil
+}
+
+// scheduleValue returns the schedule in the form stored in the database.
+func (rm roleMapping) scheduleValue() *string {
+	if rm.Schedule == nil {
+		return nil
+	}
+	s := rm.Schedule.String()
+	return &s
 }
 
 type roleMappingList struct {
 	RoleMappings []roleMapping `json:"RoleMappings"`
+	listPage
 }
 
-func listRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func listRoleMappingFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stmtKey := database.StmtKeyRoleMappingSelectList
-		var filter []string
-		for fkey, fval := range r.URL.Query() {
-			switch fkey {
-			case FilterAccountIDs:
-				stmtKey = database.StmtKeyRoleMappingByAcct
-				filter = fval
-				break
-			case FilterARN:
-				stmtKey = database.StmtKeyRoleMappingByARN
-				filter = fval
-				break
-			case FilterAuthz:
-				stmtKey = database.StmtKeyRoleMappingByAuthz
-				filter = fval
-				break
-			}
-		}
-
-		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for listing Role Mappings not found")
-			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
-			return
-		}
-
-		stmt := (*stmtMap)[stmtKey]
-		var rows *sql.Rows
-		var err error
-		if stmtKey == database.StmtKeyRoleMappingSelectList {
-			rows, err = stmt.Query()
-		} else {
-			rows, err = stmt.Query(strings.Join(filter, ", "))
-		}
-		if err != nil {
-			c.ApplicationLogf("error retrieving Role Mappings from database: %v", err)
-			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+		conn, release := db.Acquire()
+		defer release()
+		rows, lq, total, ok := listRows(w, r, c, conn.DB, database.ListRoleMapping, "Role Mappings")
+		if !ok {
 			return
 		}
 		defer rows.Close()
 		var as roleMappingList
 		for rows.Next() {
 			var a roleMapping
-			err := rows.Scan(&a.ID, &a.AccountID, &a.RoleARN, &a.AuthzAttribute, &a.Policy, &a.Duration, &a.SessionNameFormat)
+			err := a.scan(rows)
 			if err != nil {
-				c.ApplicationLogf("error processing rows of Role Mappings from database: %v", err)
+				requestLogger(r, c).Errorf("error processing rows of Role Mappings from database: %v", err)
 				respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 				return
 			}
 			as.RoleMappings = append(as.RoleMappings, a)
 		}
+		as.listPage = newListPage(lq.Offset, len(as.RoleMappings), total)
 		respondWithJSON(w, http.StatusOK, as)
 		return
 	})
 }
 
-func getRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func getRoleMappingFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		id := requestToRoleUUID(r)
 		if id == "" {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "Role Mapping UUID not found in request")
@@ -104,88 +123,137 @@ func getRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.Handle
 		}
 		stmtKey := database.StmtKeyRoleMappingSelect
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for getting Role Mapping not found")
+			requestLogger(r, c).Errorf("error, prepared statement for getting Role Mapping not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
 		var a roleMapping
-		err := stmt.QueryRow(id).Scan(&a.ID, &a.AccountID, &a.RoleARN, &a.AuthzAttribute, &a.Policy, &a.Duration, &a.SessionNameFormat)
+		err := a.scan(database.QueryRow(r.Context(), stmt, id))
 		if err != nil {
-			c.ApplicationLogf("error processing Role Mapping from database: %v", err)
+			requestLogger(r, c).Errorf("error processing Role Mapping from database: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
+		setETag(w, a.Version)
 		respondWithJSON(w, http.StatusOK, a)
 		return
 	})
 }
 
-func updateRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func updateRoleMappingFunc(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		id := requestToRoleUUID(r)
 		if id == "" {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "class ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindRoleMapping, id, "role mapping") {
+			return
+		}
 		a, err := roleMappingFromPost(c, r)
 		if err != nil {
+			if e, ok := err.(appcodes.ErrBadPostData); ok {
+				respondGeneric(w, http.StatusBadRequest, e.Code, e.Error())
+				return
+			}
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyRoleMappingVersion, id, "Role Mapping")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, id)
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyRoleMappingUpdate
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for updating Role Mapping not found")
+			requestLogger(r, c).Errorf("error, prepared statement for updating Role Mapping not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.AccountID, a.RoleARN, a.AuthzAttribute, a.Policy, a.Duration, a.SessionNameFormat, id)
+		res, err := database.TxExec(r.Context(), tx, stmt, a.AccountID, a.RoleARN, a.AuthzAttribute, a.Policy, a.Duration, a.SessionNameFormat, a.ValidFrom, a.ValidUntil, a.scheduleValue(), id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for updating Role Mapping: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for updating Role Mapping: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
-		if i, e := res.RowsAffected(); i != 1 || e != nil {
-			c.ApplicationLogf("error unexpected result from database update of Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
+		i, e := res.RowsAffected()
+		if e == nil && i == 0 && v != 0 {
+			// Changed by another request since the version was checked.
+			respondPreconditionFailed(w, 0, "Role Mapping", id)
+			return
+		}
+		if i != 1 || e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database update of Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, id, history.ActionUpdate, before) {
+			return
+		}
+		if v != 0 {
+			setETag(w, v+1)
+		}
+		invalidateCredentials(r, c, cc, credentialCacheRoleMapping, id)
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Role Mapping %s updated.", a.ID))
 		return
 	})
 }
 
-func createRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func createRoleMappingFunc(c *config.Config, db database.Source) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		a, err := roleMappingFromPost(c, r)
 		if err != nil {
+			if e, ok := err.(appcodes.ErrBadPostData); ok {
+				respondGeneric(w, http.StatusBadRequest, e.Code, e.Error())
+				return
+			}
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "invalid post data")
 			return
 		}
 		a.ID, err = uuid.GenerateUUID()
 		if err != nil {
 			e := fmt.Errorf("error generating UUID for new Role Mapping: %v", err)
-			c.ApplicationLogf(e.Error())
+			requestLogger(r, c).Error(e.Error())
 			respondGeneric(w, http.StatusInternalServerError, appcodes.UUIDGenerationError, e.Error())
 			return
 		}
 		stmtKey := database.StmtKeyRoleMappingInsert
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for creating Role Mapping not found")
+			requestLogger(r, c).Errorf("error, prepared statement for creating Role Mapping not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(a.ID, a.AccountID, a.RoleARN, a.AuthzAttribute, a.Policy, a.Duration, a.SessionNameFormat)
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, a.ID, a.AccountID, a.RoleARN, a.AuthzAttribute, a.Policy, a.Duration, a.SessionNameFormat, a.ValidFrom, a.ValidUntil, a.scheduleValue())
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for creating Role Mapping: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for creating Role Mapping: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil || (i != 1 && i != 0) {
-			c.ApplicationLogf("error unexpected result from database for creating Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for creating Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
@@ -193,81 +261,160 @@ func createRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.Han
 			respondGeneric(w, http.StatusBadRequest, appcodes.RoleMappingAlreadyExists, fmt.Sprintf("Role Mapping with ARN %s and Authz Attrbute %s already exists.", a.RoleARN, a.AuthzAttribute))
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, a.ID, history.ActionCreate, nil) {
+			return
+		}
 		respondCreated(w, a.ID, fmt.Sprintf("Role Mapping %s created.", a.ID))
 		return
 	})
 }
 
-func deleteRoleMappingFunc(c *config.Config, stmtMap *database.StmtMap) http.HandlerFunc {
+func deleteRoleMappingFunc(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
 		id := requestToRoleUUID(r)
 		if id == "" {
 			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "Role Mapping ID not in request")
 			return
 		}
+		if readOnly(w, c, sy, catalogue.KindRoleMapping, id, "role mapping") {
+			return
+		}
+		v, ok := ifMatch(w, r, c, stmtMap, database.StmtKeyRoleMappingVersion, id, "Role Mapping")
+		if !ok {
+			return
+		}
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		before, ok := currentValue(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, id)
+		if !ok {
+			return
+		}
 		stmtKey := database.StmtKeyRoleMappingDelete
 		if _, ok := (*stmtMap)[stmtKey]; !ok {
-			c.ApplicationLogf("error, prepared statement for deleting Role Mapping not found")
+			requestLogger(r, c).Errorf("error, prepared statement for deleting Role Mapping not found")
 			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
 			return
 		}
 		stmt := (*stmtMap)[stmtKey]
-		res, err := stmt.Exec(id)
+		res, err := database.TxExec(r.Context(), tx, stmt, id, v, v)
 		if err != nil {
-			c.ApplicationLogf("error executing database statement for deleting Role Mapping: %v", err)
+			requestLogger(r, c).Errorf("error executing database statement for deleting Role Mapping: %v", err)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
 			return
 		}
 		i, e := res.RowsAffected()
 		if e != nil {
-			c.ApplicationLogf("error unexpected result from database for deleting Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
+			requestLogger(r, c).Errorf("error unexpected result from database for deleting Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
 			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
 			return
 		}
+		if i != 1 && v != 0 {
+			respondPreconditionFailed(w, 0, "Role Mapping", id)
+			return
+		}
 		if i != 1 {
 			respondGeneric(w, http.StatusNotFound, appcodes.RoleMappingUnknown, "Role Mapping ID not found.")
 			return
 		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, id, history.ActionDelete, before) {
+			return
+		}
+		invalidateCredentials(r, c, cc, credentialCacheRoleMapping, id)
 		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Role Mapping with ID %s deleted.", id))
 		return
 	})
 }
 
-func getRoleMappingRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
+func restoreRoleMappingFunc(c *config.Config, db database.Source, sy *catalogue.Syncer) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, release := db.Acquire()
+		defer release()
+		stmtMap := conn.Stmts
+		id := requestToRoleUUID(r)
+		if id == "" {
+			respondGeneric(w, http.StatusBadRequest, appcodes.BadData, "Role Mapping ID not in request")
+			return
+		}
+		if readOnly(w, c, sy, catalogue.KindRoleMapping, id, "role mapping") {
+			return
+		}
+		stmtKey := database.StmtKeyRoleMappingRestore
+		if _, ok := (*stmtMap)[stmtKey]; !ok {
+			requestLogger(r, c).Errorf("error, prepared statement for restoring Role Mapping not found")
+			respondGeneric(w, http.StatusInternalServerError, appcodes.ServerConfigurationError, "database statement not found")
+			return
+		}
+		stmt := (*stmtMap)[stmtKey]
+		tx, ok := beginChange(w, r, c, conn)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+		res, err := database.TxExec(r.Context(), tx, stmt, id)
+		if err != nil {
+			requestLogger(r, c).Errorf("error executing database statement for restoring Role Mapping: %v", err)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, err.Error())
+			return
+		}
+		i, e := res.RowsAffected()
+		if e != nil {
+			requestLogger(r, c).Errorf("error unexpected result from database for restoring Role Mapping: expected (1) row affected, got (%d); error: %v", i, e)
+			respondGeneric(w, http.StatusInternalServerError, appcodes.DatabaseError, "unexpected response from databse")
+			return
+		}
+		if i != 1 {
+			respondGeneric(w, http.StatusNotFound, appcodes.RoleMappingUnknown, "Deleted Role Mapping ID not found or its account is deleted.")
+			return
+		}
+		if !commitChange(w, r, c, tx, stmtMap, catalogue.KindRoleMapping, id, history.ActionRestore, nil) {
+			return
+		}
+		respondGeneric(w, http.StatusOK, appcodes.Info, fmt.Sprintf("Role Mapping with ID %s restored.", id))
+		return
+	})
+}
+
+func getRoleMappingRoutes(c *config.Config, db database.Source, cc *credcache.Cache, sy *catalogue.Syncer) []Route {
 	return []Route{
 		{
 			Name:           "RoleMappingAllList",
 			Method:         "GET",
 			Pattern:        "/" + APIVersion + "/rolemapping",
-			HandlerFunc:    listRoleMappingFunc(c, stmtMap),
+			HandlerFunc:    listRoleMappingFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "RoleMappingGet",
 			Method:         "GET",
 			Pattern:        fmt.Sprintf(`/%s/rolemapping/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarRoleUUID),
-			HandlerFunc:    getRoleMappingFunc(c, stmtMap),
+			HandlerFunc:    getRoleMappingFunc(c, db),
 			Authentication: false,
 		},
 		{
 			Name:           "RoleMappingUpdate",
 			Method:         "PUT",
 			Pattern:        fmt.Sprintf(`/%s/rolemapping/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarRoleUUID),
-			HandlerFunc:    updateRoleMappingFunc(c, stmtMap),
+			HandlerFunc:    updateRoleMappingFunc(c, db, cc, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "RoleMappingDelete",
 			Method:         "DELETE",
 			Pattern:        fmt.Sprintf(`/%s/rolemapping/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}`, APIVersion, MuxVarRoleUUID),
-			HandlerFunc:    deleteRoleMappingFunc(c, stmtMap),
+			HandlerFunc:    deleteRoleMappingFunc(c, db, cc, sy),
 			Authentication: true,
 		},
 		{
 			Name:           "RoleMappingCreate",
 			Method:         "POST",
 			Pattern:        "/" + APIVersion + "/rolemapping",
-			HandlerFunc:    createRoleMappingFunc(c, stmtMap),
+			HandlerFunc:    createRoleMappingFunc(c, db),
 			Authentication: true,
 		},
 		{
@@ -277,22 +424,60 @@ func getRoleMappingRoutes(c *config.Config, stmtMap *database.StmtMap) []Route {
 			HandlerFunc:    MethodNotAllowed(),
 			Authentication: true,
 		},
+		{
+			Name:           "RoleMappingRestore",
+			Method:         "POST",
+			Pattern:        fmt.Sprintf(`/%s/rolemapping/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}/restore`, APIVersion, MuxVarRoleUUID),
+			HandlerFunc:    restoreRoleMappingFunc(c, db, sy),
+			Authentication: true,
+		},
+		{
+			Name:           "RoleMappingHistory",
+			Method:         "GET",
+			Pattern:        fmt.Sprintf(`/%s/rolemapping/{%s:\w{8}-\w{4}-\w{4}-\w{4}-\w{12}}/history`, APIVersion, MuxVarRoleUUID),
+			HandlerFunc:    getHistoryFunc(c, db, catalogue.KindRoleMapping, MuxVarRoleUUID),
+			Authentication: true,
+		},
 	}
 }
 
 func roleMappingFromPost(c *config.Config, r *http.Request) (rm roleMapping, err error) {
-	reader := io.LimitReader(r.Body, 1024)
+	reader := io.LimitReader(r.Body, 4096)
 	defer r.Body.Close()
 	dec := json.NewDecoder(reader)
 	err = dec.Decode(&rm)
 	if err != nil {
-		c.ApplicationLogf("error decoding provided JSON into roleMapping: %v", err)
+		requestLogger(r, c).Errorf("error decoding provided JSON into roleMapping: %v", err)
 	}
 	a, e := awsarn.Parse(rm.RoleARN, nil)
 	if e != nil {
 		err = fmt.Errorf("invalid Role ARN: %s", e)
-		c.ApplicationLogf("invalid ARN [%s] in roleMapping provided: %v", rm.RoleARN, err)
+		requestLogger(r, c).Errorf("invalid ARN [%s] in roleMapping provided: %v", rm.RoleARN, err)
 	}
 	rm.AccountID = a.AccountID
+	if e := authz.Validate(rm.AuthzAttribute); e != nil {
+		err = appcodes.ErrBadPostData{}.Errorf("invalid authorization attribute or expression: %v", e)
+		requestLogger(r, c).Errorf("invalid authorization [%s] in roleMapping provided: %v", rm.AuthzAttribute, e)
+	}
+	// Active and remaining validity are calculated values and not set by the client.
+	rm.Active = nil
+	rm.RemainingValidity = nil
+	if rm.ValidFrom != nil {
+		t := rm.ValidFrom.UTC()
+		rm.ValidFrom = &t
+	}
+	if rm.ValidUntil != nil {
+		t := rm.ValidUntil.UTC()
+		rm.ValidUntil = &t
+	}
+	v := authz.Validity{
+		From:     rm.ValidFrom,
+		Until:    rm.ValidUntil,
+		Schedule: rm.Schedule,
+	}
+	if e := v.Validate(); e != nil {
+		err = appcodes.ErrBadPostData{}.Errorf("invalid validity: %v", e)
+		requestLogger(r, c).Errorf("invalid validity in roleMapping provided: %v", e)
+	}
 	return
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/history"
 	"github.com/jcmturner/awsfederation/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -15,15 +16,16 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 const ()
 
 func TestRoleMapping(t *testing.T) {
-	c, _, _, ep, stmtMap, s := test.TestEnv(t)
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
 	defer s.Close()
 	fc := make(federationuser.FedUserCache)
-	rt := NewRouter(c, stmtMap, &fc)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
 
 	var tests = []struct {
 		Method         string
@@ -37,35 +39,62 @@ func TestRoleMapping(t *testing.T) {
 		// Create
 		{"POST", RoleMappingAPI, true, "", fmt.Sprintf(RoleMappingPOSTTmpl, test.RoleARN1, test.AuthzAttrib1), http.StatusCreated, fmt.Sprintf(test.CreatedResponseTmpl, "", "")},
 		// List 1 entry
-		{"GET", RoleMappingAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"RoleMappings":[`+RoleMappingGETTmpl+`]}`, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1)},
+		{"GET", RoleMappingAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"RoleMappings":[`+RoleMappingGETTmpl+`],"Total":1}`, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1)},
 		// Get
 		{"GET", RoleMappingAPI, false, "/" + test.UUID1, "", http.StatusOK, fmt.Sprintf(RoleMappingGETTmpl, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1)},
 		{"POST", RoleMappingAPI, true, "", fmt.Sprintf(RoleMappingPOSTTmpl, test.RoleARN2, test.AuthzAttrib2), http.StatusCreated, fmt.Sprintf(test.CreatedResponseTmpl, "", "")},
 		//// List multiple
-		{"GET", RoleMappingAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"RoleMappings":[`+RoleMappingGETTmpl+`,`+RoleMappingGETTmpl+`]}`, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1, test.UUID2, test.RoleARN2, test.AuthzAttrib2, test.AWSAccountID2)},
+		{"GET", RoleMappingAPI, false, "", "", http.StatusOK, fmt.Sprintf(`{"RoleMappings":[`+RoleMappingGETTmpl+`,`+RoleMappingGETTmpl+`],"Total":2}`, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1, test.UUID2, test.RoleARN2, test.AuthzAttrib2, test.AWSAccountID2)},
 		//// Method not allowed
 		{"POST", RoleMappingAPI, true, "/" + test.UUID1, fmt.Sprintf(RoleMappingPOSTTmpl, test.RoleARN1, test.AuthzAttrib2), http.StatusMethodNotAllowed, fmt.Sprintf(test.GenericResponseTmpl, "The POST method cannot be performed against this part of the API", http.StatusMethodNotAllowed, appcodes.BadData)},
 		{"DELETE", RoleMappingAPI, true, "/" + test.UUID2, "", http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, "Role Mapping with ID "+test.UUID2+" deleted.", http.StatusOK, appcodes.Info)},
 		{"DELETE", RoleMappingAPI, true, "/" + test.UUID2, "", http.StatusNotFound, fmt.Sprintf(test.GenericResponseTmpl, "Role Mapping ID not found.", http.StatusNotFound, appcodes.RoleMappingUnknown)},
 		{"PUT", RoleMappingAPI, true, "/" + test.UUID1, fmt.Sprintf(RoleMappingPUTTmpl, test.UUID1, test.RoleARN1, test.AuthzAttrib2), http.StatusOK, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("Role Mapping %s updated.", test.UUID1), http.StatusOK, appcodes.Info)},
+		// Invalid authorization expression
+		{"POST", RoleMappingAPI, true, "", fmt.Sprintf(RoleMappingPOSTTmpl, test.RoleARN1, `glob(\"aws-*\") AND`), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "invalid authorization attribute or expression: unexpected end of expression", http.StatusBadRequest, appcodes.BadData)},
+		// Invalid validity
+		{"POST", RoleMappingAPI, true, "", fmt.Sprintf(RoleMappingValidityPOSTTmpl, test.RoleARN1, test.AuthzAttrib1, "2018-02-01T00:00:00Z", "2018-01-01T00:00:00Z", "09:00", "17:00"), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "invalid validity: validity end must be after the validity start", http.StatusBadRequest, appcodes.BadData)},
+		{"POST", RoleMappingAPI, true, "", fmt.Sprintf(RoleMappingValidityPOSTTmpl, test.RoleARN1, test.AuthzAttrib1, "2018-01-01T00:00:00Z", "2018-02-01T00:00:00Z", "9am", "17:00"), http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "invalid validity: schedule window 0 has invalid start time 9am, must be HH:MM", http.StatusBadRequest, appcodes.BadData)},
+		// Get expired
+		{"GET", RoleMappingAPI, false, "/" + test.UUID2, "", http.StatusOK, fmt.Sprintf(RoleMappingValidityGETTmpl, test.UUID2, test.RoleARN2, test.AuthzAttrib2, test.AWSAccountID2, "2018-01-01T00:00:00Z", "2018-02-01T00:00:00Z", false, 0)},
 	}
 
 	// Set the expected database calls that are performed as part of the table tests
-	ep[database.StmtKeyRoleMappingInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "").WillReturnResult(sqlmock.NewResult(0, 1))
-	rows1 := sqlmock.NewRows([]string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt"}).
-		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "")
-	ep[database.StmtKeyRoleMappingSelectList].ExpectQuery().WillReturnRows(rows1)
-	rows1a := sqlmock.NewRows([]string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt"}).
-		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "")
+	rmCols := []string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt", "validfrom", "validuntil", "schedule", "version"}
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(rmCols).AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 1))
+	expectHistory(mock, ep, history.ResourceRoleMapping, sqlmock.AnyArg(), history.ActionCreate)
+	rows1 := sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 1)
+	database.ExpectList(mock, database.ListRoleMapping, 1, rows1)
+	rows1a := sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 1)
 	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(rows1a)
-	ep[database.StmtKeyRoleMappingInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "").WillReturnResult(sqlmock.NewResult(1, 1))
-	rows2 := sqlmock.NewRows([]string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt"}).
-		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "").
-		AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "")
-	ep[database.StmtKeyRoleMappingSelectList].ExpectQuery().WillReturnRows(rows2)
-	ep[database.StmtKeyRoleMappingDelete].ExpectExec().WithArgs(test.UUID2).WillReturnResult(sqlmock.NewResult(0, 1))
-	ep[database.StmtKeyRoleMappingDelete].ExpectExec().WithArgs(test.UUID2).WillReturnResult(sqlmock.NewResult(0, 0))
-	ep[database.StmtKeyRoleMappingUpdate].ExpectExec().WithArgs(test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib2, "", 0, "", test.UUID1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingInsert].ExpectExec().WithArgs(sqlmock.AnyArg(), test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", nil, nil, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(rmCols).AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 1))
+	expectHistory(mock, ep, history.ResourceRoleMapping, sqlmock.AnyArg(), history.ActionCreate)
+	rows2 := sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 1).
+		AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 1)
+	database.ExpectList(mock, database.ListRoleMapping, 2, rows2)
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID2).WillReturnRows(sqlmock.NewRows(rmCols).AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 1))
+	ep[database.StmtKeyRoleMappingDelete].ExpectExec().WithArgs(test.UUID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, ep, history.ResourceRoleMapping, test.UUID2, history.ActionDelete)
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID2).WillReturnRows(sqlmock.NewRows(rmCols))
+	ep[database.StmtKeyRoleMappingDelete].ExpectExec().WithArgs(test.UUID2, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows(rmCols).AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 1))
+	ep[database.StmtKeyRoleMappingUpdate].ExpectExec().WithArgs(test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib2, "", 0, "", nil, nil, nil, test.UUID1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows(rmCols).AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 2))
+	expectHistory(mock, ep, history.ResourceRoleMapping, test.UUID1, history.ActionUpdate)
+	rows3 := sqlmock.NewRows(rmCols).
+		AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), nil, 1)
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID2).WillReturnRows(rows3)
 
 	for _, test := range tests {
 		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, test.Endpoint, test.Path)
@@ -103,3 +132,100 @@ func TestRoleMapping(t *testing.T) {
 		assert.Equal(t, test.ResponseString, respStr, fmt.Sprintf("Response not as expected (%s %s)", test.Method, url))
 	}
 }
+
+func TestRoleMapping_List(t *testing.T) {
+	c, db, mock, _, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+
+	rmCols := []string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt", "validfrom", "validuntil", "schedule", "version"}
+	count, page := database.ExpectList(mock, database.ListRoleMapping, 3, sqlmock.NewRows(rmCols).
+		AddRow(test.UUID2, test.AWSAccountID2, test.RoleARN2, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 1))
+	count.WithArgs(test.AWSAccountID1, test.AWSAccountID2)
+	page.WithArgs(test.AWSAccountID1, test.AWSAccountID2, 1, 1)
+
+	var tests = []struct {
+		Query          string
+		HttpCode       int
+		ResponseString string
+	}{
+		{"?account=" + test.AWSAccountID1 + "&account=" + test.AWSAccountID2 + "&sort=-arn&limit=1&page=" + pageCursor(1), http.StatusOK,
+			fmt.Sprintf(`{"RoleMappings":[`+RoleMappingGETTmpl+`],"Total":3,"NextPage":"`+pageCursor(2)+`"}`, test.UUID2, test.RoleARN2, test.AuthzAttrib2, test.AWSAccountID2)},
+		{"?sort=policy", http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "cannot sort on policy, must be one of account, arn, authz, id, validfrom, validuntil", http.StatusBadRequest, appcodes.BadData)},
+		{"?limit=0", http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("limit must be a number from 1 to %d", MaxListLimit), http.StatusBadRequest, appcodes.BadData)},
+		{"?page=-1", http.StatusBadRequest, fmt.Sprintf(test.GenericResponseTmpl, "page is not valid", http.StatusBadRequest, appcodes.BadData)},
+		{"?account=" + test.AWSAccountID1 + strings.Repeat("&arn="+test.RoleARN1, MaxListFilterValues), http.StatusBadRequest,
+			fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("at most %d filter values can be given", MaxListFilterValues), http.StatusBadRequest, appcodes.BadData)},
+	}
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s%s", APIVersion, RoleMappingAPI, test.Query)
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, "Expected HTTP code: %d got: %d (%s)", test.HttpCode, response.Code, url)
+		assert.Equal(t, test.ResponseString, response.Body.String(), "Response not as expected (%s)", url)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("list queries not as expected: %v", err)
+	}
+}
+
+func TestRoleMapping_IfMatch(t *testing.T) {
+	c, db, mock, ep, stmtMap, s := test.TestEnv(t)
+	defer s.Close()
+	fc := make(federationuser.FedUserCache)
+	rt := NewRouter(c, Dependencies{Database: &database.Conn{DB: db, Stmts: stmtMap}, FedUserCache: &fc})
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser@TESTING:"+config.MockStaticSecret))
+	url := fmt.Sprintf("http://127.0.0.1:8443/%s/%s/%s", APIVersion, RoleMappingAPI, test.UUID1)
+	put := fmt.Sprintf(RoleMappingPUTTmpl, test.UUID1, test.RoleARN1, test.AuthzAttrib2)
+
+	var tests = []struct {
+		Name           string
+		Method         string
+		IfMatch        string
+		HttpCode       int
+		ETag           string
+		ResponseString string
+	}{
+		{"get", "GET", "", http.StatusOK, `"7"`, fmt.Sprintf(RoleMappingGETTmpl, test.UUID1, test.RoleARN1, test.AuthzAttrib1, test.AWSAccountID1)},
+		{"update current version", "PUT", `"7"`, http.StatusOK, `"8"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("Role Mapping %s updated.", test.UUID1), http.StatusOK, appcodes.Info)},
+		{"delete old version", "DELETE", `"7"`, http.StatusPreconditionFailed, `"8"`, fmt.Sprintf(test.GenericResponseTmpl, fmt.Sprintf("The Role Mapping %s has been modified.", test.UUID1), http.StatusPreconditionFailed, appcodes.PreconditionFailed)},
+	}
+	// Set the expected database calls that are performed as part of the table tests
+	rmCols := []string{"id", "acctid", "rolearn", "authz", "policy", "duration", "sessfmt", "validfrom", "validuntil", "schedule", "version"}
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 7))
+	ep[database.StmtKeyRoleMappingVersion].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(7))
+	mock.ExpectBegin()
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib1, "", 0, "", nil, nil, nil, 7))
+	ep[database.StmtKeyRoleMappingUpdate].ExpectExec().WithArgs(test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib2, "", 0, "", nil, nil, nil, test.UUID1, 7, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	ep[database.StmtKeyRoleMappingSelect].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows(rmCols).
+		AddRow(test.UUID1, test.AWSAccountID1, test.RoleARN1, test.AuthzAttrib2, "", 0, "", nil, nil, nil, 8))
+	expectHistory(mock, ep, history.ResourceRoleMapping, test.UUID1, history.ActionUpdate)
+	ep[database.StmtKeyRoleMappingVersion].ExpectQuery().WithArgs(test.UUID1).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(8))
+
+	for _, test := range tests {
+		var body string
+		if test.Method == "PUT" {
+			body = put
+		}
+		request, err := http.NewRequest(test.Method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error building request: %v", err)
+		}
+		request.Header.Set("Authorization", auth)
+		if test.IfMatch != "" {
+			request.Header.Set(HeaderIfMatch, test.IfMatch)
+		}
+		response := httptest.NewRecorder()
+		rt.ServeHTTP(response, request)
+		assert.Equal(t, test.HttpCode, response.Code, "HTTP code not as expected (%s)", test.Name)
+		assert.Equal(t, test.ETag, response.Header().Get(HeaderETag), "ETag not as expected (%s)", test.Name)
+		assert.Equal(t, test.ResponseString, response.Body.String(), "Response not as expected (%s)", test.Name)
+	}
+}
@@ -2,9 +2,13 @@ package httphandling
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/credcache"
 	"github.com/jcmturner/awsfederation/database"
 	"github.com/jcmturner/awsfederation/federationuser"
+	"github.com/jcmturner/awsfederation/health"
+	"github.com/jcmturner/awsfederation/ratelimit"
 	"net/http"
 )
 
@@ -20,15 +24,36 @@ type Route struct {
 	HandlerFunc    http.HandlerFunc
 }
 
-func NewRouter(c *config.Config, stmtMap *database.StmtMap, fc *federationuser.FedUserCache) *mux.Router {
+// Dependencies are the components the handlers of the API use. Those that are not enabled are left nil.
+type Dependencies struct {
+	// Database provides the connection to the database for each request, which may change between requests.
+	Database        database.Source
+	FedUserCache    *federationuser.FedUserCache
+	RateLimiter     *ratelimit.Limiter
+	CredCache       *credcache.Cache
+	CatalogueSyncer *catalogue.Syncer
+	HealthChecker   *health.Checker
+	Reload          Reloader
+}
+
+func NewRouter(c *config.Config, d Dependencies) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
-	addRoutes(router, getFederationUserRoutes(c, stmtMap), c)
-	addRoutes(router, getAssumeRoleRoutes(c, stmtMap, fc), c)
-	addRoutes(router, getAccountClassRoutes(c, stmtMap), c)
-	addRoutes(router, getAccountTypeRoutes(c, stmtMap), c)
-	addRoutes(router, getAccountStatusRoutes(c, stmtMap), c)
-	addRoutes(router, getRoleMappingRoutes(c, stmtMap), c)
-	addRoutes(router, getAccountRoutes(c, stmtMap), c)
+	addRoutes(router, getFederationUserRoutes(c, d.Database, d.CredCache), c)
+	addRoutes(router, getAssumeRoleRoutes(c, d.Database, d.FedUserCache, d.RateLimiter, d.CredCache), c)
+	addRoutes(router, getAccountClassRoutes(c, d.Database, d.CatalogueSyncer), c)
+	addRoutes(router, getAccountTypeRoutes(c, d.Database, d.CatalogueSyncer), c)
+	addRoutes(router, getAccountStatusRoutes(c, d.Database, d.CatalogueSyncer), c)
+	addRoutes(router, getRoleMappingRoutes(c, d.Database, d.CredCache, d.CatalogueSyncer), c)
+	addRoutes(router, getAccountRoutes(c, d.Database, d.CatalogueSyncer), c)
+	addRoutes(router, getCatalogueRoutes(c, d.Database, d.CredCache, d.CatalogueSyncer), c)
+	addRoutes(router, getAccessRequestRoutes(c, d.Database), c)
+	addRoutes(router, getAccessApproverRoutes(c, d.Database), c)
+	addRoutes(router, getRateLimitRoutes(c, d.RateLimiter), c)
+	addRoutes(router, getCredentialCacheRoutes(c, d.CredCache), c)
+	addRoutes(router, getMetricsRoutes(), c)
+	addRoutes(router, getHealthRoutes(c, d.HealthChecker), c)
+	addRoutes(router, getAdminRoutes(c, d.Reload), c)
+	addRoutes(router, getAuditRoutes(c), c)
 
 	return router
 }
@@ -38,7 +63,7 @@ func addRoutes(router *mux.Router, routes []Route, c *config.Config) *mux.Router
 		var handler http.Handler
 
 		handler = route.HandlerFunc
-		handler = WrapCommonHandler(handler, route.Authentication, c)
+		handler = WrapCommonHandler(handler, route.Name, route.Authentication, c)
 
 		router.
 			Methods(route.Method).
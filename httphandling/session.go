@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"github.com/gorilla/securecookie"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/metrics"
 	"gopkg.in/jcmturner/goidentity.v1"
 	"net/http"
 	"sync"
@@ -24,6 +25,14 @@ var blockKey []byte = securecookie.GenerateRandomKey(32)
 // Instance of the ServiceCache. This needs to be a singleton.
 var sessionCache authSessionCache
 var once sync.Once
+var stopCleaner = make(chan struct{})
+var stopOnce sync.Once
+
+func init() {
+	metrics.RegisterGauge("session", "cache_entries", "Number of authenticated sessions in the session cache.", func() float64 {
+		return float64(sessionCache.len())
+	})
+}
 
 // GetAuthSessionCache returns a pointer to the Cache singleton.
 // Durations is the period to wait between cache garbage collection.
@@ -34,15 +43,28 @@ func getAuthSessionCache(d time.Duration) *authSessionCache {
 			Entries: make(map[string]authSessionEntry),
 		}
 		go func() {
+			ticker := time.NewTicker(time.Duration(2) * time.Minute)
+			defer ticker.Stop()
 			for {
-				time.Sleep(time.Duration(2) * time.Minute)
-				sessionCache.clearOldEntries()
+				select {
+				case <-ticker.C:
+					sessionCache.clearOldEntries()
+				case <-stopCleaner:
+					return
+				}
 			}
 		}()
 	})
 	return &sessionCache
 }
 
+// StopSessionCacheCleaner stops the background thread that cleans old entries out of the session cache.
+func StopSessionCacheCleaner() {
+	stopOnce.Do(func() {
+		close(stopCleaner)
+	})
+}
+
 func setSession(w http.ResponseWriter, id goidentity.Identity, c *config.Config) error {
 	var s = securecookie.New(hashKey, blockKey)
 	sessionSecret := base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(64))
@@ -119,6 +141,12 @@ func (a *authSessionCache) addSessionEntry(sessionSecret string, id goidentity.I
 	}
 }
 
+func (a *authSessionCache) len() int {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	return len(a.Entries)
+}
+
 func (a *authSessionCache) clearOldEntries() {
 	a.mux.Lock()
 	defer a.mux.Unlock()
@@ -0,0 +1,262 @@
+package logsink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBackoff is the wait before the first retry of a batch. It doubles for each further retry.
+var retryBackoff = time.Second
+
+// httpWriter posts the records in batches to a log collector.
+type httpWriter struct {
+	cfg        HTTP
+	httpClient *http.Client
+	token      string
+	records    chan []byte
+	done       chan struct{}
+	// mux guards sending to the records channel against it being closed.
+	mux    sync.Mutex
+	closed bool
+	// bufMux guards the buffer file.
+	bufMux sync.Mutex
+}
+
+func newHTTPWriter(cfg HTTP) (*httpWriter, error) {
+	w := &httpWriter{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		records:    make(chan []byte, cfg.BatchSize*10),
+		done:       make(chan struct{}),
+	}
+	if cfg.TokenFile != "" {
+		b, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read HTTP log collector token: %v", err)
+		}
+		w.token = strings.TrimSpace(string(b))
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues the record to be sent. If the queue is full, because the collector is slow or unavailable, the record
+// is written to the buffer file so that logging does not hold up requests.
+func (w *httpWriter) Write(p []byte) (int, error) {
+	r := make([]byte, len(p))
+	copy(r, p)
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.closed {
+		return 0, errors.New("log sink closed")
+	}
+	select {
+	case w.records <- r:
+	default:
+		w.buffer([][]byte{r})
+	}
+	return len(p), nil
+}
+
+func (w *httpWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	var batch [][]byte
+	for {
+		select {
+		case r, ok := <-w.records:
+			if !ok {
+				if len(batch) > 0 && !w.send(batch) {
+					w.buffer(batch)
+				}
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = nil
+			}
+			w.resend()
+		}
+	}
+}
+
+// flush sends the batch, keeping it in the buffer file if it cannot be delivered.
+func (w *httpWriter) flush(batch [][]byte) {
+	if !w.sendWithRetry(batch) {
+		w.buffer(batch)
+	}
+}
+
+func (w *httpWriter) sendWithRetry(batch [][]byte) bool {
+	wait := retryBackoff
+	for attempt := 0; ; attempt++ {
+		if w.send(batch) {
+			return true
+		}
+		if attempt >= w.cfg.MaxRetries {
+			return false
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// send posts the batch as a JSON array. Records that are not JSON, such as the application log lines, are sent as
+// JSON strings.
+func (w *httpWriter) send(batch [][]byte) bool {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, r := range batch {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		r = bytes.TrimSpace(r)
+		if json.Valid(r) {
+			b.Write(r)
+		} else {
+			s, _ := json.Marshal(string(r))
+			b.Write(s)
+		}
+	}
+	b.WriteByte(']')
+	req, err := http.NewRequest("POST", w.cfg.URL, &b)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// buffer appends the records to the buffer file, one per line, if there is room for them.
+func (w *httpWriter) buffer(batch [][]byte) {
+	if w.cfg.BufferFile == "" {
+		w.drop(len(batch))
+		return
+	}
+	w.bufMux.Lock()
+	defer w.bufMux.Unlock()
+	var size int64
+	if fi, err := os.Stat(w.cfg.BufferFile); err == nil {
+		size = fi.Size()
+	}
+	var b bytes.Buffer
+	for _, r := range batch {
+		b.Write(bytes.TrimRight(r, "\r\n"))
+		b.WriteByte('\n')
+	}
+	if size+int64(b.Len()) > int64(w.cfg.MaxBufferSize)*megabyte {
+		w.drop(len(batch))
+		return
+	}
+	f, err := os.OpenFile(w.cfg.BufferFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not open log buffer file %s: %v\n", w.cfg.BufferFile, err)
+		w.drop(len(batch))
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(b.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write to log buffer file %s: %v\n", w.cfg.BufferFile, err)
+	}
+}
+
+func (w *httpWriter) drop(n int) {
+	fmt.Fprintf(os.Stderr, "%d log records could not be delivered to %s and were dropped\n", n, w.cfg.URL)
+}
+
+// resend sends the records in the buffer file, including those left from before the server was restarted. The file is
+// moved aside while its records are sent so that records can be buffered in the meantime. Records that still cannot be
+// delivered are appended back to the buffer file.
+func (w *httpWriter) resend() {
+	if w.cfg.BufferFile == "" {
+		return
+	}
+	sending := w.cfg.BufferFile + ".sending"
+	w.bufMux.Lock()
+	// A file being sent is left behind if the server stopped while sending it, in which case it is sent first.
+	if _, err := os.Stat(sending); os.IsNotExist(err) {
+		if err := os.Rename(w.cfg.BufferFile, sending); err != nil {
+			w.bufMux.Unlock()
+			return
+		}
+	}
+	records, err := readBuffer(sending)
+	w.bufMux.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read log buffer file %s: %v\n", sending, err)
+		return
+	}
+	sent := 0
+	for sent < len(records) {
+		end := sent + w.cfg.BatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		if !w.send(records[sent:end]) {
+			break
+		}
+		sent = end
+	}
+	if sent < len(records) {
+		w.buffer(records[sent:])
+	}
+	os.Remove(sending)
+}
+
+// readBuffer returns the records in the buffer file.
+func readBuffer(name string) ([][]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records [][]byte
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for s.Scan() {
+		if len(s.Bytes()) > 0 {
+			records = append(records, append([]byte(nil), s.Bytes()...))
+		}
+	}
+	return records, s.Err()
+}
+
+// Close sends the queued records, buffering any that cannot be delivered, and stops the writer.
+func (w *httpWriter) Close() error {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.records)
+	w.mux.Unlock()
+	<-w.done
+	return nil
+}
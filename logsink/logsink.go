@@ -0,0 +1,232 @@
+// Package logsink provides the destinations the application, audit and access logs can be written to in addition to
+// the standard output and plain files: files rotated by size or time, syslog servers and HTTP log collectors.
+// Each call to Write is expected to be a single log record, as written by log.Logger and json.Encoder.
+package logsink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	TypeFile   = "File"
+	TypeSyslog = "Syslog"
+	TypeHTTP   = "HTTP"
+
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// Config defines the sink of a logger. The File type writes to the logger's file, rotating it if configured to.
+type Config struct {
+	Type     string   `json:"Type"`
+	Rotation Rotation `json:"Rotation"`
+	Syslog   Syslog   `json:"Syslog"`
+	HTTP     HTTP     `json:"HTTP"`
+}
+
+// Rotation configures rotating a log file when it reaches MaxSize or every Interval. The rotated files are named with
+// the time they were rotated and are compressed with gzip if Compress is set. Only the most recent MaxBackups rotated
+// files are kept, or all of them if MaxBackups is zero.
+type Rotation struct {
+	MaxSize    int  `json:"MaxSize"`  // Size in megabytes
+	Interval   int  `json:"Interval"` // Duration in hours
+	MaxBackups int  `json:"MaxBackups"`
+	Compress   bool `json:"Compress"`
+}
+
+// Enabled indicates if the file is to be rotated.
+func (r Rotation) Enabled() bool {
+	return r.MaxSize > 0 || r.Interval > 0
+}
+
+// Syslog configures sending the log records to a syslog server as RFC 5424 messages over UDP, TCP or TLS.
+// The CAFile is a PEM bundle of the CAs trusted to issue the server's certificate. If not set the system's trusted CAs
+// are used. A client certificate is presented if the CertificateFile and KeyFile are set.
+type Syslog struct {
+	Network         string `json:"Network"` // udp, tcp or tls
+	Address         string `json:"Address"`
+	Facility        string `json:"Facility"`
+	Severity        string `json:"Severity"`
+	AppName         string `json:"AppName"`
+	CAFile          string `json:"CAFile"`
+	CertificateFile string `json:"CertificateFile"`
+	KeyFile         string `json:"KeyFile"`
+}
+
+// HTTP configures posting the log records as JSON arrays to a log collector. Records are sent in batches of BatchSize
+// or every FlushInterval. A batch that cannot be delivered after MaxRetries attempts is kept in the BufferFile, up to
+// MaxBufferSize, and sent once the collector is available again. Without a BufferFile undelivered records are dropped.
+// If a TokenFile is set its contents are sent as a bearer token.
+type HTTP struct {
+	URL           string `json:"URL"`
+	BatchSize     int    `json:"BatchSize"`
+	FlushInterval int    `json:"FlushInterval"` // Duration in seconds
+	Timeout       int    `json:"Timeout"`       // Duration in seconds
+	MaxRetries    int    `json:"MaxRetries"`
+	BufferFile    string `json:"BufferFile"`
+	MaxBufferSize int    `json:"MaxBufferSize"` // Size in megabytes
+	TokenFile     string `json:"TokenFile"`
+}
+
+// NewConfig returns the default sink configuration, a file without rotation.
+func NewConfig() Config {
+	return Config{
+		Type: TypeFile,
+		Syslog: Syslog{
+			Network:  SyslogUDP,
+			Facility: "local0",
+			Severity: "info",
+			AppName:  "awsfederation",
+		},
+		HTTP: HTTP{
+			BatchSize:     100,
+			FlushInterval: 5,
+			Timeout:       10,
+			MaxRetries:    3,
+			MaxBufferSize: 100,
+		},
+	}
+}
+
+// IsFile indicates if the sink writes to the logger's file.
+func (c Config) IsFile() bool {
+	return c.Type == "" || strings.EqualFold(c.Type, TypeFile)
+}
+
+// Validate checks the settings of the sink's type.
+func (c Config) Validate() error {
+	var problems []string
+	switch {
+	case c.IsFile():
+		r := c.Rotation
+		if r.MaxSize < 0 || r.Interval < 0 || r.MaxBackups < 0 {
+			problems = append(problems, "rotation MaxSize, Interval and MaxBackups must not be negative")
+		}
+	case strings.EqualFold(c.Type, TypeSyslog):
+		s := c.Syslog
+		switch strings.ToLower(s.Network) {
+		case SyslogUDP, SyslogTCP, SyslogTLS:
+		default:
+			problems = append(problems, fmt.Sprintf("invalid syslog network %s, must be udp, tcp or tls", s.Network))
+		}
+		if s.Address == "" {
+			problems = append(problems, "syslog address not defined")
+		}
+		if _, ok := facilities[strings.ToLower(s.Facility)]; !ok {
+			problems = append(problems, fmt.Sprintf("invalid syslog facility %s", s.Facility))
+		}
+		if _, ok := severities[strings.ToLower(s.Severity)]; !ok {
+			problems = append(problems, fmt.Sprintf("invalid syslog severity %s", s.Severity))
+		}
+		if (s.CertificateFile == "") != (s.KeyFile == "") {
+			problems = append(problems, "syslog client CertificateFile and KeyFile must both be defined")
+		}
+	case strings.EqualFold(c.Type, TypeHTTP):
+		h := c.HTTP
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("invalid HTTP log collector URL %s", h.URL))
+		}
+		if h.BatchSize < 1 || h.FlushInterval < 1 || h.Timeout < 1 {
+			problems = append(problems, "HTTP BatchSize, FlushInterval and Timeout must be at least 1")
+		}
+		if h.MaxRetries < 0 || h.MaxBufferSize < 0 {
+			problems = append(problems, "HTTP MaxRetries and MaxBufferSize must not be negative")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown log sink type %s, must be File, Syslog or HTTP", c.Type))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// destination identifies where the sink writes to so that loggers writing to the same place share a sink. Syslog
+// messages identify the logger that wrote them so each logger has its own syslog sink.
+func (c Config) destination(name, path string) string {
+	switch {
+	case strings.EqualFold(c.Type, TypeSyslog):
+		return TypeSyslog + "|" + name + "|" + strings.ToLower(c.Syslog.Network) + "|" + c.Syslog.Address
+	case strings.EqualFold(c.Type, TypeHTTP):
+		return TypeHTTP + "|" + c.HTTP.URL + "|" + c.HTTP.BufferFile
+	default:
+		return TypeFile + "|" + path
+	}
+}
+
+type sink struct {
+	io.WriteCloser
+	refs int
+}
+
+var (
+	sinksMux sync.Mutex
+	sinks    = make(map[string]*sink)
+)
+
+// Open returns the sink for the logger. The name identifies the logger in the records sent to syslog. The path is
+// the logger's file, used by the File type.
+// If the destination is already open, for example by the logger of the configuration being replaced when the
+// configuration is reloaded, the same sink is returned, and its settings kept, so that a file is not rotated by two
+// writers and buffered records are not sent twice. Each call to Open must be matched by a call to Close on the
+// returned writer.
+func Open(name, path string, c Config) (io.WriteCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	key := c.destination(name, path)
+	sinksMux.Lock()
+	defer sinksMux.Unlock()
+	if s, ok := sinks[key]; ok {
+		s.refs++
+		return &handle{key: key, s: s}, nil
+	}
+	var w io.WriteCloser
+	var err error
+	switch {
+	case strings.EqualFold(c.Type, TypeSyslog):
+		w, err = newSyslogWriter(name, c.Syslog)
+	case strings.EqualFold(c.Type, TypeHTTP):
+		w, err = newHTTPWriter(c.HTTP)
+	default:
+		w, err = newRotatingFile(path, c.Rotation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &sink{WriteCloser: w, refs: 1}
+	sinks[key] = s
+	return &handle{key: key, s: s}, nil
+}
+
+// handle is a logger's reference to a shared sink.
+type handle struct {
+	key  string
+	s    *sink
+	once sync.Once
+}
+
+func (h *handle) Write(p []byte) (int, error) {
+	return h.s.Write(p)
+}
+
+// Close releases the logger's reference to the sink, closing the sink when it is no longer used by any logger.
+func (h *handle) Close() (err error) {
+	h.once.Do(func() {
+		sinksMux.Lock()
+		defer sinksMux.Unlock()
+		h.s.refs--
+		if h.s.refs > 0 {
+			return
+		}
+		delete(sinks, h.key)
+		err = h.s.Close()
+	})
+	return
+}
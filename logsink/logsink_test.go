@@ -0,0 +1,377 @@
+package logsink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testDir(t *testing.T) (string, func()) {
+	d, err := ioutil.TempDir(os.TempDir(), "logsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, func() { os.RemoveAll(d) }
+}
+
+func TestConfig_Validate(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.Validate(), "Default configuration not valid")
+	c.Rotation.MaxSize = -1
+	assert.Error(t, c.Validate(), "Expected error for negative rotation size")
+
+	c = NewConfig()
+	c.Type = TypeSyslog
+	assert.Error(t, c.Validate(), "Expected error for syslog without an address")
+	c.Syslog.Address = "127.0.0.1:514"
+	assert.NoError(t, c.Validate(), "Valid syslog configuration returned an error")
+	c.Syslog.Network = "sctp"
+	c.Syslog.Facility = "local9"
+	err := c.Validate()
+	if assert.Error(t, err, "Expected error for invalid syslog configuration") {
+		assert.Equal(t, 2, len(strings.Split(err.Error(), "; ")), "Number of problems not as expected: %v", err)
+	}
+
+	c = NewConfig()
+	c.Type = TypeHTTP
+	c.HTTP.URL = "ftp://logs.example.com"
+	assert.Error(t, c.Validate(), "Expected error for invalid collector URL")
+	c.HTTP.URL = "https://logs.example.com/ingest"
+	assert.NoError(t, c.Validate(), "Valid HTTP configuration returned an error")
+
+	c.Type = "Kafka"
+	assert.Error(t, c.Validate(), "Expected error for unknown sink type")
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	d, cleanup := testDir(t)
+	defer cleanup()
+	p := filepath.Join(d, "audit.log")
+	c := NewConfig()
+	c.Rotation = Rotation{MaxSize: 1, MaxBackups: 2, Compress: true}
+	w, err := Open("audit", p, c)
+	if err != nil {
+		t.Fatalf("Error opening log sink: %v", err)
+	}
+	rec := strings.Repeat("a", 400*1024) + "\n"
+	// Each file holds two records so writing seven records rotates three times.
+	for i := 0; i < 7; i++ {
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Fatalf("Error writing record: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing log sink: %v", err)
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("Current log file not found: %v", err)
+	}
+	assert.Equal(t, int64(len(rec)), fi.Size(), "Size of current log file not as expected")
+	rotated, _ := filepath.Glob(p + ".*")
+	if assert.Equal(t, 2, len(rotated), "Number of rotated files kept not as expected: %v", rotated) {
+		for _, r := range rotated {
+			assert.True(t, strings.HasSuffix(r, compressedSuffix), "Rotated file %s not compressed", r)
+		}
+		f, _ := os.Open(rotated[1])
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Error reading compressed file: %v", err)
+		}
+		b, _ := ioutil.ReadAll(zr)
+		assert.Equal(t, 2*len(rec), len(b), "Size of rotated file not as expected")
+	}
+}
+
+func TestRotatingFile_Interval(t *testing.T) {
+	d, cleanup := testDir(t)
+	defer cleanup()
+	p := filepath.Join(d, "access.log")
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	rf, err := newRotatingFile(p, Rotation{Interval: 24})
+	if err != nil {
+		t.Fatalf("Error opening rotating file: %v", err)
+	}
+	rf.now = func() time.Time { return now }
+	rf.next = now.Add(24 * time.Hour)
+	rf.Write([]byte("day1\n"))
+	now = now.Add(23 * time.Hour)
+	rf.Write([]byte("day1\n"))
+	now = now.Add(time.Hour)
+	rf.Write([]byte("day2\n"))
+	rf.Close()
+	b, _ := ioutil.ReadFile(p)
+	assert.Equal(t, "day2\n", string(b), "Current log file not as expected")
+	b, err = ioutil.ReadFile(p + "." + now.Format(rotatedTimeFormat))
+	if assert.NoError(t, err, "Rotated file not found") {
+		assert.Equal(t, "day1\nday1\n", string(b), "Rotated log file not as expected")
+	}
+}
+
+func TestOpen_Shared(t *testing.T) {
+	d, cleanup := testDir(t)
+	defer cleanup()
+	p := filepath.Join(d, "application.log")
+	c := NewConfig()
+	c.Rotation.MaxSize = 10
+	w1, err := Open("application", p, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, err := Open("application", p, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, w1.(*handle).s == w2.(*handle).s, "Sink for the same file not shared")
+	w1.Close()
+	// Closing twice only releases the reference once.
+	w1.Close()
+	_, err = w2.Write([]byte("still open\n"))
+	assert.NoError(t, err, "Sink closed while still in use")
+	w2.Close()
+	_, err = w2.Write([]byte("closed\n"))
+	assert.Error(t, err, "Expected error writing to a closed sink")
+}
+
+func TestSyslog_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := NewConfig()
+	c.Type = TypeSyslog
+	c.Syslog.Address = conn.LocalAddr().String()
+	c.Syslog.Severity = "notice"
+	w, err := Open("audit", "", c)
+	if err != nil {
+		t.Fatalf("Error opening syslog sink: %v", err)
+	}
+	defer w.Close()
+	w.Write([]byte(`{"EventType":"Test"}` + "\n"))
+	b := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("Error receiving syslog message: %v", err)
+	}
+	m := strings.SplitN(string(b[:n]), " ", 8)
+	if assert.Equal(t, 8, len(m), "Message not as expected: %s", b[:n]) {
+		// local0 (16) * 8 + notice (5)
+		assert.Equal(t, "<133>1", m[0], "Priority and version not as expected")
+		_, err := time.Parse(time.RFC3339Nano, m[1])
+		assert.NoError(t, err, "Timestamp not valid")
+		assert.Equal(t, "awsfederation", m[3], "App name not as expected")
+		assert.Equal(t, fmt.Sprintf("%d", os.Getpid()), m[4], "Process ID not as expected")
+		assert.Equal(t, "audit", m[5], "Message ID not as expected")
+		assert.Equal(t, "-", m[6], "Structured data not as expected")
+		assert.Equal(t, `{"EventType":"Test"}`, m[7], "Message not as expected")
+	}
+}
+
+func TestSyslog_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			var n int
+			if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+				return
+			}
+			b := make([]byte, n)
+			if _, err := r.Read(b); err != nil {
+				return
+			}
+			received <- string(b)
+		}
+	}()
+	c := NewConfig()
+	c.Type = TypeSyslog
+	c.Syslog.Network = SyslogTCP
+	c.Syslog.Address = l.Addr().String()
+	w, err := Open("application", "", c)
+	if err != nil {
+		t.Fatalf("Error opening syslog sink: %v", err)
+	}
+	defer w.Close()
+	w.Write([]byte("first message\n"))
+	w.Write([]byte("second message\n"))
+	for _, exp := range []string{"first message", "second message"} {
+		select {
+		case m := <-received:
+			assert.True(t, strings.HasPrefix(m, "<134>1 "), "Priority not as expected: %s", m)
+			assert.True(t, strings.HasSuffix(m, " application - "+exp), "Message not as expected: %s", m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Syslog message not received")
+		}
+	}
+}
+
+type collector struct {
+	mux     sync.Mutex
+	fail    bool
+	batches [][]json.RawMessage
+	auth    string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var b []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.auth = r.Header.Get("Authorization")
+	c.batches = append(c.batches, b)
+}
+
+func (c *collector) setFail(f bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.fail = f
+}
+
+func (c *collector) records() []string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var rs []string
+	for _, b := range c.batches {
+		for _, r := range b {
+			rs = append(rs, string(r))
+		}
+	}
+	return rs
+}
+
+func TestHTTP(t *testing.T) {
+	retryBackoff = time.Millisecond
+	d, cleanup := testDir(t)
+	defer cleanup()
+	col := new(collector)
+	s := httptest.NewServer(col)
+	defer s.Close()
+	tokenFile := filepath.Join(d, "token")
+	ioutil.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600)
+	c := NewConfig()
+	c.Type = TypeHTTP
+	c.HTTP.URL = s.URL
+	c.HTTP.BatchSize = 2
+	c.HTTP.FlushInterval = 1
+	c.HTTP.MaxRetries = 1
+	c.HTTP.BufferFile = filepath.Join(d, "buffer")
+	c.HTTP.TokenFile = tokenFile
+	w, err := Open("access", "", c)
+	if err != nil {
+		t.Fatalf("Error opening HTTP sink: %v", err)
+	}
+
+	// A full batch is sent straight away.
+	w.Write([]byte(`{"n":1}` + "\n"))
+	w.Write([]byte("plain text\n"))
+	waitFor(t, func() bool { return len(col.records()) == 2 })
+	assert.Equal(t, []string{`{"n":1}`, `"plain text"`}, col.records(), "Records not as expected")
+	assert.Equal(t, "Bearer s3cr3t", col.auth, "Authorization not as expected")
+
+	// Records that cannot be delivered are buffered and sent when the collector is available.
+	col.setFail(true)
+	w.Write([]byte(`{"n":2}` + "\n"))
+	w.Write([]byte(`{"n":3}` + "\n"))
+	waitFor(t, func() bool {
+		b, _ := ioutil.ReadFile(c.HTTP.BufferFile)
+		return string(b) == `{"n":2}`+"\n"+`{"n":3}`+"\n"
+	})
+	col.setFail(false)
+	waitFor(t, func() bool { return len(col.records()) == 4 })
+	_, err = os.Stat(c.HTTP.BufferFile)
+	assert.True(t, os.IsNotExist(err), "Buffer file not removed once sent")
+
+	// A partial batch is sent on close.
+	w.Write([]byte(`{"n":4}` + "\n"))
+	w.Close()
+	assert.Equal(t, []string{`{"n":1}`, `"plain text"`, `{"n":2}`, `{"n":3}`, `{"n":4}`}, col.records(), "Records not as expected")
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTP_WriteDuringResend(t *testing.T) {
+	d, cleanup := testDir(t)
+	defer cleanup()
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	c := NewConfig()
+	c.HTTP.URL = s.URL
+	c.HTTP.BufferFile = filepath.Join(d, "buffer")
+	ioutil.WriteFile(c.HTTP.BufferFile, []byte(`{"n":1}`+"\n"), 0600)
+	// The records channel is never read so every record written goes to the buffer file.
+	w := &httpWriter{
+		cfg:        c.HTTP,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		records:    make(chan []byte),
+	}
+
+	resent := make(chan struct{})
+	go func() {
+		w.resend()
+		close(resent)
+	}()
+	<-received
+	written := make(chan struct{})
+	go func() {
+		w.Write([]byte(`{"n":2}` + "\n"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		close(unblock)
+		t.Fatal("Write blocked while the buffer file was being resent")
+	}
+	close(unblock)
+	<-resent
+	b, _ := ioutil.ReadFile(c.HTTP.BufferFile)
+	assert.Equal(t, `{"n":2}`+"\n"+`{"n":1}`+"\n", string(b), "Records not kept in the buffer file")
+	_, err := os.Stat(c.HTTP.BufferFile + ".sending")
+	assert.True(t, os.IsNotExist(err), "Buffer file being sent not removed")
+}
@@ -0,0 +1,207 @@
+package logsink
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	megabyte = 1024 * 1024
+	// rotatedTimeFormat is the format of the time in the name of rotated files. It sorts in time order.
+	rotatedTimeFormat = "20060102T150405.000000000Z"
+	compressedSuffix  = ".gz"
+)
+
+// rotatingFile is a log file that is rotated when it reaches the maximum size or the rotation interval has elapsed.
+type rotatingFile struct {
+	mux      sync.Mutex
+	path     string
+	rotation Rotation
+	f        *os.File
+	size     int64
+	next     time.Time
+	// compressing tracks the rotated files being compressed so that Close can wait for them.
+	compressing sync.WaitGroup
+	now         func() time.Time
+}
+
+func newRotatingFile(path string, r Rotation) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:     path,
+		rotation: r,
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	if rf.rotation.Interval > 0 {
+		rf.next = rf.now().Add(time.Duration(rf.rotation.Interval) * time.Hour)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	if rf.f == nil {
+		return 0, errors.New("log file closed")
+	}
+	if rf.due(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			// Keep logging to the current file rather than losing the record.
+			fmt.Fprintf(os.Stderr, "could not rotate log file %s: %v\n", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// due indicates if the file should be rotated before writing the number of bytes given.
+func (rf *rotatingFile) due(n int64) bool {
+	if rf.rotation.MaxSize > 0 && rf.size > 0 && rf.size+n > int64(rf.rotation.MaxSize)*megabyte {
+		return true
+	}
+	return !rf.next.IsZero() && !rf.now().Before(rf.next)
+}
+
+// rotate renames the current file with the time of rotation and starts a new file.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+	rotated := rf.path + "." + rf.now().Format(rotatedTimeFormat)
+	if err := os.Rename(rf.path, rotated); err != nil {
+		// Reopen the current file so that logging continues.
+		if e := rf.open(); e != nil {
+			return fmt.Errorf("%v; could not reopen log file: %v", err, e)
+		}
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.compressing.Add(1)
+	go func() {
+		defer rf.compressing.Done()
+		if rf.rotation.Compress {
+			if err := compress(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "could not compress rotated log file %s: %v\n", rotated, err)
+			}
+		}
+		if err := rf.prune(); err != nil {
+			fmt.Fprintf(os.Stderr, "could not remove old log files of %s: %v\n", rf.path, err)
+		}
+	}()
+	return nil
+}
+
+// compress replaces the file with a gzip compressed copy.
+func compress(p string) error {
+	in, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(p+compressedSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(p)
+}
+
+// rotated returns the rotated files, oldest first.
+func (rf *rotatingFile) rotated() ([]string, error) {
+	ms, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var fs []string
+	prefix := rf.path + "."
+	for _, m := range ms {
+		ts := strings.TrimSuffix(strings.TrimPrefix(m, prefix), compressedSuffix)
+		if _, err := time.Parse(rotatedTimeFormat, ts); err == nil {
+			fs = append(fs, m)
+		}
+	}
+	sort.Strings(fs)
+	return fs, nil
+}
+
+// prune removes the oldest rotated files beyond the number to keep.
+func (rf *rotatingFile) prune() error {
+	if rf.rotation.MaxBackups < 1 {
+		return nil
+	}
+	fs, err := rf.rotated()
+	if err != nil {
+		return err
+	}
+	for len(fs) > rf.rotation.MaxBackups {
+		if err := os.Remove(fs[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fs = fs[1:]
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	rf.compressing.Wait()
+	if rf.f == nil {
+		return nil
+	}
+	var errs []string
+	if err := rf.f.Sync(); err != nil {
+		errs = append(errs, fmt.Sprintf("could not flush %s: %v", rf.path, err))
+	}
+	if err := rf.f.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("could not close %s: %v", rf.path, err))
+	}
+	rf.f = nil
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package logsink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 10 * time.Second
+	// nilValue is used in RFC 5424 messages for fields without a value.
+	nilValue = "-"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8, "cron": 9,
+	"authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21,
+	"local6": 22, "local7": 23,
+}
+
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// syslogWriter sends each record as an RFC 5424 message. Over TCP and TLS the messages are framed by octet counting
+// as described in RFC 6587 and RFC 5425. The connection is re-established if sending fails.
+type syslogWriter struct {
+	mux      sync.Mutex
+	network  string
+	address  string
+	tls      *tls.Config
+	priority int
+	hostname string
+	appName  string
+	msgID    string
+	conn     net.Conn
+}
+
+func newSyslogWriter(name string, s Syslog) (*syslogWriter, error) {
+	w := &syslogWriter{
+		network:  strings.ToLower(s.Network),
+		address:  s.Address,
+		priority: facilities[strings.ToLower(s.Facility)]*8 + severities[strings.ToLower(s.Severity)],
+		appName:  header(s.AppName, 48),
+		msgID:    header(name, 32),
+	}
+	h, err := os.Hostname()
+	if err != nil {
+		h = nilValue
+	}
+	w.hostname = header(h, 255)
+	if w.network == SyslogTLS {
+		w.tls, err = syslogTLSConfig(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Connect now so that an unreachable server is reported when the logger is configured.
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func syslogTLSConfig(s Syslog) (*tls.Config, error) {
+	tc := new(tls.Config)
+	if host, _, err := net.SplitHostPort(s.Address); err == nil {
+		tc.ServerName = host
+	}
+	if s.CAFile != "" {
+		b, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read syslog CA file: %v", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("no PEM encoded certificates found in syslog CA file")
+		}
+	}
+	if s.CertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertificateFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load syslog client certificate: %v", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// header returns the value for a header field of the message. RFC 5424 limits the fields to printable ASCII without
+// spaces and to a maximum length.
+func header(v string, max int) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	v = b.String()
+	if v == "" {
+		return nilValue
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
+
+func (w *syslogWriter) connect() (err error) {
+	d := &net.Dialer{Timeout: syslogDialTimeout}
+	switch w.network {
+	case SyslogTLS:
+		w.conn, err = tls.DialWithDialer(d, "tcp", w.address, w.tls)
+	default:
+		w.conn, err = d.Dial(w.network, w.address)
+	}
+	if err != nil {
+		w.conn = nil
+		return fmt.Errorf("could not connect to syslog server %s: %v", w.address, err)
+	}
+	return nil
+}
+
+// message formats the record as an RFC 5424 message.
+func (w *syslogWriter) message(p []byte, t time.Time) []byte {
+	msg := bytes.TrimRight(p, "\r\n")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s %s ", w.priority, t.Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname,
+		w.appName, os.Getpid(), w.msgID, nilValue)
+	b.Write(msg)
+	if w.network == SyslogUDP {
+		return b.Bytes()
+	}
+	return append([]byte(fmt.Sprintf("%d ", b.Len())), b.Bytes()...)
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	m := w.message(p, time.Now().UTC())
+	// Retry once with a new connection in case the server has closed the connection.
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err := w.connect(); err != nil {
+				return 0, err
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err := w.conn.Write(m); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			if attempt > 0 {
+				return 0, fmt.Errorf("could not send to syslog server %s: %v", w.address, err)
+			}
+			continue
+		}
+		break
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"flag"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jcmturner/awsfederation/app"
+	"github.com/jcmturner/awsfederation/auditchain"
+	"github.com/jcmturner/awsfederation/catalogue"
 	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -16,7 +24,19 @@ func main() {
 	dbInitAdminUser := flag.String("dbinit-adminuser", "root", "The database admin username for initial database deployment")
 	dbInitAdminPasswd := flag.String("dbinit-adminpasswd", "", "The database admin user password for initial database deployment")
 	dbInitSocket := flag.String("dbinit-dbsocket", "", "The socket to connect to the database over TCP (format <IP>:<PORT>)")
+	dbUpgrade := flag.Bool("dbupgrade", false, "Upgrade the tables of a database created by an earlier release, using the -dbinit admin user and socket, and exit")
 	configPath := flag.String("config", "./awsfederation-config.json", "Specify the path to the configuration file.")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration and exit")
+	dumpConfig := flag.String("dump-config", "", "Print the effective configuration, with secrets redacted, in the format specified (json or yaml) and exit")
+	verifyAudit := flag.String("verify-audit", "", "Verify the tamper-evident chain of the audit log file specified and exit")
+	auditKey := flag.String("audit-key", "", "The file of the audit chain HMAC key. Defaults to the key file in the configuration")
+	auditPublicKey := flag.String("audit-public-key", "", "The PEM file of the Ed25519 public key to verify the audit checkpoints with. Defaults to the public key of the signing key in the configuration")
+	exportCatalogue := flag.String("export-catalogue", "", "Export the catalogue to the file specified, or to stdout if -, and exit")
+	catalogueFormat := flag.String("catalogue-format", "yaml", "The format of the exported catalogue (json or yaml)")
+	importCatalogue := flag.String("import-catalogue", "", "Import the catalogue document (json or yaml) in the file specified, print the report of the changes and exit")
+	importDryRun := flag.Bool("import-dry-run", false, "Report the changes the catalogue import would make without making them")
+	importUpsert := flag.Bool("import-upsert", false, "Update the existing entities that differ from the catalogue imported")
+	importPrune := flag.Bool("import-prune", false, "Delete the entities that are not in the catalogue imported")
 	flag.Parse()
 
 	// Print version information and exit.
@@ -26,31 +46,179 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The catalogue export and import write their output to stdout, so the logs, which are set up with the
+	// configuration, are written to stderr instead.
+	stdout := os.Stdout
+	if *exportCatalogue == "-" || *importCatalogue != "" {
+		os.Stdout = os.Stderr
+	}
+
 	// Load configuration
 	c, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to configure AWS Federation Server: %v\n", err)
 	}
-	c.ApplicationLogf(c.Summary())
+
+	// Validate the configuration and exit.
+	if *checkConfig {
+		if err := app.CheckConfig(c); err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration %s is not valid:\n", *configPath)
+			if verr, ok := err.(config.ValidationError); ok {
+				for _, p := range verr.Problems {
+					fmt.Fprintf(os.Stderr, "\t%s\n", p)
+				}
+			} else {
+				fmt.Fprintf(os.Stderr, "\t%v\n", err)
+			}
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Configuration %s is valid\n", *configPath)
+		os.Exit(0)
+	}
+
+	// Verify the audit log and exit.
+	if *verifyAudit != "" {
+		verifyAuditLog(c, *verifyAudit, *auditKey, *auditPublicKey)
+	}
+
+	// Print the effective configuration and exit.
+	if *dumpConfig != "" {
+		b, err := c.Dump(strings.ToLower(*dumpConfig))
+		if err != nil {
+			log.Fatalf("Failed to dump configuration: %v\n", err)
+		}
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
+	// Export or import the catalogue and exit.
+	if *exportCatalogue != "" {
+		exportCatalogueFile(c, *exportCatalogue, strings.ToLower(*catalogueFormat), stdout)
+	}
+	if *importCatalogue != "" {
+		importCatalogueFile(c, *importCatalogue, catalogue.Options{
+			DryRun: *importDryRun,
+			Upsert: *importUpsert,
+			Prune:  *importPrune,
+		}, stdout)
+	}
+	c.Logger().Info(c.Summary())
 
 	// Initialise the database.
 	if *dbInit {
 		dbinit(c, dbInitSocket, dbInitAdminUser, dbInitAdminPasswd)
 	}
 
+	// Upgrade the database.
+	if *dbUpgrade {
+		dbupgrade(c, dbInitSocket, dbInitAdminUser, dbInitAdminPasswd)
+	}
+
 	// Create the app
 	var a app.App
+	a.ConfigPath = *configPath
 	// Initialise the app
 	err = a.Initialize(c)
 	if err != nil {
-		c.ApplicationLogf("application initialisation error: %v", err)
+		c.Logger().Errorf("application initialisation error: %v", err)
 		log.Fatalf("Application initialisation error: %v\n", err)
 	}
 
 	// Run the app
 	err = a.Run()
-	c.ApplicationLogf("Application exit: %v", err)
-	log.Fatalf("Application exit: %v\n", err)
+	if err != nil {
+		c.Logger().Errorf("Application exit: %v", err)
+		log.Fatalf("Application exit: %v\n", err)
+	}
+	log.Println("Application shut down")
+}
+
+func verifyAuditLog(c *config.Config, p, keyFile, pubKeyFile string) {
+	ac := c.Server.Logging.AuditChain
+	if keyFile == "" {
+		keyFile = ac.KeyFile
+	}
+	key, err := auditchain.LoadKey(keyFile)
+	if err != nil {
+		log.Fatalf("Failed to verify audit log: %v\n", err)
+	}
+	var pub ed25519.PublicKey
+	if pubKeyFile != "" {
+		pub, err = auditchain.LoadPublicKey(pubKeyFile)
+	} else {
+		var sk ed25519.PrivateKey
+		sk, err = auditchain.LoadSigningKey(ac.SigningKeyFile)
+		if err == nil {
+			pub = sk.Public().(ed25519.PublicKey)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to verify audit log: %v\n", err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		log.Fatalf("Failed to verify audit log: %v\n", err)
+	}
+	rep, err := auditchain.Verify(f, key, pub, ac.CheckpointInterval)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to verify audit log: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Audit log %s: %d records (sequence %d to %d), %d events, %d checkpoints\n", p, rep.Records, rep.FirstSeq, rep.LastSeq, rep.Events, rep.Checkpoints)
+	if rep.Unsigned > 0 {
+		fmt.Fprintf(os.Stderr, "%d records after the last checkpoint are not covered by a signature\n", rep.Unsigned)
+	}
+	if !rep.Valid() {
+		fmt.Fprintf(os.Stderr, "Audit log %s FAILED verification:\n", p)
+		for _, s := range rep.Problems {
+			fmt.Fprintf(os.Stderr, "\t%s\n", s)
+		}
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Audit log %s verified\n", p)
+	os.Exit(0)
+}
+
+func exportCatalogueFile(c *config.Config, p, format string, stdout io.Writer) {
+	b, err := app.ExportCatalogue(c, format)
+	if err != nil {
+		log.Fatalf("Failed to export catalogue: %v\n", err)
+	}
+	if p == "-" {
+		stdout.Write(b)
+		os.Exit(0)
+	}
+	if err := ioutil.WriteFile(p, b, 0640); err != nil {
+		log.Fatalf("Failed to export catalogue: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Catalogue exported to %s\n", p)
+	os.Exit(0)
+}
+
+func importCatalogueFile(c *config.Config, p string, opts catalogue.Options, stdout io.Writer) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		log.Fatalf("Failed to import catalogue: %v\n", err)
+	}
+	rep, err := app.ImportCatalogue(c, b, opts)
+	if verr, ok := err.(catalogue.ValidationError); ok {
+		fmt.Fprintf(os.Stderr, "Catalogue %s is not valid:\n", p)
+		for _, s := range verr.Problems {
+			fmt.Fprintf(os.Stderr, "\t%s\n", s)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Failed to import catalogue: %v\n", err)
+	}
+	out, _ := json.MarshalIndent(rep, "", "  ")
+	fmt.Fprintln(stdout, string(out))
+	if opts.DryRun {
+		fmt.Fprintf(os.Stderr, "Dry run of catalogue import from %s: no changes made\n", p)
+	} else {
+		fmt.Fprintf(os.Stderr, "Catalogue imported from %s: %d created, %d updated, %d deleted, %d conflicts\n", p, rep.Created, rep.Updated, rep.Deleted, rep.Conflicts)
+	}
+	os.Exit(0)
 }
 
 func dbinit(c *config.Config, dbInitSocket, dbInitAdminUser, dbInitAdminPasswd *string) {
@@ -74,3 +242,25 @@ func dbinit(c *config.Config, dbInitSocket, dbInitAdminUser, dbInitAdminPasswd *
 	l.Println("Database Initialisation SUCCESSFUL")
 	os.Exit(0)
 }
+
+func dbupgrade(c *config.Config, dbInitSocket, dbInitAdminUser, dbInitAdminPasswd *string) {
+	l := log.New(os.Stderr, "AWS Federation DB Upgrade: ", log.Ldate|log.Ltime)
+	l.Println("AWS Federation database upgrade underway.")
+	if *dbInitSocket == "" {
+		l.Println("Database connection socket not provided.")
+		l.Fatalln("Database Upgrade FAILED")
+	}
+	if *dbInitAdminPasswd == "" {
+		l.Println("Password for the database admin user not provided.")
+		l.Fatalln("Database Upgrade FAILED")
+	}
+	l.Printf("Connecting to database: %s\n", *dbInitSocket)
+	l.Printf("Connecting as: %s\n", *dbInitAdminUser)
+	from, err := app.UpgradeDBSchema(c, *dbInitSocket, *dbInitAdminUser, *dbInitAdminPasswd)
+	if err != nil {
+		l.Printf("Error upgrading database from schema version %d:\n---\n%v\n---\n", from, err)
+		l.Fatalln("Database Upgrade FAILED")
+	}
+	l.Printf("Database Upgrade SUCCESSFUL: schema version %d to %d\n", from, database.SchemaVersion)
+	os.Exit(0)
+}
@@ -0,0 +1,139 @@
+// Package metrics defines the Prometheus metrics exposed by the server.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	namespace = "awsfederation"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeError   = "error"
+)
+
+var (
+	// Registry holds all the server's metrics.
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	Authentications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "authentication",
+		Name:      "attempts_total",
+		Help:      "Number of authentication attempts by mechanism and outcome.",
+	}, []string{"mechanism", "outcome"})
+
+	Federations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "requests_total",
+		Help:      "Number of federation requests by role mapping, account and outcome.",
+	}, []string{"role_mapping", "account", "outcome"})
+
+	STSDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sts",
+		Name:      "assume_role_duration_seconds",
+		Help:      "Latency of calls to AWS STS to assume a role.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	VaultErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "vault",
+		Name:      "errors_total",
+		Help:      "Number of errors interacting with the vault.",
+	})
+
+	DatabaseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "errors_total",
+		Help:      "Number of errors interacting with the database.",
+	})
+
+	PreparedStatementErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "prepared_statement_errors_total",
+		Help:      "Number of errors preparing database statements or finding prepared statements.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		Authentications,
+		Federations,
+		STSDuration,
+		VaultErrors,
+		DatabaseErrors,
+		PreparedStatementErrors,
+	)
+}
+
+// Handler returns the HTTP handler that exposes the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterGauge adds a gauge whose value is provided by the function when the metrics are collected.
+func RegisterGauge(subsystem, name, help string, f func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, f))
+}
+
+// ObserveHTTPRequest records a completed HTTP request.
+func ObserveHTTPRequest(route, method string, status int, d time.Duration) {
+	s := strconv.Itoa(status)
+	HTTPRequests.WithLabelValues(route, method, s).Inc()
+	HTTPRequestDuration.WithLabelValues(route, method, s).Observe(d.Seconds())
+}
+
+// ObserveAuthentication records the outcome of an authentication attempt.
+func ObserveAuthentication(mechanism, outcome string) {
+	Authentications.WithLabelValues(mechanism, outcome).Inc()
+}
+
+// ObserveFederation records the outcome of a federation request. The account is taken from the role's ARN.
+func ObserveFederation(roleMappingID, roleArn, outcome string) {
+	Federations.WithLabelValues(roleMappingID, AccountFromARN(roleArn), outcome).Inc()
+}
+
+// AccountFromARN returns the AWS account ID from the ARN or an empty string if it cannot be determined.
+func AccountFromARN(arn string) string {
+	// arn:partition:service:region:account-id:resource
+	a := strings.SplitN(arn, ":", 6)
+	if len(a) < 6 {
+		return ""
+	}
+	return a[4]
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountFromARN(t *testing.T) {
+	assert.Equal(t, "012345678912", AccountFromARN("arn:aws:iam::012345678912:role/role-name"), "account not as expected")
+	assert.Equal(t, "012345678912", AccountFromARN("arn:aws:iam::012345678912:role/path:with:colons"), "account not as expected")
+	assert.Equal(t, "", AccountFromARN("notanarn"), "account should be empty for an invalid ARN")
+}
+
+func TestObserve(t *testing.T) {
+	ObserveFederation("0f8fad5b-d9cb-469f-a165-70867728950e", "arn:aws:iam::012345678912:role/role-name", OutcomeSuccess)
+	assert.Equal(t, float64(1), testutil.ToFloat64(Federations.WithLabelValues("0f8fad5b-d9cb-469f-a165-70867728950e", "012345678912", OutcomeSuccess)), "federation count not as expected")
+
+	ObserveAuthentication("Static Basic", OutcomeFailure)
+	assert.Equal(t, float64(1), testutil.ToFloat64(Authentications.WithLabelValues("Static Basic", OutcomeFailure)), "authentication count not as expected")
+
+	ObserveHTTPRequest("TestRoute", "GET", http.StatusOK, time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(HTTPRequests.WithLabelValues("TestRoute", "GET", "200")), "request count not as expected")
+
+	RegisterGauge("test", "gauge", "Test gauge.", func() float64 { return 42 })
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code, "status code not as expected")
+	b := w.Body.String()
+	assert.True(t, strings.Contains(b, `awsfederation_http_requests_total{method="GET",route="TestRoute",status="200"} 1`), "request count not in metrics output")
+	assert.True(t, strings.Contains(b, "awsfederation_test_gauge 42"), "gauge not in metrics output")
+}
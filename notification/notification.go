@@ -0,0 +1,136 @@
+// Package notification delivers notable events to external systems.
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/awsfederation/config"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Event struct {
+	EventType  string      `json:"EventType"`
+	EventUUID  string      `json:"EventUUID"`
+	Time       time.Time   `json:"Time"`
+	Username   string      `json:"Username"`
+	UserDomain string      `json:"UserDomain"`
+	Severity   string      `json:"Severity,omitempty"`
+	Detail     interface{} `json:"Detail"`
+}
+
+// DroppedNotification is the detail of the audit log line written when a notification is dropped.
+type DroppedNotification struct {
+	EventType string `json:"EventType"`
+	EventUUID string `json:"EventUUID"`
+	InFlight  int    `json:"InFlight"`
+	Backlog   int    `json:"Backlog"`
+}
+
+type pending struct {
+	e Event
+	c *config.Config
+}
+
+// queue tracks the notifications being delivered and the high severity notifications waiting for a delivery to finish.
+var queue struct {
+	mux      sync.Mutex
+	inFlight int
+	backlog  []pending
+}
+
+// Send notifies the configured webhook of the event if enabled.
+// Delivery happens in the background so as not to delay the request that raised the event. If Webhook.MaxInFlight
+// notifications are already being delivered a high severity event is queued, up to Webhook.MaxBacklog events, and
+// delivered once a delivery finishes. Other events, and high severity events beyond the backlog, are dropped and the
+// drop is recorded in the audit log. Delivery failures are written to the application log.
+func Send(e Event, c *config.Config) {
+	wh := c.Notification.Webhook
+	if !wh.Enabled {
+		return
+	}
+	queue.mux.Lock()
+	if queue.inFlight < wh.MaxInFlight {
+		queue.inFlight++
+		queue.mux.Unlock()
+		go deliver(pending{e: e, c: c})
+		return
+	}
+	if e.Severity == config.AuditSeverityHigh && len(queue.backlog) < wh.MaxBacklog {
+		queue.backlog = append(queue.backlog, pending{e: e, c: c})
+		queue.mux.Unlock()
+		return
+	}
+	n, b := queue.inFlight, len(queue.backlog)
+	queue.mux.Unlock()
+	dropped(e, n, b, c)
+}
+
+// deliver delivers the notification and then those in the backlog, freeing the in flight slot once the backlog is
+// empty.
+func deliver(p pending) {
+	for {
+		if err := Deliver(p.e, p.c); err != nil {
+			p.c.Logger().Errorf("error sending %s notification %s: %v", p.e.EventType, p.e.EventUUID, err)
+		}
+		queue.mux.Lock()
+		if len(queue.backlog) == 0 {
+			queue.inFlight--
+			queue.mux.Unlock()
+			return
+		}
+		p = queue.backlog[0]
+		queue.backlog = queue.backlog[1:]
+		queue.mux.Unlock()
+	}
+}
+
+func dropped(e Event, inFlight, backlog int, c *config.Config) {
+	c.Logger().Errorf("%s notification %s dropped, %d notifications already being delivered and %d queued", e.EventType, e.EventUUID, inFlight, backlog)
+	eventUUID, _ := uuid.GenerateUUID()
+	b, _ := json.Marshal(DroppedNotification{
+		EventType: e.EventType,
+		EventUUID: e.EventUUID,
+		InFlight:  inFlight,
+		Backlog:   backlog,
+	})
+	c.AuditLog(config.AuditLogLine{
+		Username:   e.Username,
+		UserDomain: e.UserDomain,
+		EventType:  "NotificationDropped",
+		Time:       time.Now().UTC(),
+		UUID:       eventUUID,
+		Severity:   e.Severity,
+		Outcome:    config.AuditOutcomeFailure,
+		Detail:     url.QueryEscape(string(b)),
+	})
+}
+
+// Deliver posts the event to the configured webhook and waits for the response.
+func Deliver(e Event, c *config.Config) error {
+	wh := c.Notification.Webhook
+	if wh.URL == "" {
+		return errors.New("webhook URL not defined")
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %v", err)
+	}
+	cl := http.Client{
+		Timeout: time.Duration(wh.Timeout) * time.Second,
+	}
+	resp, err := cl.Post(wh.URL, "application/json; charset=UTF-8", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliver(t *testing.T) {
+	var got Event
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	c := config.NewConfig()
+	c.Notification.Webhook.Enabled = true
+	c.Notification.Webhook.URL = s.URL
+	e := Event{
+		EventType:  "AccessRequestCreated",
+		EventUUID:  "8e7c5d5e-2c8a-4c0c-9d3f-1f6d7a0e9b11",
+		Time:       time.Now().UTC().Truncate(time.Second),
+		Username:   "testuser",
+		UserDomain: "TESTING",
+		Detail:     "detail",
+	}
+	err := Deliver(e, c)
+	if err != nil {
+		t.Fatalf("error delivering event: %v", err)
+	}
+	assert.Equal(t, e, got, "event received by webhook not as expected")
+
+	c.Notification.Webhook.URL = s.URL + "/missing"
+	s.Config.Handler = http.NotFoundHandler()
+	assert.Error(t, Deliver(e, c), "expected error for webhook returning a non success status")
+
+	c.Notification.Webhook.URL = ""
+	assert.Error(t, Deliver(e, c), "expected error when no webhook URL is defined")
+}
+
+func TestSend_MaxInFlight(t *testing.T) {
+	received := make(chan string, 3)
+	unblock := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e.EventUUID
+		<-unblock
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	c := config.NewConfig()
+	var buf bytes.Buffer
+	c.SetAuditLogger(json.NewEncoder(&buf))
+	c.Notification.Webhook.Enabled = true
+	c.Notification.Webhook.URL = s.URL
+	c.Notification.Webhook.MaxInFlight = 1
+	c.Notification.Webhook.MaxBacklog = 1
+	Send(Event{EventType: "BreakGlass", EventUUID: "1", Severity: config.AuditSeverityHigh}, c)
+	select {
+	case id := <-received:
+		assert.Equal(t, "1", id, "first notification not delivered")
+	case <-time.After(time.Second):
+		t.Fatal("first notification not delivered")
+	}
+	// The first notification is still being delivered so the second, being high severity, is queued while the third
+	// and fourth are dropped as they are not high severity and the backlog is full respectively.
+	Send(Event{EventType: "BreakGlass", EventUUID: "2", Severity: config.AuditSeverityHigh}, c)
+	Send(Event{EventType: "AccessRequestCreated", EventUUID: "3", Username: "testuser", UserDomain: "TESTING"}, c)
+	Send(Event{EventType: "BreakGlass", EventUUID: "4", Severity: config.AuditSeverityHigh}, c)
+	close(unblock)
+	select {
+	case id := <-received:
+		assert.Equal(t, "2", id, "queued high severity notification not delivered")
+	case <-time.After(time.Second):
+		t.Fatal("queued high severity notification not delivered")
+	}
+	select {
+	case id := <-received:
+		t.Fatalf("notification %s delivered when the maximum in flight and backlog were reached", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Equal(t, 2, len(lines), "dropped notifications not audit logged") {
+		return
+	}
+	for i, want := range []struct {
+		uuid     string
+		username string
+		severity string
+	}{
+		{"3", "testuser", ""},
+		{"4", "", config.AuditSeverityHigh},
+	} {
+		var l config.AuditLogLine
+		if err := json.Unmarshal([]byte(lines[i]), &l); err != nil {
+			t.Fatalf("could not unmarshal audit log line: %v", err)
+		}
+		assert.Equal(t, "NotificationDropped", l.EventType, "audit event type not as expected")
+		assert.Equal(t, config.AuditOutcomeFailure, l.Outcome, "audit outcome not as expected")
+		assert.Equal(t, want.username, l.Username, "audit username not as expected")
+		assert.Equal(t, want.severity, l.Severity, "audit severity not as expected")
+		d, _ := url.QueryUnescape(l.Detail)
+		var dn DroppedNotification
+		json.Unmarshal([]byte(d), &dn)
+		assert.Equal(t, want.uuid, dn.EventUUID, "dropped notification not as expected")
+		assert.Equal(t, 1, dn.InFlight, "notifications in flight not as expected")
+	}
+}
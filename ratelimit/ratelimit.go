@@ -0,0 +1,207 @@
+// Package ratelimit applies token bucket rate limits to federation requests.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	StoreDatabase      = "Database"
+	StoreMemory        = "Memory"
+	KindUser           = "user"
+	KindRoleMapping    = "rolemapping"
+	KindFederationUser = "federationuser"
+	// Maximum number of times to retry taking a token when another request updates the bucket concurrently.
+	maxAttempts = 5
+)
+
+// Bucket is the state of a token bucket. The Version is used to detect concurrent updates.
+type Bucket struct {
+	Key     string
+	Tokens  float64
+	Updated time.Time
+	Version int64
+}
+
+// Store holds the token buckets.
+type Store interface {
+	// Get returns the bucket for the key and false if there is no such bucket.
+	Get(ctx context.Context, key string) (Bucket, bool, error)
+	// Put saves the bucket only if it has not been updated since it was read and returns false if it has.
+	Put(ctx context.Context, b Bucket) (bool, error)
+	// List returns all the buckets.
+	List(ctx context.Context) ([]Bucket, error)
+}
+
+// Usage is the current state of a token bucket as reported to operators.
+type Usage struct {
+	Key     string    `json:"Key"`
+	Tokens  float64   `json:"Tokens"`
+	Burst   int       `json:"Burst"`
+	Rate    float64   `json:"Rate"`
+	Updated time.Time `json:"Updated"`
+}
+
+// Limiter applies the configured limits. A nil Limiter does not limit anything.
+type Limiter struct {
+	store  Store
+	limits map[string]config.Limit
+}
+
+// NewLimiter returns a Limiter for the rate limit configuration using the configured store.
+func NewLimiter(rl config.RateLimit, db database.Source) (*Limiter, error) {
+	if err := Validate(rl); err != nil {
+		return nil, err
+	}
+	var s Store
+	switch strings.ToLower(rl.Store) {
+	case strings.ToLower(StoreDatabase):
+		s = NewDBStore(db)
+	case strings.ToLower(StoreMemory):
+		s = NewMemoryStore()
+	}
+	return NewLimiterWithStore(rl, s), nil
+}
+
+// NewLimiterWithStore returns a Limiter for the rate limit configuration using the store provided.
+func NewLimiterWithStore(rl config.RateLimit, s Store) *Limiter {
+	return &Limiter{
+		store: s,
+		limits: map[string]config.Limit{
+			KindUser:           rl.User,
+			KindRoleMapping:    rl.RoleMapping,
+			KindFederationUser: rl.FederationUser,
+		},
+	}
+}
+
+// Validate checks the rate limit configuration.
+func Validate(rl config.RateLimit) error {
+	switch strings.ToLower(rl.Store) {
+	case strings.ToLower(StoreDatabase), strings.ToLower(StoreMemory):
+	default:
+		return fmt.Errorf("invalid rate limit store %s, must be %s or %s", rl.Store, StoreDatabase, StoreMemory)
+	}
+	for k, l := range map[string]config.Limit{KindUser: rl.User, KindRoleMapping: rl.RoleMapping, KindFederationUser: rl.FederationUser} {
+		if l.Rate < 0 {
+			return fmt.Errorf("rate limit for %s must not be negative", k)
+		}
+		if l.Rate > 0 && l.Burst < 1 {
+			return fmt.Errorf("burst for %s rate limit must be at least one", k)
+		}
+	}
+	return nil
+}
+
+// Allow takes a token from the buckets of the user, role mapping and federation user in that order.
+// An appcodes.ErrRateLimited error is returned for the first bucket without a token available. Tokens already taken
+// from the earlier buckets are not returned.
+func (l *Limiter) Allow(ctx context.Context, u goidentity.Identity, roleMappingID, fedUserArn string) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	for _, b := range []struct{ kind, id string }{
+		{KindUser, u.Domain() + "/" + u.UserName()},
+		{KindRoleMapping, roleMappingID},
+		{KindFederationUser, fedUserArn},
+	} {
+		ok, retry, err := l.Take(ctx, b.kind, b.id, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			e := appcodes.ErrRateLimited{}.Errorf("rate limit for %s exceeded", b.kind)
+			e.RetryAfter = retry
+			return e
+		}
+	}
+	return nil
+}
+
+// Take takes a token from the bucket for the id. If no token is available it returns false and the time until one
+// will be.
+func (l *Limiter) Take(ctx context.Context, kind, id string, now time.Time) (bool, time.Duration, error) {
+	lim, ok := l.limits[kind]
+	if !ok {
+		return false, 0, fmt.Errorf("unknown rate limit %s", kind)
+	}
+	if lim.Rate == 0 {
+		return true, 0, nil
+	}
+	key := Key(kind, id)
+	for i := 0; i < maxAttempts; i++ {
+		b, found, err := l.store.Get(ctx, key)
+		if err != nil {
+			return false, 0, fmt.Errorf("error reading rate limit bucket %s: %v", key, err)
+		}
+		if found {
+			b.Tokens = refill(b, lim, now)
+		} else {
+			b = Bucket{Key: key, Tokens: float64(lim.Burst)}
+		}
+		if b.Tokens < 1 {
+			retry := time.Duration(math.Ceil((1 - b.Tokens) / lim.Rate * float64(time.Minute)))
+			return false, retry, nil
+		}
+		b.Tokens--
+		b.Updated = now
+		ok, err := l.store.Put(ctx, b)
+		if err != nil {
+			return false, 0, fmt.Errorf("error updating rate limit bucket %s: %v", key, err)
+		}
+		if ok {
+			return true, 0, nil
+		}
+	}
+	return false, 0, fmt.Errorf("could not update rate limit bucket %s due to concurrent requests", key)
+}
+
+// Usage returns the tokens currently available in each bucket.
+func (l *Limiter) Usage(ctx context.Context) ([]Usage, error) {
+	us := []Usage{}
+	if l == nil {
+		return us, nil
+	}
+	bs, err := l.store.List(ctx)
+	if err != nil {
+		return us, err
+	}
+	now := time.Now().UTC()
+	for _, b := range bs {
+		kind := strings.SplitN(b.Key, ":", 2)[0]
+		lim, ok := l.limits[kind]
+		if !ok {
+			continue
+		}
+		us = append(us, Usage{
+			Key:     b.Key,
+			Tokens:  refill(b, lim, now),
+			Burst:   lim.Burst,
+			Rate:    lim.Rate,
+			Updated: b.Updated,
+		})
+	}
+	return us, nil
+}
+
+// Key returns the key of the bucket for the id.
+func Key(kind, id string) string {
+	return kind + ":" + id
+}
+
+func refill(b Bucket, lim config.Limit, now time.Time) float64 {
+	t := b.Tokens
+	if d := now.Sub(b.Updated); d > 0 {
+		t += d.Minutes() * lim.Rate
+	}
+	return math.Min(t, float64(lim.Burst))
+}
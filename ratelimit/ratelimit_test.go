@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/jcmturner/awsfederation/appcodes"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/awsfederation/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	goidentity "gopkg.in/jcmturner/goidentity.v1"
+	"testing"
+	"time"
+)
+
+const (
+	roleMappingID = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	fedUserArn    = "arn:aws:iam::012345678912:user/feduser"
+)
+
+func testLimits() config.RateLimit {
+	return config.RateLimit{
+		Enabled:        true,
+		Store:          StoreMemory,
+		User:           config.Limit{Rate: 60, Burst: 2},
+		RoleMapping:    config.Limit{Rate: 60, Burst: 5},
+		FederationUser: config.Limit{Rate: 0},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(testLimits()), "valid configuration returned an error")
+	rl := testLimits()
+	rl.Store = "redis"
+	assert.Error(t, Validate(rl), "expected error for an unknown store")
+	rl = testLimits()
+	rl.User.Rate = -1
+	assert.Error(t, Validate(rl), "expected error for a negative rate")
+	rl = testLimits()
+	rl.RoleMapping.Burst = 0
+	assert.Error(t, Validate(rl), "expected error for a rate without a burst")
+}
+
+func TestTake(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiterWithStore(testLimits(), NewMemoryStore())
+	now := time.Now().UTC()
+	for i := 0; i < 2; i++ {
+		ok, _, err := l.Take(ctx, KindUser, "TESTING/testuser", now)
+		if err != nil {
+			t.Fatalf("error taking token: %v", err)
+		}
+		assert.True(t, ok, "token should be available within the burst")
+	}
+	ok, retry, err := l.Take(ctx, KindUser, "TESTING/testuser", now)
+	if err != nil {
+		t.Fatalf("error taking token: %v", err)
+	}
+	assert.False(t, ok, "token should not be available once the burst is used")
+	assert.Equal(t, time.Second, retry, "retry after not as expected")
+
+	// Another user has their own bucket
+	ok, _, _ = l.Take(ctx, KindUser, "TESTING/otheruser", now)
+	assert.True(t, ok, "token should be available to another user")
+
+	// Refilled at the configured rate
+	ok, _, _ = l.Take(ctx, KindUser, "TESTING/testuser", now.Add(time.Second))
+	assert.True(t, ok, "token should be available after refill")
+
+	// Zero rate means unlimited
+	for i := 0; i < 10; i++ {
+		ok, _, _ = l.Take(ctx, KindFederationUser, fedUserArn, now)
+		assert.True(t, ok, "federation user should not be limited")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	ctx := context.Background()
+	var nl *Limiter
+	u := goidentity.NewUser("testuser")
+	u.SetDomain("TESTING")
+	assert.NoError(t, nl.Allow(ctx, &u, roleMappingID, fedUserArn), "nil limiter should not limit")
+
+	l := NewLimiterWithStore(testLimits(), NewMemoryStore())
+	assert.NoError(t, l.Allow(ctx, &u, roleMappingID, fedUserArn), "first request should be allowed")
+	assert.NoError(t, l.Allow(ctx, &u, roleMappingID, fedUserArn), "second request should be allowed")
+	err := l.Allow(ctx, &u, roleMappingID, fedUserArn)
+	if assert.Error(t, err, "third request should be limited") {
+		e, ok := err.(appcodes.ErrRateLimited)
+		assert.True(t, ok, "error not of the expected type")
+		assert.Equal(t, appcodes.RateLimitExceeded, e.AppCode, "app code not as expected")
+		assert.True(t, e.RetryAfter > 0, "retry after should be set")
+	}
+
+	us, err := l.Usage(ctx)
+	if err != nil {
+		t.Fatalf("error getting usage: %v", err)
+	}
+	if assert.Len(t, us, 2, "number of buckets not as expected") {
+		assert.Equal(t, Key(KindRoleMapping, roleMappingID), us[0].Key, "bucket key not as expected")
+		assert.Equal(t, 5, us[0].Burst, "bucket burst not as expected")
+		assert.InDelta(t, 3, us[0].Tokens, 0.1, "tokens remaining not as expected")
+		assert.Equal(t, Key(KindUser, "TESTING/testuser"), us[1].Key, "bucket key not as expected")
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	ctx := context.Background()
+	db, _, ep, stmtMap := database.Mock(t)
+	defer db.Close()
+	l := NewLimiterWithStore(testLimits(), NewDBStore(&database.Conn{DB: db, Stmts: stmtMap}))
+	now := time.Now().UTC()
+	key := Key(KindRoleMapping, roleMappingID)
+	cols := []string{"bucket_key", "tokens", "updated", "version"}
+
+	// New bucket
+	ep[database.StmtKeyRateLimitSelect].ExpectQuery().WithArgs(key).WillReturnRows(sqlmock.NewRows(cols))
+	ep[database.StmtKeyRateLimitInsert].ExpectExec().WithArgs(key, float64(4), now).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, _, err := l.Take(ctx, KindRoleMapping, roleMappingID, now)
+	if err != nil {
+		t.Fatalf("error taking token: %v", err)
+	}
+	assert.True(t, ok, "token should be available from a new bucket")
+
+	// Bucket updated by another replica between read and write
+	ep[database.StmtKeyRateLimitSelect].ExpectQuery().WithArgs(key).WillReturnRows(sqlmock.NewRows(cols).AddRow(key, 4, now, 1))
+	ep[database.StmtKeyRateLimitUpdate].ExpectExec().WithArgs(float64(3), now, key, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	ep[database.StmtKeyRateLimitSelect].ExpectQuery().WithArgs(key).WillReturnRows(sqlmock.NewRows(cols).AddRow(key, 3, now, 2))
+	ep[database.StmtKeyRateLimitUpdate].ExpectExec().WithArgs(float64(2), now, key, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, _, err = l.Take(ctx, KindRoleMapping, roleMappingID, now)
+	if err != nil {
+		t.Fatalf("error taking token: %v", err)
+	}
+	assert.True(t, ok, "token should be available after retrying a concurrent update")
+
+	// Empty bucket is not written to
+	ep[database.StmtKeyRateLimitSelect].ExpectQuery().WithArgs(key).WillReturnRows(sqlmock.NewRows(cols).AddRow(key, 0.5, now, 3))
+	ok, retry, err := l.Take(ctx, KindRoleMapping, roleMappingID, now)
+	if err != nil {
+		t.Fatalf("error taking token: %v", err)
+	}
+	assert.False(t, ok, "token should not be available from an empty bucket")
+	assert.Equal(t, time.Millisecond*500, retry, "retry after not as expected")
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jcmturner/awsfederation/database"
+	"sort"
+	"sync"
+)
+
+var errStmtNotFound = errors.New("prepared statement for rate limit bucket not found")
+
+// MemoryStore holds the buckets in memory. The limits only apply to this instance of the server.
+type MemoryStore struct {
+	mux     sync.Mutex
+	buckets map[string]Bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]Bucket)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Bucket, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	b, ok := s.buckets[key]
+	return b, ok, nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, b Bucket) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.buckets[b.Key].Version != b.Version {
+		return false, nil
+	}
+	b.Version++
+	s.buckets[b.Key] = b
+	return true, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Bucket, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var bs []Bucket
+	for _, b := range s.buckets {
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].Key < bs[j].Key })
+	return bs, nil
+}
+
+// DBStore holds the buckets in the database so that the limits are shared by all instances of the server.
+// The connection is acquired on each call so that the store follows the connection being replaced when the database
+// credentials are rotated.
+type DBStore struct {
+	db database.Source
+}
+
+func NewDBStore(db database.Source) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Get(ctx context.Context, key string) (b Bucket, ok bool, err error) {
+	conn, release := s.db.Acquire()
+	defer release()
+	stmt, ok := (*conn.Stmts)[database.StmtKeyRateLimitSelect]
+	if !ok {
+		err = errStmtNotFound
+		return
+	}
+	err = database.QueryRow(ctx, stmt, key).Scan(&b.Key, &b.Tokens, &b.Updated, &b.Version)
+	if err == sql.ErrNoRows {
+		return b, false, nil
+	}
+	if err != nil {
+		return b, false, err
+	}
+	return b, true, nil
+}
+
+func (s *DBStore) Put(ctx context.Context, b Bucket) (bool, error) {
+	conn, release := s.db.Acquire()
+	defer release()
+	var res sql.Result
+	var err error
+	if b.Version == 0 {
+		stmt, ok := (*conn.Stmts)[database.StmtKeyRateLimitInsert]
+		if !ok {
+			return false, errStmtNotFound
+		}
+		res, err = database.Exec(ctx, stmt, b.Key, b.Tokens, b.Updated)
+	} else {
+		stmt, ok := (*conn.Stmts)[database.StmtKeyRateLimitUpdate]
+		if !ok {
+			return false, errStmtNotFound
+		}
+		res, err = database.Exec(ctx, stmt, b.Tokens, b.Updated, b.Key, b.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	// No rows are affected if another request has created or updated the bucket since it was read.
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *DBStore) List(ctx context.Context) ([]Bucket, error) {
+	conn, release := s.db.Acquire()
+	defer release()
+	stmt, ok := (*conn.Stmts)[database.StmtKeyRateLimitSelectList]
+	if !ok {
+		return nil, errStmtNotFound
+	}
+	rows, err := database.Query(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bs []Bucket
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Key, &b.Tokens, &b.Updated, &b.Version); err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, rows.Err()
+}
@@ -0,0 +1,60 @@
+package secretstore
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/jcmturner/vaultclient"
+)
+
+// appIDStore is a vault authenticated to with the app ID and user ID in the vault credentials configuration.
+type appIDStore struct {
+	client *vaultclient.Client
+}
+
+func newAppIDStore(c *config.Config) (*appIDStore, error) {
+	if c.Vault.Config == nil || c.Vault.Credentials == nil {
+		return nil, fmt.Errorf("vault configuration and credentials must be defined for the %s secret store", config.SecretStoreVaultAppID)
+	}
+	cl, err := vaultclient.NewClient(c.Vault.Config, c.Vault.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client: %v", err)
+	}
+	return &appIDStore{client: &cl}, nil
+}
+
+func (s *appIDStore) Read(p string) (map[string]interface{}, error) {
+	m, err := s.client.Read(p)
+	return m, notFound(p, err)
+}
+
+func (s *appIDStore) Write(p string, m map[string]interface{}) error {
+	return s.client.Write(p, m)
+}
+
+func (s *appIDStore) Delete(p string) error {
+	return notFound(p, s.client.Delete(p))
+}
+
+func (s *appIDStore) List(p string) ([]string, error) {
+	m, err := s.client.List(p)
+	if err != nil {
+		return nil, notFound(p, err)
+	}
+	var l []string
+	if keys, ok := m["keys"].([]interface{}); ok {
+		for _, k := range keys {
+			if ks, ok := k.(string); ok {
+				l = append(l, ks)
+			}
+		}
+	}
+	return l, nil
+}
+
+// notFound converts the vault client's not found error to ErrSecretNotFound.
+func notFound(p string, err error) error {
+	if _, ok := err.(vaultclient.ErrSecretNotFound); ok {
+		return ErrSecretNotFound{Path: p}
+	}
+	return err
+}
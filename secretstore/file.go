@@ -0,0 +1,194 @@
+package secretstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileStore holds the secrets in a local file encrypted with AES-GCM. It is intended for development where a vault
+// is not available.
+type fileStore struct {
+	path string
+	root string
+	aead cipher.AEAD
+	mux  sync.Mutex
+}
+
+func newFileStore(c *config.Config) (*fileStore, error) {
+	kh, err := ioutil.ReadFile(c.SecretStore.File.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret store key file: %v", err)
+	}
+	k, err := hex.DecodeString(strings.TrimSpace(string(kh)))
+	if err != nil {
+		return nil, fmt.Errorf("secret store key is not hex encoded: %v", err)
+	}
+	if len(k) != 32 {
+		return nil, errors.New("secret store key must be 256 bits")
+	}
+	b, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{
+		path: c.SecretStore.File.Path,
+		aead: aead,
+	}
+	if c.Vault.Config != nil {
+		s.root = c.Vault.Config.SecretsPath
+	}
+	return s, nil
+}
+
+// load decrypts the secrets in the file. A file that does not exist holds no secrets.
+func (s *fileStore) load() (map[string]map[string]interface{}, error) {
+	secrets := make(map[string]map[string]interface{})
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return secrets, nil
+		}
+		return nil, err
+	}
+	ns := s.aead.NonceSize()
+	if len(b) < ns {
+		return nil, errors.New("secret store file is not valid")
+	}
+	pt, err := s.aead.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secret store file: %v", err)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(pt)))
+	dec.UseNumber()
+	if err := dec.Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("could not decode secret store file: %v", err)
+	}
+	return secrets, nil
+}
+
+// save encrypts the secrets and replaces the file.
+func (s *fileStore) save(secrets map[string]map[string]interface{}) error {
+	pt, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(s.aead.Seal(nonce, nonce, pt, nil)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Check decrypts the secrets file, which fails if the file cannot be read or was encrypted with a different key.
+func (s *fileStore) Check() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, err := s.load()
+	return err
+}
+
+func (s *fileStore) Read(p string) (map[string]interface{}, error) {
+	p = resolve(s.root, p)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := secrets[p]
+	if !ok {
+		return nil, ErrSecretNotFound{Path: p}
+	}
+	return m, nil
+}
+
+func (s *fileStore) Write(p string, m map[string]interface{}) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[resolve(s.root, p)] = m
+	return s.save(secrets)
+}
+
+func (s *fileStore) Delete(p string) error {
+	p = resolve(s.root, p)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[p]; !ok {
+		return ErrSecretNotFound{Path: p}
+	}
+	delete(secrets, p)
+	return s.save(secrets)
+}
+
+func (s *fileStore) List(p string) ([]string, error) {
+	p = resolve(s.root, p)
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for k := range secrets {
+		if !strings.HasPrefix(k, p) {
+			continue
+		}
+		n := strings.TrimPrefix(k, p)
+		if i := strings.Index(n, "/"); i >= 0 {
+			n = n[:i+1]
+		}
+		names[n] = true
+	}
+	if len(names) < 1 {
+		return nil, ErrSecretNotFound{Path: p}
+	}
+	var l []string
+	for n := range names {
+		l = append(l, n)
+	}
+	sort.Strings(l)
+	return l, nil
+}
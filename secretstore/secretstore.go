@@ -0,0 +1,112 @@
+// Package secretstore provides access to the secrets the server needs, such as the keytabs, the database credentials
+// and the federation users' AWS credentials, independently of where they are held.
+package secretstore
+
+import (
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds secrets as maps of values at a path.
+// Paths starting with "/" are absolute. Other paths are relative to the secrets root of the vault configuration.
+type Store interface {
+	Read(p string) (map[string]interface{}, error)
+	Write(p string, m map[string]interface{}) error
+	Delete(p string) error
+	// List returns the names of the secrets and folders below the path. Folder names end with "/".
+	List(p string) ([]string, error)
+}
+
+// Lease is the lease of a dynamic secret, such as the short-lived credentials issued by the vault's database secrets
+// engine. The secret is revoked by the vault when the lease expires unless it is renewed.
+type Lease struct {
+	ID        string
+	Duration  time.Duration
+	Renewable bool
+}
+
+// Leaser is implemented by the stores that can issue dynamic secrets.
+type Leaser interface {
+	// ReadLease reads a dynamic secret and returns the lease under which it was issued.
+	ReadLease(p string) (map[string]interface{}, Lease, error)
+	// Renew extends the lease by the increment requested. The vault may grant a shorter extension than requested.
+	Renew(l Lease, increment time.Duration) (Lease, error)
+	// Revoke ends the lease so that the secret can no longer be used.
+	Revoke(l Lease) error
+}
+
+// Checker is implemented by the stores that can check they are reachable and their access is still valid without
+// reading a secret.
+type Checker interface {
+	Check() error
+}
+
+// ErrSecretNotFound is returned when there is no secret at the path requested.
+type ErrSecretNotFound struct {
+	Path string
+}
+
+func (e ErrSecretNotFound) Error() string {
+	return fmt.Sprintf("secret not found at %s", e.Path)
+}
+
+var (
+	storesMux sync.Mutex
+	stores    = make(map[*config.Config]Store)
+)
+
+// New returns the secret store defined by the configuration.
+func New(c *config.Config) (Store, error) {
+	switch strings.ToLower(c.SecretStore.Type) {
+	case strings.ToLower(config.SecretStoreVaultAppID):
+		return newAppIDStore(c)
+	case strings.ToLower(config.SecretStoreVaultAppRole), strings.ToLower(config.SecretStoreVaultToken),
+		strings.ToLower(config.SecretStoreVaultKubernetes):
+		return newVaultStore(c)
+	case strings.ToLower(config.SecretStoreFile):
+		return newFileStore(c)
+	default:
+		return nil, fmt.Errorf("unknown secret store type %s", c.SecretStore.Type)
+	}
+}
+
+// ForConfig returns the secret store defined by the configuration, creating it the first time it is requested so that
+// the login to the vault is reused.
+func ForConfig(c *config.Config) (Store, error) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	if s, ok := stores[c]; ok {
+		return s, nil
+	}
+	s, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	stores[c] = s
+	return s, nil
+}
+
+// Set makes the store the one returned by ForConfig for the configuration.
+func Set(c *config.Config, s Store) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	stores[c] = s
+}
+
+// Release removes the store held for a configuration that is no longer in use.
+func Release(c *config.Config) {
+	storesMux.Lock()
+	defer storesMux.Unlock()
+	delete(stores, c)
+}
+
+// resolve returns the absolute path of the secret.
+func resolve(root, p string) string {
+	if strings.HasPrefix(p, "/") {
+		return p
+	}
+	return strings.TrimSuffix(root, "/") + "/" + p
+}
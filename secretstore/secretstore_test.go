@@ -0,0 +1,293 @@
+package secretstore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testRoleID   = "test-role-id"
+	testSecretID = "test-secret-id"
+	testToken    = "test-token"
+)
+
+// fakeVault is a minimal vault supporting the AppRole and Kubernetes logins and a key/value secrets engine.
+type fakeVault struct {
+	mux     sync.Mutex
+	token   string
+	logins  int
+	leases  int
+	revoked []string
+	secrets map[string]map[string]interface{}
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/v1/auth/") && strings.HasSuffix(r.URL.Path, "/login") {
+		var b map[string]string
+		json.NewDecoder(r.Body).Decode(&b)
+		if (r.URL.Path == "/v1/auth/approle/login" && b["role_id"] == testRoleID && b["secret_id"] == testSecretID) ||
+			(r.URL.Path == "/v1/auth/kubernetes/login" && b["role"] == "awsfederation" && b["jwt"] == "jwt") {
+			v.logins++
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": v.token, "lease_duration": 3600}})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get(vaultTokenHeader) != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/v1")
+	switch p {
+	case "/auth/token/lookup-self":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": v.token}})
+		return
+	case "/database/creds/awsfederation":
+		v.leases++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/awsfederation/%d", v.leases),
+			"lease_duration": 3600,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("v-user-%d", v.leases), "password": "pass"},
+		})
+		return
+	case "/sys/leases/renew":
+		var b map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&b)
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_id": b["lease_id"], "lease_duration": 600, "renewable": true})
+		return
+	case "/sys/leases/revoke":
+		var b map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&b)
+		v.revoked = append(v.revoked, b["lease_id"].(string))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if r.URL.Query().Get("list") == "true" {
+			var keys []string
+			for k := range v.secrets {
+				if strings.HasPrefix(k, p) {
+					keys = append(keys, strings.TrimPrefix(k, p))
+				}
+			}
+			if len(keys) < 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			return
+		}
+		m, ok := v.secrets[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": m})
+	case "PUT":
+		var m map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&m)
+		v.secrets[p] = m
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(v.secrets, p)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testConfig(addr, storeType string) *config.Config {
+	c := config.NewConfig()
+	c.SetVault(addr, "", "", "", "/secret/")
+	c.SecretStore.Type = storeType
+	return c
+}
+
+func testStore(t *testing.T, s Store) {
+	err := s.Write("arn:aws:iam::123456789012:user/test1", map[string]interface{}{"AccessKeyID": "AKIAEXAMPLE", "TTL": 60})
+	if err != nil {
+		t.Fatalf("Error writing secret: %v", err)
+	}
+	err = s.Write("/secret/keytab", map[string]interface{}{"keytab": "0502"})
+	if err != nil {
+		t.Fatalf("Error writing secret with absolute path: %v", err)
+	}
+	m, err := s.Read("arn:aws:iam::123456789012:user/test1")
+	if err != nil {
+		t.Fatalf("Error reading secret: %v", err)
+	}
+	assert.Equal(t, "AKIAEXAMPLE", m["AccessKeyID"], "Secret value not as expected")
+	assert.Equal(t, json.Number("60"), m["TTL"], "Numeric secret value not as expected")
+	m, err = s.Read("keytab")
+	if err != nil {
+		t.Fatalf("Error reading secret by relative path: %v", err)
+	}
+	assert.Equal(t, "0502", m["keytab"], "Secret value not as expected")
+
+	l, err := s.List("arn:aws:iam::123456789012:user/")
+	if err != nil {
+		t.Fatalf("Error listing secrets: %v", err)
+	}
+	assert.Equal(t, []string{"test1"}, l, "List not as expected")
+
+	if err := s.Delete("arn:aws:iam::123456789012:user/test1"); err != nil {
+		t.Fatalf("Error deleting secret: %v", err)
+	}
+	_, err = s.Read("arn:aws:iam::123456789012:user/test1")
+	_, notFound := err.(ErrSecretNotFound)
+	assert.True(t, notFound, "Expected ErrSecretNotFound reading deleted secret, got: %v", err)
+}
+
+func TestVaultStore_AppRole(t *testing.T) {
+	v := &fakeVault{token: testToken, secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	c := testConfig(srv.URL, config.SecretStoreVaultAppRole)
+	c.SecretStore.AppRole.RoleID = testRoleID
+	c.SecretStore.AppRole.SecretID = testSecretID
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	testStore(t, s)
+	assert.Equal(t, 1, v.logins, "Token not reused")
+
+	// A revoked token causes a login to obtain a new one.
+	v.mux.Lock()
+	v.token = "new-token"
+	v.mux.Unlock()
+	if _, err := s.Read("keytab"); err != nil {
+		t.Fatalf("Error reading secret after token revoked: %v", err)
+	}
+	assert.Equal(t, 2, v.logins, "Expected login after token revoked")
+}
+
+func TestVaultStore_Kubernetes(t *testing.T) {
+	v := &fakeVault{token: testToken, secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+	jwt, _ := ioutil.TempFile(os.TempDir(), "jwt")
+	defer os.Remove(jwt.Name())
+	jwt.WriteString("jwt\n")
+	jwt.Close()
+
+	c := testConfig(srv.URL, config.SecretStoreVaultKubernetes)
+	c.SecretStore.Kubernetes.Role = "awsfederation"
+	c.SecretStore.Kubernetes.ServiceAccountTokenFile = jwt.Name()
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	testStore(t, s)
+}
+
+func TestVaultStore_Token(t *testing.T) {
+	v := &fakeVault{token: testToken, secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	c := testConfig(srv.URL, config.SecretStoreVaultToken)
+	c.SecretStore.Token.Token = testToken
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	testStore(t, s)
+	assert.NoError(t, s.(Checker).Check(), "Expected check of valid token to pass")
+	assert.Equal(t, 0, v.leases, "Check should not issue dynamic secrets")
+
+	c.SecretStore.Token.Token = "wrong"
+	s, _ = New(c)
+	_, err = s.Read("keytab")
+	assert.Error(t, err, "Expected error with invalid token")
+	assert.Error(t, s.(Checker).Check(), "Expected check of invalid token to fail")
+}
+
+func TestFileStore(t *testing.T) {
+	d, err := ioutil.TempDir(os.TempDir(), "secretstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	kf := filepath.Join(d, "key")
+	ioutil.WriteFile(kf, []byte(hex.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))), 0600)
+
+	c := testConfig("http://127.0.0.1:8200", config.SecretStoreFile)
+	c.SecretStore.File = config.FileStore{Path: filepath.Join(d, "secrets"), KeyFile: kf}
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	testStore(t, s)
+	assert.NoError(t, s.(Checker).Check(), "Expected check of secrets file to pass")
+
+	b, _ := ioutil.ReadFile(c.SecretStore.File.Path)
+	assert.False(t, strings.Contains(string(b), "0502"), "Secrets file not encrypted")
+	fi, _ := os.Stat(c.SecretStore.File.Path)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "Secrets file permissions not as expected")
+
+	// A different key cannot read the secrets.
+	ioutil.WriteFile(kf, []byte(hex.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))), 0600)
+	s, _ = New(c)
+	_, err = s.Read("keytab")
+	assert.Error(t, err, "Expected error reading with a different key")
+	assert.Error(t, s.(Checker).Check(), "Expected check with a different key to fail")
+}
+
+func TestVaultStore_Lease(t *testing.T) {
+	v := &fakeVault{token: testToken, secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	c := testConfig(srv.URL, config.SecretStoreVaultToken)
+	c.SecretStore.Token.Token = testToken
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	ls, ok := s.(Leaser)
+	if !ok {
+		t.Fatal("Vault store does not issue dynamic secrets")
+	}
+	m, l, err := ls.ReadLease("/database/creds/awsfederation")
+	if err != nil {
+		t.Fatalf("Error reading dynamic secret: %v", err)
+	}
+	assert.Equal(t, "v-user-1", m["username"], "Username not as expected")
+	assert.Equal(t, "database/creds/awsfederation/1", l.ID, "Lease ID not as expected")
+	assert.Equal(t, time.Hour, l.Duration, "Lease duration not as expected")
+	assert.True(t, l.Renewable, "Lease not renewable")
+
+	l, err = ls.Renew(l, time.Hour)
+	if err != nil {
+		t.Fatalf("Error renewing lease: %v", err)
+	}
+	assert.Equal(t, "database/creds/awsfederation/1", l.ID, "Lease ID not as expected after renewal")
+	assert.Equal(t, 10*time.Minute, l.Duration, "Lease duration not as expected after renewal")
+
+	if err := ls.Revoke(l); err != nil {
+		t.Fatalf("Error revoking lease: %v", err)
+	}
+	assert.Equal(t, []string{"database/creds/awsfederation/1"}, v.revoked, "Lease not revoked")
+
+	// A static secret has no lease.
+	s.Write("/secret/static", map[string]interface{}{"password": "pass"})
+	_, _, err = ls.ReadLease("/secret/static")
+	assert.Error(t, err, "Expected error reading a static secret as a dynamic secret")
+}
@@ -0,0 +1,307 @@
+package secretstore
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/awsfederation/config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const vaultTokenHeader = "X-Vault-Token"
+
+// vaultStore is a vault accessed with a token. The token is either configured directly or obtained by logging in with
+// the AppRole or Kubernetes auth methods, in which case it is renewed by logging in again when it expires.
+type vaultStore struct {
+	endpoint   string
+	root       string
+	httpClient *http.Client
+	login      func(s *vaultStore) (string, time.Duration, error)
+	mux        sync.Mutex
+	token      string
+	expiry     time.Time
+}
+
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *vaultAuth             `json:"auth"`
+	Errors        []string               `json:"errors"`
+}
+
+func (vr *vaultResponse) lease() Lease {
+	return Lease{
+		ID:        vr.LeaseID,
+		Duration:  time.Duration(vr.LeaseDuration) * time.Second,
+		Renewable: vr.Renewable,
+	}
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+}
+
+func newVaultStore(c *config.Config) (*vaultStore, error) {
+	if c.Vault.Config == nil || c.Vault.Config.ReSTClientConfig.EndPoint == nil {
+		return nil, errors.New("vault endpoint not defined")
+	}
+	s := &vaultStore{
+		endpoint:   strings.TrimSuffix(*c.Vault.Config.ReSTClientConfig.EndPoint, "/"),
+		root:       c.Vault.Config.SecretsPath,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if ca := c.Vault.Config.ReSTClientConfig.TrustCACert; ca != nil && *ca != "" {
+		pem, err := ioutil.ReadFile(*ca)
+		if err != nil {
+			return nil, fmt.Errorf("could not read vault CA certificate: %v", err)
+		}
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM(pem) {
+			return nil, errors.New("vault CA certificate could not be loaded, is it PEM format?")
+		}
+		s.httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: cp}}
+	}
+	sc := c.SecretStore
+	switch strings.ToLower(sc.Type) {
+	case strings.ToLower(config.SecretStoreVaultToken):
+		t, err := valueOrFile(sc.Token.Token, sc.Token.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read vault token: %v", err)
+		}
+		s.token = t
+	case strings.ToLower(config.SecretStoreVaultAppRole):
+		secretID, err := valueOrFile(sc.AppRole.SecretID, sc.AppRole.SecretIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read AppRole secret ID: %v", err)
+		}
+		s.login = func(s *vaultStore) (string, time.Duration, error) {
+			return s.authenticate(sc.AppRole.MountPath, map[string]string{
+				"role_id":   sc.AppRole.RoleID,
+				"secret_id": secretID,
+			})
+		}
+	case strings.ToLower(config.SecretStoreVaultKubernetes):
+		s.login = func(s *vaultStore) (string, time.Duration, error) {
+			// The service account token is rotated by Kubernetes so is read each time.
+			jwt, err := ioutil.ReadFile(sc.Kubernetes.ServiceAccountTokenFile)
+			if err != nil {
+				return "", 0, fmt.Errorf("could not read service account token: %v", err)
+			}
+			return s.authenticate(sc.Kubernetes.MountPath, map[string]string{
+				"role": sc.Kubernetes.Role,
+				"jwt":  strings.TrimSpace(string(jwt)),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("secret store type %s is not a token based vault", sc.Type)
+	}
+	return s, nil
+}
+
+// valueOrFile returns the value if set, otherwise the trimmed contents of the file.
+func valueOrFile(v, f string) (string, error) {
+	if v != "" {
+		return v, nil
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// authenticate logs in to the auth method mounted at the path and returns the client token and its lease duration.
+func (s *vaultStore) authenticate(mount string, body map[string]string) (string, time.Duration, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequest("POST", s.endpoint+"/v1/auth/"+strings.Trim(mount, "/")+"/login", bytes.NewReader(b))
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("vault login failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var vr vaultResponse
+	if err := decodeResponse(resp, &vr); err != nil {
+		return "", 0, fmt.Errorf("vault login failed: %v", err)
+	}
+	if vr.Auth == nil || vr.Auth.ClientToken == "" {
+		return "", 0, errors.New("vault login failed: no token returned")
+	}
+	return vr.Auth.ClientToken, time.Duration(vr.Auth.LeaseDuration) * time.Second, nil
+}
+
+// getToken returns the token to use, logging in if there is no token or it has expired.
+func (s *vaultStore) getToken(renew bool) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.login == nil {
+		return s.token, nil
+	}
+	if !renew && s.token != "" && (s.expiry.IsZero() || time.Now().UTC().Before(s.expiry)) {
+		return s.token, nil
+	}
+	t, d, err := s.login(s)
+	if err != nil {
+		return "", err
+	}
+	s.token = t
+	s.expiry = time.Time{}
+	if d > 0 {
+		// Log in again a little before the token expires.
+		s.expiry = time.Now().UTC().Add(d - d/10)
+	}
+	return s.token, nil
+}
+
+// do sends the request to the vault. If the token is rejected it logs in again and retries once.
+func (s *vaultStore) do(method, p string, body interface{}) (*vaultResponse, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		t, err := s.getToken(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		var r io.Reader
+		if b != nil {
+			r = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, s.endpoint+"/v1"+p, r)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(vaultTokenHeader, t)
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error accessing the vault: %v", err)
+		}
+		if resp.StatusCode == http.StatusForbidden && s.login != nil && attempt == 0 {
+			resp.Body.Close()
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrSecretNotFound{Path: p}
+		}
+		var vr vaultResponse
+		if err := decodeResponse(resp, &vr); err != nil {
+			return nil, err
+		}
+		return &vr, nil
+	}
+}
+
+func decodeResponse(resp *http.Response, vr *vaultResponse) error {
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(vr); err != nil && err != io.EOF {
+		return fmt.Errorf("could not decode vault response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("vault returned %d: %s", resp.StatusCode, strings.Join(vr.Errors, "; "))
+	}
+	return nil
+}
+
+func (s *vaultStore) Read(p string) (map[string]interface{}, error) {
+	p = resolve(s.root, p)
+	vr, err := s.do("GET", p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if vr.Data == nil {
+		return nil, ErrSecretNotFound{Path: p}
+	}
+	return vr.Data, nil
+}
+
+func (s *vaultStore) Write(p string, m map[string]interface{}) error {
+	_, err := s.do("PUT", resolve(s.root, p), m)
+	return err
+}
+
+func (s *vaultStore) Delete(p string) error {
+	_, err := s.do("DELETE", resolve(s.root, p), nil)
+	return err
+}
+
+func (s *vaultStore) List(p string) ([]string, error) {
+	vr, err := s.do("GET", resolve(s.root, p)+"?list=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	var l []string
+	if keys, ok := vr.Data["keys"].([]interface{}); ok {
+		for _, k := range keys {
+			if ks, ok := k.(string); ok {
+				l = append(l, ks)
+			}
+		}
+	}
+	return l, nil
+}
+
+// Check looks up the store's own token, which fails if the vault cannot be reached or the token is no longer valid and
+// a new one cannot be obtained.
+func (s *vaultStore) Check() error {
+	_, err := s.do("GET", "/auth/token/lookup-self", nil)
+	return err
+}
+
+func (s *vaultStore) ReadLease(p string) (map[string]interface{}, Lease, error) {
+	p = resolve(s.root, p)
+	vr, err := s.do("GET", p, nil)
+	if err != nil {
+		return nil, Lease{}, err
+	}
+	if vr.Data == nil {
+		return nil, Lease{}, ErrSecretNotFound{Path: p}
+	}
+	if vr.LeaseID == "" {
+		return nil, Lease{}, fmt.Errorf("secret at %s is not a dynamic secret with a lease", p)
+	}
+	return vr.Data, vr.lease(), nil
+}
+
+func (s *vaultStore) Renew(l Lease, increment time.Duration) (Lease, error) {
+	vr, err := s.do("PUT", "/sys/leases/renew", map[string]interface{}{
+		"lease_id":  l.ID,
+		"increment": int64(increment / time.Second),
+	})
+	if err != nil {
+		return l, fmt.Errorf("could not renew lease %s: %v", l.ID, err)
+	}
+	return vr.lease(), nil
+}
+
+func (s *vaultStore) Revoke(l Lease) error {
+	if _, err := s.do("PUT", "/sys/leases/revoke", map[string]interface{}{"lease_id": l.ID}); err != nil {
+		return fmt.Errorf("could not revoke lease %s: %v", l.ID, err)
+	}
+	return nil
+}
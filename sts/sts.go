@@ -9,40 +9,70 @@ import (
 	"github.com/jcmturner/awsfederation/awscredential"
 	"github.com/jcmturner/awsfederation/config"
 	"github.com/jcmturner/awsfederation/federationuser"
-	"github.com/jcmturner/awsvaultcredsprovider"
+	"github.com/jcmturner/awsfederation/metrics"
+	"github.com/jcmturner/awsfederation/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
 )
 
-func Federate(c *config.Config, fc *federationuser.FedUserCache, fedUserArn, role, roleSessionName, policy string, duration int64) (*sts.AssumeRoleOutput, error) {
-	var p *awsvaultcredsprovider.VaultCredsProvider
+// Federate assumes the role using the federation user's credentials. The call is logged to the application log with the
+// ID of the request carried by the context and recorded in a span that is a child of the span in the context.
+func Federate(ctx context.Context, c *config.Config, fc *federationuser.FedUserCache, fedUserArn, role, roleSessionName, policy string, duration int64) (*sts.AssumeRoleOutput, error) {
+	l := c.Logger().ForRequest(ctx).With("role", role, "federation_user", fedUserArn, "role_session_name", roleSessionName)
+	var p *federationuser.Provider
 	if fu, ok := (*fc)[fedUserArn]; ok {
 		p = fu.Provider
 	} else {
 		fu, err := federationuser.NewFederationUser(c, fedUserArn)
 		if err != nil {
+			l.Error("could not load federation user for STS AssumeRole call", "error", err)
 			return &sts.AssumeRoleOutput{}, err
 		}
 		(*fc)[fedUserArn] = &fu
 		p = fu.Provider
 	}
 	creds := credentials.NewCredentials(p)
-	return AssumeRole(role, roleSessionName, policy, duration, creds)
+	// The credentials are retrieved here, rather than when the call is signed, so that reading them from the secret
+	// store is recorded in its own span.
+	_, span := tracing.Start(ctx, "secretstore.read", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("federation_user", fedUserArn)))
+	_, err := creds.Get()
+	tracing.End(span, err)
+	if err != nil {
+		l.Error("could not retrieve federation user credentials for STS AssumeRole call", "error", err)
+		return &sts.AssumeRoleOutput{}, err
+	}
+	start := time.Now()
+	o, err := AssumeRole(ctx, role, roleSessionName, policy, duration, creds)
+	if err != nil {
+		l.Error("STS AssumeRole call failed", "duration", time.Since(start), "error", err)
+		return o, err
+	}
+	l.Info("STS AssumeRole call succeeded", "duration", time.Since(start), "session_duration", duration)
+	return o, nil
 }
 
-func AssumeRole(role, roleSessionName, policy string, duration int64, creds *credentials.Credentials) (*sts.AssumeRoleOutput, error) {
+func AssumeRole(ctx context.Context, role, roleSessionName, policy string, duration int64, creds *credentials.Credentials) (*sts.AssumeRoleOutput, error) {
 	config := aws.NewConfig().WithCredentials(creds)
 	sess := session.Must(session.NewSession(config))
 	svc := sts.New(sess)
-	ctx := context.Background()
 	//TODO configure context timeout
 
-	var params *sts.AssumeRoleInput
+	params := new(sts.AssumeRoleInput)
 	params.SetRoleArn(role).
 		SetDurationSeconds(duration).
 		SetRoleSessionName(roleSessionName)
 	if policy != "" {
 		params.SetPolicy(policy)
 	}
-	return svc.AssumeRoleWithContext(ctx, params)
+	ctx, span := tracing.Start(ctx, "sts.AssumeRole", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("aws.role.arn", role), attribute.Int64("aws.sts.duration_seconds", duration)))
+	start := time.Now()
+	o, err := svc.AssumeRoleWithContext(ctx, params)
+	metrics.STSDuration.Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	return o, err
 }
 
 type AssumedRole struct {
@@ -49,4 +49,8 @@ const (
 	AuthzAttrib2            = "myGroup2"
 	UUID1                   = "6901e2f6-0677-4a0c-95f8-174testuuid1"
 	UUID2                   = "e1932ce8-212e-4cb1-b71c-906testuuid2"
+	UUID3                   = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	UUID4                   = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	UUID5                   = "a8098c1a-f86e-11da-bd1a-00112444be1e"
+	UUID6                   = "16fd2706-8baf-433b-82eb-8c7fada847da"
 )
@@ -0,0 +1,195 @@
+// Package tracing provides the OpenTelemetry tracing of the server. Spans are recorded for the HTTP requests, including
+// authentication, the database statements, the vault and LDAP operations and the calls to AWS STS so that the time
+// taken by each dependency of a request can be seen. The W3C trace context sent by callers is continued.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	ExporterOTLP   = "OTLP"
+	ExporterStdout = "stdout"
+
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	instrumentationName = "github.com/jcmturner/awsfederation"
+)
+
+// Config configures exporting the spans. The OTLP exporter sends them to the collector at the Endpoint, a host and
+// port, using gRPC or HTTP. Insecure disables TLS to the collector and Headers are sent with each export, for example to
+// authenticate. The stdout exporter writes the spans as JSON to the File, or to stdout if not set, and is intended for
+// development.
+// SampleRatio is the fraction of the traces started by the server that are recorded. Traces continued from a caller
+// are recorded if the caller recorded them.
+type Config struct {
+	Enabled     bool              `json:"Enabled"`
+	Exporter    string            `json:"Exporter"` // OTLP or stdout
+	Endpoint    string            `json:"Endpoint"`
+	Protocol    string            `json:"Protocol"` // grpc or http
+	Insecure    bool              `json:"Insecure"`
+	Headers     map[string]string `json:"Headers"`
+	File        string            `json:"File"`
+	SampleRatio float64           `json:"SampleRatio"`
+	ServiceName string            `json:"ServiceName"`
+}
+
+// NewConfig returns the default tracing configuration, disabled and exporting all traces with OTLP over gRPC.
+func NewConfig() Config {
+	return Config{
+		Exporter:    ExporterOTLP,
+		Protocol:    ProtocolGRPC,
+		SampleRatio: 1,
+		ServiceName: "awsfederation",
+	}
+}
+
+// Validate checks the settings of the exporter.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	var problems []string
+	switch {
+	case strings.EqualFold(c.Exporter, ExporterOTLP):
+		if c.Endpoint == "" {
+			problems = append(problems, "OTLP endpoint not defined")
+		} else if u, err := url.Parse("//" + c.Endpoint); err != nil || u.Host == "" || u.Path != "" {
+			problems = append(problems, fmt.Sprintf("invalid OTLP endpoint %s, must be host:port", c.Endpoint))
+		}
+		if !strings.EqualFold(c.Protocol, ProtocolGRPC) && !strings.EqualFold(c.Protocol, ProtocolHTTP) {
+			problems = append(problems, fmt.Sprintf("invalid OTLP protocol %s, must be grpc or http", c.Protocol))
+		}
+	case strings.EqualFold(c.Exporter, ExporterStdout):
+	default:
+		problems = append(problems, fmt.Sprintf("unknown exporter %s, must be OTLP or stdout", c.Exporter))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		problems = append(problems, "SampleRatio must be from 0 to 1")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Init sets up the exporter and makes the W3C trace context the propagated format. The returned function flushes the
+// spans not yet exported and stops the exporter. When tracing is disabled no spans are recorded but the trace context
+// of callers is still passed on.
+func Init(c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !c.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	exp, closer, err := exporter(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", c.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace resource: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+func exporter(c Config) (sdktrace.SpanExporter, io.Closer, error) {
+	if strings.EqualFold(c.Exporter, ExporterStdout) {
+		if c.File == "" {
+			e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+			return e, nil, err
+		}
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return e, f, nil
+	}
+	ctx := context.Background()
+	if strings.EqualFold(c.Protocol, ProtocolHTTP) {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint), otlptracehttp.WithHeaders(c.Headers)}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		e, err := otlptracehttp.New(ctx, opts...)
+		return e, nil, err
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(c.Endpoint), otlptracegrpc.WithHeaders(c.Headers)}
+	if c.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	e, err := otlptracegrpc.New(ctx, opts...)
+	return e, nil, err
+}
+
+// Start starts a span as a child of the span in the context, if any.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End ends the span, recording the error if the operation failed.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Extract returns the context continuing the trace context sent by the caller in the carrier, such as the HTTP headers.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Inject adds the trace context to the carrier, such as the headers of an outgoing HTTP request.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// TraceID returns the ID of the trace of the span in the context or an empty string if there is none.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
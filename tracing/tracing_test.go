@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	otlp := NewConfig()
+	otlp.Enabled = true
+	otlp.Endpoint = "collector.example.com:4317"
+
+	var tests = []struct {
+		Name   string
+		Change func(c *Config)
+		Valid  bool
+	}{
+		{"disabled", func(c *Config) { c.Enabled = false; c.Endpoint = "" }, true},
+		{"OTLP gRPC", func(c *Config) {}, true},
+		{"OTLP HTTP", func(c *Config) { c.Protocol = "HTTP"; c.Endpoint = "collector.example.com:4318" }, true},
+		{"OTLP no endpoint", func(c *Config) { c.Endpoint = "" }, false},
+		{"OTLP endpoint URL", func(c *Config) { c.Endpoint = "https://collector.example.com:4317/v1/traces" }, false},
+		{"OTLP unknown protocol", func(c *Config) { c.Protocol = "thrift" }, false},
+		{"stdout", func(c *Config) { c.Exporter = "STDOUT"; c.Endpoint = "" }, true},
+		{"unknown exporter", func(c *Config) { c.Exporter = "jaeger" }, false},
+		{"sample ratio too low", func(c *Config) { c.SampleRatio = -0.1 }, false},
+		{"sample ratio too high", func(c *Config) { c.SampleRatio = 1.5 }, false},
+		{"sample none", func(c *Config) { c.SampleRatio = 0 }, true},
+	}
+	for _, test := range tests {
+		c := otlp
+		test.Change(&c)
+		err := c.Validate()
+		if test.Valid {
+			assert.NoError(t, err, "configuration should be valid (%s)", test.Name)
+		} else {
+			assert.Error(t, err, "configuration should not be valid (%s)", test.Name)
+		}
+	}
+}
+
+func TestInit_Stdout(t *testing.T) {
+	d, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+	f := filepath.Join(d, "spans.json")
+
+	c := NewConfig()
+	c.Enabled = true
+	c.Exporter = ExporterStdout
+	c.File = f
+	stop, err := Init(c)
+	if err != nil {
+		t.Fatalf("error initialising tracing: %v", err)
+	}
+
+	// Continue the trace of a caller.
+	h := make(http.Header)
+	h.Set("traceparent", "00-4bf92f3577b34da6a1ce929065e4736a-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), propagation.HeaderCarrier(h))
+	ctx, span := Start(ctx, "test operation")
+	assert.Equal(t, "4bf92f3577b34da6a1ce929065e4736a", TraceID(ctx), "caller's trace not continued")
+	_, child := Start(ctx, "test child")
+	End(child, errors.New("child failed"))
+	End(span, nil)
+
+	// The trace context is passed on to the next hop.
+	out := make(http.Header)
+	Inject(ctx, propagation.HeaderCarrier(out))
+	assert.True(t, strings.HasPrefix(out.Get("traceparent"), "00-4bf92f3577b34da6a1ce929065e4736a-"), "trace context not injected: %s", out.Get("traceparent"))
+
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("error stopping tracing: %v", err)
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		t.Fatalf("error reading exported spans: %v", err)
+	}
+	assert.Contains(t, string(b), `"Name":"test operation"`, "span not exported")
+	assert.Contains(t, string(b), `"Name":"test child"`, "child span not exported")
+	assert.Contains(t, string(b), "child failed", "error not recorded on span")
+}
+
+func TestTraceID_NoSpan(t *testing.T) {
+	assert.Equal(t, "", TraceID(context.Background()), "trace ID returned without a span")
+}